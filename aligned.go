@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// alignedWindowDays is the length of each window of an aligned Octopus fetch, two weeks to
+// match a page of half-hourly tariffs.
+const alignedWindowDays = 14
+
+// fetchedTariffs replays the tariffs gathered by an aligned fetch for its range, falling back
+// to the provider for any other range.
+type fetchedTariffs struct {
+	TariffProvider
+	start, end time.Time
+	tariffs    map[Direction][]TariffData
+}
+
+// Tariffs returns the gathered tariffs when asked for the range they were gathered over.
+func (p *fetchedTariffs) Tariffs(ctx context.Context, direction Direction, start, end time.Time) ([]TariffData, error) {
+	if start.Equal(p.start) && end.Equal(p.end) {
+		return p.tariffs[direction], nil
+	}
+	return p.TariffProvider.Tariffs(ctx, direction, start, end)
+}
+
+// add appends tariffs, skipping those already gathered from an earlier window, as a rate
+// spanning a window boundary is returned for both windows.
+func (p *fetchedTariffs) add(direction Direction, tariffs []TariffData) {
+	seen := make(map[string]bool)
+	for _, tariff := range p.tariffs[direction] {
+		seen[tariffKey(tariff)] = true
+	}
+	for _, tariff := range tariffs {
+		if key := tariffKey(tariff); !seen[key] {
+			seen[key] = true
+			p.tariffs[direction] = append(p.tariffs[direction], tariff)
+		}
+	}
+}
+
+// tariffKey identifies a tariff by its rates and validity.
+func tariffKey(tariff TariffData) string {
+	bound := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%g/%g/%s/%s", tariff.Rate, tariff.RateExcVat, bound(tariff.ValidFrom), bound(tariff.ValidTo))
+}
+
+// fetchOctopusAligned fetches the Octopus import and export consumption and both sides' tariffs
+// from start to end a window at a time, so each window's consumption and tariffs are requested
+// over the same dates, pricing each window's rows as they arrive. It returns the gathered
+// tariffs, to be used in place of fetching them again for the whole range.
+func (app *App) fetchOctopusAligned(ctx context.Context, usage UsageStore, start, end time.Time) (*fetchedTariffs, error) {
+	fetched := &fetchedTariffs{
+		TariffProvider: app.Tariffs,
+		start:          start,
+		end:            end,
+		tariffs:        make(map[Direction][]TariffData),
+	}
+
+	for windowStart := start; windowStart.Before(end); {
+		windowEnd := windowStart.AddDate(0, 0, alignedWindowDays)
+		if windowEnd.After(end) {
+			windowEnd = end
+		}
+		slog.Info("Getting Octopus data and tariffs", "start", windowStart.Format(time.RFC3339), "end", windowEnd.Format(time.RFC3339))
+
+		window := make(UsageStore)
+		err := app.OctopusService.GetMeterConsumption(ctx, window, app.ImportMeter, windowStart, windowEnd.UTC(), func(value float64, estimated bool, row *UsageRow) {
+			row.OCTO_ImportKWh = &value
+			row.OCTO_ImportEstimated = estimated
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to fetch Ocotopus data: %w", ErrPartialData, err)
+		}
+		if app.ExportMeter != nil {
+			err = app.OctopusService.GetMeterConsumption(ctx, window, app.ExportMeter, windowStart, windowEnd.UTC(), func(value float64, estimated bool, row *UsageRow) {
+				row.OCTO_ExportKWh = &value
+				row.OCTO_ExportEstimated = estimated
+			})
+			if err != nil {
+				return nil, fmt.Errorf("%w: failed to fetch Ocotopus data: %w", ErrPartialData, err)
+			}
+		}
+
+		importTariffs, err := app.Tariffs.Tariffs(ctx, DirectionImport, windowStart, windowEnd.UTC())
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to fetch import tariffs: %w", ErrPartialData, err)
+		}
+		exportTariffs, err := app.Tariffs.Tariffs(ctx, DirectionExport, windowStart, windowEnd.UTC())
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to fetch export tariffs: %w", ErrPartialData, err)
+		}
+		fetched.add(DirectionImport, importTariffs)
+		fetched.add(DirectionExport, exportTariffs)
+
+		importRates, exportRates := buildRateIndex(importTariffs), buildRateIndex(exportTariffs)
+		for timestamp, row := range window {
+			app.priceRow(timestamp, row, importRates, exportRates)
+			usage[timestamp] = row
+		}
+		windowStart = windowEnd
+	}
+	return fetched, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// octopusRangeRoundTripper serves half-hourly consumption and Agile style import rates for the
+// requested period, paged like the Octopus API, and a single export rate valid throughout.
+func octopusRangeRoundTripper(t *testing.T, requests *int) *MockRoundTripper {
+	return &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			*requests++
+			query := req.URL.Query()
+			from, err := time.Parse(time.RFC3339, query.Get("period_from"))
+			require.NoError(t, err)
+			to, err := time.Parse(time.RFC3339, query.Get("period_to"))
+			require.NoError(t, err)
+			page, _ := strconv.Atoi(query.Get("page"))
+			pageSize, _ := strconv.Atoi(query.Get("page_size"))
+			page = max(page, 1)
+
+			var results []string
+			switch {
+			case strings.Contains(req.URL.Path, "EXPORT"):
+				results = append(results, `{"value_inc_vat": 15, "value_exc_vat": 15, "valid_from": "2024-01-01T00:00:00Z", "valid_to": null}`)
+			case strings.Contains(req.URL.Path, "standard-unit-rates"):
+				for slot := from; slot.Before(to); slot = slot.Add(30 * time.Minute) {
+					rate := float64(slot.Unix()/1800%48) + 0.25
+					results = append(results, fmt.Sprintf(`{"value_inc_vat": %g, "value_exc_vat": %g, "valid_from": %q, "valid_to": %q}`,
+						rate, rate/1.05, slot.Format(time.RFC3339), slot.Add(30*time.Minute).Format(time.RFC3339)))
+				}
+			default:
+				consumption := 0.5
+				if strings.Contains(req.URL.Path, "EXP") {
+					consumption = 0.25
+				}
+				for slot := from; slot.Before(to); slot = slot.Add(30 * time.Minute) {
+					results = append(results, fmt.Sprintf(`{"interval_start": %q, "interval_end": %q, "consumption": %g}`,
+						slot.Format(time.RFC3339), slot.Add(30*time.Minute).Format(time.RFC3339), consumption))
+				}
+			}
+
+			first := min((page-1)*pageSize, len(results))
+			last := min(page*pageSize, len(results))
+			next := "null"
+			if last < len(results) {
+				next = `"https://api.octopus.energy/next"`
+			}
+			body := fmt.Sprintf(`{"count": %d, "next": %s, "results": [%s]}`, len(results), next, strings.Join(results[first:last], ","))
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(body))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+}
+
+func TestFetchOctopusAlignedMatchesSeparatePasses(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 20)
+
+	newApp := func(requests *int) *App {
+		service := NewOctopusService(octopusRangeRoundTripper(t, requests), "dummyApiKey")
+		importMeter := &MeterInfo{ProductCode: "AGILE-24-10-01", TariffCode: "E-1R-AGILE-24-10-01-M", Mpan: "IMP", SerialNumber: "1"}
+		exportMeter := &MeterInfo{ProductCode: "OUTGOING", TariffCode: "E-1R-OUTGOING-EXPORT-M", Mpan: "EXP", SerialNumber: "2"}
+		return &App{
+			Config:         &Config{},
+			OctopusService: service,
+			ImportMeter:    importMeter,
+			ExportMeter:    exportMeter,
+			Tariffs:        &OctopusTariffProvider{Service: service, ImportMeter: importMeter, ExportMeter: exportMeter},
+		}
+	}
+
+	var separateRequests int
+	separate := newApp(&separateRequests)
+	usage := make(UsageStore)
+	require.NoError(t, separate.OctopusService.GetMeterConsumption(context.Background(), usage, separate.ImportMeter, start, end, func(value float64, estimated bool, row *UsageRow) {
+		row.OCTO_ImportKWh = &value
+	}))
+	require.NoError(t, separate.OctopusService.GetMeterConsumption(context.Background(), usage, separate.ExportMeter, start, end, func(value float64, estimated bool, row *UsageRow) {
+		row.OCTO_ExportKWh = &value
+	}))
+	expected, err := separate.priceUsage(context.Background(), usage, start, end)
+	require.NoError(t, err)
+
+	var alignedRequests int
+	aligned := newApp(&alignedRequests)
+	usage = make(UsageStore)
+	fetched, err := aligned.fetchOctopusAligned(context.Background(), usage, start, end)
+	require.NoError(t, err)
+	require.Len(t, fetched.tariffs[DirectionExport], 1, "Expected the export rate spanning both windows gathered once")
+	require.Len(t, usage, 20*48)
+	for _, row := range usage {
+		require.NotNil(t, row.ImportPrice, "Expected rows priced as their window arrived, %s wasn't", row.Timestamp)
+	}
+
+	requestsBefore := alignedRequests
+	aligned.Tariffs = fetched
+	actual, err := aligned.priceUsage(context.Background(), usage, start, end)
+	require.NoError(t, err)
+	require.Equal(t, requestsBefore, alignedRequests, "Expected the gathered tariffs used rather than fetched again")
+
+	require.Equal(t, len(expected), len(actual))
+	for i := range expected {
+		require.Equal(t, expected[i].Timestamp, actual[i].Timestamp)
+		require.Equal(t, *expected[i].OCTO_ImportKWh, *actual[i].OCTO_ImportKWh)
+		require.Equal(t, *expected[i].OCTO_ExportKWh, *actual[i].OCTO_ExportKWh)
+		require.Equal(t, *expected[i].ImportPrice, *actual[i].ImportPrice, "Import price differs at %s", expected[i].Timestamp)
+		require.Equal(t, *expected[i].ImportPriceExcVat, *actual[i].ImportPriceExcVat)
+		require.Equal(t, *expected[i].ExportPrice, *actual[i].ExportPrice)
+	}
+}
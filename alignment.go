@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// maxAlignmentLag is how many slots either side of zero are tried when aligning sources.
+const maxAlignmentLag = 4
+
+// minAlignmentPairs is the fewest overlapping slots needed to trust a correlation.
+const minAlignmentPairs = 4
+
+// Alignment is the lag at which a source's import series best matches the reference source.
+type Alignment struct {
+	Source string
+	// Lag is how many slots the source trails the reference by, so shifting the source's
+	// timestamps back by Lag slots aligns it. Negative when the source leads.
+	Lag         int
+	Correlation float64
+	Pairs       int
+}
+
+// importSeries returns the import figures a source reported for each slot in data.
+func importSeries(data []*UsageRow, source string) map[time.Time]float64 {
+	series := make(map[time.Time]float64)
+	for _, row := range data {
+		if value := importFromSource(row, source); value != nil {
+			series[row.Timestamp] = *value
+		}
+	}
+	return series
+}
+
+// shiftSlots moves t by n slots of granularity g.
+func shiftSlots(t time.Time, n int, g Granularity) time.Time {
+	for ; n > 0; n-- {
+		t = g.next(t)
+	}
+	for ; n < 0; n++ {
+		t = g.prev(t)
+	}
+	return t
+}
+
+// alignSources cross-correlates each source's import series against the reference source's
+// over lags of up to maxLag slots, returning the best lag for each source that has enough
+// overlapping data. Ties go to the lag closest to zero.
+func alignSources(data []*UsageRow, reference string, sources []string, g Granularity, maxLag int) []Alignment {
+	ref := importSeries(data, reference)
+
+	var results []Alignment
+	for _, source := range sources {
+		if source == reference {
+			continue
+		}
+		other := importSeries(data, source)
+
+		var best *Alignment
+		for _, lag := range lagsByDistance(maxLag) {
+			var xs, ys []float64
+			for t, x := range ref {
+				if y, ok := other[shiftSlots(t, lag, g)]; ok {
+					xs = append(xs, x)
+					ys = append(ys, y)
+				}
+			}
+			if len(xs) < minAlignmentPairs {
+				continue
+			}
+			r, ok := correlation(xs, ys)
+			if !ok {
+				continue
+			}
+			if best == nil || r > best.Correlation {
+				best = &Alignment{Source: source, Lag: lag, Correlation: r, Pairs: len(xs)}
+			}
+		}
+		if best != nil {
+			results = append(results, *best)
+		}
+	}
+	return results
+}
+
+// lagsByDistance returns the lags from -maxLag to maxLag ordered by distance from zero.
+func lagsByDistance(maxLag int) []int {
+	lags := []int{0}
+	for i := 1; i <= maxLag; i++ {
+		lags = append(lags, -i, i)
+	}
+	return lags
+}
+
+// correlation returns the Pearson correlation coefficient of xs and ys, which must be the
+// same length. It is not defined when either series is constant.
+func correlation(xs, ys []float64) (float64, bool) {
+	n := float64(len(xs))
+	var sumX, sumY float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var cov, varX, varY float64
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return 0, false
+	}
+	return cov / math.Sqrt(varX*varY), true
+}
+
+// logAlignment reports the best lag for each source and the timestamp offset that would correct it.
+func logAlignment(reference string, results []Alignment, g Granularity) {
+	if len(results) == 0 {
+		slog.Info("Alignment: not enough overlapping data to align sources", "reference", reference)
+		return
+	}
+	for _, result := range results {
+		if result.Lag == 0 {
+			slog.Info("Alignment: source is aligned", "source", result.Source, "reference", reference,
+				"r", fmt.Sprintf("%.3f", result.Correlation), "slots", result.Pairs)
+			continue
+		}
+		slog.Info("Alignment: source best matches at a lag", "source", result.Source, "reference", reference,
+			"lagSlots", result.Lag, "r", fmt.Sprintf("%.3f", result.Correlation), "slots", result.Pairs,
+			"suggestedOffset", fmt.Sprintf("%+d %s slots", -result.Lag, granularityName(g)))
+	}
+}
+
+// granularityName returns g for display, naming the zero value as half-hourly.
+func granularityName(g Granularity) Granularity {
+	if g == "" {
+		return GranularityHalfHour
+	}
+	return g
+}
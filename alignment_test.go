@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlignSourcesDetectsShift(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	usage := func(i int) float64 { return 0.5 + 0.4*math.Sin(float64(i)/3) + 0.1*float64(i%5) }
+
+	var data []*UsageRow
+	for i := 0; i < 48; i++ {
+		data = append(data, &UsageRow{
+			Timestamp:      start.Add(time.Duration(i) * 30 * time.Minute),
+			OCTO_ImportKWh: floatPtr(usage(i)),
+			// GivEnergy reports each slot's usage one slot late
+			GE_ImportKWh: floatPtr(usage(i - 1)),
+			// Geo reports each slot's usage two slots early
+			GEO_ImportWh: func() *int64 { wh := int64(math.Round(usage(i+2) * 1000)); return &wh }(),
+		})
+	}
+
+	results := alignSources(data, SourceOctopus, []string{SourceGeo, SourceGivEnergy}, GranularityHalfHour, maxAlignmentLag)
+	require.Len(t, results, 2)
+
+	require.Equal(t, SourceGeo, results[0].Source)
+	require.Equal(t, -2, results[0].Lag)
+	require.InDelta(t, 1, results[0].Correlation, 0.001)
+
+	require.Equal(t, SourceGivEnergy, results[1].Source)
+	require.Equal(t, 1, results[1].Lag)
+	require.InDelta(t, 1, results[1].Correlation, 0.001)
+}
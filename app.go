@@ -2,27 +2,222 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"slices"
 	"sort"
+	"strings"
 	"time"
 )
 
 // Config contains configuration for the application.
 type Config struct {
-	APIKey         string
-	GivAPIKey      string
-	AccountID      string
-	SerialNumber   string
+	APIKey    string
+	GivAPIKey string
+	AccountID string
+	// SerialNumbers are the GivEnergy inverters, whose figures are summed.
+	SerialNumbers  []string
 	OutputCSV      string
 	CacheDirectory string
 	GeoUsername    string
 	GeoPassword    string
 	StartTime      *time.Time
 	EndTime        time.Time
+
+	// ImportStandingCharge is the daily import standing charge in pence.
+	ImportStandingCharge float64
+	// UseGivMeterRegister takes GivEnergy grid export from the inverter's meter register.
+	UseGivMeterRegister bool
+	// GivFreshness is how old the latest GivEnergy data point may be before interpolation stops at it.
+	GivFreshness time.Duration
+	// TrimToRange drops rows outside the requested range rather than just those before it.
+	TrimToRange bool
+	// ImportPriority is the order of sources used to pick the import figure for pricing.
+	ImportPriority []string
+	// IncludeImportSource adds a CSV column recording the chosen import source.
+	IncludeImportSource bool
+	// CostSource is the preferred source for the canonical Import_Cost/Export_Cost columns,
+	// falling back through ImportPriority when it has no figure for a slot. Empty disables the columns.
+	CostSource string
+	// PriceCaps clamp import unit rates and standing charges over their date ranges.
+	PriceCaps []PriceCap
+	// IncludeExcVat adds ex-VAT price and cost columns alongside the inc-VAT ones.
+	IncludeExcVat bool
+	// ReconcileTolerance is the kWh difference between GivEnergy and Octopus import treated as matching.
+	ReconcileTolerance float64
+	// Granularity is the width of each output row; prices are taken at the start of each row.
+	Granularity Granularity
+	// FailOnGaps makes Run return ErrGapsDetected when the output has slots with no Octopus import data.
+	FailOnGaps bool
+	// MergeExisting is a prior CSV whose rows are kept where this run has none for the timestamp.
+	MergeExisting string
+	// IncludeLive appends a row with the current Geo live power after the historical rows.
+	IncludeLive bool
+	// ExcludeEstimates drops Octopus readings flagged as estimated, leaving those slots as gaps.
+	ExcludeEstimates bool
+	// CostsCSV, when set, is a second output with only the timestamp, price and cost columns.
+	CostsCSV string
+	// CheckpointFile, when set, saves fetch progress so a failed run can resume where it stopped.
+	CheckpointFile string
+	// AlignmentDiagnostics reports the slot lag that best aligns the Geo and GivEnergy import with Octopus.
+	AlignmentDiagnostics bool
+	// OnlyGaps writes only the rows missing at least one expected source value.
+	OnlyGaps bool
+	// FloatFormat selects fixed, scientific or general float formatting per CSV column group.
+	FloatFormat FloatFormat
+	// Precision selects the digits after the decimal point per CSV column group.
+	Precision Precision
+	// GeoAccounts are additional Geo logins output as rows tagged with their label alongside
+	// the main account's rows, which are tagged with AccountID.
+	GeoAccounts []GeoAccount
+	// ClampToAvailable narrows the start and end to the Octopus import readings available.
+	ClampToAvailable bool
+	// StandingChargePlacement controls how the standing charge appears in the per-slot output,
+	// by default only in the summary.
+	StandingChargePlacement StandingChargePlacement
+	// ExpectedImportKWh is the billed import the summed Octopus import is validated against, nil to skip.
+	ExpectedImportKWh *float64
+	// ExpectedImportTolerance is the kWh difference from ExpectedImportKWh treated as matching.
+	ExpectedImportTolerance float64
+	// Proxy overrides the HTTP proxy for all requests. When nil HTTP_PROXY/HTTPS_PROXY are honoured.
+	Proxy *url.URL
+	// RegisterReads adds the cumulative Octopus import register readings, where the meter exposes them.
+	RegisterReads bool
+	// ForceRefresh ignores cached responses for this run while still caching the fresh ones.
+	ForceRefresh bool
+	// FillExportFromGivEnergy fills Octopus export gaps with the GivEnergy export, flagged as estimated.
+	FillExportFromGivEnergy bool
+	// UserAgent is sent on every request, defaultUserAgent when empty.
+	UserAgent string
+	// TariffNames adds columns with the friendly import and export tariff names.
+	TariffNames bool
+	// SplitImportExport writes the import and export columns to separate CSVs instead of one.
+	SplitImportExport bool
+	// CacheReadDirs are read-only caches checked after CacheDirectory, which alone receives new responses.
+	CacheReadDirs []string
+	// TimestampFormat selects the Timestamp column format, RFC3339 by default.
+	TimestampFormat TimestampFormat
+	// CostReconcileTolerance is the daily pence difference between the GivEnergy and Octopus
+	// import costs treated as matching.
+	CostReconcileTolerance float64
+	// CompareRegions are the regions whose import product unit rates the import is also priced at.
+	CompareRegions []string
+	// GeoTokenTTL is how long a Geo login is cached on disk and reused for, 0 to log in every run.
+	GeoTokenTTL time.Duration
+	// RoundingMode rounds costs to hundredths of a penny, half-up by default.
+	RoundingMode RoundingMode
+	// ValidateOutput reads each CSV back once written, failing the run if it is malformed.
+	ValidateOutput bool
+	// PrintConfig prints the resolved configuration and exits rather than running.
+	PrintConfig bool
+	// CarbonIntensity adds the import meter region's half-hourly carbon intensity and import emissions.
+	CarbonIntensity bool
+	// BatteryArbitrage logs each day's battery charging cost against the value of its discharge.
+	BatteryArbitrage bool
+	// SlotOffset moves every source's slot boundaries past the hour and half-hour, e.g. 5m for :05/:35.
+	SlotOffset time.Duration
+	// TrimToCompleteDay drops rows after the latest day every source has fully reported.
+	TrimToCompleteDay bool
+	// AlignStartToMidnight moves a configured StartTime back to the first slot of its local
+	// day, as a start derived from the latest Octopus reading always is.
+	AlignStartToMidnight bool
+	// ManualTariffFile is a JSON schedule of daily rate windows to price against instead of the Octopus tariffs.
+	ManualTariffFile string
+	// FlushWindow, when set, fetches and writes the output a day or month at a time to bound memory.
+	FlushWindow FlushWindow
+	// StartupJitter bounds a random wait before the first request, so scheduled runs don't all start at once.
+	StartupJitter time.Duration
+	// RequestJitter bounds a random pause between the paged Octopus and GivEnergy requests.
+	RequestJitter time.Duration
+	// EnergyBalance adds a column checking the GivEnergy solar, grid, battery and load figures balance.
+	EnergyBalance bool
+	// ImportDelta adds columns comparing the Octopus and GivEnergy import of each slot.
+	ImportDelta bool
+	// ExtraHeaders are added to every outgoing request, e.g. for an API gateway, without
+	// replacing the authentication headers.
+	ExtraHeaders map[string]string
+	// FillMode selects how gaps in each source's usage are written: left as NaN, interpolated or
+	// forward filled.
+	FillMode FillMode
+	// AlignedOctopusFetch fetches the Octopus consumption and tariffs together over the same
+	// two week windows, rather than in separate passes over the whole range.
+	AlignedOctopusFetch bool
+	// ValidateSerial checks at startup that each of SerialNumbers is one of the GivEnergy account's inverters.
+	ValidateSerial bool
+	// SettlementLag is how far EndTime was pulled back from now, when not given, to leave out
+	// recent data Octopus hasn't settled.
+	SettlementLag time.Duration
+	// OutputFormat selects a row per slot or, when tidy, a timestamp, metric, value and source
+	// row per figure.
+	OutputFormat OutputFormat
+	// GeoAPIVersion selects the Geo readings and systems endpoint version, or auto to switch to
+	// v3 when v1 isn't found.
+	GeoAPIVersion GeoAPIVersion
+	// BackfillMissingOnly tops up the existing output, fetching each source only over the
+	// windows it is missing from.
+	BackfillMissingOnly bool
+	// TariffHistoryFile, when set, is written with each distinct import, export and standing
+	// charge rate window over the range.
+	TariffHistoryFile string
+	// AccountConcurrency is how many GeoAccounts are fetched at once.
+	AccountConcurrency int
+	// AccountsContinueOnError writes the accounts that succeeded when others fail, returning
+	// the failure once the output is written, rather than failing before writing anything.
+	AccountsContinueOnError bool
+	// ExplainTime, when set, prints how the slot containing it is priced instead of running.
+	ExplainTime *time.Time
+	// RetryProfile names the preset Retries, RetryBackoff, RequestTimeout, HTTPTimeout,
+	// AccountConcurrency and GivConcurrency were taken from where not set individually.
+	RetryProfile string
+	// Retries is how many times a GET answered by 429 Too Many Requests or a server error is retried.
+	Retries int
+	// RetryBackoff is the wait before the first retry, doubling for each further retry, when
+	// the response has no Retry-After header.
+	RetryBackoff time.Duration
+	// RequestTimeout bounds the wait for each response's headers, 0 waiting indefinitely. It
+	// only has an effect below HTTPTimeout, failing a response that's slow to start sooner.
+	RequestTimeout time.Duration
+	// HTTPTimeout bounds each attempt, from sending it to reading the whole response, 0
+	// waiting indefinitely.
+	HTTPTimeout time.Duration
+	// CacheTTL, when set, refetches cached responses older than it. Zero never expires them.
+	CacheTTL time.Duration
+	// GivConcurrency is how many days of GivEnergy inverter data are fetched at once.
+	GivConcurrency int
+	// Interval, when set, reruns the collection this often until interrupted, each run resuming
+	// from the oldest recent slot still missing Octopus data, or the last slot written, and
+	// rewriting only the output's rows from there.
+	Interval time.Duration
+	// Append adds the rows after the last timestamp already in OutputCSV to it rather than
+	// replacing it.
+	Append bool
+	// MetricsAddr, when set, is the address a Prometheus metrics endpoint is served on.
+	MetricsAddr string
+	// MQTTBroker, when set, is the MQTT broker rows are published to under MQTTTopic.
+	MQTTBroker string
+	MQTTTopic  string
+	// LogFormat selects plain text or JSON log records.
+	LogFormat LogFormat
+	// LogLevel drops log records below it.
+	LogLevel slog.Level
+	// Location, when set, is the time zone days are bucketed in and timestamps written in,
+	// rather than local time.
+	Location *time.Location
+	// GeoExport adds the Geo export and generation columns, for meters that report them.
+	GeoExport bool
+	// GasUnits is the unit the Octopus gas meter reports in, GasUnitsM3 or GasUnitsKWh.
+	GasUnits string
+	// GasCalorificValue is the calorific value in MJ/m³ gas volumes are converted to kWh at.
+	GasCalorificValue float64
+	// SQLitePath, when set, is a SQLite database the rows are upserted into, keyed on their
+	// timestamp. OutputCSV may then be empty to write only the database.
+	SQLitePath string
 }
 
 // App manages application dependencies and logic.
@@ -36,11 +231,46 @@ type App struct {
 	ExportMeter     *MeterInfo
 	CollectionStart time.Time
 	GeoService      *GeoTogetherService
+
+	// Further Geo accounts and those that couldn't be logged in to, by label
+	GeoAccounts       []GeoAccountService
+	FailedGeoAccounts []string
+
+	// Pricing: the unit rates, Octopus's unless a manual schedule is configured, and the import
+	// tariff's daily standing charges
+	Tariffs         TariffProvider
+	StandingCharges []TariffData
+
+	// Where each run's rows are published besides the output, when set
+	Metrics *Metrics
+	MQTT    *MQTTSink
+
+	// Daemon state: where the next run resumes from, see resumeFrom, and whether runs write
+	// over the output's rows from their first on rather than rewriting the whole file
+	ResumeFrom time.Time
+	tailOutput bool
 }
 
-func NewApp(config *Config) *App {
-	rt := http.DefaultTransport
+func NewApp(config *Config) (*App, error) {
+	if wait := randomJitter(config.StartupJitter); wait > 0 {
+		slog.Info("Waiting before starting", "wait", wait.Round(time.Millisecond))
+		jitterSleep(wait)
+	}
+
+	// Each attempt, cached or not, is bounded by HTTPTimeout, including the go-openapi clients
+	// the services build on rt and the Octopus register requests
+	base := withTimeout(withHeaders(withUserAgent(baseTransport(config.Proxy, config.RequestTimeout), config.UserAgent), config.ExtraHeaders), config.HTTPTimeout)
+	var rt http.RoundTripper = &RetryingRoundTripper{
+		Next:    base,
+		Retries: config.Retries,
+		Backoff: config.RetryBackoff,
+	}
+	if config.Proxy != nil {
+		slog.Info("Using HTTP proxy", "proxy", config.Proxy.Redacted())
+	}
 
+	// Geo tokens are kept alongside the HTTP cache when there is one
+	geoTokenDir := os.TempDir()
 	if config.CacheDirectory != "disable" {
 		cacheDir := config.CacheDirectory
 		if cacheDir == "" {
@@ -48,49 +278,113 @@ func NewApp(config *Config) *App {
 		}
 		err := os.MkdirAll(cacheDir, 0755)
 		if err != nil {
-			log.Fatalf("failed to create cache dir: %v", err)
+			return nil, fmt.Errorf("%w: failed to create cache dir: %w", ErrConfig, err)
 		}
 
 		rt = &CachingRoundTripper{
-			UnderlyingTransport: http.DefaultTransport, CacheDir: path.Clean(cacheDir),
+			UnderlyingTransport: rt, CacheDir: path.Clean(cacheDir), ReadDirs: config.CacheReadDirs,
+			ForceRefresh: config.ForceRefresh, TTL: config.CacheTTL,
 		}
 
-		log.Printf("HTTP caching enabled in directory: %s", cacheDir)
+		geoTokenDir = cacheDir
+		slog.Info("HTTP caching enabled", "dir", cacheDir)
 	} else {
-		log.Println("HTTP caching disabled")
+		slog.Info("HTTP caching disabled")
 	}
 
 	// Initialize services
 	givService := NewGivEnergyService(rt, config.GivAPIKey)
+	// The shared transport already retries server errors
+	givService.SetRetries(0, 0)
+	givService.UseMeterRegister = config.UseGivMeterRegister
+	givService.FreshnessThreshold = config.GivFreshness
+	givService.Granularity = config.Granularity
+	givService.SlotOffset = config.SlotOffset
+	givService.Location = config.Location
+	givService.RequestJitter = config.RequestJitter
+	givService.Concurrency = config.GivConcurrency
+	if config.ValidateSerial {
+		for _, serial := range config.SerialNumbers {
+			if err := givService.ValidateSerial(serial); err != nil {
+				return nil, err
+			}
+		}
+	}
 	octopusService := NewOctopusService(rt, config.APIKey)
+	octopusService.Granularity = config.Granularity
+	octopusService.SlotOffset = config.SlotOffset
+	octopusService.Location = config.Location
+	octopusService.RequestJitter = config.RequestJitter
+	octopusService.ExcludeEstimates = config.ExcludeEstimates
+	octopusService.RegisterTransport = base
 
 	// Fetch meter and tariff details
 	importMeter, exportMeter, gasMeter, err := octopusService.GetMetersAndTariff(config.AccountID)
 	if err != nil {
-		log.Fatalf("Failed to get meter and tariff details: %v", err)
+		return nil, fmt.Errorf("failed to get meter and tariff details: %w", err)
+	}
+
+	if exportMeter == nil {
+		slog.Info("No export meter so export won't be fetched", "importTariff", importMeter.DisplayName, "importTariffCode", importMeter.TariffCode)
+	} else {
+		slog.Info("Tariffs", "importTariff", importMeter.DisplayName, "importTariffCode", importMeter.TariffCode,
+			"exportTariff", exportMeter.DisplayName, "exportTariffCode", exportMeter.TariffCode)
 	}
 
 	// Determine collection start
-	var collectionStart time.Time
-	if config.StartTime == nil {
-		log.Println("Querying latest reading from Octopus...")
+	collectionStart, err := resolveCollectionStart(*config, func() (time.Time, error) {
+		slog.Info("Querying latest reading from Octopus...")
 		lastReadingDate, lastReadingValue, err := octopusService.GetLastReading(importMeter)
 		if err != nil {
-			log.Fatalf("Failed to get last reading: %v", err)
-		}
-		collectionStart = truncateToMidnight(lastReadingDate.Add(-30 * time.Minute))
-		log.Printf("Latest reading %s with value %.4f kWh\n",
-			lastReadingDate.Format(time.RFC3339),
-			lastReadingValue)
-		log.Printf("Beginning query from %s\n",
-			collectionStart.Format(time.RFC3339))
-	} else {
-		collectionStart = *config.StartTime
+			return time.Time{}, fmt.Errorf("failed to get last reading: %w", err)
+		}
+		slog.Info("Latest reading", "time", lastReadingDate.Format(time.RFC3339), "kWh", lastReadingValue)
+		return lastReadingDate, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if config.StartTime == nil {
+		slog.Info("Beginning query", "start", collectionStart.Format(time.RFC3339))
 	}
 
-	geoService, err := NewGeoTogetherService(rt, config.GeoUsername, config.GeoPassword)
+	if config.ClampToAvailable {
+		collectionStart, config.EndTime, err = octopusService.ClampToAvailable(importMeter, collectionStart, config.EndTime)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var geoTokens *GeoTokenCache
+	if config.GeoTokenTTL > 0 {
+		geoTokens = &GeoTokenCache{Dir: geoTokenDir, TTL: config.GeoTokenTTL}
+	}
+
+	geoService, err := NewGeoTogetherService(rt, config.GeoUsername, config.GeoPassword, geoTokens)
 	if err != nil {
-		log.Fatalf("Failed to initialize GeoTogether service: %v", err)
+		return nil, fmt.Errorf("failed to initialize GeoTogether service: %w", err)
+	}
+	geoService.Granularity = config.Granularity
+	geoService.SlotOffset = config.SlotOffset
+	geoService.Location = config.Location
+	geoService.APIVersion = config.GeoAPIVersion
+
+	geoAccounts, failedGeoAccounts := newGeoAccountServices(rt, config.GeoAccounts, config.Granularity, geoTokens)
+	for _, account := range geoAccounts {
+		account.Service.SlotOffset = config.SlotOffset
+		account.Service.Location = config.Location
+		account.Service.APIVersion = config.GeoAPIVersion
+	}
+
+	var tariffs TariffProvider = &OctopusTariffProvider{Service: octopusService, ImportMeter: importMeter, ExportMeter: exportMeter}
+	if config.ManualTariffFile != "" {
+		manual, err := LoadManualTariffProvider(config.ManualTariffFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to load manual tariffs: %w", ErrConfig, err)
+		}
+		manual.Location = config.Location
+		slog.Info("Pricing against the manual tariffs", "file", config.ManualTariffFile)
+		tariffs = manual
 	}
 
 	return &App{
@@ -103,94 +397,681 @@ func NewApp(config *Config) *App {
 		ExportMeter:     exportMeter,
 		CollectionStart: collectionStart,
 		GeoService:      geoService,
+
+		GeoAccounts:       geoAccounts,
+		FailedGeoAccounts: failedGeoAccounts,
+		Tariffs:           tariffs,
+	}, nil
+}
+
+// Run collects the configured range and writes it out, or with an Interval keeps collecting
+// until ctx is cancelled.
+func (app *App) Run(ctx context.Context) error {
+	if app.Config.Interval > 0 {
+		return app.runDaemon(ctx)
 	}
+	return app.runOnce(ctx)
 }
 
-func (app *App) Run() error {
-	log.Println("Starting application...")
-	log.Printf("Using date range %s - %s", app.CollectionStart.Format(time.RFC3339), app.Config.EndTime.Format(time.RFC3339))
+// runOnce collects the range from CollectionStart to EndTime and writes it out.
+func (app *App) runOnce(ctx context.Context) error {
+	slog.Info("Starting application...")
+	slog.Info("Using date range", "start", app.CollectionStart.Format(time.RFC3339), "end", app.Config.EndTime.Format(time.RFC3339))
+	if err := app.fetchStandingCharges(ctx, app.CollectionStart, app.Config.EndTime.UTC()); err != nil {
+		return err
+	}
+	if app.Config.FlushWindow != FlushNone {
+		return app.runWindowed(ctx)
+	}
+	if app.Config.BackfillMissingOnly {
+		return app.runBackfill(ctx)
+	}
 
-	usage := make(map[time.Time]*UsageRow)
+	usage := make(UsageStore)
 	var err error
 
-	// Get data from geo
-	log.Println("Getting Octopus data...")
-	err = app.OctopusService.GetMeterConsumption(usage, app.ImportMeter, app.CollectionStart, app.Config.EndTime.UTC(), func(value float64, row *UsageRow) {
-		row.OCTO_ImportKWh = &value
-	})
+	var checkpoint *Checkpoint
+	if app.Config.CheckpointFile != "" {
+		checkpoint, err = LoadCheckpoint(app.Config.CheckpointFile, usage, app.CollectionStart, app.Config.EndTime, app.Config.Location)
+		if err != nil {
+			return err
+		}
+		app.OctopusService.Checkpoint = checkpoint
+		app.GivService.Checkpoint = checkpoint
+	}
+
+	if app.Config.AlignedOctopusFetch {
+		fetched, err := app.fetchOctopusAligned(ctx, usage, app.CollectionStart, app.Config.EndTime)
+		if err != nil {
+			return err
+		}
+		app.Tariffs = fetched
+	}
+
+	// fetch runs a source's fetch unless the checkpoint shows it has already completed
+	fetch := func(source string, f func() error) error {
+		if app.Config.AlignedOctopusFetch && (source == "Octopus import" || source == "Octopus export") {
+			return nil
+		}
+		if checkpoint.Done(source) {
+			slog.Info("Skipping source already fetched before the checkpoint", "source", source)
+			return nil
+		}
+		if err := f(); err != nil {
+			return err
+		}
+		return checkpoint.Complete(source)
+	}
+
+	if err := app.fetchSources(ctx, usage, app.CollectionStart, app.Config.EndTime, fetch); err != nil {
+		return err
+	}
+
+	data, err := app.priceUsage(ctx, usage, app.CollectionStart, app.Config.EndTime)
 	if err != nil {
-		return fmt.Errorf("failed to fetch Ocotopus data: %w", err)
+		return err
 	}
+	costPriority := withPrimary(app.Config.CostSource, app.Config.ImportPriority)
 
-	err = app.OctopusService.GetMeterConsumption(usage, app.ExportMeter, app.CollectionStart, app.Config.EndTime.UTC(), func(value float64, row *UsageRow) {
-		row.OCTO_ExportKWh = &value
+	// GivEnergy timestamps are shifted back a slot, leaving a row before the range holding just
+	// the baseline counters. It's dropped so it can't replace a complete row when merged
+	if app.Config.TrimToRange {
+		data = filterRange(data, app.CollectionStart, app.Config.EndTime)
+	} else {
+		data = slices.DeleteFunc(data, func(row *UsageRow) bool { return row.Timestamp.Before(app.CollectionStart) })
+	}
+
+	completeDay, complete := latestCompleteDay(data, DefaultImportPriority, app.Config.Granularity, app.Config.Location)
+	if complete {
+		slog.Info("Latest day complete across all sources", "day", completeDay.Format(time.DateOnly))
+	} else {
+		slog.Info("No day is complete across all sources")
+	}
+	if app.Config.TrimToCompleteDay && complete {
+		dayEnd := completeDay.AddDate(0, 0, 1)
+		data = slices.DeleteFunc(data, func(row *UsageRow) bool { return !row.Timestamp.Before(dayEnd) })
+	}
+
+	if app.Config.MergeExisting != "" {
+		existing, err := readCSV(app.Config.MergeExisting, app.Config.Location)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to read existing CSV: %w", err)
+		}
+		// Drop the previous live snapshot, it's superseded by this run
+		existing = slices.DeleteFunc(existing, func(row *UsageRow) bool { return row.Tag == TagLive })
+		for _, row := range existing {
+			selectCostFigures(row, costPriority)
+		}
+		slog.Info("Merging rows into existing rows", "rows", len(data), "existing", len(existing), "file", app.Config.MergeExisting)
+		data = mergeRows(existing, data)
+	}
+
+	// Write CSV output
+	csvOptions := app.csvOptions()
+	rows := data
+	if app.Config.OnlyGaps {
+		rows = gapRows(data, app.Config.Granularity)
+		slog.Info("Writing rows with a missing source value", "rows", len(rows), "of", len(data))
+	}
+	rows = fillGaps(rows, app.Config.FillMode)
+	rows = placeStandingCharge(rows, app.Config.StandingChargePlacement, app.standingCharge, app.Config.Granularity, app.Config.Location)
+	failedAccounts := app.FailedGeoAccounts
+	if len(app.Config.GeoAccounts) > 0 {
+		accountRows, results := fetchGeoAccounts(ctx, app.GeoAccounts, app.CollectionStart, app.Config.EndTime.UTC(), app.Config.AccountConcurrency)
+		logGeoAccountSummary(results, app.FailedGeoAccounts)
+		failedAccounts = append(slices.Clip(failedAccounts), failedGeoAccounts(results)...)
+		if len(failedAccounts) > 0 && !app.Config.AccountsContinueOnError {
+			return fmt.Errorf("%w: failed to fetch Geo accounts %s", ErrPartialData, strings.Join(failedAccounts, ", "))
+		}
+		rows = mergeAccountRows(rows, filterRange(accountRows, app.CollectionStart, app.Config.EndTime), app.Config.AccountID)
+	}
+	if app.Config.IncludeLive {
+		if live := app.liveRow(); live != nil {
+			rows = append(slices.Clip(rows), live)
+		}
+	}
+	if app.Config.SQLitePath != "" {
+		// The live snapshot would overwrite its slot's figures
+		sqliteRows := slices.DeleteFunc(slices.Clone(rows), func(row *UsageRow) bool { return row.Tag == TagLive })
+		if err := writeSQLite(app.Config.SQLitePath, sqliteRows, csvOptions); err != nil {
+			return fmt.Errorf("failed to write SQLite: %w", err)
+		}
+		slog.Info("Wrote rows to SQLite", "rows", len(sqliteRows), "file", app.Config.SQLitePath)
+	}
+	if app.Config.OutputCSV == "" {
+		slog.Info("No output CSV configured, not writing CSV")
+	} else if app.Config.OnlyGaps && len(rows) == 0 {
+		slog.Info("No rows with missing source values, not writing CSV")
+	} else {
+		if app.Config.SplitImportExport {
+			if err := writeSplitCSV(app.Config.OutputCSV, rows, csvOptions); err != nil {
+				return fmt.Errorf("failed to write CSV: %w", err)
+			}
+			slog.Info("Wrote CSVs", "import", splitFilename(app.Config.OutputCSV, DirectionImport),
+				"export", splitFilename(app.Config.OutputCSV, DirectionExport))
+		} else {
+			write := writeCSV
+			if app.Config.Append {
+				write = appendCSV
+			} else if app.tailOutput {
+				write = func(filename string, rows []*UsageRow, opts CSVOptions) error {
+					return replaceCSVTail(filename, rows, opts, func(row *UsageRow) { selectCostFigures(row, costPriority) })
+				}
+			}
+			if err := write(app.Config.OutputCSV, rows, csvOptions); err != nil {
+				return fmt.Errorf("failed to write CSV: %w", err)
+			}
+			slog.Info("Wrote CSV", "file", app.Config.OutputCSV)
+		}
+	}
+
+	if len(data) > 0 {
+		app.ResumeFrom = resumeFrom(data, app.CollectionStart)
+	}
+
+	if err := checkpoint.Remove(); err != nil {
+		slog.Warn("failed to remove checkpoint", "err", err)
+	}
+
+	if app.Config.CostsCSV != "" {
+		csvOptions.CostsOnly = true
+		costRows := placeStandingCharge(data, app.Config.StandingChargePlacement, app.standingCharge, app.Config.Granularity, app.Config.Location)
+		if err := writeCSV(app.Config.CostsCSV, costRows, csvOptions); err != nil {
+			return fmt.Errorf("failed to write costs CSV: %w", err)
+		}
+		slog.Info("Wrote costs CSV", "file", app.Config.CostsCSV)
+	}
+
+	if app.Config.TariffHistoryFile != "" {
+		if err := app.writeTariffHistory(ctx, app.Config.TariffHistoryFile, app.CollectionStart, app.Config.EndTime); err != nil {
+			return fmt.Errorf("failed to write tariff history: %w", err)
+		}
+		slog.Info("Wrote tariff history", "file", app.Config.TariffHistoryFile)
+	}
+
+	if app.Metrics != nil {
+		app.Metrics.Update(data, app.Config.RoundingMode)
+	}
+	if app.MQTT != nil {
+		// The outputs are written, so a broker problem shouldn't fail the run
+		if err := app.MQTT.Publish(data); err != nil {
+			slog.Warn("MQTT publishing failed", "err", err)
+		} else {
+			slog.Info("Published rows to MQTT", "rows", len(data))
+		}
+	}
+	logSummary(summarise(data, app.standingCharge, app.Config.Location))
+	logReconcileReport(reconcileImport(data, app.Config.ReconcileTolerance), app.Config.ReconcileTolerance)
+	logCostReconciliation(reconcileCost(data, app.Config.CostReconcileTolerance, app.Config.RoundingMode, app.Config.Location), app.Config.CostReconcileTolerance)
+	if len(app.Config.CompareRegions) > 0 {
+		regionTariffs, err := app.fetchRegionTariffs(ctx, app.Config.CompareRegions, app.CollectionStart, app.Config.EndTime.UTC())
+		if err != nil {
+			return fmt.Errorf("%w: failed to compare regions: %w", ErrPartialData, err)
+		}
+		logRegionComparison(app.ImportMeter.ProductCode, regionCosts(data, regionTariffs))
+	}
+	if app.Config.BatteryArbitrage {
+		logBatteryArbitrage(batteryArbitrage(data, app.Config.RoundingMode, app.Config.Location))
+	}
+	if app.Config.ExpectedImportKWh != nil {
+		tolerance := app.Config.ExpectedImportTolerance
+		logTotalCheck(validateTotal(data, *app.Config.ExpectedImportKWh, tolerance), tolerance)
+	}
+	if app.Config.AlignmentDiagnostics {
+		alignments := alignSources(data, SourceOctopus, []string{SourceGeo, SourceGivEnergy}, app.Config.Granularity, maxAlignmentLag)
+		logAlignment(SourceOctopus, alignments, app.Config.Granularity)
+	}
+
+	gaps := findGaps(data, app.Config.Granularity)
+	logGaps(gaps)
+	if app.Config.FailOnGaps && len(gaps) > 0 {
+		return fmt.Errorf("%w: %d slots with no Octopus import data", ErrGapsDetected, len(gaps))
+	}
+	if len(failedAccounts) > 0 {
+		return fmt.Errorf("%w: failed to fetch Geo accounts %s", ErrPartialData, strings.Join(failedAccounts, ", "))
+	}
+
+	return nil
+}
+
+// baseTransport makes the transport requests go out on, replaced in tests.
+var baseTransport = newTransport
+
+// newTransport returns the transport used for all requests. http.DefaultTransport already
+// honours HTTP_PROXY/HTTPS_PROXY/NO_PROXY; proxy, when set, replaces them. timeout, when set,
+// bounds the wait for each response's headers.
+func newTransport(proxy *url.URL, timeout time.Duration) http.RoundTripper {
+	if proxy == nil && timeout == 0 {
+		return http.DefaultTransport
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	transport.ResponseHeaderTimeout = timeout
+	return transport
+}
+
+// userAgentTransport sets the User-Agent header on every request.
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.base.RoundTrip(req)
+}
+
+// withUserAgent wraps rt to send userAgent, or defaultUserAgent when empty, on every request.
+func withUserAgent(rt http.RoundTripper, userAgent string) http.RoundTripper {
+	if userAgent == "" {
+		userAgent = defaultUserAgent()
+	}
+	return &userAgentTransport{base: rt, userAgent: userAgent}
+}
+
+// headerTransport adds extra headers to every request, leaving any the request already has,
+// such as its authentication, untouched.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		if req.Header.Get(name) == "" {
+			req.Header.Set(name, value)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// withHeaders wraps rt to add headers to every request, returning rt as is when there are none.
+func withHeaders(rt http.RoundTripper, headers map[string]string) http.RoundTripper {
+	if len(headers) == 0 {
+		return rt
+	}
+	return &headerTransport{base: rt, headers: headers}
+}
+
+// parseHeaders parses a comma separated list of Name:value headers.
+func parseHeaders(value string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, headerValue, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header %q, expected Name:value", entry)
+		}
+		headers[http.CanonicalHeaderKey(name)] = strings.TrimSpace(headerValue)
+	}
+	return headers, nil
+}
+
+// defaultUserAgent identifies the tool and its version.
+func defaultUserAgent() string {
+	return "givenergy-octopus-gaps/" + version
+}
+
+// fetchSources fetches every source's readings from start to end into usage, running each
+// source's fetch through fetch.
+func (app *App) fetchSources(ctx context.Context, usage UsageStore, start, end time.Time, fetch func(source string, f func() error) error) error {
+	// Get data from geo
+	slog.Info("Getting Octopus data...")
+	err := fetch("Octopus import", func() error {
+		return app.OctopusService.GetMeterConsumption(ctx, usage, app.ImportMeter, start, end.UTC(), func(value float64, estimated bool, row *UsageRow) {
+			row.OCTO_ImportKWh = &value
+			row.OCTO_ImportEstimated = estimated
+		})
 	})
 	if err != nil {
-		return fmt.Errorf("failed to fetch Ocotopus data: %w", err)
+		return fmt.Errorf("%w: failed to fetch Ocotopus data: %w", ErrPartialData, err)
+	}
+
+	if app.ExportMeter == nil {
+		slog.Info("No Octopus export meter, skipping export consumption")
+	} else {
+		err = fetch("Octopus export", func() error {
+			return app.OctopusService.GetMeterConsumption(ctx, usage, app.ExportMeter, start, end.UTC(), func(value float64, estimated bool, row *UsageRow) {
+				row.OCTO_ExportKWh = &value
+				row.OCTO_ExportEstimated = estimated
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("%w: failed to fetch Ocotopus data: %w", ErrPartialData, err)
+		}
+	}
+
+	if app.GasMeter == nil {
+		slog.Info("No Octopus gas meter, skipping gas consumption")
+	} else {
+		err = fetch("Octopus gas", func() error {
+			return app.OctopusService.GetGasConsumption(ctx, usage, app.GasMeter, start, end.UTC(), func(value float64, estimated bool, row *UsageRow) {
+				kWh := gasKWh(value, app.Config.GasUnits, app.Config.GasCalorificValue)
+				row.OCTO_GasKWh = &kWh
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("%w: failed to fetch Octopus gas data: %w", ErrPartialData, err)
+		}
+	}
+
+	if app.Config.RegisterReads {
+		err = fetch("Octopus register", func() error {
+			readings, err := app.OctopusService.GetRegisterReadings(app.Config.AccountID, app.ImportMeter, start, end.UTC())
+			if err != nil {
+				return err
+			}
+			if len(readings) == 0 {
+				slog.Warn("Octopus meter doesn't expose register readings", "meter", app.ImportMeter.SerialNumber)
+			}
+			app.OctopusService.PopulateRegisterReadings(usage, readings)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%w: failed to fetch Octopus register readings: %w", ErrPartialData, err)
+		}
 	}
 
 	// Get data from geo
-	log.Println("Getting GEO data...")
-	err = app.GeoService.PopulateGeoData(usage, app.CollectionStart, app.Config.EndTime.UTC())
+	slog.Info("Getting GEO data...")
+	err = fetch("GEO", func() error {
+		return app.GeoService.PopulateGeoData(ctx, usage, start, end.UTC())
+	})
 	if err != nil {
-		return fmt.Errorf("failed to fetch GEO data: %w", err)
+		return fmt.Errorf("%w: failed to fetch GEO data: %w", ErrPartialData, err)
 	}
 
 	// Fetch GivEnergy data
-	log.Printf("Getting GivEnergy inverter data ...")
-	err = app.GivService.FetchHalfHourlyInverterData(usage, app.Config.SerialNumber, app.CollectionStart, app.Config.EndTime.Local())
+	slog.Info("Getting GivEnergy inverter data...")
+	err = fetch("GivEnergy", func() error {
+		return app.GivService.FetchHalfHourlyInverterData(ctx, usage, app.Config.SerialNumbers, start, inZone(end, app.Config.Location))
+	})
 	if err != nil {
-		return fmt.Errorf("failed to fetch GivEnergy data: %w", err)
+		return fmt.Errorf("%w: failed to fetch GivEnergy data: %w", ErrPartialData, err)
 	}
 
-	// Fetch Octopus tariffs for both import and export meters
-	importTariffs, err := app.OctopusService.FetchTariffs(app.ImportMeter.ProductCode, app.ImportMeter.TariffCode, app.CollectionStart, app.Config.EndTime.UTC())
+	if app.Config.FillExportFromGivEnergy {
+		fillExportFromGivEnergy(usage)
+	}
+
+	if app.Config.CarbonIntensity {
+		if err := app.fetchCarbonIntensity(ctx, usage, start, end); err != nil {
+			return fmt.Errorf("%w: %w", ErrPartialData, err)
+		}
+	}
+	return nil
+}
+
+// priceUsage prices every row in usage at the tariffs from start to end and returns the rows
+// sorted by timestamp.
+func (app *App) priceUsage(ctx context.Context, usage UsageStore, start, end time.Time) ([]*UsageRow, error) {
+	// Fetch tariffs for both import and export
+	importTariffs, err := app.Tariffs.Tariffs(ctx, DirectionImport, start, end.UTC())
 	if err != nil {
-		return fmt.Errorf("failed to fetch import tariffs: %w", err)
+		return nil, fmt.Errorf("%w: failed to fetch import tariffs: %w", ErrPartialData, err)
 	}
-	log.Printf("Fetched %d import tariff records", len(importTariffs))
+	slog.Info("Fetched import tariff records", "count", len(importTariffs))
 
-	exportTariffs, err := app.OctopusService.FetchTariffs(app.ExportMeter.ProductCode, app.ExportMeter.TariffCode, app.CollectionStart, app.Config.EndTime.UTC())
+	exportTariffs, err := app.Tariffs.Tariffs(ctx, DirectionExport, start, end.UTC())
 	if err != nil {
-		return fmt.Errorf("failed to fetch export tariffs: %w", err)
+		return nil, fmt.Errorf("%w: failed to fetch export tariffs: %w", ErrPartialData, err)
+	}
+	slog.Info("Fetched export tariff records", "count", len(exportTariffs))
+
+	for name, tariffs := range map[string][]TariffData{"import": importTariffs, "export": exportTariffs} {
+		if overlaps := countTariffOverlaps(tariffs); overlaps > 0 {
+			slog.Warn("overlapping tariff intervals, using the most recent valid_from", "direction", name, "count", overlaps)
+		}
 	}
-	log.Printf("Fetched %d export tariff records", len(exportTariffs))
 
 	// Calculate half-hourly costs
+	costPriority := withPrimary(app.Config.CostSource, app.Config.ImportPriority)
+	cappedRates := 0
+	importRates, exportRates := buildRateIndex(importTariffs), buildRateIndex(exportTariffs)
 	var data []*UsageRow
 	for timestamp, row := range usage {
-		row.ImportPrice = findRateForTime(timestamp, importTariffs)
-		row.ExportPrice = findRateForTime(timestamp, exportTariffs)
+		if app.priceRow(timestamp, row, importRates, exportRates) {
+			cappedRates++
+		}
+		selectCostFigures(row, costPriority)
 		data = append(data, row)
 	}
 
+	if cappedRates > 0 {
+		slog.Info("Capped import rates to the configured price cap", "count", cappedRates)
+	}
+
 	sort.Slice(data, func(i, j int) bool {
 		return data[i].Timestamp.Before(data[j].Timestamp)
 	})
+	return data, nil
+}
 
-	// Write CSV output
-	if err := writeCSV(app.Config.OutputCSV, data); err != nil {
-		return fmt.Errorf("failed to write CSV: %w", err)
+// csvOptions returns the CSV output options selected by the configuration.
+func (app *App) csvOptions() CSVOptions {
+	csvOptions := CSVOptions{
+		IncludeImportSource:   app.Config.IncludeImportSource,
+		IncludeCost:           app.Config.CostSource != "",
+		IncludeExcVat:         app.Config.IncludeExcVat,
+		IncludeLive:           app.Config.IncludeLive,
+		IncludeAccount:        len(app.Config.GeoAccounts) > 0,
+		IncludeRegister:       app.Config.RegisterReads,
+		IncludeExportFill:     app.Config.FillExportFromGivEnergy,
+		IncludeStandingCharge: app.Config.StandingChargePlacement != StandingChargeNone,
+		FloatFormat:           app.Config.FloatFormat,
+		Precision:             app.Config.Precision,
+		TimestampFormat:       app.Config.TimestampFormat,
+		Location:              app.Config.Location,
+		RoundingMode:          app.Config.RoundingMode,
+		Validate:              app.Config.ValidateOutput,
+		IncludeCarbon:         app.Config.CarbonIntensity,
+		IncludeEnergyBalance:  app.Config.EnergyBalance,
+		IncludeImportDelta:    app.Config.ImportDelta,
+		IncludeGeoExport:      app.Config.GeoExport,
+		IncludeGas:            app.GasMeter != nil,
+		Format:                app.Config.OutputFormat,
+	}
+	if app.Config.TariffNames {
+		csvOptions.ImportTariff = app.ImportMeter.DisplayName
+		if app.ExportMeter != nil {
+			csvOptions.ExportTariff = app.ExportMeter.DisplayName
+		}
 	}
-	log.Printf("Wrote CSV to %s", app.Config.OutputCSV)
+	return csvOptions
+}
 
+// fetchCarbonIntensity populates the carbon intensity for the import meter's region from start to end.
+func (app *App) fetchCarbonIntensity(ctx context.Context, usage UsageStore, start, end time.Time) error {
+	regionID, err := carbonRegionID(app.ImportMeter.TariffCode)
+	if err != nil {
+		return fmt.Errorf("failed to find carbon intensity region: %w", err)
+	}
+	service := NewCarbonIntensityService(app.HTTPClient.Transport)
+	service.Granularity = app.Config.Granularity
+	service.SlotOffset = app.Config.SlotOffset
+	service.Location = app.Config.Location
+	intensity, err := service.GetRegionalIntensity(ctx, regionID, start, end)
+	if err != nil {
+		return err
+	}
+	service.PopulateCarbonIntensity(usage, intensity)
 	return nil
 }
 
+// liveRow returns a row tagged TagLive holding the current Geo live power, or nil with a
+// warning if it is unavailable.
+func (app *App) liveRow() *UsageRow {
+	systemID, err := app.GeoService.GetUserSystemID()
+	if err != nil {
+		slog.Warn("live power unavailable, omitting the live row", "err", err)
+		return nil
+	}
+
+	live, err := app.GeoService.GetLivePower(systemID)
+	if err != nil {
+		slog.Warn("live power unavailable, omitting the live row", "err", err)
+		return nil
+	}
+
+	return &UsageRow{
+		Timestamp:        live.Timestamp,
+		GEO_LiveWatts:    live.ElectricityWatts,
+		GEO_LiveGasWatts: live.GasWatts,
+		Tag:              TagLive,
+	}
+}
+
+// priceRow sets the import and export prices for the slot starting at timestamp,
+// reporting whether the import rate was clamped to a price cap.
+func (app *App) priceRow(timestamp time.Time, row *UsageRow, importRates, exportRates *rateIndex) bool {
+	var capped bool
+	if tariff := importRates.find(timestamp); tariff != nil {
+		row.ImportPrice, capped = capRate(timestamp, &tariff.Rate, app.Config.PriceCaps)
+		excVat := tariff.RateExcVat
+		if capped {
+			// Scale the ex-VAT rate by the same proportion as the capped rate
+			excVat = tariff.RateExcVat * *row.ImportPrice / tariff.Rate
+		}
+		row.ImportPriceExcVat = &excVat
+	}
+	if tariff := exportRates.find(timestamp); tariff != nil {
+		row.ExportPrice = &tariff.Rate
+		row.ExportPriceExcVat = &tariff.RateExcVat
+	}
+	return capped
+}
+
 func findRateForTime(t time.Time, intervals []TariffData) *float64 {
-	for _, iv := range intervals {
-		// Handle nil Start: treat as before zero time
-		startBefore := iv.ValidFrom == nil || !t.Before(*iv.ValidFrom)
-		// Handle nil End: treat as after Max(time.Time)
-		endAfter := iv.ValidTo == nil || t.Before(*iv.ValidTo)
+	if tariff := findTariffForTime(t, intervals); tariff != nil {
+		return &tariff.Rate
+	}
+	return nil
+}
 
-		if startBefore && endAfter {
-			return &iv.Rate
+// findTariffForTime returns the tariff interval covering t, or nil if there isn't one.
+// Where intervals overlap, the most recent ValidFrom wins, then the first in slice order.
+func findTariffForTime(t time.Time, intervals []TariffData) *TariffData {
+	var match *TariffData
+	for i, iv := range intervals {
+		if !iv.covers(t) {
+			continue
+		}
+		if match == nil || iv.startsAfter(match) {
+			match = &intervals[i]
 		}
 	}
+	return match
+}
+
+// covers reports whether t falls within the interval.
+func (iv TariffData) covers(t time.Time) bool {
+	// Handle nil Start: treat as before zero time
+	startBefore := iv.ValidFrom == nil || !t.Before(*iv.ValidFrom)
+	// Handle nil End: treat as after Max(time.Time)
+	endAfter := iv.ValidTo == nil || t.Before(*iv.ValidTo)
+	return startBefore && endAfter
+}
+
+// startsAfter reports whether the interval starts strictly after other, a nil ValidFrom being earliest.
+func (iv TariffData) startsAfter(other *TariffData) bool {
+	if iv.ValidFrom == nil {
+		return false
+	}
+	return other.ValidFrom == nil || iv.ValidFrom.After(*other.ValidFrom)
+}
+
+// countTariffOverlaps returns how many pairs of intervals overlap.
+func countTariffOverlaps(intervals []TariffData) int {
+	overlaps := 0
+	for i, a := range intervals {
+		for _, b := range intervals[i+1:] {
+			first, second := a, b
+			if a.startsAfter(&b) {
+				first, second = b, a
+			}
+			// The later starting interval overlaps if it starts before the earlier one ends
+			if second.ValidFrom == nil || first.ValidTo == nil || second.ValidFrom.Before(*first.ValidTo) {
+				overlaps++
+			}
+		}
+	}
+	return overlaps
+}
+
+// standingCharge returns the import standing charge in pence for the given day, the configured
+// charge or, when none is configured, the one fetched from Octopus for that day.
+func (app *App) standingCharge(day time.Time) float64 {
+	charge := app.Config.ImportStandingCharge
+	if charge == 0 {
+		if rate := findRateForTime(day, app.StandingCharges); rate != nil {
+			charge = *rate
+		}
+	}
+	return capStandingCharge(day, charge, app.Config.PriceCaps)
+}
+
+// fetchStandingCharges fetches the import tariff's standing charges from start to end when no
+// standing charge is configured.
+func (app *App) fetchStandingCharges(ctx context.Context, start, end time.Time) error {
+	if app.Config.ImportStandingCharge != 0 || app.ImportMeter == nil {
+		return nil
+	}
+	charges, err := app.OctopusService.FetchStandingCharges(ctx, app.ImportMeter.ProductCode, app.ImportMeter.TariffCode, start, end)
+	if err != nil {
+		return fmt.Errorf("%w: failed to fetch standing charges: %w", ErrPartialData, err)
+	}
+	slog.Info("Fetched standing charge records", "count", len(charges))
+	app.StandingCharges = charges
 	return nil
 }
 
+// filterRange returns the rows whose timestamp falls within [start, end).
+func filterRange(data []*UsageRow, start, end time.Time) []*UsageRow {
+	var filtered []*UsageRow
+	for _, row := range data {
+		if row.Timestamp.Before(start) || !row.Timestamp.Before(end) {
+			continue
+		}
+		filtered = append(filtered, row)
+	}
+	return filtered
+}
+
+// resolveCollectionStart returns the configured start, or the start of the slot before the
+// latest Octopus reading when none is configured. A derived start, or a configured one with
+// AlignStartToMidnight, is moved back to the first slot boundary of its local day, which is
+// midnight plus any SlotOffset.
+func resolveCollectionStart(config Config, lastReading func() (time.Time, error)) (time.Time, error) {
+	var start time.Time
+	align := true
+	if config.StartTime != nil {
+		start = *config.StartTime
+		align = config.AlignStartToMidnight
+	} else {
+		lastReadingDate, err := lastReading()
+		if err != nil {
+			return time.Time{}, err
+		}
+		start = lastReadingDate.Add(-30 * time.Minute)
+	}
+
+	if align {
+		aligned := truncateToMidnight(inZone(start, config.Location)).Add(config.SlotOffset)
+		if aligned.After(start) {
+			aligned = truncateToMidnight(inZone(start, config.Location).AddDate(0, 0, -1)).Add(config.SlotOffset)
+		}
+		start = aligned
+	}
+	return start, nil
+}
+
 func truncateToMidnight(t time.Time) time.Time {
 	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
 }
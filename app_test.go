@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterRange(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+
+	var data []*UsageRow
+	// Includes a leading row from the GivEnergy shift-back and a trailing row from the Geo day extension
+	for ts := start.Add(-30 * time.Minute); !ts.After(end.Add(30 * time.Minute)); ts = ts.Add(30 * time.Minute) {
+		data = append(data, &UsageRow{Timestamp: ts})
+	}
+
+	filtered := filterRange(data, start, end)
+	require.Len(t, filtered, 4)
+	for _, row := range filtered {
+		require.False(t, row.Timestamp.Before(start), "Row %s before start", row.Timestamp)
+		require.True(t, row.Timestamp.Before(end), "Row %s at or after end", row.Timestamp)
+	}
+	require.Equal(t, start, filtered[0].Timestamp)
+	require.Equal(t, end.Add(-30*time.Minute), filtered[len(filtered)-1].Timestamp)
+}
+
+func TestExcVatColumns(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	importTariffs := []TariffData{{Rate: 21, RateExcVat: 20, ValidFrom: &start}}
+	exportTariffs := []TariffData{{Rate: 15, RateExcVat: 15, ValidFrom: &start}}
+
+	app := &App{Config: &Config{}}
+	row := &UsageRow{Timestamp: start, OCTO_ImportKWh: floatPtr(2), OCTO_ExportKWh: floatPtr(1)}
+	require.False(t, app.priceRow(start, row, buildRateIndex(importTariffs), buildRateIndex(exportTariffs)))
+
+	filename := filepath.Join(t.TempDir(), "output.csv")
+	require.NoError(t, writeCSV(filename, []*UsageRow{row}, CSVOptions{IncludeExcVat: true}))
+
+	records := readCSVFile(t, filename)
+	require.Len(t, records, 2)
+	values := make(map[string]float64)
+	for i, name := range records[0] {
+		if value, err := strconv.ParseFloat(records[1][i], 64); err == nil {
+			values[name] = value
+		}
+	}
+
+	require.Contains(t, values, "OCTO_Import_PenceCost_ExcVat")
+	require.Contains(t, values, "OCTO_Export_PenceCost_ExcVat")
+	require.InDelta(t, 42, values["OCTO_Import_PenceCost"], 0.001)
+	require.InDelta(t, 40, values["OCTO_Import_PenceCost_ExcVat"], 0.001)
+	require.InDelta(t, values["OCTO_Import_PenceCost"], values["OCTO_Import_PenceCost_ExcVat"]*1.05, 0.001)
+	require.InDelta(t, values["OCTO_Export_PenceCost"], values["OCTO_Export_PenceCost_ExcVat"], 0.001)
+
+	require.NoError(t, writeCSV(filename, []*UsageRow{row}, CSVOptions{}))
+	records = readCSVFile(t, filename)
+	require.NotContains(t, records[0], "OCTO_Import_PenceCost_ExcVat")
+}
+
+func TestNewTransportProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		_, _ = io.WriteString(w, "via proxy")
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+
+	rt := &CachingRoundTripper{UnderlyingTransport: newTransport(proxyURL, 0), CacheDir: t.TempDir()}
+	resp, err := (&http.Client{Transport: rt}).Get("http://api.octopus.example/v1/accounts/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "via proxy", string(body))
+	require.Equal(t, []string{"http://api.octopus.example/v1/accounts/"}, proxied)
+
+	require.Equal(t, http.DefaultTransport, newTransport(nil, 0), "Without an override the environment proxy settings apply")
+}
+
+func TestUserAgent(t *testing.T) {
+	var userAgents []string
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			userAgents = append(userAgents, req.Header.Get("User-Agent"))
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`{"results": []}`)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	// Through the caching wrapper, as NewApp sets it up
+	rt := &CachingRoundTripper{UnderlyingTransport: withUserAgent(mockRoundTripper, ""), CacheDir: t.TempDir()}
+	octopusService := NewOctopusService(rt, "dummyApiKey")
+	_, err := octopusService.FetchTariffs(context.Background(), "AGILE-24-10-01", "E-1R-AGILE-24-10-01-M", time.Now().Add(-time.Hour), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, []string{defaultUserAgent()}, userAgents)
+
+	userAgents = nil
+	resp, err := (&http.Client{Transport: withUserAgent(mockRoundTripper, "my-tool/1.0")}).Get("https://api.givenergy.cloud/v1/communication-device")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, []string{"my-tool/1.0"}, userAgents)
+}
+
+func TestExtraHeaders(t *testing.T) {
+	var requests []http.Header
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			requests = append(requests, req.Header.Clone())
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`{"results": [], "data": [], "meta": {"current_page": 1, "last_page": 1}}`)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	headers, err := parseHeaders("x-api-key: gateway-secret, Authorization: Bearer gateway")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"X-Api-Key": "gateway-secret", "Authorization": "Bearer gateway"}, headers)
+	rt := withHeaders(withUserAgent(mockRoundTripper, ""), headers)
+
+	octopusService := NewOctopusService(rt, "dummyApiKey")
+	_, err = octopusService.FetchTariffs(context.Background(), "AGILE-24-10-01", "E-1R-AGILE-24-10-01-M", time.Now().Add(-time.Hour), time.Now())
+	require.NoError(t, err)
+	givService := NewGivEnergyService(rt, "dummyBearerToken")
+	midday := time.Date(2025, 1, 1, 12, 0, 0, 0, time.Local)
+	require.NoError(t, givService.FetchHalfHourlyInverterData(context.Background(), map[time.Time]*UsageRow{}, []string{"ABC12345"}, midday, midday.Add(time.Hour)))
+
+	require.Len(t, requests, 2)
+	for _, header := range requests {
+		require.Equal(t, "gateway-secret", header.Get("X-Api-Key"))
+	}
+	require.Equal(t, "Basic ZHVtbXlBcGlLZXk6", requests[0].Get("Authorization"), "Expected the Octopus basic auth kept")
+	require.Equal(t, "Bearer dummyBearerToken", requests[1].Get("Authorization"), "Expected the GivEnergy bearer token kept")
+
+	_, err = parseHeaders("no-value")
+	require.Error(t, err)
+}
+
+func TestResolveCollectionStart(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	midday := time.Date(2025, 1, 10, 12, 17, 0, 0, time.Local)
+	midnight := time.Date(2025, 1, 10, 0, 0, 0, 0, time.Local)
+	noReading := func() (time.Time, error) {
+		t.Fatal("Unexpected last reading query with an explicit start")
+		return time.Time{}, nil
+	}
+
+	start, err := resolveCollectionStart(Config{StartTime: &midday, AlignStartToMidnight: true}, noReading)
+	require.NoError(t, err)
+	require.Equal(t, midnight, start, "Expected the explicit start aligned to midnight")
+
+	start, err = resolveCollectionStart(Config{StartTime: &midday}, noReading)
+	require.NoError(t, err)
+	require.Equal(t, midday, start, "Expected the explicit start unchanged when not aligning")
+
+	// The day's first slot starts at 00:05, so a start before it belongs to the day before
+	early := time.Date(2025, 1, 10, 0, 2, 0, 0, time.Local)
+	start, err = resolveCollectionStart(Config{StartTime: &early, AlignStartToMidnight: true, SlotOffset: 5 * time.Minute}, noReading)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2025, 1, 9, 0, 5, 0, 0, time.Local), start)
+
+	// A derived start is always aligned, so a Config's zero value keeps doing so
+	start, err = resolveCollectionStart(Config{}, func() (time.Time, error) { return midday, nil })
+	require.NoError(t, err)
+	require.Equal(t, midnight, start, "Expected the derived start aligned to midnight")
+}
+
+func TestNewApp(t *testing.T) {
+	accountStatus := http.StatusOK
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			status, responseBody := http.StatusOK, `{}`
+			switch {
+			case req.URL.Path == "/v1/accounts/A-123":
+				status = accountStatus
+				responseBody = `{"properties": [{"electricity_meter_points": [
+					{"mpan": "123", "meters": [{"serial_number": "SN1"}], "agreements": [{"tariff_code": "E-1R-AGILE-24-10-01-M"}]},
+					{"mpan": "456", "meters": [{"serial_number": "SN2"}], "agreements": [{"tariff_code": "E-1R-EXPORT-24-10-01-M"}], "is_export": true}
+				]}]}`
+			case req.URL.Path == "/v1/products/":
+				responseBody = `{"results": [{"code": "AGILE-24-10-01"}, {"code": "EXPORT-24-10-01"}]}`
+			case strings.Contains(req.URL.Path, "/usersservice/v2/login"):
+				responseBody = `{"accessToken": "wibble"}`
+			default:
+				t.Fatalf("unhandled request %s", req.URL)
+			}
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(strings.NewReader(responseBody)),
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+			}, nil
+		},
+	}
+	oldTransport := baseTransport
+	t.Cleanup(func() { baseTransport = oldTransport })
+	baseTransport = func(*url.URL, time.Duration) http.RoundTripper { return mockRoundTripper }
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	config := &Config{AccountID: "A-123", CacheDirectory: "disable", StartTime: &start, EndTime: start.AddDate(0, 0, 1)}
+	app, err := NewApp(config)
+	require.NoError(t, err)
+	require.Equal(t, "123", app.ImportMeter.Mpan)
+	require.Equal(t, "456", app.ExportMeter.Mpan)
+	require.Equal(t, start, app.CollectionStart)
+
+	// A failed meter lookup is returned rather than exiting
+	accountStatus = http.StatusUnauthorized
+	_, err = NewApp(config)
+	require.ErrorContains(t, err, "failed to get meter and tariff details")
+}
+
+func TestImportOnlyAccount(t *testing.T) {
+	withLocation(t, "UTC")
+	withMockAccount(t, func() string {
+		return `{"interval_start": "2025-01-01T00:30:00Z", "interval_end": "2025-01-01T01:00:00Z", "consumption": 0.4},
+			{"interval_start": "2025-01-01T00:00:00Z", "interval_end": "2025-01-01T00:30:00Z", "consumption": 0.2}`
+	}, nil, func() string { return "" })
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	output := filepath.Join(t.TempDir(), "output.csv")
+	config := &Config{AccountID: "A-123", SerialNumbers: []string{"ABC12345"}, CacheDirectory: "disable", OutputCSV: output,
+		Granularity: GranularityHalfHour, StartTime: &start, EndTime: start.Add(time.Hour), TrimToRange: true, TariffNames: true}
+	app, err := NewApp(config)
+	require.NoError(t, err)
+	require.Nil(t, app.ExportMeter)
+
+	require.NoError(t, app.Run(context.Background()))
+	records := readCSVFile(t, output)
+	require.Len(t, records, 3)
+	column := func(name string) int { return slices.Index(records[0], name) }
+	for i, expected := range []string{"0.200000", "0.400000"} {
+		require.Equal(t, expected, records[i+1][column("OCTO_Import_KWh")])
+		require.Equal(t, "NaN", records[i+1][column("OCTO_Export_KWh")], "Expected no export without an export meter")
+		require.Equal(t, "NaN", records[i+1][column("Export_Price")])
+	}
+	require.Equal(t, "4.00", records[1][column("OCTO_Import_PenceCost")])
+}
+
+func TestExportConsumption(t *testing.T) {
+	withLocation(t, "UTC")
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	withMockAccount(t, func() string { return consumptionResults(start, 2, 0.2) },
+		func() string { return consumptionResults(start, 2, 0.7) }, func() string { return "" })
+
+	output := filepath.Join(t.TempDir(), "output.csv")
+	config := &Config{AccountID: "A-123", SerialNumbers: []string{"ABC12345"}, CacheDirectory: "disable", OutputCSV: output,
+		Granularity: GranularityHalfHour, StartTime: &start, EndTime: start.Add(time.Hour), TrimToRange: true}
+	app, err := NewApp(config)
+	require.NoError(t, err)
+	require.Equal(t, "456", app.ExportMeter.Mpan)
+
+	require.NoError(t, app.Run(context.Background()))
+	records := readCSVFile(t, output)
+	require.Len(t, records, 3)
+	column := func(name string) int { return slices.Index(records[0], name) }
+	for _, record := range records[1:] {
+		require.Equal(t, "0.200000", record[column("OCTO_Import_KWh")])
+		require.Equal(t, "0.700000", record[column("OCTO_Export_KWh")], "Expected the export meter's consumption")
+	}
+}
+
+// withMockAccount serves NewApp an Octopus account at a flat 20p/kWh, whose consumption results
+// and GivEnergy data points are read from the functions on each request, and a Geo system
+// without readings. The account has an export meter when exportConsumption is set.
+func withMockAccount(t *testing.T, consumption, exportConsumption, dataPoints func() string) {
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			responseBody := `{"results": []}`
+			switch {
+			case req.URL.Path == "/v1/accounts/A-123":
+				exportPoint := ""
+				if exportConsumption != nil {
+					exportPoint = `, {"mpan": "456", "meters": [{"serial_number": "SN2"}], "agreements": [{"tariff_code": "E-1R-EXPORT-24-10-01-M"}], "is_export": true}`
+				}
+				responseBody = `{"properties": [{"electricity_meter_points": [
+					{"mpan": "123", "meters": [{"serial_number": "SN1"}], "agreements": [{"tariff_code": "E-1R-AGILE-24-10-01-M"}]}` + exportPoint + `
+				]}]}`
+			case req.URL.Path == "/v1/products/":
+				responseBody = `{"results": [{"code": "AGILE-24-10-01"}, {"code": "EXPORT-24-10-01"}]}`
+			case req.URL.Path == "/v1/electricity-meter-points/123/meters/SN1/consumption/":
+				responseBody = `{"results": [` + consumption() + `]}`
+			case req.URL.Path == "/v1/electricity-meter-points/456/meters/SN2/consumption/" && exportConsumption != nil:
+				responseBody = `{"results": [` + exportConsumption() + `]}`
+			case strings.HasSuffix(req.URL.Path, "/standard-unit-rates/"):
+				responseBody = `{"results": [{"value_inc_vat": 20, "valid_from": "2024-12-01T00:00:00Z", "valid_to": "2025-02-01T00:00:00Z"}]}`
+			case strings.HasSuffix(req.URL.Path, "/standing-charges/"):
+			case strings.HasPrefix(req.URL.Path, "/v1/inverter/"):
+				responseBody = `{"data": [` + dataPoints() + `], "meta": {"current_page": 1, "last_page": 1}}`
+			case strings.Contains(req.URL.Path, "/usersservice/v2/login"):
+				responseBody = `{"accessToken": "wibble"}`
+			case strings.Contains(req.URL.Path, "/api/userapi/v2/user/detail-systems"):
+				responseBody = `{"systemRoles": [{"name": "Home", "systemId": "123"}], "systemDetails": [{"name": "Home", "systemId": "123", "devices": [{"deviceType": "TRIO_II_TB_GEO"}]}]}`
+			case strings.Contains(req.URL.Path, "/epochservice/v1/system/"):
+				responseBody = `[]`
+			default:
+				t.Fatalf("unhandled request %s", req.URL)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(responseBody)),
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+			}, nil
+		},
+	}
+	oldTransport := baseTransport
+	t.Cleanup(func() { baseTransport = oldTransport })
+	baseTransport = func(*url.URL, time.Duration) http.RoundTripper { return mockRoundTripper }
+}
+
+// consumptionResults returns Octopus consumption results of value for each half hour from start
+// for slots.
+func consumptionResults(start time.Time, slots int, value float64) string {
+	var results []string
+	for i := 0; i < slots; i++ {
+		from := start.Add(time.Duration(i) * 30 * time.Minute)
+		results = append(results, fmt.Sprintf(`{"interval_start": %q, "interval_end": %q, "consumption": %g}`,
+			from.Format(time.RFC3339), from.Add(30*time.Minute).Format(time.RFC3339), value))
+	}
+	return strings.Join(results, ",")
+}
+
+func TestMergeExistingKeepsRowBeforeRange(t *testing.T) {
+	withLocation(t, "UTC")
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	withMockAccount(t, func() string { return consumptionResults(start, 3, 0.2) }, nil, func() string {
+		// An import counter rising 1kWh an hour, 0.5 a slot
+		return `{"time": "2024-12-31T23:00:00Z", "total": {"grid": {"import": 100, "export": 10}}},
+			{"time": "2025-01-01T03:00:00Z", "total": {"grid": {"import": 104, "export": 10}}}`
+	})
+
+	// The master CSV already holds a complete row for the slot before the window
+	master := filepath.Join(t.TempDir(), "master.csv")
+	before := &UsageRow{Timestamp: start.Add(-30 * time.Minute), OCTO_ImportKWh: floatPtr(0.5), GE_ImportKWh: floatPtr(1), ImportPrice: floatPtr(20)}
+	require.NoError(t, writeCSV(master, []*UsageRow{before}, CSVOptions{}))
+
+	config := &Config{AccountID: "A-123", SerialNumbers: []string{"ABC12345"}, CacheDirectory: "disable", OutputCSV: master,
+		MergeExisting: master, Granularity: GranularityHalfHour, StartTime: &start, EndTime: start.Add(90 * time.Minute)}
+	app, err := NewApp(config)
+	require.NoError(t, err)
+	require.NoError(t, app.Run(context.Background()))
+
+	records := readCSVFile(t, master)
+	column := func(name string) int { return slices.Index(records[0], name) }
+	require.Equal(t, "2024-12-31T23:30:00Z", records[1][column("Timestamp")])
+	require.Equal(t, "0.500000", records[1][column("OCTO_Import_KWh")], "Expected the existing row before the window kept")
+	require.Equal(t, "1.000000", records[1][column("GE_Import_KWh")], "Expected the existing row before the window kept")
+	require.Equal(t, "0.200000", records[2][column("OCTO_Import_KWh")])
+	require.Equal(t, "0.500000", records[2][column("GE_Import_KWh")])
+}
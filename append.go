@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+)
+
+// appendCSV appends the rows of data after the last timestamp already in filename, without a
+// header, or writes it as writeCSV does when filename doesn't exist or is empty. Rows at or before that
+// timestamp are skipped so the file never holds a timestamp twice, and the existing header
+// must match the columns written. data must be sorted by timestamp.
+func appendCSV(filename string, data []*UsageRow, opts CSVOptions) error {
+	header, last, err := csvTail(filename)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, io.EOF) {
+		return writeCSV(filename, data, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+
+	columns, err := existingColumns(filename, header, opts)
+	if err != nil {
+		return err
+	}
+
+	rows := slices.DeleteFunc(slices.Clone(data), func(row *UsageRow) bool { return !row.Timestamp.After(last) })
+	if skipped := len(data) - len(rows); skipped > 0 {
+		slog.Info("Skipping rows already in the output", "rows", skipped, "last", last.Format(time.RFC3339), "file", filename)
+	}
+	if len(rows) == 0 {
+		slog.Info("No new rows to append", "file", filename)
+		return nil
+	}
+
+	file, err := openAppend(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Abort()
+	w := &csvWriter{filename: filename, file: file, writer: csv.NewWriter(file), columns: columns, header: header, timestamp: opts.TimestampFormat, location: opts.Location}
+	if err := w.Write(rows); err != nil {
+		return err
+	}
+	slog.Info("Appending rows", "rows", len(rows), "file", filename)
+	return w.Close()
+}
+
+// existingColumns returns the columns opts selects, or an error when they differ from header,
+// that of the existing filename, as rows written with them would be misaligned.
+func existingColumns(filename string, header []string, opts CSVOptions) ([]csvColumn, error) {
+	columns := csvColumns(opts)
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+	}
+	if !slices.Equal(header, names) {
+		return nil, fmt.Errorf("%w: can't add rows to %s, its columns differ from those written", ErrConfig, filename)
+	}
+	return columns, nil
+}
+
+// replaceCSVTail merges data into the rows of filename from data's first timestamp on, or
+// writes it as writeCSV does when filename doesn't exist or is empty. Only that tail is parsed,
+// the rows before it being copied as they are, apart from compressed files, which are merged in
+// full. The file is replaced as writeCSV replaces it, so a failure leaves it untouched. The
+// existing header must match the columns written, and data must be sorted by timestamp. See
+// mergeTail for how the rows are merged.
+func replaceCSVTail(filename string, data []*UsageRow, opts CSVOptions, prepare func(row *UsageRow)) error {
+	if len(data) < 1 {
+		return fmt.Errorf("no data to write CSV")
+	}
+	from := data[0].Timestamp
+	header, offset, err := csvOffset(filename, from)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, io.EOF) {
+		return writeCSV(filename, data, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	columns, err := existingColumns(filename, header, opts)
+	if err != nil {
+		return err
+	}
+
+	if isGzip(filename) {
+		existing, err := readCSV(filename, opts.Location)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", filename, err)
+		}
+		return writeCSV(filename, mergeTail(existing, data, from, prepare), opts)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	out, _, err := openOutput(filename)
+	if err != nil {
+		return err
+	}
+	defer out.Abort()
+	if _, err := io.CopyN(out, file, offset); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", filename, err)
+	}
+
+	// The tail is read back under the header so its columns can be matched
+	var headerLine bytes.Buffer
+	headerWriter := csv.NewWriter(&headerLine)
+	if err := headerWriter.Write(header); err != nil {
+		return err
+	}
+	headerWriter.Flush()
+	tail, err := parseCSVRows(io.MultiReader(&headerLine, file), filename, opts.Location)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	rows := mergeTail(tail, data, from, prepare)
+
+	w := &csvWriter{filename: filename, file: out, writer: csv.NewWriter(out), columns: columns, header: header, timestamp: opts.TimestampFormat, location: opts.Location}
+	if err := w.Write(rows); err != nil {
+		return err
+	}
+	slog.Info("Replaced the output's rows from the run's start", "file", filename, "from", from.Format(time.RFC3339), "rows", len(rows))
+	return w.Close()
+}
+
+// mergeTail merges data into the existing rows field by field, so a slot refetched without one
+// of its sources keeps the figures already collected. Existing tagged rows from from on are
+// dropped, data carrying their replacements. The merged rows are passed through prepare so the
+// figures chosen from them are redone.
+func mergeTail(existing, data []*UsageRow, from time.Time, prepare func(row *UsageRow)) []*UsageRow {
+	existing = slices.DeleteFunc(existing, func(row *UsageRow) bool { return row.Tag != "" && !row.Timestamp.Before(from) })
+	byTime := make(map[mergeKey]*UsageRow, len(existing))
+	for _, row := range existing {
+		byTime[mergeKey{row.Account, row.Timestamp.Unix()}] = row
+	}
+	for _, row := range data {
+		if older, ok := byTime[mergeKey{row.Account, row.Timestamp.Unix()}]; ok && row.Tag == "" {
+			fillMissing(row, older)
+		}
+	}
+
+	merged := mergeRows(existing, data)
+	for _, row := range merged {
+		prepare(row)
+	}
+	return merged
+}
+
+// csvOffset returns the header of the CSV filename and the byte offset of its first row at or
+// after from, or of its end when there is none. The rows must be sorted by timestamp.
+func csvOffset(filename string, from time.Time) (header []string, offset int64, err error) {
+	file, err := openInput(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	header, err = reader.Read()
+	if err != nil {
+		return nil, 0, err
+	}
+	timestampIndex := slices.Index(header, "Timestamp")
+	if timestampIndex < 0 {
+		return nil, 0, fmt.Errorf("%s has no Timestamp column", filename)
+	}
+	for line := 2; ; line++ {
+		offset = reader.InputOffset()
+		record, err := reader.Read()
+		if err == io.EOF {
+			return header, offset, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		timestamp, err := parseTimestamp(record[timestampIndex])
+		if err != nil {
+			return nil, 0, fmt.Errorf("line %d: invalid timestamp: %w", line, err)
+		}
+		if !timestamp.Before(from) {
+			return header, offset, nil
+		}
+	}
+}
+
+// csvTail returns the header of the CSV filename and its latest timestamp.
+func csvTail(filename string) (header []string, last time.Time, err error) {
+	file, err := openInput(filename)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	header, err = reader.Read()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	timestampIndex := slices.Index(header, "Timestamp")
+	if timestampIndex < 0 {
+		return nil, time.Time{}, fmt.Errorf("%s has no Timestamp column", filename)
+	}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return header, last, nil
+		}
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		timestamp, err := parseTimestamp(record[timestampIndex])
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("line %d: invalid timestamp: %w", line, err)
+		}
+		if timestamp.After(last) {
+			last = timestamp
+		}
+	}
+}
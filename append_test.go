@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/csv"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendCSV(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := func(from, to int) []*UsageRow {
+		var data []*UsageRow
+		for i := from; i < to; i++ {
+			data = append(data, &UsageRow{Timestamp: start.Add(time.Duration(i) * 30 * time.Minute), OCTO_ImportKWh: floatPtr(float64(i))})
+		}
+		return data
+	}
+
+	for _, name := range []string{"output.csv", "output.csv.gz"} {
+		filename := filepath.Join(t.TempDir(), name)
+		require.NoError(t, appendCSV(filename, rows(0, 4), CSVOptions{}), "Expected a missing file to be created")
+		// The second run overlaps the first by two slots
+		require.NoError(t, appendCSV(filename, rows(2, 6), CSVOptions{}))
+		require.NoError(t, appendCSV(filename, rows(3, 5), CSVOptions{}), "Expected nothing new to append")
+
+		file, err := openInput(filename)
+		require.NoError(t, err)
+		records, err := csv.NewReader(file).ReadAll()
+		file.Close()
+		require.NoError(t, err)
+		require.Len(t, records, 7, "Expected a header and six rows in %s", name)
+		require.Equal(t, "Timestamp", records[0][0])
+		for i, record := range records[1:] {
+			require.Equal(t, start.Add(time.Duration(i)*30*time.Minute).Format(time.RFC3339), record[0], "Expected continuous rows in %s", name)
+		}
+
+		// Appending different columns would misalign them
+		require.ErrorIs(t, appendCSV(filename, rows(6, 7), CSVOptions{IncludeCost: true}), ErrConfig)
+	}
+}
+
+func TestReplaceCSVTail(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := func(from, to int, value float64) []*UsageRow {
+		var data []*UsageRow
+		for i := from; i < to; i++ {
+			data = append(data, &UsageRow{Timestamp: start.Add(time.Duration(i) * 30 * time.Minute), OCTO_ImportKWh: floatPtr(value)})
+		}
+		return data
+	}
+	opts := CSVOptions{IncludeLive: true}
+	var prepared []string
+	prepare := func(row *UsageRow) { prepared = append(prepared, row.Timestamp.UTC().Format(time.RFC3339)) }
+
+	for _, name := range []string{"output.csv", "output.csv.gz"} {
+		prepared = nil
+		filename := filepath.Join(t.TempDir(), name)
+		live := &UsageRow{Timestamp: start.Add(150 * time.Minute), Tag: TagLive}
+		first := rows(0, 4, 1)
+		for _, row := range first {
+			row.GE_ImportKWh = floatPtr(0.5)
+		}
+		require.NoError(t, replaceCSVTail(filename, append(first, live), opts, prepare), "Expected a missing file to be created")
+		// A later run from the third slot without GivEnergy data, the fourth slot being missing this time
+		require.NoError(t, replaceCSVTail(filename, append(rows(2, 3, 2), rows(4, 6, 2)...), opts, prepare))
+		require.Contains(t, prepared, "2025-01-01T01:30:00Z", "Expected the existing row without a replacement prepared")
+
+		file, err := openInput(filename)
+		require.NoError(t, err)
+		records, err := csv.NewReader(file).ReadAll()
+		file.Close()
+		require.NoError(t, err)
+		column, geColumn := slices.Index(records[0], "OCTO_Import_KWh"), slices.Index(records[0], "GE_Import_KWh")
+		var values, geValues []string
+		for i, record := range records[1:] {
+			require.Equal(t, start.Add(time.Duration(i)*30*time.Minute).Local().Format(time.RFC3339), record[0], "Expected continuous rows in %s", name)
+			values = append(values, record[column])
+			geValues = append(geValues, record[geColumn])
+		}
+		require.Equal(t, []string{"1.000000", "1.000000", "2.000000", "1.000000", "2.000000", "2.000000"}, values,
+			"Expected the rows before the tail kept, the tail replaced and the old live row dropped in %s", name)
+		require.Equal(t, []string{"0.500000", "0.500000", "0.500000", "0.500000", "NaN", "NaN"}, geValues,
+			"Expected the replaced rows to keep the figures they weren't given in %s", name)
+
+		require.ErrorIs(t, replaceCSVTail(filename, rows(6, 7, 3), CSVOptions{}, prepare), ErrConfig)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// gapWindow is a run of consecutive slots missing a source's figures, from Start up to End.
+type gapWindow struct {
+	Start, End time.Time
+}
+
+// backfillSources maps each expected source value to the fetch that provides it.
+var backfillSources = map[string]string{
+	"Octopus import":   "Octopus import",
+	"Octopus export":   "Octopus export",
+	"GEO import":       "GEO",
+	"GivEnergy import": "GivEnergy",
+	"GivEnergy export": "GivEnergy",
+}
+
+// gapWindows returns, per fetch, the windows of data missing a value that fetch provides,
+// including slots missing from data altogether. data must be sorted by timestamp.
+func gapWindows(data []*UsageRow, g Granularity) map[string][]gapWindow {
+	windows := make(map[string][]gapWindow)
+	for _, row := range gapRows(data, g) {
+		fetches := make(map[string]bool)
+		for _, missing := range missingValues(row) {
+			fetches[backfillSources[missing]] = true
+		}
+		for fetch := range fetches {
+			end := g.next(row.Timestamp)
+			if last := len(windows[fetch]) - 1; last >= 0 && windows[fetch][last].End.Equal(row.Timestamp) {
+				windows[fetch][last].End = end
+				continue
+			}
+			windows[fetch] = append(windows[fetch], gapWindow{Start: row.Timestamp, End: end})
+		}
+	}
+	return windows
+}
+
+// backfillMissing fetches each source only over the windows of existing it is missing from,
+// filling them into the existing rows, then prices the rows in those windows. It returns the
+// rows, existing and new, sorted by timestamp.
+func (app *App) backfillMissing(ctx context.Context, existing []*UsageRow) ([]*UsageRow, error) {
+	g := app.Config.Granularity
+	sort.Slice(existing, func(i, j int) bool { return existing[i].Timestamp.Before(existing[j].Timestamp) })
+	windows := gapWindows(existing, g)
+
+	usage := make(UsageStore, len(existing))
+	for _, row := range existing {
+		usage[row.Timestamp] = row
+	}
+
+	fetches := map[string]func(w gapWindow) error{
+		"Octopus import": func(w gapWindow) error {
+			return app.OctopusService.GetMeterConsumption(ctx, usage, app.ImportMeter, w.Start, w.End.UTC(), func(value float64, estimated bool, row *UsageRow) {
+				row.OCTO_ImportKWh = &value
+				row.OCTO_ImportEstimated = estimated
+			})
+		},
+		"Octopus export": func(w gapWindow) error {
+			if app.ExportMeter == nil {
+				return nil
+			}
+			return app.OctopusService.GetMeterConsumption(ctx, usage, app.ExportMeter, w.Start, w.End.UTC(), func(value float64, estimated bool, row *UsageRow) {
+				row.OCTO_ExportKWh = &value
+				row.OCTO_ExportEstimated = estimated
+			})
+		},
+		"GEO": func(w gapWindow) error {
+			return app.GeoService.PopulateGeoData(ctx, usage, w.Start, w.End.UTC())
+		},
+		"GivEnergy": func(w gapWindow) error {
+			return app.GivService.FetchHalfHourlyInverterData(ctx, usage, app.Config.SerialNumbers, w.Start, inZone(w.End, app.Config.Location))
+		},
+	}
+
+	var priced []gapWindow
+	for _, source := range []string{"Octopus import", "Octopus export", "GEO", "GivEnergy"} {
+		for _, w := range windows[source] {
+			slog.Info("Backfilling", "source", source, "start", w.Start.Format(time.RFC3339), "end", w.End.Format(time.RFC3339))
+			if err := fetches[source](w); err != nil {
+				return nil, fmt.Errorf("%w: failed to backfill %s: %w", ErrPartialData, source, err)
+			}
+			priced = append(priced, w)
+		}
+	}
+	if len(priced) == 0 {
+		slog.Info("No gaps to backfill")
+	}
+
+	// Price the backfilled windows, merging those that overlap so each range's tariffs are fetched once
+	sort.Slice(priced, func(i, j int) bool { return priced[i].Start.Before(priced[j].Start) })
+	var merged []gapWindow
+	for _, w := range priced {
+		if last := len(merged) - 1; last >= 0 && !w.Start.After(merged[last].End) {
+			if w.End.After(merged[last].End) {
+				merged[last].End = w.End
+			}
+			continue
+		}
+		merged = append(merged, w)
+	}
+	for _, w := range merged {
+		window := make(UsageStore)
+		for timestamp, row := range usage {
+			if !timestamp.Before(w.Start) && timestamp.Before(w.End) {
+				window[timestamp] = row
+			}
+		}
+		if _, err := app.priceUsage(ctx, window, w.Start, w.End); err != nil {
+			return nil, err
+		}
+	}
+
+	costPriority := withPrimary(app.Config.CostSource, app.Config.ImportPriority)
+	data := make([]*UsageRow, 0, len(usage))
+	for _, row := range usage {
+		selectCostFigures(row, costPriority)
+		data = append(data, row)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Timestamp.Before(data[j].Timestamp) })
+	return data, nil
+}
+
+// runBackfill tops up the existing output, fetching only the windows its sources are missing
+// rather than the whole range, and writes it back.
+func (app *App) runBackfill(ctx context.Context) error {
+	existing, err := readCSV(app.Config.OutputCSV, app.Config.Location)
+	if err != nil {
+		return fmt.Errorf("failed to read existing CSV: %w", err)
+	}
+	if len(existing) == 0 {
+		return fmt.Errorf("%w: %s has no rows to backfill", ErrConfig, app.Config.OutputCSV)
+	}
+
+	data, err := app.backfillMissing(ctx, existing)
+	if err != nil {
+		return err
+	}
+
+	rows := placeStandingCharge(data, app.Config.StandingChargePlacement, app.standingCharge, app.Config.Granularity, app.Config.Location)
+	if err := writeCSV(app.Config.OutputCSV, rows, app.csvOptions()); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	slog.Info("Wrote CSV", "file", app.Config.OutputCSV)
+
+	gaps := findGaps(data, app.Config.Granularity)
+	logGaps(gaps)
+	if app.Config.FailOnGaps && len(gaps) > 0 {
+		return fmt.Errorf("%w: %d slots with no Octopus import data", ErrGapsDetected, len(gaps))
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfillMissingFetchesOnlyGaps(t *testing.T) {
+	withLocation(t, "Europe/London")
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	slot := func(i int) time.Time { return start.Add(time.Duration(i) * 30 * time.Minute) }
+
+	var existing []*UsageRow
+	for i := 0; i < 8; i++ {
+		wh := int64(500)
+		row := &UsageRow{
+			Timestamp:      slot(i),
+			OCTO_ImportKWh: floatPtr(0.5),
+			OCTO_ExportKWh: floatPtr(0.1),
+			GEO_ImportWh:   &wh,
+			GE_ImportKWh:   floatPtr(0.5),
+			GE_ExportKWh:   floatPtr(0.1),
+			ImportPrice:    floatPtr(20),
+			ExportPrice:    floatPtr(15),
+		}
+		if i == 2 || i == 3 {
+			row.OCTO_ImportKWh = nil
+			row.ImportPrice = nil
+		}
+		existing = append(existing, row)
+	}
+	windows := gapWindows(existing, GranularityHalfHour)
+	require.Equal(t, map[string][]gapWindow{"Octopus import": {{Start: slot(2), End: slot(4)}}}, windows)
+
+	var requested []string
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			query := req.URL.Query()
+			requested = append(requested, fmt.Sprintf("%s %s %s", req.URL.Path, query.Get("period_from"), query.Get("period_to")))
+			responseBody := `{"count": 1, "next": null, "results": [{"value_inc_vat": 24, "value_exc_vat": 22.857, "valid_from": "2024-01-01T00:00:00Z", "valid_to": null}]}`
+			if strings.Contains(req.URL.Path, "consumption") {
+				responseBody = fmt.Sprintf(`{"count": 2, "next": null, "results": [
+					{"interval_start": %q, "interval_end": %q, "consumption": 0.75},
+					{"interval_start": %q, "interval_end": %q, "consumption": 0.8}
+				]}`, slot(2).Format(time.RFC3339), slot(3).Format(time.RFC3339), slot(3).Format(time.RFC3339), slot(4).Format(time.RFC3339))
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	service := NewOctopusService(mockRoundTripper, "dummyApiKey")
+	importMeter := &MeterInfo{ProductCode: "AGILE", TariffCode: "E-1R-AGILE-M", Mpan: "IMP", SerialNumber: "1"}
+	exportMeter := &MeterInfo{ProductCode: "OUTGOING", TariffCode: "E-1R-OUTGOING-M", Mpan: "EXP", SerialNumber: "2"}
+	// Only Octopus is missing data, so the GEO and GivEnergy services must not be used
+	app := &App{
+		Config:         &Config{Granularity: GranularityHalfHour, ImportPriority: DefaultImportPriority},
+		OctopusService: service,
+		ImportMeter:    importMeter,
+		ExportMeter:    exportMeter,
+		Tariffs:        &OctopusTariffProvider{Service: service, ImportMeter: importMeter, ExportMeter: exportMeter},
+	}
+
+	data, err := app.backfillMissing(context.Background(), existing)
+	require.NoError(t, err)
+
+	from, to := slot(2).UTC().Format("2006-01-02T15:04:05.000Z"), slot(4).UTC().Format("2006-01-02T15:04:05.000Z")
+	require.Equal(t, []string{
+		"/v1/electricity-meter-points/IMP/meters/1/consumption/ " + from + " " + to,
+		"/v1/products/AGILE/electricity-tariffs/E-1R-AGILE-M/standard-unit-rates/ " + from + " " + to,
+		"/v1/products/OUTGOING/electricity-tariffs/E-1R-OUTGOING-M/standard-unit-rates/ " + from + " " + to,
+	}, requested, "Expected only the gap window fetched")
+
+	require.Len(t, data, 8)
+	require.InDelta(t, 0.75, *data[2].OCTO_ImportKWh, 1e-9)
+	require.InDelta(t, 0.8, *data[3].OCTO_ImportKWh, 1e-9)
+	require.Equal(t, 24.0, *data[2].ImportPrice, "Expected the backfilled slots priced")
+	require.Equal(t, 20.0, *data[1].ImportPrice, "Expected the existing prices kept outside the gap")
+	require.Equal(t, SourceOctopus, data[2].ImportSource)
+}
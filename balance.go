@@ -0,0 +1,17 @@
+package main
+
+// energyBalanceResidual returns how far the GivEnergy figures for the slot are from balancing:
+// solar + grid import + battery discharge - grid export - battery charge - house load, in kWh.
+// It should stay near zero, so spikes point at inconsistent data. It is nil unless every
+// component is present.
+func energyBalanceResidual(row *UsageRow) *float64 {
+	for _, component := range []*float64{row.GE_SolarKWh, row.GE_ImportKWh, row.GE_BatteryDischargeKWh,
+		row.GE_ExportKWh, row.GE_BatteryChargeKWh, row.GE_ConsumptionKWh} {
+		if component == nil {
+			return nil
+		}
+	}
+	residual := *row.GE_SolarKWh + *row.GE_ImportKWh + *row.GE_BatteryDischargeKWh -
+		*row.GE_ExportKWh - *row.GE_BatteryChargeKWh - *row.GE_ConsumptionKWh
+	return &residual
+}
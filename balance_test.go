@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnergyBalanceResidual(t *testing.T) {
+	// 1.2 solar + 0.5 import + 0.3 discharge = 0.4 export + 0.6 charge + 1.0 load
+	row := &UsageRow{
+		GE_SolarKWh:            floatPtr(1.2),
+		GE_ImportKWh:           floatPtr(0.5),
+		GE_BatteryDischargeKWh: floatPtr(0.3),
+		GE_ExportKWh:           floatPtr(0.4),
+		GE_BatteryChargeKWh:    floatPtr(0.6),
+		GE_ConsumptionKWh:      floatPtr(1.0),
+	}
+	require.InDelta(t, 0, *energyBalanceResidual(row), 1e-9)
+
+	// A dropped solar reading leaves the load unexplained
+	row.GE_SolarKWh = floatPtr(0.2)
+	require.InDelta(t, -1, *energyBalanceResidual(row), 1e-9)
+
+	row.GE_ConsumptionKWh = nil
+	require.Nil(t, energyBalanceResidual(row))
+}
+
+func TestFetchHalfHourlyInverterDataEnergyBalance(t *testing.T) {
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			responseBody := `{
+				"data": [
+					{"time": "2025-06-01T00:00:00Z", "total": {"solar": 10, "consumption": 20, "grid": {"import": 100, "export": 50}, "battery": {"charge": 5, "discharge": 7}}},
+					{"time": "2025-06-01T00:30:00Z", "total": {"solar": 11.2, "consumption": 21, "grid": {"import": 100.5, "export": 50.4}, "battery": {"charge": 5.6, "discharge": 7.3}}}
+				],
+				"meta": {"current_page": 1, "last_page": 1}
+			}`
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(responseBody)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	givService := NewGivEnergyService(mockRoundTripper, "dummyBearerToken")
+	start := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	data := map[time.Time]*UsageRow{}
+	require.NoError(t, givService.FetchHalfHourlyInverterData(context.Background(), data, []string{"ABC12345"}, start, start.Add(time.Hour)))
+	row := data[start.Local()]
+	require.InDelta(t, 1.2, *row.GE_SolarKWh, 1e-9)
+	require.InDelta(t, 1.0, *row.GE_ConsumptionKWh, 1e-9)
+	require.InDelta(t, 0, *energyBalanceResidual(row), 1e-9)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// BatteryDay is one local day's battery arbitrage: what charging cost against what the
+// discharged energy would otherwise have cost to import.
+type BatteryDay struct {
+	Day            time.Time
+	ChargeKWh      float64
+	DischargeKWh   float64
+	ChargeCost     float64 // pence, charge kWh at the charging slots' import price
+	DischargeValue float64 // pence, discharge kWh at the discharging slots' import price
+}
+
+// Arbitrage is the day's discharge value less its charge cost, in pence.
+func (d BatteryDay) Arbitrage() float64 {
+	return d.DischargeValue - d.ChargeCost
+}
+
+// batteryArbitrage prices each slot's GivEnergy battery charge and discharge at the slot import
+// price and totals them per local day. Days without any battery activity are left out.
+func batteryArbitrage(data []*UsageRow, mode RoundingMode, loc *time.Location) []BatteryDay {
+	byDay := make(map[time.Time]*BatteryDay)
+	var days []time.Time
+	for _, row := range data {
+		charge, discharge := row.GE_BatteryChargeKWh, row.GE_BatteryDischargeKWh
+		if (charge == nil || *charge <= 0) && (discharge == nil || *discharge <= 0) {
+			continue
+		}
+		day := truncateToMidnight(inZone(row.Timestamp, loc))
+		d, ok := byDay[day]
+		if !ok {
+			d = &BatteryDay{Day: day}
+			byDay[day] = d
+			days = append(days, day)
+		}
+		if charge != nil && *charge > 0 {
+			d.ChargeKWh += *charge
+			if cost := costPence(charge, row.ImportPrice, mode, defaultPrecision[GroupCost]); cost != nil {
+				d.ChargeCost += *cost
+			}
+		}
+		if discharge != nil && *discharge > 0 {
+			d.DischargeKWh += *discharge
+			if value := costPence(discharge, row.ImportPrice, mode, defaultPrecision[GroupCost]); value != nil {
+				d.DischargeValue += *value
+			}
+		}
+	}
+
+	result := make([]BatteryDay, len(days))
+	for i, day := range days {
+		result[i] = *byDay[day]
+	}
+	return result
+}
+
+// logBatteryArbitrage writes each day's battery charge cost and discharge value to the log.
+func logBatteryArbitrage(days []BatteryDay) {
+	if len(days) == 0 {
+		slog.Info("Battery arbitrage: no battery activity")
+		return
+	}
+	var total float64
+	for _, d := range days {
+		slog.Info("Battery", "day", d.Day.Format(time.DateOnly), "chargedKWh", fmt.Sprintf("%.3f", d.ChargeKWh),
+			"chargeCost", fmt.Sprintf("%.2fp", d.ChargeCost), "dischargedKWh", fmt.Sprintf("%.3f", d.DischargeKWh),
+			"dischargeValue", fmt.Sprintf("%.2fp", d.DischargeValue), "arbitrage", fmt.Sprintf("%+.2fp", d.Arbitrage()))
+		total += d.Arbitrage()
+	}
+	slog.Info("Battery arbitrage", "total", fmt.Sprintf("%+.2fp", total), "days", len(days))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatteryArbitrage(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	idle := day.AddDate(0, 0, 1)
+	data := []*UsageRow{
+		// Charge 3 kWh overnight at 7p, discharge 2.5 kWh in the evening peak at 30p
+		{Timestamp: day.Add(2 * time.Hour), GE_BatteryChargeKWh: floatPtr(1.5), GE_BatteryDischargeKWh: floatPtr(0), ImportPrice: floatPtr(7)},
+		{Timestamp: day.Add(150 * time.Minute), GE_BatteryChargeKWh: floatPtr(1.5), GE_BatteryDischargeKWh: floatPtr(0), ImportPrice: floatPtr(7)},
+		{Timestamp: day.Add(17 * time.Hour), GE_BatteryChargeKWh: floatPtr(0), GE_BatteryDischargeKWh: floatPtr(1.0), ImportPrice: floatPtr(30)},
+		{Timestamp: day.Add(1050 * time.Minute), GE_BatteryChargeKWh: floatPtr(0), GE_BatteryDischargeKWh: floatPtr(1.5), ImportPrice: floatPtr(30)},
+		{Timestamp: day.Add(20 * time.Hour), GE_ImportKWh: floatPtr(1), ImportPrice: floatPtr(20)},
+		// The next day the battery does nothing
+		{Timestamp: idle, GE_BatteryChargeKWh: floatPtr(0), GE_BatteryDischargeKWh: floatPtr(0), ImportPrice: floatPtr(7)},
+		{Timestamp: idle.Add(30 * time.Minute), ImportPrice: floatPtr(7)},
+	}
+
+	days := batteryArbitrage(data, RoundHalfUp, nil)
+	require.Len(t, days, 1, "Expected the idle day to be left out")
+	require.Equal(t, day, days[0].Day)
+	require.InDelta(t, 3, days[0].ChargeKWh, 1e-9)
+	require.InDelta(t, 2.5, days[0].DischargeKWh, 1e-9)
+	require.InDelta(t, 21, days[0].ChargeCost, 1e-9)
+	require.InDelta(t, 75, days[0].DischargeValue, 1e-9)
+	require.InDelta(t, 54, days[0].Arbitrage(), 1e-9)
+
+	require.Empty(t, batteryArbitrage(data[5:], RoundHalfUp, nil))
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // cachedResponse is a helper struct to store the response fields
@@ -27,6 +28,17 @@ type CachingRoundTripper struct {
 
 	// CacheDir is the directory where response files are stored.
 	CacheDir string
+
+	// ReadDirs are further directories checked, in order, after CacheDir for a cached
+	// response. They are never written to, so can hold a read-only seeded cache.
+	ReadDirs []string
+
+	// ForceRefresh treats every request as a miss, overwriting any cached response with the fresh one.
+	ForceRefresh bool
+
+	// TTL, when set, treats cached responses older than it as a miss, so they are refetched
+	// and overwritten. Zero never expires them.
+	TTL time.Duration
 }
 
 func (c *CachingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -42,13 +54,24 @@ func (c *CachingRoundTripper) RoundTrip(req *http.Request) (*http.Response, erro
 		req.Body = io.NopCloser(strings.NewReader(string(bodyBytes)))
 	}
 
+	// Requests other than GET, such as logins and GraphQL queries, aren't cached: the cache is
+	// keyed only on the method and URL, so their responses would be replayed for other bodies
+	if req.Method != http.MethodGet {
+		return c.UnderlyingTransport.RoundTrip(req)
+	}
+
 	// Build a filename from the method + URL. Then sanitize it.
 	fileName := sanitizeFileName(req.Method + "_" + req.URL.String())
 	cacheFilePath := filepath.Join(c.CacheDir, fileName+".json")
 
 	// If we have a cached file, try to load it and return it.
-	if _, err := os.Stat(cacheFilePath); err == nil {
-		return c.loadCachedResponse(cacheFilePath, req)
+	if !c.ForceRefresh {
+		for _, dir := range append([]string{c.CacheDir}, c.ReadDirs...) {
+			path := filepath.Join(dir, fileName+".json")
+			if info, err := os.Stat(path); err == nil && !c.expired(info.ModTime()) {
+				return c.loadCachedResponse(path, req)
+			}
+		}
 	}
 
 	// Otherwise, do a real round trip.
@@ -64,7 +87,8 @@ func (c *CachingRoundTripper) RoundTrip(req *http.Request) (*http.Response, erro
 		return nil, err
 	}
 
-	// Save response to disk.
+	// Save response to disk only when it succeeded, so errors such as a transient 500 or a
+	// 429 are fetched afresh on the next request rather than replayed.
 	cr := cachedResponse{
 		Status:     resp.Status,
 		StatusCode: resp.StatusCode,
@@ -72,14 +96,26 @@ func (c *CachingRoundTripper) RoundTrip(req *http.Request) (*http.Response, erro
 		Header:     resp.Header.Clone(),
 		Body:       respBodyBytes,
 	}
-	if err := saveCachedResponse(cacheFilePath, &cr); err != nil {
-		return nil, err
+	if cacheable(resp.StatusCode) {
+		if err := saveCachedResponse(cacheFilePath, &cr); err != nil {
+			return nil, err
+		}
 	}
 
 	// We need to return a new http.Response that has a readable Body.
 	return buildHTTPResponse(req, cr), nil
 }
 
+// expired reports whether a response cached at modified has outlived the TTL.
+func (c *CachingRoundTripper) expired(modified time.Time) bool {
+	return c.TTL > 0 && time.Since(modified) > c.TTL
+}
+
+// cacheable reports whether a response with the status is saved to the cache.
+func cacheable(code int) bool {
+	return code >= http.StatusOK && code < http.StatusMultipleChoices || code == http.StatusNotModified
+}
+
 // loadCachedResponse reads the cached file, deserializes it, and returns an *http.Response.
 func (c *CachingRoundTripper) loadCachedResponse(path string, req *http.Request) (*http.Response, error) {
 	data, err := os.ReadFile(path)
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachingRoundTripperForceRefresh(t *testing.T) {
+	calls := 0
+	body := "stale"
+	underlying := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			calls++
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(body))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	dir := t.TempDir()
+	get := func(rt http.RoundTripper) string {
+		resp, err := (&http.Client{Transport: rt}).Get("https://api.octopus.energy/v1/products/")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	cached := &CachingRoundTripper{UnderlyingTransport: underlying, CacheDir: dir}
+	require.Equal(t, "stale", get(cached))
+	body = "fresh"
+	require.Equal(t, "stale", get(cached), "Expected the cached response")
+	require.Equal(t, 1, calls)
+
+	refresh := &CachingRoundTripper{UnderlyingTransport: underlying, CacheDir: dir, ForceRefresh: true}
+	require.Equal(t, "fresh", get(refresh), "Expected the cache to be bypassed")
+	require.Equal(t, 2, calls)
+
+	require.Equal(t, "fresh", get(cached), "Expected the cached entry to be overwritten")
+	require.Equal(t, 2, calls)
+}
+
+func TestCachingRoundTripperReadDirs(t *testing.T) {
+	var requested []string
+	underlying := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			requested = append(requested, req.URL.Path)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte("fetched"))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	get := func(rt http.RoundTripper, url string) string {
+		resp, err := (&http.Client{Transport: rt}).Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	// Seed a cache then make it read-only
+	seed := t.TempDir()
+	seedBody := "seeded"
+	seeder := &CachingRoundTripper{CacheDir: seed, UnderlyingTransport: &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(seedBody))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}}
+	require.Equal(t, "seeded", get(seeder, "https://api.octopus.energy/v1/products/"))
+	seeded, err := os.ReadDir(seed)
+	require.NoError(t, err)
+	require.NoError(t, os.Chmod(seed, 0555))
+	t.Cleanup(func() { _ = os.Chmod(seed, 0755) })
+
+	overlay := t.TempDir()
+	rt := &CachingRoundTripper{UnderlyingTransport: underlying, CacheDir: overlay, ReadDirs: []string{seed}}
+
+	require.Equal(t, "seeded", get(rt, "https://api.octopus.energy/v1/products/"), "Expected a hit from the seed")
+	require.Equal(t, "fetched", get(rt, "https://api.octopus.energy/v1/accounts/A-123/"), "Expected a miss")
+	require.Equal(t, []string{"/v1/accounts/A-123/"}, requested)
+
+	written, err := os.ReadDir(overlay)
+	require.NoError(t, err)
+	require.Len(t, written, 1, "Expected the miss in the write dir")
+	unchanged, err := os.ReadDir(seed)
+	require.NoError(t, err)
+	require.Len(t, unchanged, len(seeded), "Expected the seed to be left alone")
+}
+
+func TestCachingRoundTripperSkipsErrors(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	calls := 0
+	underlying := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			calls++
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(bytes.NewReader([]byte("body"))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	dir := t.TempDir()
+	cached := &CachingRoundTripper{UnderlyingTransport: underlying, CacheDir: dir}
+	get := func() int {
+		resp, err := (&http.Client{Transport: cached}).Get("https://api.givenergy.cloud/v1/inverter/ABC/data-points/2025-01-01")
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	cacheFiles := func() int {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		return len(entries)
+	}
+
+	require.Equal(t, http.StatusServiceUnavailable, get())
+	require.Zero(t, cacheFiles(), "Expected the server error not to be cached")
+	status = http.StatusTooManyRequests
+	require.Equal(t, http.StatusTooManyRequests, get())
+	require.Zero(t, cacheFiles(), "Expected the rate limit not to be cached")
+	status = http.StatusOK
+	require.Equal(t, http.StatusOK, get())
+	require.Equal(t, 1, cacheFiles())
+	require.Equal(t, http.StatusOK, get())
+	require.Equal(t, 3, calls)
+}
+
+func TestCachingRoundTripperTTL(t *testing.T) {
+	calls := 0
+	body := "stale"
+	underlying := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			calls++
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(body))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	dir := t.TempDir()
+	get := func(rt http.RoundTripper) string {
+		resp, err := (&http.Client{Transport: rt}).Get("https://api.octopus.energy/v1/products/")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	cached := &CachingRoundTripper{UnderlyingTransport: underlying, CacheDir: dir, TTL: time.Hour}
+	require.Equal(t, "stale", get(cached))
+	body = "fresh"
+	require.Equal(t, "stale", get(cached), "Expected the entry within its TTL to be used")
+	require.Equal(t, 1, calls)
+
+	// Age the cached entry past the TTL
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, entries[0].Name()), old, old))
+
+	forever := &CachingRoundTripper{UnderlyingTransport: underlying, CacheDir: dir}
+	require.Equal(t, "stale", get(forever), "Expected a zero TTL never to expire entries")
+	require.Equal(t, "fresh", get(cached), "Expected the expired entry to be refetched")
+	require.Equal(t, 2, calls)
+	require.Equal(t, "fresh", get(cached), "Expected the refetched entry to be cached")
+	require.Equal(t, 2, calls)
+}
+
+func TestCachingRoundTripperSkipsPost(t *testing.T) {
+	calls := 0
+	underlying := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			calls++
+			body, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader(body)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	dir := t.TempDir()
+	client := &http.Client{Transport: &CachingRoundTripper{UnderlyingTransport: underlying, CacheDir: dir}}
+	post := func(body string) string {
+		resp, err := client.Post("https://api.example/graphql", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(got)
+	}
+
+	require.Equal(t, "first", post("first"))
+	require.Equal(t, "second", post("second"), "Expected a POST with another body not answered from the cache")
+	require.Equal(t, 2, calls)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries, "Expected POST responses not to be cached")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// carbonIntensityURL is the National Grid Carbon Intensity API.
+const carbonIntensityURL = "https://api.carbonintensity.org.uk"
+
+// carbonIntensityMaxRange is the longest range the regional endpoint returns in one request.
+const carbonIntensityMaxRange = 14 * 24 * time.Hour
+
+// carbonRegionIDs maps the grid supply point region letter, as at the end of a tariff code,
+// to the Carbon Intensity API region ID.
+var carbonRegionIDs = map[string]int{
+	"P": 1, "N": 2, "G": 3, "F": 4, "M": 5, "D": 6, "K": 7,
+	"E": 8, "B": 9, "A": 10, "L": 11, "H": 12, "C": 13, "J": 14,
+}
+
+// carbonRegionID returns the Carbon Intensity region ID for the region of tariffCode.
+func carbonRegionID(tariffCode string) (int, error) {
+	region := tariffCode[strings.LastIndex(tariffCode, "-")+1:]
+	id, ok := carbonRegionIDs[region]
+	if !ok {
+		return 0, fmt.Errorf("tariff code %q has no region suffix", tariffCode)
+	}
+	return id, nil
+}
+
+// CarbonIntensityService fetches regional carbon intensity from the National Grid.
+type CarbonIntensityService struct {
+	Client  *http.Client
+	BaseURL string
+	// Granularity is the slot width intensity is averaged into, half-hourly by default.
+	Granularity Granularity
+	// SlotOffset moves the slot boundaries past the hour and half-hour, for meters reporting at e.g. :05 and :35.
+	SlotOffset time.Duration
+	// Location is the zone slots are bucketed in, local time when nil.
+	Location *time.Location
+}
+
+// NewCarbonIntensityService creates a CarbonIntensityService. The API needs no authentication.
+func NewCarbonIntensityService(rt http.RoundTripper) *CarbonIntensityService {
+	return &CarbonIntensityService{Client: &http.Client{Transport: rt}, BaseURL: carbonIntensityURL}
+}
+
+// GetRegionalIntensity returns the half-hourly carbon intensity, in gCO2/kWh, for the region
+// between start and end, keyed by the start of each half-hour. Half-hours the API has no
+// figure for are left out.
+func (s *CarbonIntensityService) GetRegionalIntensity(ctx context.Context, regionID int, start, end time.Time) (map[time.Time]float64, error) {
+	const layout = "2006-01-02T15:04Z"
+	intensity := make(map[time.Time]float64)
+
+	for from := start.UTC(); from.Before(end); from = from.Add(carbonIntensityMaxRange) {
+		to := from.Add(carbonIntensityMaxRange)
+		if to.After(end) {
+			to = end.UTC()
+		}
+		url := fmt.Sprintf("%s/regional/intensity/%s/%s/regionid/%d", s.BaseURL, from.Format(layout), to.Format(layout), regionID)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.Client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch carbon intensity: %w", err)
+		}
+
+		var body struct {
+			Data struct {
+				Data []struct {
+					From      string `json:"from"`
+					Intensity struct {
+						Forecast *float64 `json:"forecast"`
+						Actual   *float64 `json:"actual"`
+					} `json:"intensity"`
+				} `json:"data"`
+			} `json:"data"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch carbon intensity: unexpected status %s", resp.Status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode carbon intensity: %w", err)
+		}
+
+		for _, d := range body.Data.Data {
+			t, err := time.Parse(layout, d.From)
+			if err != nil {
+				return nil, fmt.Errorf("invalid carbon intensity time %q: %w", d.From, err)
+			}
+			value := d.Intensity.Actual
+			if value == nil {
+				value = d.Intensity.Forecast
+			}
+			if value != nil {
+				intensity[inZone(t, s.Location)] = *value
+			}
+		}
+	}
+	slog.Info("Fetched carbon intensity records", "count", len(intensity))
+	return intensity, nil
+}
+
+// PopulateCarbonIntensity sets each row's carbon intensity to the mean of the half-hours in its
+// slot. Rows without any intensity are left as gaps.
+func (s *CarbonIntensityService) PopulateCarbonIntensity(usage UsageStore, intensity map[time.Time]float64) {
+	sums := make(map[time.Time]float64)
+	counts := make(map[time.Time]int)
+	for t, value := range intensity {
+		slot := s.Granularity.offsetSlot(t, s.SlotOffset, s.Location)
+		sums[slot] += value
+		counts[slot]++
+	}
+	for timestamp, row := range usage {
+		if counts[timestamp] == 0 {
+			continue
+		}
+		mean := sums[timestamp] / float64(counts[timestamp])
+		row.CarbonIntensity = &mean
+	}
+}
+
+// importGramsCO2 returns the emissions of the row's Octopus import, or nil if either figure is missing.
+func importGramsCO2(row *UsageRow) *float64 {
+	if row.OCTO_ImportKWh == nil || row.CarbonIntensity == nil {
+		return nil
+	}
+	grams := *row.OCTO_ImportKWh * *row.CarbonIntensity
+	return &grams
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCarbonIntensity(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			require.Equal(t, "/regional/intensity/2025-01-01T00:00Z/2025-01-01T02:00Z/regionid/5", req.URL.Path)
+			responseBody := `{"data": {"regionid": 5, "shortname": "Yorkshire", "data": [
+				{"from": "2025-01-01T00:00Z", "to": "2025-01-01T00:30Z", "intensity": {"forecast": 200, "index": "moderate"}},
+				{"from": "2025-01-01T00:30Z", "to": "2025-01-01T01:00Z", "intensity": {"forecast": 150, "actual": 160, "index": "moderate"}},
+				{"from": "2025-01-01T01:00Z", "to": "2025-01-01T01:30Z", "intensity": {"forecast": null, "index": "moderate"}}
+			]}}`
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	regionID, err := carbonRegionID("E-1R-AGILE-24-10-01-M")
+	require.NoError(t, err)
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	service := NewCarbonIntensityService(mockRoundTripper)
+	intensity, err := service.GetRegionalIntensity(context.Background(), regionID, start, start.Add(2*time.Hour))
+	require.NoError(t, err)
+
+	usage := make(UsageStore)
+	for i := 0; i < 4; i++ {
+		ts := start.Add(time.Duration(i) * 30 * time.Minute)
+		usage[ts] = &UsageRow{Timestamp: ts, OCTO_ImportKWh: floatPtr(0.5)}
+	}
+	service.PopulateCarbonIntensity(usage, intensity)
+
+	first := usage[start]
+	require.InDelta(t, 200, *first.CarbonIntensity, 1e-9)
+	require.InDelta(t, 100, *importGramsCO2(first), 1e-9)
+	require.InDelta(t, 160, *usage[start.Add(30*time.Minute)].CarbonIntensity, 1e-9, "Expected the actual over the forecast")
+	require.Nil(t, usage[start.Add(time.Hour)].CarbonIntensity, "Expected a gap without a figure")
+	require.Nil(t, importGramsCO2(usage[start.Add(90*time.Minute)]), "Expected a gap outside the data")
+}
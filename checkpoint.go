@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpointSample is a GivEnergy data point as stored in a checkpoint.
+type checkpointSample struct {
+	Time        time.Time `json:"time"`
+	Import      float64   `json:"import"`
+	Export      float64   `json:"export"`
+	Charge      float64   `json:"charge,omitempty"`
+	Discharge   float64   `json:"discharge,omitempty"`
+	Solar       float64   `json:"solar,omitempty"`
+	Consumption float64   `json:"consumption,omitempty"`
+}
+
+// Checkpoint records the progress of a run so a restart can resume from the last completed
+// Octopus page, GivEnergy day or source rather than fetching everything again.
+// A nil *Checkpoint is valid and records nothing.
+type Checkpoint struct {
+	path string
+
+	// Usage is the in-progress store, saved with every update.
+	Usage UsageStore `json:"-"`
+
+	Start     time.Time                     `json:"start"`
+	End       time.Time                     `json:"end"`
+	Rows      []*UsageRow                   `json:"rows"`
+	Pages     map[string]int64              `json:"pages"`     // next Octopus page per meter
+	GivDays   map[string][]checkpointSample `json:"giv_days"`  // GivEnergy data points per fetched day
+	Completed map[string]bool               `json:"completed"` // sources fetched in full
+}
+
+// LoadCheckpoint reads the checkpoint at path into usage, with timestamps in loc. A missing
+// checkpoint, or one for a different range, starts afresh.
+func LoadCheckpoint(path string, usage UsageStore, start, end time.Time, loc *time.Location) (*Checkpoint, error) {
+	cp := &Checkpoint{
+		path:      path,
+		Usage:     usage,
+		Start:     start,
+		End:       end,
+		Pages:     make(map[string]int64),
+		GivDays:   make(map[string][]checkpointSample),
+		Completed: make(map[string]bool),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var saved Checkpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+	}
+	if !saved.Start.Equal(start) || !saved.End.Equal(end) {
+		slog.Warn("ignoring checkpoint for a different range", "checkpoint", path,
+			"start", saved.Start.Format(time.RFC3339), "end", saved.End.Format(time.RFC3339))
+		return cp, nil
+	}
+
+	for _, row := range saved.Rows {
+		row.Timestamp = inZone(row.Timestamp, loc)
+		usage[row.Timestamp] = row
+	}
+	for key, page := range saved.Pages {
+		cp.Pages[key] = page
+	}
+	for day, samples := range saved.GivDays {
+		cp.GivDays[day] = samples
+	}
+	for source, done := range saved.Completed {
+		cp.Completed[source] = done
+	}
+	slog.Info("Resuming from checkpoint", "checkpoint", path, "rows", len(saved.Rows))
+	return cp, nil
+}
+
+// Save writes the checkpoint, replacing the previous one atomically.
+func (cp *Checkpoint) Save() error {
+	if cp == nil {
+		return nil
+	}
+
+	cp.Rows = cp.Rows[:0]
+	for _, row := range cp.Usage {
+		cp.Rows = append(cp.Rows, row)
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cp.path), filepath.Base(cp.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return os.Rename(tmp.Name(), cp.path)
+}
+
+// Remove deletes the checkpoint once the run has completed.
+func (cp *Checkpoint) Remove() error {
+	if cp == nil {
+		return nil
+	}
+	if err := os.Remove(cp.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Done reports whether source has been fetched in full.
+func (cp *Checkpoint) Done(source string) bool {
+	return cp != nil && cp.Completed[source]
+}
+
+// Complete records source as fetched in full and saves the checkpoint.
+func (cp *Checkpoint) Complete(source string) error {
+	if cp == nil {
+		return nil
+	}
+	cp.Completed[source] = true
+	return cp.Save()
+}
+
+// page returns the next Octopus page to fetch for key, starting at 1.
+func (cp *Checkpoint) page(key string) int64 {
+	if cp == nil || cp.Pages[key] == 0 {
+		return 1
+	}
+	return cp.Pages[key]
+}
+
+// pageDone records that the pages before next have been fetched for key and saves the checkpoint.
+func (cp *Checkpoint) pageDone(key string, next int64) error {
+	if cp == nil {
+		return nil
+	}
+	cp.Pages[key] = next
+	return cp.Save()
+}
+
+// givDay returns the GivEnergy data points saved for day, if it has been fetched.
+func (cp *Checkpoint) givDay(day string, loc *time.Location) (points givPoints, ok bool) {
+	if cp == nil {
+		return points, false
+	}
+	samples, ok := cp.GivDays[day]
+	for _, s := range samples {
+		t := inZone(s.Time, loc)
+		points.importSeries = append(points.importSeries, givSample{t, s.Import})
+		points.exportSeries = append(points.exportSeries, givSample{t, s.Export})
+		points.chargeSeries = append(points.chargeSeries, givSample{t, s.Charge})
+		points.dischargeSeries = append(points.dischargeSeries, givSample{t, s.Discharge})
+		points.solarSeries = append(points.solarSeries, givSample{t, s.Solar})
+		points.consumptionSeries = append(points.consumptionSeries, givSample{t, s.Consumption})
+	}
+	return points, ok
+}
+
+// givDayDone records the GivEnergy data points fetched for day and saves the checkpoint.
+func (cp *Checkpoint) givDayDone(day string, points givPoints) error {
+	if cp == nil {
+		return nil
+	}
+	samples := make([]checkpointSample, len(points.importSeries))
+	for i, s := range points.importSeries {
+		samples[i] = checkpointSample{s.timestamp, s.value, points.exportSeries[i].value,
+			points.chargeSeries[i].value, points.dischargeSeries[i].value,
+			points.solarSeries[i].value, points.consumptionSeries[i].value}
+	}
+	cp.GivDays[day] = samples
+	return cp.Save()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// failingOnce returns a handler that fails the first request matching fail and records every request path.
+func failingOnce(fail string, requested *[]string, respond func(req *http.Request) string) func(req *http.Request) (*http.Response, error) {
+	failed := false
+	return func(req *http.Request) (*http.Response, error) {
+		*requested = append(*requested, req.URL.Path+"?"+req.URL.Query().Get("page"))
+		status, body := http.StatusOK, respond(req)
+		if !failed && strings.Contains(req.URL.Path+"?"+req.URL.Query().Get("page"), fail) {
+			failed = true
+			status, body = http.StatusInternalServerError, `{}`
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       io.NopCloser(bytes.NewReader([]byte(body))),
+			Header:     make(http.Header),
+		}, nil
+	}
+}
+
+func TestCheckpointResumeGivEnergy(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(0, 0, 2)
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	var requested []string
+	mockRoundTripper := &MockRoundTripper{
+		Handler: failingOnce("2025-01-02", &requested, func(req *http.Request) string {
+			day, err := time.ParseInLocation("2006-01-02", filepath.Base(req.URL.Path), time.Local)
+			require.NoError(t, err)
+			var data []string
+			for ts := day; ts.Before(day.AddDate(0, 0, 1)); ts = ts.Add(time.Hour) {
+				data = append(data, fmt.Sprintf(`{"time": %q, "total": {"grid": {"import": %f, "export": 0}}}`,
+					ts.UTC().Format(time.RFC3339), ts.Sub(start).Hours()))
+			}
+			return fmt.Sprintf(`{"data": [%s], "meta": {"current_page": 1, "last_page": 1}}`, strings.Join(data, ","))
+		}),
+	}
+
+	// The first run fails part way, after the first day has been fetched
+	usage := make(UsageStore)
+	checkpoint, err := LoadCheckpoint(path, usage, start, end, nil)
+	require.NoError(t, err)
+	givService := NewGivEnergyService(mockRoundTripper, "dummyBearerToken")
+	givService.Checkpoint = checkpoint
+	require.Error(t, givService.FetchHalfHourlyInverterData(context.Background(), usage, []string{"ABC12345"}, start, end))
+	require.Equal(t, []string{"/v1/inverter/ABC12345/data-points/2025-01-01?1", "/v1/inverter/ABC12345/data-points/2025-01-02?1"}, requested)
+
+	// The restart only fetches the day that failed
+	requested = nil
+	usage = make(UsageStore)
+	checkpoint, err = LoadCheckpoint(path, usage, start, end, nil)
+	require.NoError(t, err)
+	givService.Checkpoint = checkpoint
+	require.NoError(t, givService.FetchHalfHourlyInverterData(context.Background(), usage, []string{"ABC12345"}, start, end))
+	require.Equal(t, []string{"/v1/inverter/ABC12345/data-points/2025-01-02?1"}, requested)
+
+	row := usage[start.Add(23*time.Hour)]
+	require.NotNil(t, row)
+	require.NotNil(t, row.GE_ImportKWh)
+	require.InDelta(t, 0.5, *row.GE_ImportKWh, 0.0001)
+}
+
+func TestCheckpointResumeOctopus(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	var requested []string
+	mockRoundTripper := &MockRoundTripper{
+		Handler: failingOnce("?2", &requested, func(req *http.Request) string {
+			page := 1
+			if req.URL.Query().Get("page") == "2" {
+				page = 2
+			}
+			first := start.Add(time.Duration(page-1) * time.Hour)
+			next := `"https://api.octopus.energy/next"`
+			if page == 2 {
+				next = "null"
+			}
+			return fmt.Sprintf(`{"count": 4, "next": %s, "results": [
+				{"interval_start": %q, "interval_end": %q, "consumption": 0.5},
+				{"interval_start": %q, "interval_end": %q, "consumption": 0.5}
+			]}`, next,
+				first.Format(time.RFC3339), first.Add(30*time.Minute).Format(time.RFC3339),
+				first.Add(30*time.Minute).Format(time.RFC3339), first.Add(time.Hour).Format(time.RFC3339))
+		}),
+	}
+	meter := &MeterInfo{SerialNumber: "123456789", Mpan: "987654321"}
+	update := func(value float64, estimated bool, row *UsageRow) {
+		row.OCTO_ImportKWh = &value
+	}
+
+	usage := make(UsageStore)
+	checkpoint, err := LoadCheckpoint(path, usage, start, end, nil)
+	require.NoError(t, err)
+	octopusService := NewOctopusService(mockRoundTripper, "dummyApiKey")
+	octopusService.Checkpoint = checkpoint
+	require.Error(t, octopusService.GetMeterConsumption(context.Background(), usage, meter, start, end, update))
+	require.Len(t, requested, 2)
+
+	requested = nil
+	usage = make(UsageStore)
+	checkpoint, err = LoadCheckpoint(path, usage, start, end, nil)
+	require.NoError(t, err)
+	require.Len(t, usage, 2, "Expected the first page's rows from the checkpoint")
+	octopusService.Checkpoint = checkpoint
+	require.NoError(t, octopusService.GetMeterConsumption(context.Background(), usage, meter, start, end, update))
+	require.Len(t, requested, 1)
+	require.True(t, strings.HasSuffix(requested[0], "?2"), "Expected to resume from page 2, got %s", requested[0])
+	require.Len(t, usage, 4)
+	for timestamp, row := range usage {
+		require.NotNil(t, row.OCTO_ImportKWh, "Missing reading at %s", timestamp)
+	}
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"encoding/csv"
 	"fmt"
-	"os"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,89 +19,649 @@ func convertInt64(val *int64, divisor float64) *float64 {
 	return nil
 }
 
-// Helper function to format float64 values with precision
-func formatFloat(val *float64, precision int) string {
+// Helper function to format float64 values with precision, using a strconv format byte:
+// 'f' for fixed, 'e' for scientific or 'g' for general. General ignores the precision and
+// uses the shortest representation of the value.
+func formatFloat(val *float64, format byte, precision int) string {
 	if val != nil {
-		formatStr := fmt.Sprintf("%%.%df", precision)
-		return fmt.Sprintf(formatStr, *val)
+		if format == 'g' {
+			precision = -1
+		}
+		return strconv.FormatFloat(*val, format, precision, 64)
 	}
 	return "NaN"
 }
 
-// Compute the cost using integer math for accuracy
-func computeCost(energy *float64, price *float64) string {
-	if energy != nil && price != nil {
-		energyInt := int64(*energy * (10000))
-		priceInt := int64(*price * 10000)
-		costInt := (energyInt * priceInt) / 10000
-		return fmt.Sprintf("%.2f", float64(costInt)/10000)
+// ColumnGroup identifies a set of CSV columns that share a float format.
+type ColumnGroup string
+
+const (
+	GroupEnergy     ColumnGroup = "energy"     // kWh per slot
+	GroupPrice      ColumnGroup = "price"      // pence per kWh
+	GroupCumulative ColumnGroup = "cumulative" // cumulative meter readings
+	GroupCost       ColumnGroup = "cost"       // pence, which take a precision but not a float format
+	GroupCarbon     ColumnGroup = "carbon"     // carbon intensity and emissions, in grams
+	GroupPercent    ColumnGroup = "percent"    // percentages
+)
+
+// FloatFormat maps column groups to their format byte, see formatFloat. Groups without an
+// entry use fixed point.
+type FloatFormat map[ColumnGroup]byte
+
+func (f FloatFormat) format(group ColumnGroup) byte {
+	if format, ok := f[group]; ok {
+		return format
+	}
+	return 'f'
+}
+
+// parseFloatFormat parses either a single format applied to every group, e.g. "g", or
+// comma separated group=format pairs, e.g. "energy=g,price=f".
+func parseFloatFormat(value string) (FloatFormat, error) {
+	formats := make(FloatFormat)
+	if value == "" {
+		return formats, nil
+	}
+
+	parseFormat := func(s string) (byte, error) {
+		switch s {
+		case "f", "e", "g":
+			return s[0], nil
+		}
+		return 0, fmt.Errorf("unknown float format %q, expected f, e or g", s)
+	}
+
+	if !strings.Contains(value, "=") {
+		format, err := parseFormat(value)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range []ColumnGroup{GroupEnergy, GroupPrice, GroupCumulative} {
+			formats[group] = format
+		}
+		return formats, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		group, format, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		switch ColumnGroup(group) {
+		case GroupEnergy, GroupPrice, GroupCumulative:
+		default:
+			return nil, fmt.Errorf("unknown column group %q, expected %s, %s or %s", group, GroupEnergy, GroupPrice, GroupCumulative)
+		}
+		f, err := parseFormat(format)
+		if err != nil {
+			return nil, err
+		}
+		formats[ColumnGroup(group)] = f
+	}
+	return formats, nil
+}
+
+// Precision maps column groups to the digits written after the decimal point, see formatFloat.
+// Groups without an entry use defaultPrecision.
+type Precision map[ColumnGroup]int
+
+// defaultPrecision is each group's precision unless configured, enough for a Wh in the energy
+// columns and the tenth of a penny unit rates are quoted in.
+var defaultPrecision = Precision{GroupEnergy: 6, GroupPrice: 4, GroupCumulative: 4, GroupCost: 2, GroupCarbon: 2, GroupPercent: 2}
+
+func (p Precision) digits(group ColumnGroup) int {
+	if digits, ok := p[group]; ok {
+		return digits
+	}
+	return defaultPrecision[group]
+}
+
+// parsePrecision parses either a single precision applied to every group, e.g. "3", or comma
+// separated group=digits pairs, e.g. "energy=8,cost=4".
+func parsePrecision(value string) (Precision, error) {
+	precision := make(Precision)
+	if value == "" {
+		return precision, nil
+	}
+
+	parseDigits := func(s string) (int, error) {
+		digits, err := strconv.Atoi(s)
+		if err != nil || digits < 0 {
+			return 0, fmt.Errorf("invalid precision %q, expected a number of digits", s)
+		}
+		return digits, nil
+	}
+
+	if !strings.Contains(value, "=") {
+		digits, err := parseDigits(value)
+		if err != nil {
+			return nil, err
+		}
+		for group := range defaultPrecision {
+			precision[group] = digits
+		}
+		return precision, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		group, digits, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		if _, ok := defaultPrecision[ColumnGroup(group)]; !ok {
+			return nil, fmt.Errorf("unknown column group %q, expected %s, %s, %s, %s, %s or %s", group,
+				GroupEnergy, GroupPrice, GroupCumulative, GroupCost, GroupCarbon, GroupPercent)
+		}
+		d, err := parseDigits(digits)
+		if err != nil {
+			return nil, err
+		}
+		precision[ColumnGroup(group)] = d
+	}
+	return precision, nil
+}
+
+// TimestampFormat selects how the Timestamp column is written.
+type TimestampFormat string
+
+const (
+	TimestampRFC3339 TimestampFormat = "rfc3339"
+	TimestampEpoch   TimestampFormat = "epoch"    // Unix seconds
+	TimestampEpochMs TimestampFormat = "epoch_ms" // Unix milliseconds
+)
+
+// parseTimestampFormat validates a timestamp format name, defaulting to RFC3339 when empty.
+func parseTimestampFormat(value string) (TimestampFormat, error) {
+	switch f := TimestampFormat(value); f {
+	case "":
+		return TimestampRFC3339, nil
+	case TimestampRFC3339, TimestampEpoch, TimestampEpochMs:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown timestamp format %q, expected %s, %s or %s",
+		value, TimestampRFC3339, TimestampEpoch, TimestampEpochMs)
+}
+
+// format returns t in the timestamp format. The zero value is RFC3339, in loc or local time
+// when loc is nil.
+func (f TimestampFormat) format(t time.Time, loc *time.Location) string {
+	switch f {
+	case TimestampEpoch:
+		return strconv.FormatInt(t.Unix(), 10)
+	case TimestampEpochMs:
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	}
+	return inZone(t, loc).Format(time.RFC3339)
+}
+
+// parseTimestamp reads a timestamp written in any TimestampFormat. Epoch values above
+// 1e11, which as seconds would be thousands of years away, are taken as milliseconds.
+func parseTimestamp(value string) (time.Time, error) {
+	epoch, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Parse(time.RFC3339, value)
+	}
+	if epoch > 1e11 {
+		return time.UnixMilli(epoch), nil
+	}
+	return time.Unix(epoch, 0), nil
+}
+
+// Helper function to format int64 values
+func formatInt64(val *int64) string {
+	if val != nil {
+		return strconv.FormatInt(*val, 10)
 	}
 	return "NaN"
 }
 
+// RoundingMode selects how costs are rounded to hundredths of a penny.
+type RoundingMode string
+
+const (
+	RoundHalfUp   RoundingMode = "half-up"   // halves away from zero, as bills typically do
+	RoundHalfEven RoundingMode = "half-even" // halves to the even neighbour, banker's rounding
+	RoundTruncate RoundingMode = "truncate"  // toward zero
+)
+
+// parseRoundingMode validates a rounding mode name, defaulting to half-up when empty.
+func parseRoundingMode(value string) (RoundingMode, error) {
+	switch m := RoundingMode(value); m {
+	case "":
+		return RoundHalfUp, nil
+	case RoundHalfUp, RoundHalfEven, RoundTruncate:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown rounding mode %q, expected %s, %s or %s",
+		value, RoundHalfUp, RoundHalfEven, RoundTruncate)
+}
+
+// divide returns n / d rounded by the mode, for d > 0. The zero value rounds half-up.
+func (m RoundingMode) divide(n, d int64) int64 {
+	q, r := n/d, n%d
+	if r == 0 || m == RoundTruncate {
+		return q
+	}
+	step := int64(1)
+	if n < 0 {
+		step, r = -1, -r
+	}
+	switch {
+	case 2*r > d:
+		return q + step
+	case 2*r == d && (m != RoundHalfEven || q%2 != 0):
+		return q + step
+	}
+	return q
+}
+
+// Compute the cost using integer math for accuracy
+func computeCost(energy *float64, price *float64, mode RoundingMode, precision int) string {
+	return formatFloat(costPence(energy, price, mode, precision), 'f', precision)
+}
+
+// costDigits is the most decimal places of a penny costPence can round to.
+const costDigits = 12
+
+// costPence returns the cost in pence, rounded to precision decimal places by mode, or nil if
+// either figure is missing.
+func costPence(energy *float64, price *float64, mode RoundingMode, precision int) *float64 {
+	if energy == nil || price == nil {
+		return nil
+	}
+	precision = min(precision, costDigits)
+	// Energy to 8 decimal places and price to 4, so their product is in units of 1e-12 pence
+	energyInt := int64(math.Round(*energy * 1e8))
+	priceInt := int64(math.Round(*price * 1e4))
+	costInt := mode.divide(energyInt*priceInt, int64(math.Pow10(costDigits-precision)))
+	cost := float64(costInt) / math.Pow10(precision)
+	return &cost
+}
+
+// Direction selects the import or export side of the output columns.
+type Direction string
+
+const (
+	DirectionImport Direction = "import"
+	DirectionExport Direction = "export"
+)
+
+// sharedColumns identify a row, so are kept whatever the Direction.
+var sharedColumns = []string{"Timestamp", "Tag", "Account"}
+
+// columnDirection returns the side of the meter a column belongs to. Columns that aren't
+// named as export, including gas and the standing charge, are treated as import.
+func columnDirection(name string) Direction {
+	if strings.Contains(name, "Export") {
+		return DirectionExport
+	}
+	return DirectionImport
+}
+
+// splitFilename inserts the direction before the .csv extension, keeping any .gz suffix,
+// e.g. output.csv.gz becomes output.import.csv.gz.
+func splitFilename(filename string, direction Direction) string {
+	base, gz := strings.CutSuffix(filename, ".gz")
+	base, csv := strings.CutSuffix(base, ".csv")
+	name := base + "." + string(direction)
+	if csv {
+		name += ".csv"
+	}
+	if gz {
+		name += ".gz"
+	}
+	return name
+}
+
+// CSVOptions controls which optional columns writeCSV emits.
+type CSVOptions struct {
+	// IncludeImportSource adds the source of the import figure used for pricing.
+	IncludeImportSource bool
+	// IncludeCost adds the canonical import and export costs from the chosen figures.
+	IncludeCost bool
+	// IncludeExcVat adds ex-VAT prices and Octopus costs to pair with the inc-VAT columns.
+	IncludeExcVat bool
+	// IncludeLive adds the row tag and the live power columns populated on the live row.
+	IncludeLive bool
+	// IncludeAccount adds the account each row belongs to.
+	IncludeAccount bool
+	// IncludeRegister adds the cumulative Octopus import register reading.
+	IncludeRegister bool
+	// IncludeExportFill adds the source Octopus export gaps were filled from.
+	IncludeExportFill bool
+	// ImportTariff and ExportTariff, when set, add columns naming the import and export tariffs.
+	ImportTariff string
+	ExportTariff string
+	// IncludeCarbon adds the carbon intensity and the emissions of the Octopus import.
+	IncludeCarbon bool
+	// IncludeEnergyBalance adds the residual of the GivEnergy energy balance, which should be near zero.
+	IncludeEnergyBalance bool
+	// IncludeImportDelta adds the difference between the Octopus and GivEnergy import, in kWh
+	// and as a percentage of the Octopus import.
+	IncludeImportDelta bool
+	// IncludeGeoExport adds the Geo export and generation figures.
+	IncludeGeoExport bool
+	// IncludeGas adds the Octopus gas consumption, for accounts with a gas meter.
+	IncludeGas bool
+	// IncludeStandingCharge adds the standing charge placed on each row, and the row tag.
+	IncludeStandingCharge bool
+	// CostsOnly keeps just the timestamp, the price and cost columns and any row tag.
+	CostsOnly bool
+	// Direction, when set, keeps just the import or export columns alongside the shared ones.
+	Direction Direction
+	// FloatFormat selects the float format per column group, fixed point by default.
+	FloatFormat FloatFormat
+	// Precision selects the digits after the decimal point per column group, defaultPrecision by default.
+	Precision Precision
+	// Location, when set, is the zone RFC3339 timestamps are written in rather than local time.
+	Location *time.Location
+	// TimestampFormat selects the Timestamp column format, RFC3339 by default.
+	TimestampFormat TimestampFormat
+	// RoundingMode rounds the cost columns, half-up by default.
+	RoundingMode RoundingMode
+	// Validate reads the file back once written, failing if it is malformed.
+	Validate bool
+	// Format selects a row per slot or, when tidy, a row per slot and figure.
+	Format OutputFormat
+}
+
+// csvColumn describes a single CSV output column.
+type csvColumn struct {
+	Name  string
+	Value func(row *UsageRow) string
+}
+
+// csvColumns returns the output columns, in order, for the given options.
+func csvColumns(opts CSVOptions) []csvColumn {
+	energy := func(val *float64) string {
+		return formatFloat(val, opts.FloatFormat.format(GroupEnergy), opts.Precision.digits(GroupEnergy))
+	}
+	price := func(val *float64) string {
+		return formatFloat(val, opts.FloatFormat.format(GroupPrice), opts.Precision.digits(GroupPrice))
+	}
+	cumulative := func(val *float64) string {
+		return formatFloat(val, opts.FloatFormat.format(GroupCumulative), opts.Precision.digits(GroupCumulative))
+	}
+	carbon := func(val *float64) string { return formatFloat(val, 'f', opts.Precision.digits(GroupCarbon)) }
+	percent := func(val *float64) string { return formatFloat(val, 'f', opts.Precision.digits(GroupPercent)) }
+	cost := func(energy, price *float64) string {
+		return computeCost(energy, price, opts.RoundingMode, opts.Precision.digits(GroupCost))
+	}
+
+	columns := []csvColumn{
+		{"Timestamp", func(row *UsageRow) string { return opts.TimestampFormat.format(row.Timestamp, opts.Location) }},
+		{"GE_Cumulative_Import", func(row *UsageRow) string { return cumulative(row.CumulativeImportInverter) }},
+		{"GE_Cumulative_Export", func(row *UsageRow) string { return cumulative(row.CumulativeExportInverter) }},
+		{"GE_Import_KWh", func(row *UsageRow) string { return energy(row.GE_ImportKWh) }},
+		{"GE_Export_KWh", func(row *UsageRow) string { return energy(row.GE_ExportKWh) }},
+		{"GEO_Import_KWh", func(row *UsageRow) string { return energy(convertInt64(row.GEO_ImportWh, 1000)) }},
+		{"OCTO_Import_KWh", func(row *UsageRow) string { return energy(row.OCTO_ImportKWh) }},
+		{"OCTO_Export_KWh", func(row *UsageRow) string { return energy(row.OCTO_ExportKWh) }},
+		{"GEO_Gas_KWh", func(row *UsageRow) string { return energy(convertInt64(row.GEO_ImportGasWh, 1000)) }},
+		{"Import_Price", func(row *UsageRow) string { return price(row.ImportPrice) }},
+		{"Export_Price", func(row *UsageRow) string { return price(row.ExportPrice) }},
+		{"GE_Import_PenceCost", func(row *UsageRow) string { return cost(row.GE_ImportKWh, row.ImportPrice) }},
+		{"GE_Export_PenceCost", func(row *UsageRow) string { return cost(row.GE_ExportKWh, row.ExportPrice) }},
+		{"GEO_Import_PenceCost", func(row *UsageRow) string {
+			return cost(convertInt64(row.GEO_ImportWh, 1000), row.ImportPrice)
+		}},
+		{"OCTO_Import_PenceCost", func(row *UsageRow) string { return cost(row.OCTO_ImportKWh, row.ImportPrice) }},
+		{"OCTO_Export_PenceCost", func(row *UsageRow) string { return cost(row.OCTO_ExportKWh, row.ExportPrice) }},
+	}
+
+	if opts.IncludeCost {
+		columns = append(columns,
+			csvColumn{"Import_Cost", func(row *UsageRow) string { return cost(row.ImportKWh, row.ImportPrice) }},
+			csvColumn{"Export_Cost", func(row *UsageRow) string { return cost(row.ExportKWh, row.ExportPrice) }},
+		)
+	}
+
+	if opts.IncludeExcVat {
+		columns = append(columns,
+			csvColumn{"Import_Price_ExcVat", func(row *UsageRow) string { return price(row.ImportPriceExcVat) }},
+			csvColumn{"Export_Price_ExcVat", func(row *UsageRow) string { return price(row.ExportPriceExcVat) }},
+			csvColumn{"OCTO_Import_PenceCost_ExcVat", func(row *UsageRow) string {
+				return cost(row.OCTO_ImportKWh, row.ImportPriceExcVat)
+			}},
+			csvColumn{"OCTO_Export_PenceCost_ExcVat", func(row *UsageRow) string {
+				return cost(row.OCTO_ExportKWh, row.ExportPriceExcVat)
+			}},
+		)
+	}
+
+	if opts.IncludeRegister {
+		columns = append(columns, csvColumn{"OCTO_Import_Register", func(row *UsageRow) string {
+			return cumulative(row.OCTO_ImportRegisterKWh)
+		}})
+	}
+
+	if opts.IncludeExportFill {
+		columns = append(columns, csvColumn{"OCTO_Export_Filled_From", func(row *UsageRow) string { return row.OCTO_ExportFilledFrom }})
+	}
+
+	if opts.ImportTariff != "" || opts.ExportTariff != "" {
+		columns = append(columns,
+			csvColumn{"Import_Tariff", func(row *UsageRow) string { return opts.ImportTariff }},
+			csvColumn{"Export_Tariff", func(row *UsageRow) string { return opts.ExportTariff }},
+		)
+	}
+
+	if opts.IncludeCarbon {
+		columns = append(columns,
+			csvColumn{"Carbon_Intensity", func(row *UsageRow) string { return carbon(row.CarbonIntensity) }},
+			csvColumn{"Import_Grams_CO2", func(row *UsageRow) string { return carbon(importGramsCO2(row)) }},
+		)
+	}
+
+	if opts.IncludeEnergyBalance {
+		columns = append(columns, csvColumn{"Energy_Balance_Residual", func(row *UsageRow) string {
+			return energy(energyBalanceResidual(row))
+		}})
+	}
+
+	if opts.IncludeImportDelta {
+		columns = append(columns,
+			csvColumn{"Import_Delta_KWh", func(row *UsageRow) string { return energy(importDelta(row)) }},
+			csvColumn{"Import_Delta_Percent", func(row *UsageRow) string { return percent(importDeltaPercent(row)) }},
+		)
+	}
+
+	if opts.IncludeGeoExport {
+		columns = append(columns,
+			csvColumn{"GEO_Export_KWh", func(row *UsageRow) string { return energy(convertInt64(row.GEO_ExportWh, 1000)) }},
+			csvColumn{"GEO_Generation_KWh", func(row *UsageRow) string { return energy(convertInt64(row.GEO_GenerationWh, 1000)) }},
+		)
+	}
+
+	if opts.IncludeGas {
+		columns = append(columns, csvColumn{"OCTO_Gas_KWh", func(row *UsageRow) string { return energy(row.OCTO_GasKWh) }})
+	}
+
+	if opts.IncludeStandingCharge {
+		columns = append(columns, csvColumn{"Standing_Charge", func(row *UsageRow) string {
+			return price(row.StandingCharge)
+		}})
+	}
+
+	if opts.IncludeLive || opts.IncludeStandingCharge {
+		columns = append(columns, csvColumn{"Tag", func(row *UsageRow) string { return row.Tag }})
+	}
+
+	if opts.IncludeLive {
+		columns = append(columns,
+			csvColumn{"GEO_Live_Watts", func(row *UsageRow) string { return formatInt64(row.GEO_LiveWatts) }},
+			csvColumn{"GEO_Live_Gas_Watts", func(row *UsageRow) string { return formatInt64(row.GEO_LiveGasWatts) }},
+		)
+	}
+
+	if opts.IncludeAccount {
+		columns = append(columns, csvColumn{"Account", func(row *UsageRow) string { return row.Account }})
+	}
+
+	if opts.IncludeImportSource {
+		columns = append(columns, csvColumn{"Import_Source", func(row *UsageRow) string { return row.ImportSource }})
+	}
+
+	if opts.CostsOnly {
+		columns = slices.DeleteFunc(columns, func(column csvColumn) bool {
+			return column.Name != "Timestamp" && column.Name != "Tag" && !strings.Contains(column.Name, "Price") &&
+				!strings.Contains(column.Name, "Cost") && !strings.Contains(column.Name, "Charge")
+		})
+	}
+
+	if opts.Direction != "" {
+		columns = slices.DeleteFunc(columns, func(column csvColumn) bool {
+			return !slices.Contains(sharedColumns, column.Name) && columnDirection(column.Name) != opts.Direction
+		})
+	}
+
+	return columns
+}
+
+// writeSplitCSV writes the import and export columns of data to separate files named after
+// filename, see splitFilename.
+func writeSplitCSV(filename string, data []*UsageRow, opts CSVOptions) error {
+	for _, direction := range []Direction{DirectionImport, DirectionExport} {
+		opts.Direction = direction
+		if err := writeCSV(splitFilename(filename, direction), data, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Write data to a CSV file
-func writeCSV(filename string, data []*UsageRow) error {
-	if len(data) < 2 {
-		return fmt.Errorf("not enough data to write CSV")
+func writeCSV(filename string, data []*UsageRow, opts CSVOptions) error {
+	if len(data) < 1 {
+		return fmt.Errorf("no data to write CSV")
 	}
 
-	file, err := os.Create(filename)
+	w, err := newCSVWriter(filename, opts)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer w.file.Abort()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	// Remove the first row since we don't have the data for the previous row
-	data = data[1:]
-
-	header := []string{
-		"Timestamp",
-		"GE_Cumulative_Import",
-		"GE_Cumulative_Export",
-		"GE_Import_KWh",
-		"GE_Export_KWh",
-		"GEO_Import_KWh",
-		"OCTO_Import_KWh",
-		"OCTO_Export_KWh",
-		"GEO_Gas_KWh",
-		"Import_Price",
-		"Export_Price",
-		"GE_Import_PenceCost",
-		"GE_Export_PenceCost",
-		"GEO_Import_PenceCost",
-		"OCTO_Import_PenceCost",
-		"OCTO_Export_PenceCost",
-	}
-	if err := writer.Write(header); err != nil {
+	if err := w.Write(data); err != nil {
 		return err
 	}
+	return w.Close()
+}
+
+// csvWriter writes rows to a CSV as they become available, for output produced in parts.
+type csvWriter struct {
+	filename  string
+	file      *outputFile
+	stream    bool
+	writer    *csv.Writer
+	columns   []csvColumn
+	header    []string
+	rows      int
+	validate  bool
+	tidy      bool
+	timestamp TimestampFormat
+	location  *time.Location
+}
+
+// newCSVWriter creates filename and writes the header of the columns opts selects.
+func newCSVWriter(filename string, opts CSVOptions) (*csvWriter, error) {
+	file, stream, err := openOutput(filename)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, row := range data {
-		record := []string{
-			row.Timestamp.Format(time.RFC3339),
-			formatFloat(row.CumulativeImportInverter, 4),
-			formatFloat(row.CumulativeExportInverter, 4),
-			formatFloat(row.GE_ImportKWh, 16),
-			formatFloat(row.GE_ExportKWh, 16),
-			formatFloat(convertInt64(row.GEO_ImportWh, 1000), 16),
-			formatFloat(row.OCTO_ImportKWh, 16),
-			formatFloat(row.OCTO_ExportKWh, 16),
-			formatFloat(convertInt64(row.GEO_ImportGasWh, 1000), 16),
-			formatFloat(row.ImportPrice, 4),
-			formatFloat(row.ExportPrice, 4),
-			computeCost(row.GE_ImportKWh, row.ImportPrice),
-			computeCost(row.GE_ExportKWh, row.ExportPrice),
-			computeCost(convertInt64(row.GEO_ImportWh, 1000), row.ImportPrice),
-			computeCost(row.OCTO_ImportKWh, row.ImportPrice),
-			computeCost(row.OCTO_ExportKWh, row.ExportPrice),
-		}
-		if err := writer.Write(record); err != nil {
-			return err
+	w := &csvWriter{
+		filename:  filename,
+		file:      file,
+		stream:    stream,
+		writer:    csv.NewWriter(file),
+		columns:   csvColumns(opts),
+		validate:  opts.Validate,
+		tidy:      opts.Format == FormatTidy,
+		timestamp: opts.TimestampFormat,
+		location:  opts.Location,
+	}
+	if w.tidy {
+		w.header = tidyHeader
+	} else {
+		w.header = make([]string, len(w.columns))
+		for i, column := range w.columns {
+			w.header[i] = column.Name
 		}
 	}
+	if err := w.writer.Write(w.header); err != nil {
+		file.Abort()
+		return nil, err
+	}
+	return w, nil
+}
 
+// Write appends rows to the CSV.
+func (w *csvWriter) Write(rows []*UsageRow) error {
+	for _, row := range rows {
+		records := [][]string{make([]string, len(w.columns))}
+		if w.tidy {
+			records = tidyRecords(row, w.columns, w.timestamp.format(row.Timestamp, w.location))
+		} else {
+			for i, column := range w.columns {
+				records[0][i] = column.Value(row)
+			}
+		}
+		for _, record := range records {
+			if err := w.writer.Write(record); err != nil {
+				return err
+			}
+			w.rows++
+		}
+		if w.stream {
+			w.writer.Flush()
+			if err := w.writer.Error(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close finishes the CSV, validating it when requested.
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Abort()
+		return err
+	}
+	// A stream can't be read back; files are validated before replacing any existing one
+	if w.validate && !w.stream {
+		w.file.verify = func(name string) error {
+			if err := validateCSV(name, w.header, w.rows); err != nil {
+				return fmt.Errorf("failed to validate %s: %w", w.filename, err)
+			}
+			return nil
+		}
+	}
+	// Close explicitly so errors finishing the file, such as writing the gzip footer, are reported
+	return w.file.Close()
+}
+
+// validateCSV reads back a written CSV, checking it has the expected header and rows, each
+// with a field per column.
+func validateCSV(filename string, header []string, rows int) error {
+	file, err := openInput(filename)
+	if err != nil {
+		return fmt.Errorf("failed to validate %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return fmt.Errorf("%s is malformed: %w", filename, err)
+	}
+	if len(records) == 0 || !slices.Equal(records[0], header) {
+		return fmt.Errorf("%s has an unexpected header", filename)
+	}
+	for i, record := range records[1:] {
+		if len(record) != len(header) {
+			return fmt.Errorf("%s line %d has %d fields, expected %d", filename, i+2, len(record), len(header))
+		}
+	}
+	if len(records)-1 != rows {
+		return fmt.Errorf("%s has %d rows, expected %d", filename, len(records)-1, rows)
+	}
 	return nil
 }
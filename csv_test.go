@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCostsOnlyCSV(t *testing.T) {
+	row := &UsageRow{
+		Timestamp:      time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		GE_ImportKWh:   floatPtr(1.5),
+		OCTO_ImportKWh: floatPtr(2),
+		OCTO_ExportKWh: floatPtr(1),
+		ImportPrice:    floatPtr(20),
+		ExportPrice:    floatPtr(15),
+	}
+	selectCostFigures(row, DefaultImportPriority)
+
+	filename := filepath.Join(t.TempDir(), "costs.csv")
+	require.NoError(t, writeCSV(filename, []*UsageRow{row}, CSVOptions{IncludeCost: true, CostsOnly: true}))
+
+	records := readCSVFile(t, filename)
+	require.Equal(t, []string{
+		"Timestamp",
+		"Import_Price",
+		"Export_Price",
+		"GE_Import_PenceCost",
+		"GE_Export_PenceCost",
+		"GEO_Import_PenceCost",
+		"OCTO_Import_PenceCost",
+		"OCTO_Export_PenceCost",
+		"Import_Cost",
+		"Export_Cost",
+	}, records[0])
+	require.Equal(t, []string{"2025-01-01T00:00:00Z", "20.0000", "15.0000", "30.00", "NaN", "NaN", "40.00", "15.00", "40.00", "15.00"}, records[1])
+
+	// Daily standing charge rows stay distinguishable from the slots
+	charge := &UsageRow{Timestamp: row.Timestamp, StandingCharge: floatPtr(45.5), Tag: TagStandingCharge}
+	require.NoError(t, writeCSV(filename, []*UsageRow{charge, row}, CSVOptions{IncludeStandingCharge: true, CostsOnly: true}))
+	records = readCSVFile(t, filename)
+	require.Equal(t, []string{"Standing_Charge", "Tag"}, records[0][len(records[0])-2:])
+	require.Equal(t, TagStandingCharge, records[1][len(records[1])-1])
+}
+
+func TestFloatFormat(t *testing.T) {
+	row := &UsageRow{
+		Timestamp:      time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		OCTO_ImportKWh: floatPtr(0.0001),
+		ImportPrice:    floatPtr(24.5),
+	}
+	value := func(opts CSVOptions, name string) string {
+		for _, column := range csvColumns(opts) {
+			if column.Name == name {
+				return column.Value(row)
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return ""
+	}
+
+	require.Equal(t, "0.000100", value(CSVOptions{}, "OCTO_Import_KWh"), "Expected fixed point by default")
+
+	formats, err := parseFloatFormat("energy=g")
+	require.NoError(t, err)
+	opts := CSVOptions{FloatFormat: formats}
+	require.Equal(t, "0.0001", value(opts, "OCTO_Import_KWh"))
+	require.Equal(t, "24.5000", value(opts, "Import_Price"), "Expected other groups to stay fixed point")
+
+	formats, err = parseFloatFormat("e")
+	require.NoError(t, err)
+	require.Equal(t, "2.4500e+01", value(CSVOptions{FloatFormat: formats}, "Import_Price"))
+
+	_, err = parseFloatFormat("energy=x")
+	require.Error(t, err)
+}
+
+func TestPrecision(t *testing.T) {
+	row := &UsageRow{
+		Timestamp:                time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		CumulativeImportInverter: floatPtr(1842.3),
+		OCTO_ImportKWh:           floatPtr(0.123456789),
+		ImportPrice:              floatPtr(24.5),
+	}
+	values := func(opts CSVOptions) map[string]string {
+		values := map[string]string{}
+		for _, column := range csvColumns(opts) {
+			values[column.Name] = column.Value(row)
+		}
+		return values
+	}
+	decimals := func(value string) int {
+		_, fraction, _ := strings.Cut(value, ".")
+		return len(fraction)
+	}
+
+	defaults := values(CSVOptions{})
+	require.Equal(t, "0.123457", defaults["OCTO_Import_KWh"])
+	require.Equal(t, 4, decimals(defaults["GE_Cumulative_Import"]))
+	require.Equal(t, 4, decimals(defaults["Import_Price"]))
+	require.Equal(t, "3.02", defaults["OCTO_Import_PenceCost"])
+	require.Equal(t, "NaN", defaults["GE_Import_KWh"], "Expected NaN for a missing figure")
+
+	precision, err := parsePrecision("3")
+	require.NoError(t, err)
+	for name, value := range values(CSVOptions{Precision: precision}) {
+		if value != "NaN" && name != "Timestamp" {
+			require.Equal(t, 3, decimals(value), "Unexpected width of %s %s", name, value)
+		}
+	}
+
+	precision, err = parsePrecision("energy=9, cost=4")
+	require.NoError(t, err)
+	set := values(CSVOptions{Precision: precision})
+	require.Equal(t, "0.123456789", set["OCTO_Import_KWh"])
+	require.Equal(t, "3.0247", set["OCTO_Import_PenceCost"])
+	require.Equal(t, "24.5000", set["Import_Price"], "Expected other groups to keep their default")
+
+	row.CarbonIntensity = floatPtr(123)
+	precision, err = parsePrecision("carbon=0")
+	require.NoError(t, err)
+	carbon := values(CSVOptions{IncludeCarbon: true, Precision: precision})
+	require.Equal(t, "123", carbon["Carbon_Intensity"])
+	require.Equal(t, "15", carbon["Import_Grams_CO2"])
+
+	for _, invalid := range []string{"x", "-1", "volume=2", "energy=many"} {
+		_, err = parsePrecision(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestWriteSplitCSV(t *testing.T) {
+	row := &UsageRow{
+		Timestamp:      time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		OCTO_ImportKWh: floatPtr(2),
+		OCTO_ExportKWh: floatPtr(1),
+		ImportPrice:    floatPtr(20),
+		ExportPrice:    floatPtr(15),
+		StandingCharge: floatPtr(45.5),
+	}
+
+	filename := filepath.Join(t.TempDir(), "output.csv")
+	require.NoError(t, writeSplitCSV(filename, []*UsageRow{row}, CSVOptions{IncludeStandingCharge: true}))
+
+	importHeader := readCSVFile(t, filepath.Join(filepath.Dir(filename), "output.import.csv"))[0]
+	exportHeader := readCSVFile(t, filepath.Join(filepath.Dir(filename), "output.export.csv"))[0]
+
+	require.Contains(t, importHeader, "OCTO_Import_KWh")
+	require.Contains(t, importHeader, "OCTO_Import_PenceCost")
+	require.Contains(t, importHeader, "Standing_Charge")
+	require.Contains(t, exportHeader, "OCTO_Export_KWh")
+	require.Contains(t, exportHeader, "Export_Price")
+	require.Contains(t, exportHeader, "OCTO_Export_PenceCost")
+
+	for _, name := range importHeader {
+		if slices.Contains(sharedColumns, name) {
+			require.Contains(t, exportHeader, name)
+			continue
+		}
+		require.NotContains(t, exportHeader, name, "Column in both files")
+	}
+	require.Equal(t, len(csvColumns(CSVOptions{IncludeStandingCharge: true})), len(importHeader)+len(exportHeader)-2,
+		"Expected every column in one file, with Timestamp and Tag in both")
+}
+
+func TestSplitFilename(t *testing.T) {
+	require.Equal(t, "output.import.csv", splitFilename("output.csv", DirectionImport))
+	require.Equal(t, "dir/output.export.csv.gz", splitFilename("dir/output.csv.gz", DirectionExport))
+	require.Equal(t, "output.import", splitFilename("output", DirectionImport))
+}
+
+func TestTimestampFormat(t *testing.T) {
+	row := &UsageRow{Timestamp: time.Date(2025, 1, 1, 0, 30, 0, 0, time.UTC), OCTO_ImportKWh: floatPtr(1)}
+
+	for format, expected := range map[TimestampFormat]string{
+		TimestampRFC3339: "2025-01-01T00:30:00Z",
+		TimestampEpoch:   "1735691400",
+		TimestampEpochMs: "1735691400000",
+	} {
+		filename := filepath.Join(t.TempDir(), "output.csv")
+		require.NoError(t, writeCSV(filename, []*UsageRow{row}, CSVOptions{TimestampFormat: format}))
+
+		records := readCSVFile(t, filename)
+		require.Equal(t, "Timestamp", records[0][0])
+		require.Equal(t, expected, records[1][0], "Unexpected %s timestamp", format)
+
+		parsed, err := parseTimestamp(records[1][0])
+		require.NoError(t, err)
+		require.True(t, row.Timestamp.Equal(parsed), "Expected %s to read back", format)
+	}
+}
+
+func TestRoundingMode(t *testing.T) {
+	tests := []struct {
+		energy, price float64
+		expected      map[RoundingMode]string
+	}{
+		// 0.5 kWh at 20.25p is exactly 10.125p
+		{0.5, 20.25, map[RoundingMode]string{RoundHalfUp: "10.13", RoundHalfEven: "10.12", RoundTruncate: "10.12"}},
+		// 0.5 kWh at 20.35p is exactly 10.175p
+		{0.5, 20.35, map[RoundingMode]string{RoundHalfUp: "10.18", RoundHalfEven: "10.18", RoundTruncate: "10.17"}},
+		// Above the boundary
+		{0.3333, 30, map[RoundingMode]string{RoundHalfUp: "10.00", RoundHalfEven: "10.00", RoundTruncate: "9.99"}},
+		// Negative costs round symmetrically
+		{-0.5, 20.25, map[RoundingMode]string{RoundHalfUp: "-10.13", RoundHalfEven: "-10.12", RoundTruncate: "-10.12"}},
+	}
+
+	for _, test := range tests {
+		for mode, expected := range test.expected {
+			require.Equal(t, expected, computeCost(&test.energy, &test.price, mode, 2), "%v kWh at %vp with %s", test.energy, test.price, mode)
+		}
+	}
+	require.Equal(t, "10.13", computeCost(floatPtr(0.5), floatPtr(20.25), "", 2), "Expected half-up by default")
+}
+
+func TestValidateCSV(t *testing.T) {
+	row := &UsageRow{Timestamp: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), OCTO_ImportKWh: floatPtr(1)}
+	filename := filepath.Join(t.TempDir(), "output.csv.gz")
+	require.NoError(t, writeCSV(filename, []*UsageRow{row, row}, CSVOptions{Validate: true}))
+
+	var header []string
+	for _, column := range csvColumns(CSVOptions{}) {
+		header = append(header, column.Name)
+	}
+	require.NoError(t, validateCSV(filename, header, 2))
+	require.ErrorContains(t, validateCSV(filename, header, 3), "has 2 rows")
+	require.ErrorContains(t, validateCSV(filename, header[1:], 2), "unexpected header")
+
+	malformed := filepath.Join(t.TempDir(), "malformed.csv")
+	data := strings.Join(header, ",") + "\n2025-01-01T00:00:00Z,1,2\n"
+	require.NoError(t, os.WriteFile(malformed, []byte(data), 0644))
+	require.ErrorContains(t, validateCSV(malformed, header, 1), "line 2 has 3 fields")
+}
+
+func TestWriteTidyCSV(t *testing.T) {
+	geoWh := int64(1250)
+	row := &UsageRow{
+		Timestamp:      time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		GE_ImportKWh:   floatPtr(1.5),
+		GEO_ImportWh:   &geoWh,
+		OCTO_ImportKWh: floatPtr(2),
+		ImportPrice:    floatPtr(20),
+		ImportSource:   SourceOctopus,
+	}
+
+	filename := filepath.Join(t.TempDir(), "tidy.csv")
+	require.NoError(t, writeCSV(filename, []*UsageRow{row}, CSVOptions{
+		Format:              FormatTidy,
+		FloatFormat:         FloatFormat{GroupEnergy: 'g'},
+		IncludeImportSource: true,
+		Validate:            true,
+	}))
+
+	ts := "2025-01-01T00:00:00Z"
+	require.Equal(t, [][]string{
+		{"timestamp", "metric", "value", "source"},
+		{ts, "Import_KWh", "1.5", SourceGivEnergy},
+		{ts, "Import_KWh", "1.25", SourceGeo},
+		{ts, "Import_KWh", "2", SourceOctopus},
+		{ts, "Import_Price", "20.0000", ""},
+		{ts, "Import_PenceCost", "30.00", SourceGivEnergy},
+		{ts, "Import_PenceCost", "25.00", SourceGeo},
+		{ts, "Import_PenceCost", "40.00", SourceOctopus},
+	}, readCSVFile(t, filename), "Expected a row per figure with missing figures and labels omitted")
+}
+
+func TestWriteCSVMissingGeoIsNaN(t *testing.T) {
+	row := &UsageRow{
+		Timestamp:      time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		OCTO_ImportKWh: floatPtr(0.5),
+	}
+
+	filename := filepath.Join(t.TempDir(), "output.csv")
+	require.NoError(t, writeCSV(filename, []*UsageRow{row}, CSVOptions{}))
+
+	records := readCSVFile(t, filename)
+	for _, name := range []string{"GEO_Import_KWh", "GEO_Gas_KWh"} {
+		i := slices.Index(records[0], name)
+		require.NotEqual(t, -1, i, "Expected a %s column", name)
+		require.Equal(t, "NaN", records[1][i], "Expected missing %s written as NaN rather than 0", name)
+	}
+}
+
+func TestFirstRowKeptWithoutBaseline(t *testing.T) {
+	withLocation(t, "Europe/London")
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			body := `{"data": [
+				{"time": "2024-12-31T23:00:00Z", "total": {"grid": {"import": 100, "export": 10}}},
+				{"time": "2025-01-01T03:00:00Z", "total": {"grid": {"import": 104, "export": 10}}}
+			], "meta": {"current_page": 1, "last_page": 1}}`
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+		},
+	}
+	givService := NewGivEnergyService(mockRoundTripper, "dummyBearerToken")
+
+	for _, slots := range []int{1, 2} {
+		usage := make(UsageStore)
+		end := start.Add(time.Duration(slots) * 30 * time.Minute)
+		require.NoError(t, givService.FetchHalfHourlyInverterData(context.Background(), usage, []string{"ABC12345"}, start, end))
+		var data []*UsageRow
+		for _, row := range usage {
+			data = append(data, row)
+		}
+		slices.SortFunc(data, func(a, b *UsageRow) int { return a.Timestamp.Compare(b.Timestamp) })
+		require.Len(t, data, slots)
+
+		filename := filepath.Join(t.TempDir(), "output.csv")
+		require.NoError(t, writeCSV(filename, data, CSVOptions{}))
+		records := readCSVFile(t, filename)
+		require.Len(t, records, slots+1, "Expected every row to be written")
+
+		column := func(name string) int { return slices.Index(records[0], name) }
+		first := records[1]
+		require.Equal(t, "NaN", first[column("GE_Import_KWh")], "Expected no delta without a prior baseline")
+		require.Equal(t, "101.0000", first[column("GE_Cumulative_Import")], "Expected the first row's other figures")
+		if slots == 2 {
+			require.Equal(t, "0.500000", records[2][column("GE_Import_KWh")])
+		}
+	}
+}
+
+func TestOptionalSourceColumns(t *testing.T) {
+	names := func(opts CSVOptions) []string {
+		var names []string
+		for _, column := range csvColumns(opts) {
+			names = append(names, column.Name)
+		}
+		return names
+	}
+
+	header := names(CSVOptions{})
+	for _, name := range []string{"GEO_Export_KWh", "GEO_Generation_KWh", "OCTO_Gas_KWh"} {
+		require.NotContains(t, header, name, "Expected %s left out unless its source is enabled", name)
+	}
+
+	// Appended after the default columns so existing files keep their header
+	withSources := names(CSVOptions{IncludeGeoExport: true, IncludeGas: true})
+	require.Equal(t, header, withSources[:len(header)])
+	require.Equal(t, []string{"GEO_Export_KWh", "GEO_Generation_KWh", "OCTO_Gas_KWh"}, withSources[len(header):])
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// pollSleep waits between daemon runs.
+var pollSleep = sleepContext
+
+// octopusLateWindow is how far behind the latest slot written daemon runs go back for Octopus
+// readings that arrive late. Older gaps are left rather than fetched again every run.
+const octopusLateWindow = 48 * time.Hour
+
+// resumeFrom returns where the daemon run after the one collecting data from start, sorted by
+// timestamp, starts: the oldest slot within octopusLateWindow of the latest that has its
+// GivEnergy import but is still missing its Octopus import, so late readings fill it, or else
+// the latest slot. Rows before start, or without either import, can't be where it resumes, as
+// the run would then go back rather than forward.
+func resumeFrom(data []*UsageRow, start time.Time) time.Time {
+	last := data[len(data)-1].Timestamp
+	for _, row := range data {
+		if row.Tag != "" || row.Timestamp.Before(start) || row.Timestamp.Before(last.Add(-octopusLateWindow)) {
+			continue
+		}
+		if row.GE_ImportKWh != nil && row.OCTO_ImportKWh == nil {
+			return row.Timestamp
+		}
+	}
+	return last
+}
+
+// runDaemon collects every Interval until ctx is cancelled. Each run ends at the current time,
+// less any SettlementLag, and after the first starts from resumeFrom, so slots already complete
+// aren't fetched again. Only the output's rows from the run's start are rewritten, unless
+// appending or merging into another file. A failed run is logged and retried at the next
+// interval, unless it's a configuration error.
+func (app *App) runDaemon(ctx context.Context) error {
+	app.tailOutput = app.Config.MergeExisting == "" && !app.Config.Append
+
+	for {
+		err := app.runOnce(ctx)
+		if ctx.Err() != nil {
+			slog.Info("Shutting down")
+			return nil
+		}
+		if errors.Is(err, ErrConfig) {
+			return err
+		}
+		if err != nil {
+			slog.Error("Run failed, retrying at the next interval", "err", err)
+		}
+
+		slog.Info("Next run", "in", app.Config.Interval)
+		if err := pollSleep(ctx, app.Config.Interval); err != nil {
+			slog.Info("Shutting down")
+			return nil
+		}
+
+		if !app.ResumeFrom.IsZero() {
+			app.CollectionStart = app.ResumeFrom
+		}
+		app.Config.EndTime = time.Now().Add(-app.Config.SettlementLag)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumeFrom(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var data []*UsageRow
+	for i := 0; i < 4*48; i++ {
+		data = append(data, &UsageRow{Timestamp: start.Add(time.Duration(i) * 30 * time.Minute), GE_ImportKWh: floatPtr(0.5), OCTO_ImportKWh: floatPtr(0.5)})
+	}
+	last := data[len(data)-1].Timestamp
+	require.Equal(t, last, resumeFrom(data, start), "Expected a complete run resumed from its latest slot")
+
+	// Octopus lags behind the other sources
+	for _, row := range data[len(data)-10:] {
+		row.OCTO_ImportKWh = nil
+	}
+	require.Equal(t, data[len(data)-10].Timestamp, resumeFrom(data, start), "Expected the run resumed from the first slot missing Octopus data")
+
+	// A gap older than the window is left
+	data[0].OCTO_ImportKWh = nil
+	require.Equal(t, data[len(data)-10].Timestamp, resumeFrom(data, start), "Expected the old gap left")
+}
+
+func TestResumeFromSkipsIncompleteRows(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := []*UsageRow{
+		// The GivEnergy baseline before the range
+		{Timestamp: start.Add(-30 * time.Minute)},
+		{Timestamp: start, GE_ImportKWh: floatPtr(0.5), OCTO_ImportKWh: floatPtr(0.5)},
+		{Timestamp: start.Add(30 * time.Minute), GE_ImportKWh: floatPtr(0.5)},
+		// The last slot, which GivEnergy hasn't reached
+		{Timestamp: start.Add(time.Hour)},
+	}
+	require.Equal(t, start.Add(30*time.Minute), resumeFrom(data, start), "Expected the first slot with only GivEnergy data")
+
+	data[2].OCTO_ImportKWh = floatPtr(0.5)
+	require.Equal(t, start.Add(time.Hour), resumeFrom(data, start), "Expected the latest slot")
+}
+
+func TestRunDaemonKeepsEarlierRows(t *testing.T) {
+	withLocation(t, "UTC")
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	polls := 0
+	withMockAccount(t, func() string {
+		// Octopus catches up on the second poll
+		return consumptionResults(start, 2+2*polls, 0.2)
+	}, nil, func() string {
+		// An import counter rising 1kWh an hour, 0.5 a slot
+		return `{"time": "2024-12-31T23:00:00Z", "total": {"grid": {"import": 100, "export": 10}}},
+			{"time": "2025-01-01T03:00:00Z", "total": {"grid": {"import": 104, "export": 10}}}`
+	})
+
+	output := filepath.Join(t.TempDir(), "usage.csv")
+	config := &Config{AccountID: "A-123", SerialNumbers: []string{"ABC12345"}, CacheDirectory: "disable", OutputCSV: output,
+		Granularity: GranularityHalfHour, StartTime: &start, EndTime: start.Add(2 * time.Hour), Interval: 30 * time.Minute}
+	app, err := NewApp(config)
+	require.NoError(t, err)
+
+	var afterFirst [][]string
+	oldSleep := pollSleep
+	t.Cleanup(func() { pollSleep = oldSleep })
+	pollSleep = func(ctx context.Context, d time.Duration) error {
+		if polls++; polls > 1 {
+			return context.Canceled
+		}
+		afterFirst = readCSVFile(t, output)
+		// The second run ends where the first did
+		config.SettlementLag = time.Since(start.Add(2 * time.Hour))
+		return nil
+	}
+	require.NoError(t, app.Run(context.Background()))
+	require.Equal(t, 2, polls)
+
+	records := readCSVFile(t, output)
+	column := func(name string) int { return slices.Index(records[0], name) }
+	require.Len(t, afterFirst, 4, "Expected the first run to write the slots GivEnergy had reached")
+	require.Len(t, records, 5, "Expected the second run to add the slot GivEnergy has since reached")
+	for i, timestamp := range []string{"2025-01-01T00:00:00Z", "2025-01-01T00:30:00Z", "2025-01-01T01:00:00Z", "2025-01-01T01:30:00Z"} {
+		require.Equal(t, timestamp, records[i+1][column("Timestamp")])
+		require.Equal(t, "0.200000", records[i+1][column("OCTO_Import_KWh")], "Expected %s to have Octopus data", timestamp)
+		require.Equal(t, "0.500000", records[i+1][column("GE_Import_KWh")], "Expected %s to have GivEnergy data", timestamp)
+	}
+	require.Equal(t, afterFirst[1], records[1], "Expected the first slot left as the first run wrote it")
+}
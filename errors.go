@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-openapi/runtime"
+)
+
+var (
+	// ErrConfig indicates invalid or missing configuration.
+	ErrConfig = errors.New("invalid configuration")
+	// ErrAuth indicates a data source rejected the supplied credentials.
+	ErrAuth = errors.New("authentication failed")
+	// ErrPartialData indicates a data source could not be fully fetched.
+	ErrPartialData = errors.New("partial data")
+	// ErrGapsDetected indicates the output has Octopus gaps and failing on them was requested.
+	ErrGapsDetected = errors.New("gaps detected")
+	// ErrNoImportMeter indicates the Octopus account has no electricity import meter.
+	ErrNoImportMeter = errors.New("no import meter found")
+	// ErrNoProductMatch indicates a meter's tariff code matched none of the Octopus products.
+	ErrNoProductMatch = errors.New("no product matched tariff")
+	// ErrNonJSON indicates an API returned something other than JSON, such as an outage page.
+	ErrNonJSON = errors.New("upstream returned non-JSON")
+	// ErrUnknownInverter indicates the inverter serial isn't one of the GivEnergy account's devices.
+	ErrUnknownInverter = errors.New("inverter not found in account")
+)
+
+// Process exit codes reported by main.
+const (
+	ExitOK           = 0
+	ExitConfig       = 1
+	ExitAuth         = 2
+	ExitPartialData  = 3
+	ExitGapsDetected = 4
+)
+
+// exitCode maps an error returned by the application to its process exit code.
+// Errors that don't match a more specific case exit with ExitConfig, as log.Fatal would.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrAuth) || isAuthError(err):
+		return ExitAuth
+	case errors.Is(err, ErrGapsDetected):
+		return ExitGapsDetected
+	case errors.Is(err, ErrPartialData):
+		return ExitPartialData
+	}
+	return ExitConfig
+}
+
+// isAuthError reports whether err wraps an unauthorised or forbidden API response.
+func isAuthError(err error) bool {
+	isAuthCode := func(code int) bool {
+		return code == http.StatusUnauthorized || code == http.StatusForbidden
+	}
+
+	var apiErr *runtime.APIError
+	if errors.As(err, &apiErr) {
+		return isAuthCode(apiErr.Code)
+	}
+
+	// Responses the clients define explicitly, such as GeoTogether's login Unauthorized
+	var response interface{ Code() int }
+	if errors.As(err, &response) {
+		return isAuthCode(response.Code())
+	}
+	return false
+}
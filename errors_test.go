@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExitCodeAuthError(t *testing.T) {
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusUnauthorized,
+				Body:       io.NopCloser(bytes.NewReader([]byte(`{"detail": "Invalid API key."}`))),
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+			}, nil
+		},
+	}
+
+	octopusService := NewOctopusService(mockRoundTripper, "badApiKey")
+	_, _, _, err := octopusService.GetMetersAndTariff("A-123456")
+	require.Error(t, err)
+	require.Equal(t, ExitAuth, exitCode(fmt.Errorf("failed to get meter and tariff details: %w", err)))
+
+	_, err = NewGeoTogetherService(mockRoundTripper, "user", "badPassword", nil)
+	require.Error(t, err)
+	require.Equal(t, ExitAuth, exitCode(err))
+}
+
+func TestExitCode(t *testing.T) {
+	require.Equal(t, ExitOK, exitCode(nil))
+	require.Equal(t, ExitConfig, exitCode(fmt.Errorf("%w: invalid granularity", ErrConfig)))
+	require.Equal(t, ExitConfig, exitCode(errors.New("unclassified")))
+	require.Equal(t, ExitPartialData, exitCode(fmt.Errorf("%w: failed to fetch GEO data", ErrPartialData)))
+	require.Equal(t, ExitGapsDetected, exitCode(fmt.Errorf("%w: 3 slots", ErrGapsDetected)))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// explainPricing writes how the slot containing t is priced: the import and export rates and
+// the tariff intervals they were matched from, the standing charge, and the cost of the
+// Octopus consumption at those rates.
+func (app *App) explainPricing(ctx context.Context, w io.Writer, t time.Time) error {
+	g := app.Config.Granularity
+	slot := g.offsetSlot(inZone(t, app.Config.Location), app.Config.SlotOffset, app.Config.Location)
+	end := g.next(slot)
+
+	importTariffs, err := app.Tariffs.Tariffs(ctx, DirectionImport, slot, end.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to fetch import tariffs: %w", err)
+	}
+	exportTariffs, err := app.Tariffs.Tariffs(ctx, DirectionExport, slot, end.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to fetch export tariffs: %w", err)
+	}
+
+	usage := make(UsageStore)
+	err = app.OctopusService.GetMeterConsumption(ctx, usage, app.ImportMeter, slot, end.UTC(), func(value float64, estimated bool, row *UsageRow) {
+		row.OCTO_ImportKWh = &value
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch Octopus import: %w", err)
+	}
+	if app.ExportMeter != nil {
+		err = app.OctopusService.GetMeterConsumption(ctx, usage, app.ExportMeter, slot, end.UTC(), func(value float64, estimated bool, row *UsageRow) {
+			row.OCTO_ExportKWh = &value
+		})
+		if err != nil {
+			return fmt.Errorf("failed to fetch Octopus export: %w", err)
+		}
+	}
+	row := usage[slot]
+	if row == nil {
+		row = &UsageRow{Timestamp: slot}
+	}
+
+	bound := func(t *time.Time) string {
+		if t == nil {
+			return "open"
+		}
+		return t.Format(time.RFC3339)
+	}
+	fmt.Fprintf(w, "Slot %s - %s\n", slot.Format(time.RFC3339), end.Format(time.RFC3339))
+
+	importRate, capped := capRate(slot, findRateForTime(slot, importTariffs), app.Config.PriceCaps)
+	if tariff := findTariffForTime(slot, importTariffs); tariff != nil {
+		fmt.Fprintf(w, "Import rate: %.4f p/kWh, interval %s - %s", tariff.Rate, bound(tariff.ValidFrom), bound(tariff.ValidTo))
+		if capped {
+			fmt.Fprintf(w, ", capped to %.4f p/kWh", *importRate)
+		}
+		fmt.Fprintln(w)
+	} else {
+		fmt.Fprintln(w, "Import rate: no matching tariff interval")
+	}
+
+	exportRate := findRateForTime(slot, exportTariffs)
+	if tariff := findTariffForTime(slot, exportTariffs); tariff != nil {
+		fmt.Fprintf(w, "Export rate: %.4f p/kWh, interval %s - %s\n", tariff.Rate, bound(tariff.ValidFrom), bound(tariff.ValidTo))
+	} else {
+		fmt.Fprintln(w, "Export rate: no matching tariff interval")
+	}
+
+	fmt.Fprintf(w, "Standing charge: %.2f p/day\n", app.standingCharge(slot))
+	fmt.Fprintf(w, "Octopus import: %s kWh, cost %s p\n", formatFloat(row.OCTO_ImportKWh, 'f', 4),
+		computeCost(row.OCTO_ImportKWh, importRate, app.Config.RoundingMode, defaultPrecision[GroupCost]))
+	fmt.Fprintf(w, "Octopus export: %s kWh, cost %s p\n", formatFloat(row.OCTO_ExportKWh, 'f', 4),
+		computeCost(row.OCTO_ExportKWh, exportRate, app.Config.RoundingMode, defaultPrecision[GroupCost]))
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainPricing(t *testing.T) {
+	withLocation(t, "Europe/London")
+	slot := time.Date(2025, 1, 15, 23, 30, 0, 0, time.Local)
+
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			var responseBody string
+			switch {
+			case strings.Contains(req.URL.Path, "/meters/1/consumption"):
+				responseBody = fmt.Sprintf(`{"count": 1, "next": null, "results": [{"interval_start": %q, "interval_end": %q, "consumption": 1.5}]}`,
+					slot.Format(time.RFC3339), slot.Add(30*time.Minute).Format(time.RFC3339))
+			case strings.Contains(req.URL.Path, "consumption"):
+				responseBody = `{"count": 0, "next": null, "results": []}`
+			case strings.Contains(req.URL.Path, "AGILE"):
+				responseBody = `{"count": 2, "next": null, "results": [
+					{"value_inc_vat": 30, "valid_from": "2025-01-16T00:00:00Z", "valid_to": "2025-01-16T00:30:00Z"},
+					{"value_inc_vat": 20.769, "valid_from": "2025-01-15T23:30:00Z", "valid_to": "2025-01-16T00:00:00Z"}
+				]}`
+			default:
+				responseBody = `{"count": 1, "next": null, "results": [{"value_inc_vat": 15, "valid_from": "2024-04-01T00:00:00Z", "valid_to": null}]}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	service := NewOctopusService(mockRoundTripper, "dummyApiKey")
+	importMeter := &MeterInfo{ProductCode: "AGILE-24-10-01", TariffCode: "E-1R-AGILE-24-10-01-M", Mpan: "IMP", SerialNumber: "1"}
+	exportMeter := &MeterInfo{ProductCode: "OUTGOING", TariffCode: "E-1R-OUTGOING-M", Mpan: "EXP", SerialNumber: "2"}
+	app := &App{
+		Config:         &Config{Granularity: GranularityHalfHour, ImportStandingCharge: 45.5},
+		OctopusService: service,
+		ImportMeter:    importMeter,
+		ExportMeter:    exportMeter,
+		Tariffs:        &OctopusTariffProvider{Service: service, ImportMeter: importMeter, ExportMeter: exportMeter},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, app.explainPricing(context.Background(), &out, slot.Add(10*time.Minute)))
+	require.Equal(t, `Slot 2025-01-15T23:30:00Z - 2025-01-16T00:00:00Z
+Import rate: 20.7690 p/kWh, interval 2025-01-15T23:30:00Z - 2025-01-16T00:00:00Z
+Export rate: 15.0000 p/kWh, interval 2024-04-01T00:00:00Z - open
+Standing charge: 45.50 p/day
+Octopus import: 1.5000 kWh, cost 31.15 p
+Octopus export: NaN kWh, cost NaN p
+`, out.String())
+}
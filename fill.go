@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+)
+
+// fillExportFromGivEnergy copies GivEnergy export into slots where Octopus hasn't published
+// export yet, flagging the copied figure as estimated and recording its source. It returns
+// the number of slots filled.
+func fillExportFromGivEnergy(usage UsageStore) int {
+	filled := 0
+	for _, row := range usage {
+		if row.OCTO_ExportKWh != nil || row.GE_ExportKWh == nil {
+			continue
+		}
+		value := *row.GE_ExportKWh
+		row.OCTO_ExportKWh = &value
+		row.OCTO_ExportEstimated = true
+		row.OCTO_ExportFilledFrom = SourceGivEnergy
+		filled++
+	}
+	if filled > 0 {
+		slog.Info("Filled Octopus export slots from GivEnergy", "slots", filled)
+	}
+	return filled
+}
+
+// FillMode selects how gaps in each source's usage are represented in the output.
+type FillMode string
+
+const (
+	// FillSparse leaves gaps as NaN, the default.
+	FillSparse FillMode = "sparse"
+	// FillInterpolate fills gaps linearly between the source's neighbouring values.
+	FillInterpolate FillMode = "interpolate"
+	// FillForward repeats the source's previous value into gaps.
+	FillForward FillMode = "forward-fill"
+)
+
+// parseFillMode validates a fill mode name, defaulting to sparse when empty.
+func parseFillMode(value string) (FillMode, error) {
+	switch m := FillMode(value); m {
+	case "":
+		return FillSparse, nil
+	case FillSparse, FillInterpolate, FillForward:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown fill mode %q, expected %s, %s or %s", value, FillSparse, FillInterpolate, FillForward)
+}
+
+// fillColumn is a per source usage figure gaps are filled in.
+type fillColumn struct {
+	get func(row *UsageRow) *float64
+	set func(row *UsageRow, value float64)
+}
+
+// fillColumns are the figures filled: the GivEnergy, GEO and Octopus import and export and the
+// GEO gas. Costs derived from them follow the filled values; prices and the pricing figures are
+// left as they are.
+var fillColumns = []fillColumn{
+	{func(row *UsageRow) *float64 { return row.GE_ImportKWh }, func(row *UsageRow, v float64) { row.GE_ImportKWh = &v }},
+	{func(row *UsageRow) *float64 { return row.GE_ExportKWh }, func(row *UsageRow, v float64) { row.GE_ExportKWh = &v }},
+	{func(row *UsageRow) *float64 { return convertInt64(row.GEO_ImportWh, 1) }, func(row *UsageRow, v float64) {
+		wh := int64(math.Round(v))
+		row.GEO_ImportWh = &wh
+	}},
+	{func(row *UsageRow) *float64 { return convertInt64(row.GEO_ImportGasWh, 1) }, func(row *UsageRow, v float64) {
+		wh := int64(math.Round(v))
+		row.GEO_ImportGasWh = &wh
+	}},
+	{func(row *UsageRow) *float64 { return row.OCTO_ImportKWh }, func(row *UsageRow, v float64) { row.OCTO_ImportKWh = &v }},
+	{func(row *UsageRow) *float64 { return row.OCTO_ExportKWh }, func(row *UsageRow, v float64) { row.OCTO_ExportKWh = &v }},
+}
+
+// fillGaps returns rows, which must be sorted by timestamp, with the gaps in each fill column filled by mode.
+// Gaps before a source's first value take that value, and interpolation holds the last value
+// past the end, so a source only stays NaN when it has no values at all. Filled rows are
+// copies, leaving rows as they were for the summary and checks.
+func fillGaps(rows []*UsageRow, mode FillMode) []*UsageRow {
+	if mode == FillSparse || mode == "" {
+		return rows
+	}
+
+	filled := make([]*UsageRow, len(rows))
+	for i, row := range rows {
+		copied := *row
+		filled[i] = &copied
+	}
+
+	for _, column := range fillColumns {
+		var known []int
+		for i, row := range filled {
+			if row.Tag != TagLive && column.get(row) != nil {
+				known = append(known, i)
+			}
+		}
+		if len(known) == 0 {
+			continue
+		}
+
+		next := 0 // index into known of the first value at or after i
+		for i, row := range filled {
+			if row.Tag == TagLive {
+				continue
+			}
+			for next < len(known) && known[next] < i {
+				next++
+			}
+			if next < len(known) && known[next] == i {
+				continue
+			}
+
+			switch {
+			case next == 0:
+				column.set(row, *column.get(filled[known[0]]))
+			case next == len(known) || mode == FillForward:
+				column.set(row, *column.get(filled[known[next-1]]))
+			default:
+				before, after := filled[known[next-1]], filled[known[next]]
+				span := after.Timestamp.Sub(before.Timestamp)
+				fraction := float64(row.Timestamp.Sub(before.Timestamp)) / float64(span)
+				from, to := *column.get(before), *column.get(after)
+				column.set(row, from+(to-from)*fraction)
+			}
+		}
+	}
+	return filled
+}
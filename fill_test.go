@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFillExportFromGivEnergy(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	published := &UsageRow{Timestamp: start, OCTO_ExportKWh: floatPtr(0.4), GE_ExportKWh: floatPtr(0.5)}
+	gap := &UsageRow{Timestamp: start.Add(30 * time.Minute), GE_ExportKWh: floatPtr(0.75)}
+	neither := &UsageRow{Timestamp: start.Add(60 * time.Minute)}
+	usage := UsageStore{published.Timestamp: published, gap.Timestamp: gap, neither.Timestamp: neither}
+
+	require.Equal(t, 1, fillExportFromGivEnergy(usage))
+
+	require.NotNil(t, gap.OCTO_ExportKWh)
+	require.InDelta(t, 0.75, *gap.OCTO_ExportKWh, 1e-9)
+	require.True(t, gap.OCTO_ExportEstimated)
+	require.Equal(t, SourceGivEnergy, gap.OCTO_ExportFilledFrom)
+
+	require.InDelta(t, 0.4, *published.OCTO_ExportKWh, 1e-9, "Published export should be kept")
+	require.False(t, published.OCTO_ExportEstimated)
+	require.Empty(t, published.OCTO_ExportFilledFrom)
+	require.Nil(t, neither.OCTO_ExportKWh)
+}
+
+func TestFillGaps(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	slot := func(i int) time.Time { return start.Add(time.Duration(i) * 30 * time.Minute) }
+	wh := func(v int64) *int64 { return &v }
+	gappy := func() []*UsageRow {
+		return []*UsageRow{
+			{Timestamp: slot(0), OCTO_ImportKWh: floatPtr(1)},
+			{Timestamp: slot(1), GEO_ImportWh: wh(100)},
+			{Timestamp: slot(2)},
+			{Timestamp: slot(3), OCTO_ImportKWh: floatPtr(4)},
+			{Timestamp: slot(4), GEO_ImportWh: wh(401)},
+			{Timestamp: slot(5)},
+		}
+	}
+	values := func(rows []*UsageRow, get func(row *UsageRow) *float64) []*float64 {
+		var out []*float64
+		for _, row := range rows {
+			out = append(out, get(row))
+		}
+		return out
+	}
+	octo := func(row *UsageRow) *float64 { return row.OCTO_ImportKWh }
+	geo := func(row *UsageRow) *float64 { return convertInt64(row.GEO_ImportWh, 1) }
+
+	rows := gappy()
+	sparse := fillGaps(rows, FillSparse)
+	require.Equal(t, rows, sparse)
+	require.Nil(t, sparse[1].OCTO_ImportKWh, "Expected sparse gaps left as NaN")
+
+	rows = gappy()
+	interpolated := fillGaps(rows, FillInterpolate)
+	require.Equal(t, []*float64{floatPtr(1), floatPtr(2), floatPtr(3), floatPtr(4), floatPtr(4), floatPtr(4)}, values(interpolated, octo))
+	require.Equal(t, []*float64{floatPtr(100), floatPtr(100), floatPtr(200), floatPtr(301), floatPtr(401), floatPtr(401)}, values(interpolated, geo),
+		"Expected GEO Wh interpolated to whole Wh and the leading gap taking the first value")
+	require.Nil(t, interpolated[0].GE_ImportKWh, "Expected a source with no values left as NaN")
+	require.Nil(t, rows[1].OCTO_ImportKWh, "Expected the input rows unchanged")
+
+	rows = gappy()
+	forward := fillGaps(rows, FillForward)
+	require.Equal(t, []*float64{floatPtr(1), floatPtr(1), floatPtr(1), floatPtr(4), floatPtr(4), floatPtr(4)}, values(forward, octo))
+	require.Equal(t, []*float64{floatPtr(100), floatPtr(100), floatPtr(100), floatPtr(100), floatPtr(401), floatPtr(401)}, values(forward, geo))
+}
+
+func TestParseFillMode(t *testing.T) {
+	mode, err := parseFillMode("")
+	require.NoError(t, err)
+	require.Equal(t, FillSparse, mode)
+
+	mode, err = parseFillMode("forward-fill")
+	require.NoError(t, err)
+	require.Equal(t, FillForward, mode)
+
+	_, err = parseFillMode("backfill")
+	require.Error(t, err)
+}
@@ -2,8 +2,11 @@
 package main
 
 import (
+	"slices"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/require"
 )
 
 func floatPtr(f float64) *float64 {
@@ -101,3 +104,23 @@ func TestFindRateForTime(t *testing.T) {
 func ptrTime(t time.Time) *time.Time {
 	return &t
 }
+
+func TestFindTariffForTimeOverlap(t *testing.T) {
+	at := time.Date(2025, 1, 1, 12, 15, 0, 0, time.UTC)
+	rates := []TariffData{
+		{Rate: 10.5, ValidFrom: ptrTime(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)), ValidTo: nil},
+		{Rate: 24.5, ValidFrom: ptrTime(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)), ValidTo: ptrTime(time.Date(2025, 1, 1, 12, 30, 0, 0, time.UTC))},
+		{Rate: 7.5, ValidFrom: nil, ValidTo: nil},
+	}
+
+	// The most recent valid_from wins regardless of order
+	require.Equal(t, 24.5, findTariffForTime(at, rates).Rate)
+	slices.Reverse(rates)
+	require.Equal(t, 24.5, findTariffForTime(at, rates).Rate)
+
+	require.Equal(t, 3, countTariffOverlaps(rates))
+	require.Zero(t, countTariffOverlaps([]TariffData{
+		{Rate: 5.0, ValidFrom: ptrTime(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)), ValidTo: ptrTime(time.Date(2025, 1, 1, 12, 30, 0, 0, time.UTC))},
+		{Rate: 6.0, ValidFrom: ptrTime(time.Date(2025, 1, 1, 12, 30, 0, 0, time.UTC)), ValidTo: nil},
+	}))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// FlushWindow is the period output is fetched, written and evicted from memory in.
+type FlushWindow string
+
+const (
+	// FlushNone holds the whole range in memory, the default.
+	FlushNone  FlushWindow = ""
+	FlushDay   FlushWindow = "day"
+	FlushMonth FlushWindow = "month"
+)
+
+// parseFlushWindow validates a flush window name.
+func parseFlushWindow(value string) (FlushWindow, error) {
+	switch w := FlushWindow(value); w {
+	case FlushNone, FlushDay, FlushMonth:
+		return w, nil
+	}
+	return "", fmt.Errorf("unknown flush window %q, expected %s or %s", value, FlushDay, FlushMonth)
+}
+
+// start returns the start of the window in loc containing t.
+func (w FlushWindow) start(t time.Time, loc *time.Location) time.Time {
+	day := truncateToMidnight(inZone(t, loc))
+	if w == FlushMonth {
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+// next returns the start of the window following the one starting at t.
+func (w FlushWindow) next(t time.Time) time.Time {
+	if w == FlushMonth {
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// windowFlusher writes the rows of each window once it is complete and evicts them from the
+// usage store, so only the windows still being filled are held in memory.
+type windowFlusher struct {
+	window      FlushWindow
+	granularity Granularity
+	location    *time.Location
+	// sources must all have an import figure for every slot for a window to be complete.
+	sources []string
+	write   func(rows []*UsageRow) error
+	// peak is the largest the usage store has been when flushed.
+	peak int
+}
+
+// flush writes and evicts, in time order, the windows of usage that every source has filled
+// or that end by settled, after which nothing more will arrive for them. It stops at the first
+// window that is neither, so output stays in order.
+func (f *windowFlusher) flush(usage UsageStore, settled time.Time) error {
+	f.peak = max(f.peak, len(usage))
+
+	windows := make(map[time.Time][]*UsageRow)
+	for _, row := range usage {
+		start := f.window.start(row.Timestamp, f.location)
+		windows[start] = append(windows[start], row)
+	}
+	starts := make([]time.Time, 0, len(windows))
+	for start := range windows {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	for _, start := range starts {
+		rows := windows[start]
+		if f.window.next(start).After(settled) && !f.complete(start, rows) {
+			break
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i].Timestamp.Before(rows[j].Timestamp) })
+		if err := f.write(rows); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			delete(usage, row.Timestamp)
+		}
+	}
+	return nil
+}
+
+// complete reports whether every source has an import figure for each slot of the window.
+func (f *windowFlusher) complete(start time.Time, rows []*UsageRow) bool {
+	slots := 0
+	for slot := start; slot.Before(f.window.next(start)); slot = f.granularity.next(slot) {
+		slots++
+	}
+	for _, source := range f.sources {
+		count := 0
+		for _, row := range rows {
+			if importFromSource(row, source) != nil {
+				count++
+			}
+		}
+		if count < slots {
+			return false
+		}
+	}
+	return true
+}
+
+// runWindowed fetches, prices and writes the range a window at a time, so memory is bounded by
+// the window rather than the whole range. Each window's fetch starts a slot early, as the
+// GivEnergy usage of a window's last slot is only known from the next window's first reading.
+// Figures needing the whole range, such as the summary and reconciliation, aren't produced.
+func (app *App) runWindowed(ctx context.Context) error {
+	g := app.Config.Granularity
+	end := app.Config.EndTime
+
+	w, err := newCSVWriter(app.Config.OutputCSV, app.csvOptions())
+	if err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	defer w.file.Abort()
+
+	flusher := &windowFlusher{
+		window:      app.Config.FlushWindow,
+		granularity: g,
+		location:    app.Config.Location,
+		sources:     DefaultImportPriority,
+		write: func(rows []*UsageRow) error {
+			rows = filterRange(rows, app.CollectionStart, end)
+			return w.Write(placeStandingCharge(rows, app.Config.StandingChargePlacement, app.standingCharge, g, app.Config.Location))
+		},
+	}
+
+	usage := make(UsageStore)
+	fetch := func(source string, f func() error) error { return f() }
+	for window := app.Config.FlushWindow.start(app.CollectionStart, app.Config.Location); window.Before(end); window = app.Config.FlushWindow.next(window) {
+		windowEnd := app.Config.FlushWindow.next(window)
+		if windowEnd.After(end) {
+			windowEnd = end
+		}
+		start := g.prev(window)
+		if start.Before(app.CollectionStart) {
+			start = app.CollectionStart
+		}
+
+		slog.Info("Fetching window", "start", window.Format(time.RFC3339), "end", windowEnd.Format(time.RFC3339))
+		if err := app.fetchSources(ctx, usage, start, windowEnd, fetch); err != nil {
+			return err
+		}
+		if _, err := app.priceUsage(ctx, usage, start, windowEnd); err != nil {
+			return err
+		}
+		if err := flusher.flush(usage, g.prev(windowEnd)); err != nil {
+			return fmt.Errorf("failed to write CSV: %w", err)
+		}
+	}
+	// Whatever is left can't be filled any further
+	if err := flusher.flush(usage, app.Config.FlushWindow.next(app.Config.FlushWindow.start(end, app.Config.Location))); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	slog.Info("Wrote CSV", "file", app.Config.OutputCSV, "peakRows", flusher.peak)
+	return nil
+}
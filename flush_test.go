@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindowFlusher(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	start := time.Date(2025, 10, 24, 0, 0, 0, 0, time.Local) // spans the 25 hour day on the 26th
+	end := start.AddDate(0, 0, 4)
+	wh := int64(250)
+	newRow := func(ts time.Time) *UsageRow {
+		return &UsageRow{Timestamp: ts, OCTO_ImportKWh: floatPtr(0.25), GEO_ImportWh: &wh, ImportPrice: floatPtr(24.5)}
+	}
+	var all []*UsageRow
+	for ts := start; ts.Before(end); ts = ts.Add(30 * time.Minute) {
+		row := newRow(ts)
+		if ts.Add(30 * time.Minute).Before(end) {
+			row.GE_ImportKWh = floatPtr(0.25) // the last slot's figure would come from beyond the range
+		}
+		all = append(all, row)
+	}
+
+	dir := t.TempDir()
+	opts := CSVOptions{IncludeCost: true}
+	unbounded := filepath.Join(dir, "unbounded.csv")
+	require.NoError(t, writeCSV(unbounded, all, opts))
+
+	windowed := filepath.Join(dir, "windowed.csv")
+	w, err := newCSVWriter(windowed, opts)
+	require.NoError(t, err)
+	flusher := &windowFlusher{window: FlushDay, granularity: GranularityHalfHour, sources: DefaultImportPriority, write: w.Write}
+
+	// Fetch a day at a time. As with GivEnergy, a day's last slot only gets its figure from the
+	// next day's fetch, so each day completes once the following one has been fetched.
+	usage := make(UsageStore)
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		for ts := day; ts.Before(next); ts = ts.Add(30 * time.Minute) {
+			usage[ts] = newRow(ts)
+			if ts.Add(30 * time.Minute).Before(next) {
+				usage[ts].GE_ImportKWh = floatPtr(0.25)
+			}
+		}
+		if last, ok := usage[day.Add(-30*time.Minute)]; ok {
+			last.GE_ImportKWh = floatPtr(0.25)
+		}
+		require.NoError(t, flusher.flush(usage, day.Add(-30*time.Minute)))
+		require.LessOrEqual(t, len(usage), 50, "Expected only the day being filled to be held")
+	}
+	require.NoError(t, flusher.flush(usage, end))
+	require.Empty(t, usage)
+	require.NoError(t, w.Close())
+
+	require.LessOrEqual(t, flusher.peak, 2*50)
+	expected, err := os.ReadFile(unbounded)
+	require.NoError(t, err)
+	actual, err := os.ReadFile(windowed)
+	require.NoError(t, err)
+	require.Equal(t, string(expected), string(actual))
+}
+
+func TestWindowFlusherIncomplete(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	usage := UsageStore{
+		start:                  {Timestamp: start, OCTO_ImportKWh: floatPtr(1)},
+		start.AddDate(0, 0, 1): {Timestamp: start.AddDate(0, 0, 1), OCTO_ImportKWh: floatPtr(1)},
+	}
+	var written []time.Time
+	flusher := &windowFlusher{window: FlushDay, granularity: GranularityHalfHour, sources: DefaultImportPriority,
+		write: func(rows []*UsageRow) error {
+			for _, row := range rows {
+				written = append(written, row.Timestamp)
+			}
+			return nil
+		}}
+
+	require.NoError(t, flusher.flush(usage, start))
+	require.Empty(t, written, "Expected incomplete days to be held until settled")
+
+	// Once the first day has settled it is written even though sources are missing
+	require.NoError(t, flusher.flush(usage, start.AddDate(0, 0, 1)))
+	require.Equal(t, []time.Time{start}, written)
+	require.Len(t, usage, 1)
+}
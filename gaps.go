@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// maxLoggedGaps limits how many individual gap slots are logged.
+const maxLoggedGaps = 10
+
+// findGaps returns the slots between the first and last row that have no Octopus import
+// reading, including slots missing from data altogether. data must be sorted by timestamp.
+func findGaps(data []*UsageRow, g Granularity) []time.Time {
+	var gaps []time.Time
+	if len(data) == 0 {
+		return gaps
+	}
+
+	expected := data[0].Timestamp
+	for _, row := range data {
+		for ; expected.Before(row.Timestamp); expected = g.next(expected) {
+			gaps = append(gaps, expected)
+		}
+		if row.OCTO_ImportKWh == nil {
+			gaps = append(gaps, row.Timestamp)
+		}
+		expected = g.next(row.Timestamp)
+	}
+	return gaps
+}
+
+// expectedValues are the per-source figures every slot is expected to have.
+var expectedValues = []struct {
+	Source  string
+	Present func(row *UsageRow) bool
+}{
+	{"Octopus import", func(row *UsageRow) bool { return row.OCTO_ImportKWh != nil }},
+	{"Octopus export", func(row *UsageRow) bool { return row.OCTO_ExportKWh != nil }},
+	{"GEO import", func(row *UsageRow) bool { return row.GEO_ImportWh != nil }},
+	{"GivEnergy import", func(row *UsageRow) bool { return row.GE_ImportKWh != nil }},
+	{"GivEnergy export", func(row *UsageRow) bool { return row.GE_ExportKWh != nil }},
+}
+
+// missingValues returns the expected source values that row doesn't have.
+func missingValues(row *UsageRow) []string {
+	var missing []string
+	for _, expected := range expectedValues {
+		if !expected.Present(row) {
+			missing = append(missing, expected.Source)
+		}
+	}
+	return missing
+}
+
+// gapRows returns the rows missing at least one expected source value, adding empty rows for
+// slots missing from data altogether. data must be sorted by timestamp.
+func gapRows(data []*UsageRow, g Granularity) []*UsageRow {
+	var rows []*UsageRow
+	if len(data) == 0 {
+		return rows
+	}
+
+	expected := data[0].Timestamp
+	for _, row := range data {
+		for ; expected.Before(row.Timestamp); expected = g.next(expected) {
+			rows = append(rows, &UsageRow{Timestamp: expected})
+		}
+		if len(missingValues(row)) > 0 {
+			rows = append(rows, row)
+		}
+		expected = g.next(row.Timestamp)
+	}
+	return rows
+}
+
+// latestCompleteDay returns the most recent local day on which every source reported an
+// import figure for all of the day's slots, allowing for clock change days. ok is false if
+// no day is complete.
+func latestCompleteDay(data []*UsageRow, sources []string, g Granularity, loc *time.Location) (day time.Time, ok bool) {
+	counts := make(map[time.Time]map[string]int)
+	for _, row := range data {
+		if row.Tag != "" {
+			continue
+		}
+		d := truncateToMidnight(inZone(row.Timestamp, loc))
+		if counts[d] == nil {
+			counts[d] = make(map[string]int)
+		}
+		for _, source := range sources {
+			if importFromSource(row, source) != nil {
+				counts[d][source]++
+			}
+		}
+	}
+
+	for d, bySource := range counts {
+		complete := true
+		for _, source := range sources {
+			if bySource[source] < slotsPerDay(d, g, loc) {
+				complete = false
+				break
+			}
+		}
+		if complete && (!ok || d.After(day)) {
+			day, ok = d, true
+		}
+	}
+	return day, ok
+}
+
+// logGaps logs the number of gaps and the first few of them.
+func logGaps(gaps []time.Time) {
+	if len(gaps) == 0 {
+		slog.Info("No gaps in Octopus import data")
+		return
+	}
+
+	slog.Warn("slots with no Octopus import data", "count", len(gaps))
+	for i, gap := range gaps {
+		if i == maxLoggedGaps {
+			slog.Warn("more slots with no Octopus import data", "count", len(gaps)-maxLoggedGaps)
+			break
+		}
+		slog.Warn("no Octopus import data", "slot", gap.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindGaps(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	slot := func(i int) time.Time { return start.Add(time.Duration(i) * 30 * time.Minute) }
+
+	data := []*UsageRow{
+		{Timestamp: slot(0), OCTO_ImportKWh: floatPtr(0.5)},
+		{Timestamp: slot(1)}, // no Octopus reading
+		// slot 2 missing entirely
+		{Timestamp: slot(3), OCTO_ImportKWh: floatPtr(0.5)},
+	}
+
+	require.Equal(t, []time.Time{slot(1), slot(2)}, findGaps(data, GranularityHalfHour))
+	require.Empty(t, findGaps(nil, GranularityHalfHour))
+}
+
+func TestGapRows(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	slot := func(i int) time.Time { return start.Add(time.Duration(i) * 30 * time.Minute) }
+	complete := func(i int) *UsageRow {
+		wh := int64(500)
+		return &UsageRow{
+			Timestamp:      slot(i),
+			OCTO_ImportKWh: floatPtr(0.5),
+			OCTO_ExportKWh: floatPtr(0),
+			GEO_ImportWh:   &wh,
+			GE_ImportKWh:   floatPtr(0.5),
+			GE_ExportKWh:   floatPtr(0),
+		}
+	}
+
+	missingGeo := complete(1)
+	missingGeo.GEO_ImportWh = nil
+	data := []*UsageRow{complete(0), missingGeo, complete(2), complete(4)} // slot 3 missing entirely
+
+	filename := filepath.Join(t.TempDir(), "gaps.csv")
+	require.NoError(t, writeCSV(filename, gapRows(data, GranularityHalfHour), CSVOptions{}))
+
+	records := readCSVFile(t, filename)
+	require.Len(t, records, 3)
+	require.Len(t, records[0], len(csvColumns(CSVOptions{})), "Expected the full column set")
+	require.Equal(t, slot(1).Format(time.RFC3339), records[1][0])
+	require.Equal(t, slot(3).Format(time.RFC3339), records[2][0])
+	require.Equal(t, []string{"GEO import"}, missingValues(missingGeo))
+}
+
+func TestLatestCompleteDay(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	// The clocks go back on the 26th, giving it 50 slots
+	first := time.Date(2025, 10, 25, 0, 0, 0, 0, time.Local)
+	end := first.AddDate(0, 0, 3)
+	wh := int64(500)
+	var data []*UsageRow
+	for ts := first; ts.Before(end); ts = ts.Add(30 * time.Minute) {
+		row := &UsageRow{Timestamp: ts, OCTO_ImportKWh: floatPtr(0.5), GEO_ImportWh: &wh, GE_ImportKWh: floatPtr(0.5)}
+		if ts.Day() == 27 && ts.Hour() >= 12 {
+			row.OCTO_ImportKWh = nil // Octopus hasn't reported the afternoon yet
+		}
+		data = append(data, row)
+	}
+
+	day, ok := latestCompleteDay(data, DefaultImportPriority, GranularityHalfHour, nil)
+	require.True(t, ok)
+	require.Equal(t, time.Date(2025, 10, 26, 0, 0, 0, 0, time.Local), day)
+
+	// Only GEO and GivEnergy enabled, the last day is complete
+	day, ok = latestCompleteDay(data, []string{SourceGeo, SourceGivEnergy}, GranularityHalfHour, nil)
+	require.True(t, ok)
+	require.Equal(t, time.Date(2025, 10, 27, 0, 0, 0, 0, time.Local), day)
+
+	_, ok = latestCompleteDay(data[:47], DefaultImportPriority, GranularityHalfHour, nil)
+	require.False(t, ok)
+}
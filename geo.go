@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"time"
 
+	"github.com/go-openapi/runtime"
 	httptransport "github.com/go-openapi/runtime/client"
 	"github.com/go-openapi/strfmt"
 	geo "github.com/mgazza/go-geotogether/client"
@@ -15,38 +19,107 @@ import (
 // GeoTogetherService handles interactions with the Geo Together API.
 type GeoTogetherService struct {
 	Client *geo.GeoTogetherAPI
+
+	// Granularity is the slot width readings are aggregated into, half-hourly by default.
+	Granularity Granularity
+	// SlotOffset moves the slot boundaries past the hour and half-hour, for meters reporting at e.g. :05 and :35.
+	SlotOffset time.Duration
+	// Location is the zone slots are bucketed in, local time when nil.
+	Location *time.Location
+	// SystemID selects the system to read, by default the first with devices.
+	SystemID string
+	// APIVersion selects the readings and systems endpoint version, v1 when empty.
+	APIVersion GeoAPIVersion
+
+	// The login, kept to log in again when the access token expires mid-run
+	username, password string
+	tokens             *GeoTokenCache
+	transport          *httptransport.Runtime
 }
 
-// NewGeoTogetherService creates a new GeoTogetherService with authentication.
-func NewGeoTogetherService(tr http.RoundTripper, username, password string) (*GeoTogetherService, error) {
+// NewGeoTogetherService creates a new GeoTogetherService with authentication, reusing a
+// login from tokens when it has one.
+func NewGeoTogetherService(tr http.RoundTripper, username, password string, tokens *GeoTokenCache) (*GeoTogetherService, error) {
 	cfg := geo.DefaultTransportConfig()
 	transport := httptransport.New(cfg.Host, cfg.BasePath, cfg.Schemes)
 	transport.Transport = tr
 	nc := geo.New(transport, strfmt.Default)
 
-	p := geoops.NewPostUsersserviceV2LoginParams().WithBody(geoops.PostUsersserviceV2LoginBody{
-		Identity: username,
-		Password: password,
-	})
-	r, err := nc.Operations.PostUsersserviceV2Login(p, nil)
+	accessToken, err := loginGeo(nc, username, password, tokens)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize GeoTogether client: %w", err)
+		return nil, err
 	}
 
-	if !r.IsSuccess() {
-		return nil, fmt.Errorf("failed to call GeoTogether login: %v", r.Error())
+	transport.DefaultAuthentication = httptransport.BearerToken(accessToken)
+
+	return &GeoTogetherService{Client: nc, username: username, password: password, tokens: tokens, transport: transport}, nil
+}
+
+// withLogin makes call, and when it's refused with 401 Unauthorized as the access token has
+// expired, logs in again and retries it once with the fresh token.
+func (s *GeoTogetherService) withLogin(call func() error) error {
+	err := call()
+	var apiErr *runtime.APIError
+	if s.transport == nil || !errors.As(err, &apiErr) || apiErr.Code != http.StatusUnauthorized {
+		return err
+	}
+
+	slog.Info("GeoTogether access token expired, logging in again")
+	// The cached token is the expired one
+	accessToken, loginErr := loginGeo(s.Client, s.username, s.password, nil)
+	if loginErr != nil {
+		return fmt.Errorf("%w, and failed to log in again: %w", err, loginErr)
+	}
+	s.tokens.save(s.username, accessToken)
+	s.transport.DefaultAuthentication = httptransport.BearerToken(accessToken)
+	return call()
+}
+
+// slotShare is the part of a reading falling in a slot, as the elapsed fractions of the
+// reading at the slot's start and end, so consecutive shares apportion without losing any.
+type slotShare struct {
+	from, to float64
+}
+
+// slotShares returns the share of a reading from start lasting duration in each slot it
+// covers. A reading without a duration falls wholly in the slot it starts in.
+func (s *GeoTogetherService) slotShares(start time.Time, duration time.Duration) map[time.Time]slotShare {
+	slot := s.Granularity.offsetSlot(start, s.SlotOffset, s.Location)
+	end := start.Add(duration)
+	if duration <= 0 || !s.Granularity.next(slot).Before(end) {
+		return map[time.Time]slotShare{slot: {0, 1}}
 	}
 
-	transport.DefaultAuthentication = httptransport.BearerToken(r.Payload.AccessToken)
+	shares := make(map[time.Time]slotShare)
+	for ; slot.Before(end); slot = s.Granularity.next(slot) {
+		from := max(slot.Sub(start), 0)
+		to := min(s.Granularity.next(slot).Sub(start), duration)
+		shares[slot] = slotShare{float64(from) / float64(duration), float64(to) / float64(duration)}
+	}
+	return shares
+}
 
-	return &GeoTogetherService{Client: nc}, nil
+// apportion returns the part of a whole reading value in share, rounding at the share's
+// bounds so the parts of a reading add up to the whole.
+func apportion(value int64, share slotShare) int64 {
+	return int64(math.Round(float64(value)*share.to)) - int64(math.Round(float64(value)*share.from))
 }
 
 // GetUserSystemRoles retrieves users system roles.
 func (s *GeoTogetherService) GetUserSystemID() (string, error) {
-	r, err := s.Client.Operations.GetAPIUserapiV2UserDetailSystems(
-		geoops.NewGetAPIUserapiV2UserDetailSystemsParams().
-			WithSystemDetails(true), nil)
+	if s.SystemID != "" {
+		return s.SystemID, nil
+	}
+
+	var r *geoops.GetAPIUserapiV2UserDetailSystemsOK
+	err := s.withLogin(func() error {
+		return s.withAPIVersion(func(opts ...geoops.ClientOption) (err error) {
+			r, err = s.Client.Operations.GetAPIUserapiV2UserDetailSystems(
+				geoops.NewGetAPIUserapiV2UserDetailSystemsParams().
+					WithSystemDetails(true), nil, opts...)
+			return err
+		}, withV3Systems())
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to fetch live power data: %w", err)
 	}
@@ -62,8 +135,8 @@ func (s *GeoTogetherService) GetUserSystemID() (string, error) {
 	return "", fmt.Errorf("no systems with devices")
 }
 
-func (s *GeoTogetherService) GetSystemReadings(systemID string, startDate time.Time, endDate *time.Time) ([]*geoops.GetEpochserviceV1SystemSystemIDReadingsOKBodyItems0, error) {
-	p := geoops.NewGetEpochserviceV1SystemSystemIDReadingsParams().
+func (s *GeoTogetherService) GetSystemReadings(ctx context.Context, systemID string, startDate time.Time, endDate *time.Time) ([]*geoops.GetEpochserviceV1SystemSystemIDReadingsOKBodyItems0, error) {
+	p := geoops.NewGetEpochserviceV1SystemSystemIDReadingsParamsWithContext(ctx).
 		WithSystemID(systemID).
 		WithStartDate(strfmt.Date(startDate))
 
@@ -71,7 +144,13 @@ func (s *GeoTogetherService) GetSystemReadings(systemID string, startDate time.T
 		p = p.WithEndDate(strfmt.Date(*endDate))
 	}
 
-	r, err := s.Client.Operations.GetEpochserviceV1SystemSystemIDReadings(p, nil)
+	var r *geoops.GetEpochserviceV1SystemSystemIDReadingsOK
+	err := s.withLogin(func() error {
+		return s.withAPIVersion(func(opts ...geoops.ClientOption) (err error) {
+			r, err = s.Client.Operations.GetEpochserviceV1SystemSystemIDReadings(p, nil, opts...)
+			return err
+		}, withV3Readings())
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch live power data: %w", err)
 	}
@@ -82,7 +161,43 @@ func (s *GeoTogetherService) GetSystemReadings(systemID string, startDate time.T
 	return r.Payload, nil
 }
 
-func (s *GeoTogetherService) PopulateGeoData(usage map[time.Time]*UsageRow, startDate, endDate time.Time) error {
+// LivePower is a snapshot of the current power reported by the Geo display.
+type LivePower struct {
+	Timestamp        time.Time
+	ElectricityWatts *int64
+	GasWatts         *int64
+}
+
+// GetLivePower retrieves the current live power for the system.
+func (s *GeoTogetherService) GetLivePower(systemID string) (*LivePower, error) {
+	var r *geoops.GetAPIUserapiSystemSmets2LiveDataSystemIDOK
+	err := s.withLogin(func() (err error) {
+		r, err = s.Client.Operations.GetAPIUserapiSystemSmets2LiveDataSystemID(
+			geoops.NewGetAPIUserapiSystemSmets2LiveDataSystemIDParams().
+				WithSystemID(systemID), nil)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch live power data: %w", err)
+	}
+	if !r.IsSuccess() {
+		return nil, fmt.Errorf("failed to fetch live power data: %v", r.Error())
+	}
+
+	live := &LivePower{Timestamp: inZone(time.Now(), s.Location).Truncate(time.Second)}
+	for _, p := range r.Payload.Power {
+		watts := p.Watts
+		switch p.Type {
+		case geoops.GetAPIUserapiSystemSmets2LiveDataSystemIDOKBodyPowerItems0TypeELECTRICITY:
+			live.ElectricityWatts = &watts
+		case geoops.GetAPIUserapiSystemSmets2LiveDataSystemIDOKBodyPowerItems0TypeGASENERGY:
+			live.GasWatts = &watts
+		}
+	}
+	return live, nil
+}
+
+func (s *GeoTogetherService) PopulateGeoData(ctx context.Context, usage map[time.Time]*UsageRow, startDate, endDate time.Time) error {
 	systemID, err := s.GetUserSystemID()
 	if err != nil {
 		return fmt.Errorf("getting user system roles: %w", err)
@@ -98,55 +213,59 @@ func (s *GeoTogetherService) PopulateGeoData(usage map[time.Time]*UsageRow, star
 		}
 	}
 
-	readings, err := s.GetSystemReadings(systemID, startDate, ed)
+	readings, err := s.GetSystemReadings(ctx, systemID, startDate, ed)
 	if err != nil {
 		return fmt.Errorf("getting system readings: %w", err)
 	}
 
-	// ** Store Energy Readings in Local Time **
+	// ** Aggregate Energy & Cost Readings into Slot Buckets in Local Time **
 	energyReadings := make(map[time.Time]int64)
 	gasReadings := make(map[time.Time]int64)
 	costReadings := make(map[time.Time]int64)
 	gasCostReadings := make(map[time.Time]int64)
+	exportReadings := make(map[time.Time]int64)
+	exportCostReadings := make(map[time.Time]int64)
+	generationReadings := make(map[time.Time]int64)
 
 	for _, readingGroup := range readings {
-		timestamp := time.Unix(int64(readingGroup.StartTimestamp), 0).Local() // Convert to local time
+		timestamp := inZone(time.Unix(int64(readingGroup.StartTimestamp), 0), s.Location)
 
 		for _, reading := range readingGroup.Readings {
+			var energy, cost map[time.Time]int64
 			switch reading.EnergyType {
 			case "IMPORT":
-				energyReadings[timestamp] += reading.EnergyWattHours
-				costReadings[timestamp] += reading.MilliPenceCost
+				energy, cost = energyReadings, costReadings
 			case "GAS_ENERGY":
-				gasReadings[timestamp] += reading.EnergyWattHours
-				gasCostReadings[timestamp] += reading.MilliPenceCost
+				energy, cost = gasReadings, gasCostReadings
+			case "EXPORT":
+				energy, cost = exportReadings, exportCostReadings
+			case "GENERATION", "SOLAR":
+				energy = generationReadings
+			default:
+				continue
+			}
+			// Readings may be shorter or longer than a slot, so spread each over the slots it covers
+			for slot, share := range s.slotShares(timestamp, time.Duration(reading.Duration)*time.Second) {
+				energy[slot] += apportion(reading.EnergyWattHours, share)
+				if cost != nil {
+					cost[slot] += apportion(reading.MilliPenceCost, share)
+				}
 			}
 		}
 	}
 
-	// ** Aggregate Energy & Cost Readings into 30-Minute Buckets in Local Time **
-	for t := startDate.Truncate(30 * time.Minute).Local(); t.Before(endDate); t = t.Add(30 * time.Minute) {
-		var sumEnergy, sumGas, sumCost, sumGasCost int64
-
-		for offset := 0; offset < 30; offset += 15 {
-			ts := t.Add(time.Duration(offset) * time.Minute).Local() // Ensure lookups use local time
-			if value, exists := energyReadings[ts]; exists {
-				sumEnergy += value
-			}
-			if value, exists := gasReadings[ts]; exists {
-				sumGas += value
-			}
-			if value, exists := costReadings[ts]; exists {
-				sumCost += value
-			}
-			if value, exists := gasCostReadings[ts]; exists {
-				sumGasCost += value
-			}
-		}
+	for t := s.Granularity.offsetSlot(startDate, s.SlotOffset, s.Location); t.Before(endDate); t = s.Granularity.next(t) {
+		sumEnergy := energyReadings[t]
+		sumGas := gasReadings[t]
+		sumCost := costReadings[t]
+		sumGasCost := gasCostReadings[t]
+		sumExport, hasExport := exportReadings[t]
+		sumExportCost := exportCostReadings[t]
+		sumGeneration, hasGeneration := generationReadings[t]
 
 		// If no data, leave it as nil
-		if sumEnergy == 0 && sumGas == 0 {
-			log.Printf("No GEO data for %s, leaving as nil", t.Format(time.RFC3339))
+		if sumEnergy == 0 && sumGas == 0 && sumExport == 0 && sumGeneration == 0 {
+			slog.Debug("No GEO data, leaving as nil", "slot", t.Format(time.RFC3339))
 			continue
 		}
 
@@ -157,13 +276,21 @@ func (s *GeoTogetherService) PopulateGeoData(usage map[time.Time]*UsageRow, star
 			usage[t] = row
 		}
 
-		// Assign energy and cost values for the 30-minute window
+		// Assign energy and cost values for the slot
 		row.GEO_ImportWh = &sumEnergy
 		row.GEO_ImportGasWh = &sumGas
 		row.GEO_ImportMilliPenceCost = &sumCost
 		row.GEO_ImportGasMilliPenceCost = &sumGasCost
+		// Only systems with export or generation metering report them
+		if hasExport {
+			row.GEO_ExportWh = &sumExport
+			row.GEO_ExportMilliPenceCost = &sumExportCost
+		}
+		if hasGeneration {
+			row.GEO_GenerationWh = &sumGeneration
+		}
 	}
 
-	log.Printf("Fetched %d GEO records", len(energyReadings))
+	slog.Info("Fetched GEO records", "count", len(readings))
 	return nil
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GeoAccount is an additional Geo login, such as for a second property, whose readings are
+// output as separate rows tagged with its label.
+type GeoAccount struct {
+	Label    string
+	Username string
+	Password string
+	SystemID string // empty selects the first system with devices
+}
+
+// parseGeoAccounts parses comma separated label:username:password[:systemID] entries.
+func parseGeoAccounts(value string) ([]GeoAccount, error) {
+	var accounts []GeoAccount
+	if value == "" {
+		return accounts, nil
+	}
+
+	labels := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 3 || len(parts) > 4 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid Geo account %q, expected label:username:password[:systemID]", entry)
+		}
+		if labels[parts[0]] {
+			return nil, fmt.Errorf("duplicate Geo account label %q", parts[0])
+		}
+		labels[parts[0]] = true
+
+		account := GeoAccount{Label: parts[0], Username: parts[1], Password: parts[2]}
+		if len(parts) == 4 {
+			account.SystemID = parts[3]
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// GeoAccountService is a logged in additional Geo account.
+type GeoAccountService struct {
+	Label   string
+	Service *GeoTogetherService
+}
+
+// newGeoAccountServices logs in to each account, returning the services that succeeded and
+// the labels of those that failed, so one bad login doesn't stop the others.
+func newGeoAccountServices(rt http.RoundTripper, accounts []GeoAccount, g Granularity, tokens *GeoTokenCache) ([]GeoAccountService, []string) {
+	var services []GeoAccountService
+	var failed []string
+	for _, account := range accounts {
+		service, err := NewGeoTogetherService(rt, account.Username, account.Password, tokens)
+		if err != nil {
+			if isAuthError(err) {
+				slog.Warn("Geo account rejected its credentials, skipping it", "account", account.Label, "err", err)
+			} else {
+				slog.Warn("failed to log in to Geo account, skipping it", "account", account.Label, "err", err)
+			}
+			failed = append(failed, account.Label)
+			continue
+		}
+		service.Granularity = g
+		service.SystemID = account.SystemID
+		services = append(services, GeoAccountService{Label: account.Label, Service: service})
+	}
+	return services, failed
+}
+
+// GeoAccountResult is the outcome of fetching an additional Geo account's readings.
+type GeoAccountResult struct {
+	Label string
+	Rows  int
+	Err   error
+}
+
+// fetchGeoAccounts fetches each account's readings as rows tagged with its label, sorted by
+// timestamp, running up to concurrency accounts at once. Accounts that fail are logged and
+// reported in their result rather than stopping the others. Results are in the order of
+// services, and rows at the same time keep that order, however the fetches finish.
+func fetchGeoAccounts(ctx context.Context, services []GeoAccountService, start, end time.Time, concurrency int) ([]*UsageRow, []GeoAccountResult) {
+	results := make([]GeoAccountResult, len(services))
+	accountRows := make([][]*UsageRow, len(services))
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, max(concurrency, 1))
+	for i, account := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			slog.Info("Getting GEO data", "account", account.Label)
+			results[i].Label = account.Label
+			usage := make(UsageStore)
+			if err := account.Service.PopulateGeoData(ctx, usage, start, end); err != nil {
+				slog.Warn("failed to fetch GEO data for account", "account", account.Label, "err", err)
+				results[i].Err = err
+				return
+			}
+			for _, row := range usage {
+				row.Account = account.Label
+				accountRows[i] = append(accountRows[i], row)
+			}
+			results[i].Rows = len(accountRows[i])
+		}()
+	}
+	wg.Wait()
+
+	var rows []*UsageRow
+	for i := range accountRows {
+		// Each account's own rows come from a map, so order them before combining
+		sortByTime(accountRows[i])
+		rows = append(rows, accountRows[i]...)
+	}
+	sortByTime(rows)
+	return rows, results
+}
+
+// failedGeoAccounts returns the labels of the accounts whose fetch failed.
+func failedGeoAccounts(results []GeoAccountResult) []string {
+	var failed []string
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, result.Label)
+		}
+	}
+	return failed
+}
+
+// logGeoAccountSummary logs whether each account's readings were fetched.
+func logGeoAccountSummary(results []GeoAccountResult, loginFailed []string) {
+	for _, result := range results {
+		if result.Err != nil {
+			slog.Error("Geo account failed", "account", result.Label, "err", result.Err)
+		} else {
+			slog.Info("Geo account ok", "account", result.Label, "rows", result.Rows)
+		}
+	}
+	for _, label := range loginFailed {
+		slog.Error("Geo account failed to log in", "account", label)
+	}
+}
+
+// mergeAccountRows tags the main account's rows with account and merges in the rows of the
+// additional accounts, ordered by timestamp with the main account's row first.
+func mergeAccountRows(rows, accountRows []*UsageRow, account string) []*UsageRow {
+	merged := make([]*UsageRow, 0, len(rows)+len(accountRows))
+	for _, row := range rows {
+		if row.Account == "" {
+			row.Account = account
+		}
+		merged = append(merged, row)
+	}
+	merged = append(merged, accountRows...)
+	sortByTime(merged)
+	return merged
+}
+
+// sortByTime orders rows by timestamp, keeping the existing order of rows for different
+// accounts at the same time.
+func sortByTime(rows []*UsageRow) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Timestamp.Before(rows[j].Timestamp)
+	})
+}
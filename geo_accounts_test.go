@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoAccountsTaggedAndMerged(t *testing.T) {
+	start := time.Date(2024, 12, 9, 2, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+
+	// Each login's token selects its readings: 1000Wh per 15 minutes for home, 200Wh for flat
+	importWh := map[string]int{"home-token": 1000, "flat-token": 200}
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			status := http.StatusOK
+			responseBody := ""
+			token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+
+			switch {
+			case strings.Contains(req.URL.Path, "/usersservice/v2/login"):
+				var login struct{ Identity string }
+				require.NoError(t, json.NewDecoder(req.Body).Decode(&login))
+				if login.Identity == "locked" {
+					status, responseBody = http.StatusUnauthorized, `{}`
+				} else {
+					responseBody = fmt.Sprintf(`{"accessToken": "%s-token"}`, login.Identity)
+				}
+			case strings.Contains(req.URL.Path, "/api/userapi/v2/user/detail-systems"):
+				responseBody = fmt.Sprintf(`{"systemDetails": [{"devices": [{"deviceType": "TRIO_II_TB_GEO"}], "systemId": %q}]}`, token)
+			case strings.Contains(req.URL.Path, "/epochservice/v1/system/"):
+				require.Contains(t, req.URL.Path, token, "Expected each account to read its own system")
+				var groups []string
+				for ts := start; ts.Before(end); ts = ts.Add(15 * time.Minute) {
+					groups = append(groups, fmt.Sprintf(`{"startTimestamp": %d, "readings": [{"energyType": "IMPORT", "duration": 900, "energyWattHours": %d}]}`,
+						ts.Unix(), importWh[token]))
+				}
+				responseBody = "[" + strings.Join(groups, ",") + "]"
+			default:
+				t.Fatalf("unhandled request %s", req.URL)
+			}
+
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+			}, nil
+		},
+	}
+
+	accounts, err := parseGeoAccounts("home:home:secret,flat:flat:secret,locked:locked:wrong")
+	require.NoError(t, err)
+	services, failed := newGeoAccountServices(mockRoundTripper, accounts, GranularityHalfHour, nil)
+	require.Equal(t, []string{"locked"}, failed, "Expected only the bad login to fail")
+	require.Len(t, services, 2)
+
+	accountRows, results := fetchGeoAccounts(context.Background(), services, start, end, 2)
+	require.Empty(t, failedGeoAccounts(results))
+	require.Len(t, accountRows, 4)
+
+	primary := []*UsageRow{
+		{Timestamp: start, OCTO_ImportKWh: floatPtr(0.5)},
+		{Timestamp: start.Add(30 * time.Minute), OCTO_ImportKWh: floatPtr(0.5)},
+	}
+	rows := mergeAccountRows(primary, accountRows, "A-123")
+
+	filename := filepath.Join(t.TempDir(), "output.csv")
+	require.NoError(t, writeCSV(filename, rows, CSVOptions{IncludeAccount: true}))
+	records := readCSVFile(t, filename)
+	require.Len(t, records, 7)
+
+	account := len(records[0]) - 1
+	require.Equal(t, "Account", records[0][account])
+	expected := []struct {
+		account string
+		geoKWh  string
+	}{
+		{"A-123", "NaN"}, {"home", "2.000000"}, {"flat", "0.400000"},
+		{"A-123", "NaN"}, {"home", "2.000000"}, {"flat", "0.400000"},
+	}
+	for i, e := range expected {
+		record := records[i+1]
+		require.Equal(t, e.account, record[account], "Row %d", i)
+		require.Equal(t, e.geoKWh, record[5], "Row %d", i)
+	}
+}
+
+func TestGeoAccountsFailureIsolated(t *testing.T) {
+	start := time.Date(2024, 12, 9, 2, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			status := http.StatusOK
+			responseBody := ""
+			token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+
+			switch {
+			case strings.Contains(req.URL.Path, "/usersservice/v2/login"):
+				var login struct{ Identity string }
+				require.NoError(t, json.NewDecoder(req.Body).Decode(&login))
+				responseBody = fmt.Sprintf(`{"accessToken": "%s-token"}`, login.Identity)
+			case strings.Contains(req.URL.Path, "/api/userapi/v2/user/detail-systems"):
+				responseBody = fmt.Sprintf(`{"systemDetails": [{"devices": [{"deviceType": "TRIO_II_TB_GEO"}], "systemId": %q}]}`, token)
+			case strings.Contains(req.URL.Path, "/epochservice/v1/system/broken-token"):
+				status, responseBody = http.StatusInternalServerError, `{}`
+			case strings.Contains(req.URL.Path, "/epochservice/v1/system/"):
+				responseBody = fmt.Sprintf(`[{"startTimestamp": %d, "readings": [{"energyType": "IMPORT", "duration": 900, "energyWattHours": 500}]}]`, start.Unix())
+			default:
+				t.Fatalf("unhandled request %s", req.URL)
+			}
+
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+			}, nil
+		},
+	}
+
+	accounts, err := parseGeoAccounts("broken:broken:secret,home:home:secret")
+	require.NoError(t, err)
+	services, failed := newGeoAccountServices(mockRoundTripper, accounts, GranularityHalfHour, nil)
+	require.Empty(t, failed)
+
+	accountRows, results := fetchGeoAccounts(context.Background(), services, start, end, 2)
+	require.Equal(t, []string{"broken"}, failedGeoAccounts(results))
+	require.Equal(t, "home", results[1].Label)
+	require.NoError(t, results[1].Err)
+	require.Equal(t, 1, results[1].Rows)
+
+	filename := filepath.Join(t.TempDir(), "output.csv")
+	require.NoError(t, writeCSV(filename, mergeAccountRows(nil, accountRows, "A-123"), CSVOptions{IncludeAccount: true}))
+	records := readCSVFile(t, filename)
+	require.Len(t, records, 2, "Expected the succeeding account's data written")
+	require.Equal(t, "home", records[1][len(records[1])-1])
+	require.Equal(t, "0.500000", records[1][5])
+}
+
+func TestGeoAccountSummaryLogsFailuresAsErrors(t *testing.T) {
+	out := withLogging(t, LogText, slog.LevelWarn)
+	logGeoAccountSummary([]GeoAccountResult{
+		{Label: "home", Rows: 4},
+		{Label: "flat", Err: errors.New("boom")},
+	}, []string{"locked"})
+
+	logged := out.String()
+	require.NotContains(t, logged, "home", "Expected the successful account left out at warn level")
+	require.Contains(t, logged, "Error: Geo account failed account=flat err=boom")
+	require.Contains(t, logged, "Error: Geo account failed to log in account=locked")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	geo "github.com/mgazza/go-geotogether/client"
+	geoops "github.com/mgazza/go-geotogether/client/operations"
+)
+
+// maxGeoLoginAttempts is how many times a rate limited Geo login is tried.
+const maxGeoLoginAttempts = 4
+
+// geoLoginBackoff is the wait before the first retry of a rate limited Geo login without a
+// Retry-After header, doubling for each further retry.
+const geoLoginBackoff = 5 * time.Second
+
+// geoSleep waits between login attempts, replaced in tests.
+var geoSleep = time.Sleep
+
+// GeoTokenCache keeps Geo access tokens on disk so frequent runs reuse a login.
+// A nil *GeoTokenCache caches nothing.
+type GeoTokenCache struct {
+	Dir string
+	// TTL is how long a saved token is reused for.
+	TTL time.Duration
+}
+
+// geoToken is a Geo access token as saved by GeoTokenCache.
+type geoToken struct {
+	AccessToken string    `json:"access_token"`
+	SavedAt     time.Time `json:"saved_at"`
+}
+
+// path returns the token file for username, named by a hash so the username isn't exposed.
+func (c *GeoTokenCache) path(username string) string {
+	sum := sha256.Sum256([]byte(username))
+	return filepath.Join(c.Dir, "geo-token-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// load returns the saved token for username if it is younger than the TTL.
+func (c *GeoTokenCache) load(username string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	data, err := os.ReadFile(c.path(username))
+	if err != nil {
+		return "", false
+	}
+	var token geoToken
+	if err := json.Unmarshal(data, &token); err != nil || token.AccessToken == "" {
+		return "", false
+	}
+	if time.Since(token.SavedAt) >= c.TTL {
+		return "", false
+	}
+	return token.AccessToken, true
+}
+
+// save records the token for username, logging rather than failing if it can't be written.
+func (c *GeoTokenCache) save(username, accessToken string) {
+	if c == nil {
+		return
+	}
+	data, err := json.Marshal(geoToken{AccessToken: accessToken, SavedAt: time.Now()})
+	if err == nil {
+		err = os.WriteFile(c.path(username), data, 0600)
+	}
+	if err != nil {
+		slog.Warn("failed to save Geo token", "err", err)
+	}
+}
+
+// loginGeo returns an access token for the user, reusing a cached one when possible and
+// retrying the login with backoff when it is rate limited.
+func loginGeo(nc *geo.GeoTogetherAPI, username, password string, tokens *GeoTokenCache) (string, error) {
+	if token, ok := tokens.load(username); ok {
+		slog.Info("Reusing cached GeoTogether login")
+		return token, nil
+	}
+
+	p := geoops.NewPostUsersserviceV2LoginParams().WithBody(geoops.PostUsersserviceV2LoginBody{
+		Identity: username,
+		Password: password,
+	})
+	for attempt := 1; ; attempt++ {
+		r, err := nc.Operations.PostUsersserviceV2Login(p, nil)
+		if err == nil {
+			if !r.IsSuccess() {
+				return "", fmt.Errorf("failed to call GeoTogether login: %v", r.Error())
+			}
+			tokens.save(username, r.Payload.AccessToken)
+			return r.Payload.AccessToken, nil
+		}
+
+		wait, limited := geoRetryAfter(err, attempt)
+		if !limited || attempt == maxGeoLoginAttempts {
+			return "", fmt.Errorf("failed to initialize GeoTogether client: %w", err)
+		}
+		slog.Warn("GeoTogether login rate limited, retrying", "wait", wait)
+		geoSleep(wait)
+	}
+}
+
+// geoRetryAfter reports whether err is a rate limited response and how long to wait before
+// the next attempt, honouring Retry-After when given.
+func geoRetryAfter(err error, attempt int) (time.Duration, bool) {
+	var apiErr *runtime.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if response, ok := apiErr.Response.(runtime.ClientResponse); ok {
+		if wait, ok := parseRetryAfter(response.GetHeader("Retry-After"), time.Now()); ok {
+			return wait, true
+		}
+	}
+	return geoLoginBackoff << (attempt - 1), true
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// geoLoginRoundTripper answers logins with the given status codes in turn, then successes,
+// recording the logins and the bearer token on other requests.
+func geoLoginRoundTripper(codes []int, logins *int, bearer *string) *MockRoundTripper {
+	return &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			header := make(http.Header)
+			header.Set("Content-Type", "application/json")
+			status, body := http.StatusOK, `{}`
+			if strings.Contains(req.URL.Path, "/login") {
+				*logins++
+				body = `{"accessToken": "fresh-token"}`
+				if *logins <= len(codes) {
+					status, body = codes[*logins-1], `{}`
+					header.Set("Retry-After", "3")
+				}
+			} else {
+				*bearer = req.Header.Get("Authorization")
+				body = `{"systemDetails": [{"systemId": "system-1", "devices": [{}]}]}`
+			}
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(bytes.NewReader([]byte(body))),
+				Header:     header,
+			}, nil
+		},
+	}
+}
+
+func TestGeoLoginRetriesRateLimit(t *testing.T) {
+	var waits []time.Duration
+	geoSleep = func(d time.Duration) { waits = append(waits, d) }
+	t.Cleanup(func() { geoSleep = time.Sleep })
+
+	var logins int
+	var bearer string
+	service, err := NewGeoTogetherService(geoLoginRoundTripper([]int{http.StatusTooManyRequests}, &logins, &bearer), "user", "password", nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, logins)
+	require.Equal(t, []time.Duration{3 * time.Second}, waits, "Expected to wait for Retry-After")
+
+	_, err = service.GetUserSystemID()
+	require.NoError(t, err)
+	require.Equal(t, "Bearer fresh-token", bearer)
+}
+
+func TestGeoLoginReusesCachedToken(t *testing.T) {
+	tokens := &GeoTokenCache{Dir: t.TempDir(), TTL: time.Minute}
+	tokens.save("user", "cached-token")
+
+	var logins int
+	var bearer string
+	service, err := NewGeoTogetherService(geoLoginRoundTripper(nil, &logins, &bearer), "user", "password", tokens)
+	require.NoError(t, err)
+	require.Zero(t, logins, "Expected the cached token to be reused")
+
+	_, err = service.GetUserSystemID()
+	require.NoError(t, err)
+	require.Equal(t, "Bearer cached-token", bearer)
+
+	// Once the TTL has passed the login is repeated and the new token saved
+	tokens.TTL = 0
+	_, err = NewGeoTogetherService(geoLoginRoundTripper(nil, &logins, &bearer), "user", "password", tokens)
+	require.NoError(t, err)
+	require.Equal(t, 1, logins)
+	tokens.TTL = time.Minute
+	token, ok := tokens.load("user")
+	require.True(t, ok)
+	require.Equal(t, "fresh-token", token)
+}
+
+func TestGeoReloginOnExpiredToken(t *testing.T) {
+	var logins, readings int
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			header := make(http.Header)
+			header.Set("Content-Type", "application/json")
+			status, body := http.StatusOK, `[]`
+			switch {
+			case strings.Contains(req.URL.Path, "/login"):
+				logins++
+				body = fmt.Sprintf(`{"accessToken": "token-%d"}`, logins)
+			case strings.Contains(req.URL.Path, "/readings"):
+				readings++
+				// The first token has expired by the first readings call
+				if req.Header.Get("Authorization") == "Bearer token-1" {
+					status, body = http.StatusUnauthorized, `{}`
+				}
+			}
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(strings.NewReader(body)),
+				Header:     header,
+			}, nil
+		},
+	}
+
+	tokens := &GeoTokenCache{Dir: t.TempDir(), TTL: time.Minute}
+	// The logins go through the HTTP cache, as they do in a run
+	cached := &CachingRoundTripper{UnderlyingTransport: mockRoundTripper, CacheDir: t.TempDir()}
+	service, err := NewGeoTogetherService(cached, "user", "password", tokens)
+	require.NoError(t, err)
+	require.Equal(t, 1, logins)
+
+	_, err = service.GetSystemReadings(context.Background(), "system-1", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, logins, "Expected a login again after the 401")
+	require.Equal(t, 2, readings, "Expected the readings call retried once")
+	token, ok := tokens.load("user")
+	require.True(t, ok)
+	require.Equal(t, "token-2", token, "Expected the fresh token cached")
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"net/http"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -124,7 +128,7 @@ func TestPopulateGeoData(t *testing.T) {
 	}
 
 	// Mock service with the fake HTTP response
-	mockGeoService, err := NewGeoTogetherService(mockRoundTripper, "user", "password")
+	mockGeoService, err := NewGeoTogetherService(mockRoundTripper, "user", "password", nil)
 	require.NoError(t, err)
 
 	usage := make(map[time.Time]*UsageRow)
@@ -132,7 +136,7 @@ func TestPopulateGeoData(t *testing.T) {
 	endDate := startDate.Add(1 * time.Hour) // Testing one-hour window
 
 	// Run function
-	err = mockGeoService.PopulateGeoData(usage, startDate, endDate)
+	err = mockGeoService.PopulateGeoData(context.Background(), usage, startDate, endDate)
 	require.NoError(t, err)
 
 	// Expected Aggregated Readings
@@ -161,3 +165,274 @@ func TestPopulateGeoData(t *testing.T) {
 		require.Equal(t, expected.gasCost, *row.GEO_ImportGasMilliPenceCost, "Mismatch in gasCost at %s", timestamp)
 	}
 }
+
+func TestLiveRow(t *testing.T) {
+	liveStatus := http.StatusOK
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			status := http.StatusOK
+			responseBody := ""
+
+			switch {
+			case strings.Contains(req.URL.Path, "/usersservice/v2/login"):
+				responseBody = `{"username": "wibble", "accessToken": "wibble"}`
+			case strings.Contains(req.URL.Path, "/api/userapi/v2/user/detail-systems"):
+				responseBody = `{"systemDetails": [{"name": "Home", "devices": [{"deviceType": "TRIO_II_TB_GEO"}], "systemId": "123"}]}`
+			case strings.Contains(req.URL.Path, "/api/userapi/system/smets2-live-data/123"):
+				status = liveStatus
+				responseBody = `{"power": [{"type": "ELECTRICITY", "watts": 1234}, {"type": "GAS_ENERGY", "watts": 56}]}`
+				if status != http.StatusOK {
+					responseBody = `{}`
+				}
+			default:
+				t.Fatalf("unhandled request %s", req.URL)
+			}
+
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+			}, nil
+		},
+	}
+
+	geoService, err := NewGeoTogetherService(mockRoundTripper, "user", "password", nil)
+	require.NoError(t, err)
+	app := &App{Config: &Config{IncludeLive: true}, GeoService: geoService}
+
+	live := app.liveRow()
+	require.NotNil(t, live)
+	require.Equal(t, TagLive, live.Tag)
+
+	start := time.Date(2024, 12, 9, 2, 0, 0, 0, time.Local)
+	data := []*UsageRow{{Timestamp: start, OCTO_ImportKWh: floatPtr(0.5)}, live}
+
+	filename := filepath.Join(t.TempDir(), "output.csv")
+	require.NoError(t, writeCSV(filename, data, CSVOptions{IncludeLive: true}))
+
+	records := readCSVFile(t, filename)
+	require.Len(t, records, 3, "Expected the live row after the historical row")
+	columns := make(map[string]string)
+	for i, name := range records[0] {
+		columns[name] = records[2][i]
+	}
+	require.Empty(t, records[1][slices.Index(records[0], "Tag")], "Historical row should not be tagged")
+	require.Equal(t, TagLive, columns["Tag"])
+	require.Equal(t, "1234", columns["GEO_Live_Watts"])
+	require.Equal(t, "56", columns["GEO_Live_Gas_Watts"])
+	require.Equal(t, "NaN", columns["OCTO_Import_KWh"])
+
+	// The endpoint being unavailable omits the row rather than failing
+	liveStatus = http.StatusNotFound
+	require.Nil(t, app.liveRow())
+}
+
+func TestPopulateGeoDataV3(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	var requested []string
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			requested = append(requested, req.URL.Path)
+			status, responseBody := http.StatusOK, ""
+			switch {
+			case strings.Contains(req.URL.Path, "/usersservice/v2/login"):
+				responseBody = `{"accessToken": "wibble"}`
+			case strings.Contains(req.URL.Path, "/api/userapi/v3/user/systems"):
+				responseBody = `{"systems": [
+					{"systemId": "empty", "name": "Old", "devices": []},
+					{"systemId": "123", "name": "Home", "devices": [{"deviceType": "TRIO_II_TB_GEO", "nodeId": 0}]}
+				]}`
+			case strings.Contains(req.URL.Path, "/epochservice/v3/system/123/readings"):
+				responseBody = `{"systemId": "123", "readings": [
+					{"startTime": "2024-12-09T02:00:00Z", "durationSeconds": 900, "type": "IMPORT", "energyWh": 1470, "costMilliPence": 34559},
+					{"startTime": "2024-12-09T02:00:00Z", "durationSeconds": 900, "type": "GAS_ENERGY", "energyWh": 500, "costMilliPence": 12000},
+					{"startTime": "2024-12-09T02:15:00Z", "durationSeconds": 900, "type": "IMPORT", "energyWh": 1541, "costMilliPence": 36228},
+					{"startTime": "2024-12-09T02:30:00Z", "durationSeconds": 900, "type": "IMPORT", "energyWh": 1358, "costMilliPence": 31926}
+				]}`
+			default:
+				// The v1 readings and v2 systems endpoints have moved
+				status, responseBody = http.StatusNotFound, `{"error": "not found"}`
+			}
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+			}, nil
+		},
+	}
+
+	geoService, err := NewGeoTogetherService(mockRoundTripper, "user", "password", nil)
+	require.NoError(t, err)
+	geoService.APIVersion = GeoAPIAuto
+
+	usage := make(map[time.Time]*UsageRow)
+	start := time.Date(2024, 12, 9, 2, 0, 0, 0, time.Local)
+	require.NoError(t, geoService.PopulateGeoData(context.Background(), usage, start, start.Add(time.Hour)))
+	require.Equal(t, GeoAPIV3, geoService.APIVersion, "Expected auto to switch to v3")
+	require.Equal(t, []string{
+		"/usersservice/v2/login",
+		"/api/userapi/v2/user/detail-systems",
+		"/api/userapi/v3/user/systems",
+		"/epochservice/v3/system/123/readings",
+	}, requested, "Expected v1 tried once, then v3 used for the rest of the run")
+
+	require.Len(t, usage, 2)
+	require.Equal(t, int64(1470+1541), *usage[start].GEO_ImportWh)
+	require.Equal(t, int64(500), *usage[start].GEO_ImportGasWh)
+	require.Equal(t, int64(34559+36228), *usage[start].GEO_ImportMilliPenceCost)
+	require.Equal(t, int64(1358), *usage[start.Add(30*time.Minute)].GEO_ImportWh)
+}
+
+func TestPopulateGeoDataClockChanges(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	for _, tc := range []struct {
+		day   time.Time
+		slots int
+	}{
+		{time.Date(2025, 3, 30, 0, 0, 0, 0, time.Local), 46},  // clocks go forward
+		{time.Date(2025, 10, 26, 0, 0, 0, 0, time.Local), 50}, // clocks go back
+	} {
+		end := tc.day.AddDate(0, 0, 1)
+		mockRoundTripper := &MockRoundTripper{
+			Handler: func(req *http.Request) (*http.Response, error) {
+				responseBody := `{"accessToken": "wibble"}`
+				switch {
+				case strings.Contains(req.URL.Path, "/api/userapi/v3/user/systems"):
+					responseBody = `{"systems": [{"systemId": "123", "name": "Home", "devices": [{"deviceType": "TRIO_II_TB_GEO", "nodeId": 0}]}]}`
+				case strings.Contains(req.URL.Path, "/readings"):
+					// A reading every quarter hour of the day
+					var readings []string
+					for ts := tc.day; ts.Before(end); ts = ts.Add(15 * time.Minute) {
+						readings = append(readings, fmt.Sprintf(`{"startTime": %q, "durationSeconds": 900, "type": "IMPORT", "energyWh": 100, "costMilliPence": 2500}`,
+							ts.UTC().Format(time.RFC3339)))
+					}
+					responseBody = fmt.Sprintf(`{"readings": [%s]}`, strings.Join(readings, ","))
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(responseBody)),
+					Header:     http.Header{"Content-Type": []string{"application/json"}},
+				}, nil
+			},
+		}
+		geoService, err := NewGeoTogetherService(mockRoundTripper, "user", "password", nil)
+		require.NoError(t, err)
+		geoService.APIVersion = GeoAPIV3
+
+		usage := make(map[time.Time]*UsageRow)
+		require.NoError(t, geoService.PopulateGeoData(context.Background(), usage, tc.day, end))
+		require.Len(t, usage, tc.slots, "Expected a bucket per half-hour of %s", tc.day.Format(time.DateOnly))
+		for _, row := range usage {
+			require.Equal(t, int64(200), *row.GEO_ImportWh, "Expected two quarter hours in the %s bucket", row.Timestamp)
+		}
+	}
+}
+
+func TestPopulateGeoDataExport(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			var responseBody string
+			switch {
+			case strings.Contains(req.URL.Path, "/usersservice/v2/login"):
+				responseBody = `{"accessToken": "wibble"}`
+			case strings.Contains(req.URL.Path, "/api/userapi/v2/user/detail-systems"):
+				responseBody = `{"systemDetails": [{"systemId": "123", "devices": [{"deviceType": "TRIO_II_TB_GEO"}]}]}`
+			case strings.Contains(req.URL.Path, "/epochservice/v1/system/"):
+				// 02:00 and 02:15 fall in the 02:00 slot, 02:30 in the next
+				responseBody = `[
+					{"startTimestamp": 1733709600, "readings": [
+						{"energyType": "IMPORT", "duration": 900, "energyWattHours": 100, "milliPenceCost": 2500},
+						{"energyType": "EXPORT", "duration": 900, "energyWattHours": 200, "milliPenceCost": 3000}
+					]},
+					{"startTimestamp": 1733710500, "readings": [
+						{"energyType": "EXPORT", "duration": 900, "energyWattHours": 300, "milliPenceCost": 4500},
+						{"energyType": "GENERATION", "duration": 900, "energyWattHours": 400}
+					]},
+					{"startTimestamp": 1733711400, "readings": [
+						{"energyType": "IMPORT", "duration": 900, "energyWattHours": 50, "milliPenceCost": 1250}
+					]}
+				]`
+			default:
+				t.Fatalf("unhandled request %s", req.URL)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(responseBody)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	geoService, err := NewGeoTogetherService(mockRoundTripper, "user", "password", nil)
+	require.NoError(t, err)
+
+	usage := make(UsageStore)
+	start := time.Date(2024, 12, 9, 2, 0, 0, 0, time.Local)
+	require.NoError(t, geoService.PopulateGeoData(context.Background(), usage, start, start.Add(time.Hour)))
+
+	row := usage[start]
+	require.NotNil(t, row)
+	require.Equal(t, int64(100), *row.GEO_ImportWh)
+	require.Equal(t, int64(200+300), *row.GEO_ExportWh)
+	require.Equal(t, int64(3000+4500), *row.GEO_ExportMilliPenceCost)
+	require.Equal(t, int64(400), *row.GEO_GenerationWh)
+
+	row = usage[start.Add(30*time.Minute)]
+	require.NotNil(t, row)
+	require.Equal(t, int64(50), *row.GEO_ImportWh)
+	require.Nil(t, row.GEO_ExportWh, "Expected no export figure for a slot without export readings")
+}
+
+func TestPopulateGeoDataReadingDurations(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	start := time.Date(2024, 12, 9, 2, 0, 0, 0, time.Local)
+	// Six 5 minute readings from 02:00, then an hour long reading from 02:30
+	var groups []string
+	for i := 0; i < 6; i++ {
+		groups = append(groups, fmt.Sprintf(`{"startTimestamp": %d, "readings": [
+			{"energyType": "IMPORT", "duration": 300, "energyWattHours": %d, "milliPenceCost": 100}]}`,
+			start.Add(time.Duration(i)*5*time.Minute).Unix(), 10*(i+1)))
+	}
+	groups = append(groups, fmt.Sprintf(`{"startTimestamp": %d, "readings": [
+		{"energyType": "IMPORT", "duration": 3600, "energyWattHours": 1001, "milliPenceCost": 25001}]}`,
+		start.Add(30*time.Minute).Unix()))
+
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			var responseBody string
+			switch {
+			case strings.Contains(req.URL.Path, "/usersservice/v2/login"):
+				responseBody = `{"accessToken": "wibble"}`
+			case strings.Contains(req.URL.Path, "/api/userapi/v2/user/detail-systems"):
+				responseBody = `{"systemDetails": [{"systemId": "123", "devices": [{"deviceType": "TRIO_II_TB_GEO"}]}]}`
+			case strings.Contains(req.URL.Path, "/epochservice/v1/system/"):
+				responseBody = "[" + strings.Join(groups, ",") + "]"
+			default:
+				t.Fatalf("unhandled request %s", req.URL)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(responseBody)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	geoService, err := NewGeoTogetherService(mockRoundTripper, "user", "password", nil)
+	require.NoError(t, err)
+
+	usage := make(UsageStore)
+	require.NoError(t, geoService.PopulateGeoData(context.Background(), usage, start, start.Add(90*time.Minute)))
+
+	require.Equal(t, int64(10+20+30+40+50+60), *usage[start].GEO_ImportWh, "Expected the six 5 minute readings in one slot")
+	require.Equal(t, int64(600), *usage[start].GEO_ImportMilliPenceCost)
+
+	// The hour long reading is split across its two slots without losing a watt hour
+	first, second := usage[start.Add(30*time.Minute)], usage[start.Add(60*time.Minute)]
+	require.Equal(t, int64(1001), *first.GEO_ImportWh+*second.GEO_ImportWh)
+	require.InDelta(t, 500, *first.GEO_ImportWh, 1)
+	require.Equal(t, int64(25001), *first.GEO_ImportMilliPenceCost+*second.GEO_ImportMilliPenceCost)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	geoops "github.com/mgazza/go-geotogether/client/operations"
+)
+
+// GeoAPIVersion selects the version of the Geo readings and systems endpoints.
+type GeoAPIVersion string
+
+const (
+	// GeoAPIV1 uses the v1 readings and v2 systems endpoints the client was generated from.
+	GeoAPIV1 GeoAPIVersion = "v1"
+	// GeoAPIV3 uses the v3 endpoints some accounts have been moved to.
+	GeoAPIV3 GeoAPIVersion = "v3"
+	// GeoAPIAuto uses v1, switching to v3 for the rest of the run if v1 isn't found.
+	GeoAPIAuto GeoAPIVersion = "auto"
+)
+
+// parseGeoAPIVersion validates a Geo API version, defaulting to auto when empty.
+func parseGeoAPIVersion(value string) (GeoAPIVersion, error) {
+	switch v := GeoAPIVersion(value); v {
+	case "":
+		return GeoAPIAuto, nil
+	case GeoAPIV1, GeoAPIV3, GeoAPIAuto:
+		return v, nil
+	}
+	return "", fmt.Errorf("unknown Geo API version %q, expected %s, %s or %s", value, GeoAPIV1, GeoAPIV3, GeoAPIAuto)
+}
+
+// withAPIVersion makes call against the configured version, passing v3 to switch it to the
+// v3 endpoint. In auto mode a v1 endpoint that isn't found switches the service to v3.
+func (s *GeoTogetherService) withAPIVersion(call func(opts ...geoops.ClientOption) error, v3 geoops.ClientOption) error {
+	if s.APIVersion == GeoAPIV3 {
+		return call(v3)
+	}
+	err := call()
+	var apiErr *runtime.APIError
+	if s.APIVersion == GeoAPIAuto && errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		slog.Info("Geo v1 endpoints not found, switching to v3")
+		s.APIVersion = GeoAPIV3
+		return call(v3)
+	}
+	return err
+}
+
+// withV3Readings requests the v3 readings endpoint, mapping its flat list of readings into
+// the v1 groups so they aggregate the same way.
+func withV3Readings() geoops.ClientOption {
+	return func(op *runtime.ClientOperation) {
+		op.PathPattern = "/epochservice/v3/system/{systemId}/readings"
+		op.Reader = v3Reader(func(body []byte) (interface{}, error) {
+			var page struct {
+				Readings []struct {
+					StartTime       time.Time `json:"startTime"`
+					DurationSeconds int64     `json:"durationSeconds"`
+					Type            string    `json:"type"`
+					Tier            string    `json:"tier"`
+					EnergyWh        int64     `json:"energyWh"`
+					CostMilliPence  int64     `json:"costMilliPence"`
+				} `json:"readings"`
+			}
+			if err := json.Unmarshal(body, &page); err != nil {
+				return nil, err
+			}
+
+			result := &geoops.GetEpochserviceV1SystemSystemIDReadingsOK{}
+			for _, r := range page.Readings {
+				result.Payload = append(result.Payload, &geoops.GetEpochserviceV1SystemSystemIDReadingsOKBodyItems0{
+					StartTimestamp: float64(r.StartTime.Unix()),
+					Readings: []*geoops.GetEpochserviceV1SystemSystemIDReadingsOKBodyItems0ReadingsItems0{{
+						Duration:        r.DurationSeconds,
+						EnergyType:      r.Type,
+						EnergyWattHours: r.EnergyWh,
+						MilliPenceCost:  r.CostMilliPence,
+						TierType:        r.Tier,
+					}},
+				})
+			}
+			return result, nil
+		})
+	}
+}
+
+// withV3Systems requests the v3 systems endpoint, mapping its systems into the v2 system details.
+func withV3Systems() geoops.ClientOption {
+	return func(op *runtime.ClientOperation) {
+		op.PathPattern = "/api/userapi/v3/user/systems"
+		op.Reader = v3Reader(func(body []byte) (interface{}, error) {
+			var page struct {
+				Systems []struct {
+					SystemID string `json:"systemId"`
+					Name     string `json:"name"`
+					Devices  []struct {
+						DeviceType string `json:"deviceType"`
+						NodeID     int64  `json:"nodeId"`
+					} `json:"devices"`
+				} `json:"systems"`
+			}
+			if err := json.Unmarshal(body, &page); err != nil {
+				return nil, err
+			}
+
+			result := &geoops.GetAPIUserapiV2UserDetailSystemsOK{Payload: &geoops.GetAPIUserapiV2UserDetailSystemsOKBody{}}
+			for _, system := range page.Systems {
+				details := &geoops.GetAPIUserapiV2UserDetailSystemsOKBodySystemDetailsItems0{SystemID: system.SystemID, Name: system.Name}
+				for _, device := range system.Devices {
+					details.Devices = append(details.Devices, &geoops.GetAPIUserapiV2UserDetailSystemsOKBodySystemDetailsItems0DevicesItems0{
+						DeviceType: device.DeviceType,
+						NodeID:     device.NodeID,
+					})
+				}
+				result.Payload.SystemDetails = append(result.Payload.SystemDetails, details)
+			}
+			return result, nil
+		})
+	}
+}
+
+// v3Reader decodes successful v3 responses with decode, returning other statuses as API errors.
+type v3Reader func(body []byte) (interface{}, error)
+
+func (decode v3Reader) ReadResponse(response runtime.ClientResponse, consumer runtime.Consumer) (interface{}, error) {
+	if response.Code() != http.StatusOK {
+		return nil, runtime.NewAPIError("unexpected Geo v3 response status", response, response.Code())
+	}
+	body, err := io.ReadAll(response.Body())
+	if err != nil {
+		return nil, err
+	}
+	return decode(body)
+}
@@ -2,8 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"os"
+	"path"
+	"strings"
 	"testing"
 	"time"
 
@@ -38,8 +44,432 @@ func TestFetchHalfHourlyInverterData(t *testing.T) {
 	end := time.Date(2025, 1, 1, 23, 59, 59, 0, time.Local)
 
 	data := map[time.Time]*UsageRow{}
-	err := givService.FetchHalfHourlyInverterData(data, serial, start, end)
+	err := givService.FetchHalfHourlyInverterData(context.Background(), data, []string{serial}, start, end)
 	require.NoError(t, err, "Expected no error while fetching inverter data")
-	require.Len(t, data, 48, "Expected 48 data points")
+	require.Len(t, data, slotsInDay(start, nil), "Expected a data point per half-hour of the day")
 	require.Equal(t, 1845.4, *data[start].CumulativeImportInverter, "Unexpected first cumulative import")
 }
+
+func TestFetchHalfHourlyInverterDataThreePhase(t *testing.T) {
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			// Each per-phase shape, summing to the scalar figures of TestFetchHalfHourlyInverterData
+			responseBody := `{
+				"data": [
+					{"time": "2025-01-01T00:00:00Z", "total": {"grid": {"import": [600, 600, 642.3], "export": {"l1": 1629.9, "l2": 0, "l3": 0}}}},
+					{"time": "2025-01-01T00:30:00Z", "total": {"grid": {"l1": {"import": 600, "export": 1630}, "l2": {"import": 600, "export": 0}, "l3": {"import": 645.4, "export": 0}}}}
+				],
+				"meta": {"current_page": 1, "last_page": 1}
+			}`
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	givService := NewGivEnergyService(mockRoundTripper, "dummyBearerToken")
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	end := time.Date(2025, 1, 1, 23, 59, 59, 0, time.Local)
+
+	data := map[time.Time]*UsageRow{}
+	require.NoError(t, givService.FetchHalfHourlyInverterData(context.Background(), data, []string{"ABC12345"}, start, end))
+	require.InDelta(t, 1845.4, *data[start].CumulativeImportInverter, 1e-9, "Expected the phases summed")
+	require.InDelta(t, 1630, *data[start].CumulativeExportInverter, 1e-9, "Expected the phases summed")
+}
+
+func TestFetchHalfHourlyInverterDataNonObjectPoint(t *testing.T) {
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			responseBody := `{
+				"data": [
+					"not a data point",
+					{"time": "2025-01-01T00:00:00Z", "total": {"grid": {"import": [600, 600, 642.3], "export": 0}}}
+				],
+				"meta": {"current_page": 1, "last_page": 1}
+			}`
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	givService := NewGivEnergyService(mockRoundTripper, "dummyBearerToken")
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	end := time.Date(2025, 1, 1, 23, 59, 59, 0, time.Local)
+
+	data := map[time.Time]*UsageRow{}
+	require.NotPanics(t, func() {
+		err := givService.FetchHalfHourlyInverterData(context.Background(), data, []string{"ABC12345"}, start, end)
+		require.Error(t, err, "Expected the malformed data point reported")
+	})
+}
+
+func TestFetchHalfHourlyInverterDataNonJSON(t *testing.T) {
+	var waits []time.Duration
+	givSleep = func(d time.Duration) { waits = append(waits, d) }
+	t.Cleanup(func() { givSleep = time.Sleep })
+
+	outageFor := 0
+	calls := 0
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			calls++
+			if calls <= outageFor {
+				header := make(http.Header)
+				header.Set("Content-Type", "text/html; charset=UTF-8")
+				return &http.Response{
+					StatusCode: http.StatusServiceUnavailable,
+					Status:     "503 Service Unavailable",
+					Body:       io.NopCloser(strings.NewReader("<html><body><h1>We'll be back soon</h1></body></html>")),
+					Header:     header,
+				}, nil
+			}
+			header := make(http.Header)
+			header.Set("Content-Type", "application/json")
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`{"data": [{"time": "2025-01-01T00:00:00Z", "total": {"grid": {"import": 1, "export": 0}}}], "meta": {"current_page": 1, "last_page": 1}}`)),
+				Header:     header,
+			}, nil
+		},
+	}
+	givService := NewGivEnergyService(mockRoundTripper, "dummyBearerToken")
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	// A persistent outage fails with a clear error once the retries are used up
+	outageFor = 10
+	err := givService.FetchHalfHourlyInverterData(context.Background(), map[time.Time]*UsageRow{}, []string{"ABC12345"}, start, end)
+	require.ErrorIs(t, err, ErrNonJSON)
+	require.ErrorContains(t, err, "upstream returned non-JSON (status 503 Service Unavailable, content type text/html")
+	require.Equal(t, 1+givNonJSONRetries, calls)
+	require.Equal(t, []time.Duration{givRetryBackoff, 2 * givRetryBackoff}, waits)
+
+	// A brief outage is retried through
+	calls, outageFor = 0, 1
+	require.NoError(t, givService.FetchHalfHourlyInverterData(context.Background(), map[time.Time]*UsageRow{}, []string{"ABC12345"}, start, end))
+	require.Equal(t, 2, calls)
+}
+
+func TestFetchHalfHourlyInverterDataMeterRegister(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			var responseBody string
+			switch req.URL.Path {
+			case "/v1/inverter/ABC12345/data-points/2025-01-01":
+				// Sparse data points, so the half-hour export is interpolated
+				responseBody = `{
+					"data": [
+						{"time": "2025-01-01T00:00:00Z", "total": {"grid": {"import": 10, "export": 100}}},
+						{"time": "2025-01-01T01:00:00Z", "total": {"grid": {"import": 12, "export": 102}}}
+					],
+					"meta": {"current_page": 1, "last_page": 1}
+				}`
+			case "/v1/inverter/ABC12345/meter/data":
+				// The readings span two pages
+				if req.URL.Query().Get("page") == "2" {
+					responseBody = `{
+						"data": [{"time": "2025-01-01T01:00:00.000000Z", "import_active_energy": 12, "export_active_energy": 102}],
+						"meta": {"current_page": 2, "last_page": 2}
+					}`
+					break
+				}
+				responseBody = `{
+					"data": [
+						{"time": "2025-01-01T00:00:00.000000Z", "import_active_energy": 10, "export_active_energy": 100},
+						{"time": "2025-01-01T00:30:00.000000Z", "import_active_energy": 11, "export_active_energy": 101.5}
+					],
+					"meta": {"current_page": 1, "last_page": 2}
+				}`
+			default:
+				t.Fatalf("unhandled request %s", req.URL)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour + time.Second)
+
+	fetch := func(useRegister bool) map[time.Time]*UsageRow {
+		givService := NewGivEnergyService(mockRoundTripper, "dummyBearerToken")
+		givService.UseMeterRegister = useRegister
+
+		data := map[time.Time]*UsageRow{}
+		err := givService.FetchHalfHourlyInverterData(context.Background(), data, []string{"ABC12345"}, start, end)
+		require.NoError(t, err)
+		return data
+	}
+
+	dataPoints := fetch(false)
+	register := fetch(true)
+
+	// Interpolated data points smear the export evenly across the hour
+	require.InDelta(t, 1.0, *dataPoints[start].GE_ExportKWh, 1e-9)
+	require.InDelta(t, 1.0, *dataPoints[start.Add(30*time.Minute)].GE_ExportKWh, 1e-9)
+
+	// The register reports the actual split
+	require.InDelta(t, 1.5, *register[start].GE_ExportKWh, 1e-9)
+	require.InDelta(t, 0.5, *register[start.Add(30*time.Minute)].GE_ExportKWh, 1e-9)
+
+	// Both agree on the total and on import
+	require.InDelta(t,
+		*dataPoints[start].GE_ExportKWh+*dataPoints[start.Add(30*time.Minute)].GE_ExportKWh,
+		*register[start].GE_ExportKWh+*register[start.Add(30*time.Minute)].GE_ExportKWh, 1e-9)
+	require.Equal(t, *dataPoints[start].GE_ImportKWh, *register[start].GE_ImportKWh)
+}
+
+func TestFetchHalfHourlyInverterDataStale(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			// The latest point is three hours before the end of the range
+			responseBody := `{
+				"data": [
+					{"time": "2025-01-01T20:00:00Z", "total": {"grid": {"import": 1842.3, "export": 1629.9}}},
+					{"time": "2025-01-01T21:00:00Z", "total": {"grid": {"import": 1845.4, "export": 1630}}}
+				],
+				"meta": {"current_page": 1, "last_page": 1}
+			}`
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	givService := NewGivEnergyService(mockRoundTripper, "dummyBearerToken")
+	givService.FreshnessThreshold = time.Hour
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	end := time.Date(2025, 1, 2, 0, 0, 0, 0, time.Local)
+	lastPoint := time.Date(2025, 1, 1, 21, 0, 0, 0, time.Local)
+
+	data := map[time.Time]*UsageRow{}
+	err := givService.FetchHalfHourlyInverterData(context.Background(), data, []string{"ABC12345"}, start, end)
+	require.NoError(t, err)
+
+	require.Contains(t, logs.String(), "not interpolating beyond it")
+	for timestamp := range data {
+		require.True(t, timestamp.Before(lastPoint), "Fabricated row at %s after the last data point", timestamp)
+	}
+	require.Contains(t, data, lastPoint.Add(-30*time.Minute), "Expected the slot ending at the last data point")
+}
+
+func TestFetchHalfHourlyInverterDataClockChange(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	tests := []struct {
+		name     string
+		day      time.Time
+		expected int
+	}{
+		{name: "Spring forward", day: time.Date(2024, 3, 31, 0, 0, 0, 0, time.Local), expected: 46},
+		{name: "Fall back", day: time.Date(2024, 10, 27, 0, 0, 0, 0, time.Local), expected: 50},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			end := test.day.AddDate(0, 0, 1)
+
+			// A data point every 15 minutes across the local day
+			var points []string
+			for ts := test.day; !ts.After(end); ts = ts.Add(15 * time.Minute) {
+				points = append(points, fmt.Sprintf(`{"time": %q, "total": {"grid": {"import": %f, "export": 0}}}`,
+					ts.UTC().Format(time.RFC3339), ts.Sub(test.day).Hours()))
+			}
+
+			var requested []string
+			mockRoundTripper := &MockRoundTripper{
+				Handler: func(req *http.Request) (*http.Response, error) {
+					requested = append(requested, path.Base(req.URL.Path))
+					responseBody := fmt.Sprintf(`{"data": [%s], "meta": {"current_page": 1, "last_page": 1}}`, strings.Join(points, ","))
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+						Header:     make(http.Header),
+					}, nil
+				},
+			}
+
+			givService := NewGivEnergyService(mockRoundTripper, "dummyBearerToken")
+			data := map[time.Time]*UsageRow{}
+			require.NoError(t, givService.FetchHalfHourlyInverterData(context.Background(), data, []string{"ABC12345"}, test.day, end))
+
+			require.Equal(t, []string{test.day.Format("2006-01-02")}, requested, "Expected the day to be fetched once")
+			require.Equal(t, test.expected, slotsInDay(test.day, nil))
+			require.Len(t, data, test.expected, "Unexpected number of slots")
+
+			// Every slot's usage is half an hour's worth
+			for timestamp, row := range data {
+				if row.GE_ImportKWh != nil {
+					require.InDelta(t, 0.5, *row.GE_ImportKWh, 0.0001, "Unexpected usage at %s", timestamp)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSerial(t *testing.T) {
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			require.Equal(t, "/v1/communication-device", req.URL.Path, "Unexpected request URL")
+			responseBody := `{"data": [
+				{"serial_number": "WF2345G123", "type": "WIFI", "inverter": {"serial": "CE2345G123"}},
+				{"serial_number": "WF9876G321", "type": "WIFI", "inverter": {"serial": "CE9876G321"}}
+			]}`
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	givService := NewGivEnergyService(mockRoundTripper, "dummyBearerToken")
+
+	require.NoError(t, givService.ValidateSerial("CE9876G321"))
+
+	err := givService.ValidateSerial("ABC12345")
+	require.ErrorIs(t, err, ErrUnknownInverter)
+	require.ErrorContains(t, err, "CE2345G123, CE9876G321", "Expected the account's serials listed")
+	require.Equal(t, ExitConfig, exitCode(err))
+}
+
+func TestFetchHalfHourlyInverterDataConcurrent(t *testing.T) {
+	withLocation(t, "Europe/London")
+	start := time.Date(2025, 3, 28, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(0, 0, 5)
+
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			day, err := time.ParseInLocation("2006-01-02", path.Base(req.URL.Path), time.Local)
+			if err != nil {
+				return nil, err
+			}
+			// Earlier days answer slower, so concurrent fetches finish out of order
+			time.Sleep(time.Duration(end.Sub(day).Hours()/24) * time.Millisecond)
+			var data []string
+			for ts := day; ts.Before(day.AddDate(0, 0, 1)); ts = ts.Add(20 * time.Minute) {
+				hours := ts.Sub(start).Hours()
+				data = append(data, fmt.Sprintf(`{"time": %q, "total": {"grid": {"import": %f, "export": %f}, "solar": %f}}`,
+					ts.UTC().Format(time.RFC3339), hours*0.4, hours*0.1, hours*0.2))
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(fmt.Sprintf(`{"data": [%s], "meta": {"current_page": 1, "last_page": 1}}`, strings.Join(data, ",")))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	fetch := func(concurrency int) UsageStore {
+		givService := NewGivEnergyService(mockRoundTripper, "dummyBearerToken")
+		givService.Concurrency = concurrency
+		usage := make(UsageStore)
+		require.NoError(t, givService.FetchHalfHourlyInverterData(context.Background(), usage, []string{"ABC12345"}, start, end))
+		return usage
+	}
+
+	serial := fetch(1)
+	require.NotEmpty(t, serial)
+	require.Equal(t, serial, fetch(4), "Expected the concurrent fetch to match the serial one")
+}
+
+func TestFetchHalfHourlyInverterDataCounterReset(t *testing.T) {
+	withLocation(t, "Europe/London")
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+
+	// The import counter climbs, is reset part way through, then climbs again from zero
+	imports := []float64{100, 100.5, 101, 0.2, 0.7, 1.2}
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			var data []string
+			for i, value := range imports {
+				data = append(data, fmt.Sprintf(`{"time": %q, "total": {"grid": {"import": %f, "export": 0}}}`,
+					start.Add(time.Duration(i)*30*time.Minute).UTC().Format(time.RFC3339), value))
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(fmt.Sprintf(`{"data": [%s], "meta": {"current_page": 1, "last_page": 1}}`, strings.Join(data, ",")))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	givService := NewGivEnergyService(mockRoundTripper, "dummyBearerToken")
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	usage := make(UsageStore)
+	require.NoError(t, givService.FetchHalfHourlyInverterData(context.Background(), usage, []string{"ABC12345"}, start, start.Add(3*time.Hour)))
+	deltas := 0
+	for _, row := range usage {
+		if row.GE_ImportKWh != nil {
+			deltas++
+			require.GreaterOrEqual(t, *row.GE_ImportKWh, 0.0, "Expected no negative delta at %s", row.Timestamp)
+		}
+	}
+	require.Equal(t, 5, deltas)
+	require.Contains(t, logs.String(), "GivEnergy counter went back, treating it as reset counter=import previous=101 ")
+}
+
+func TestFetchHalfHourlyInverterDataMultipleSerials(t *testing.T) {
+	withLocation(t, "Europe/London")
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+
+	// Two inverters whose counters climb at different rates and are read at different times
+	inverters := map[string]struct {
+		offset                       time.Duration
+		base, importRate, exportRate float64
+	}{
+		"ABC12345": {0, 1000, 0.4, 0.2},
+		"DEF67890": {15 * time.Minute, 500, 0.2, 0.6},
+	}
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			serial := strings.Split(req.URL.Path, "/")[3]
+			inverter, ok := inverters[serial]
+			require.True(t, ok, "Unexpected serial %s", serial)
+
+			var data []string
+			for ts := start.Add(inverter.offset); ts.Before(start.AddDate(0, 0, 1)); ts = ts.Add(30 * time.Minute) {
+				hours := ts.Sub(start).Hours()
+				data = append(data, fmt.Sprintf(`{"time": %q, "total": {"grid": {"import": %f, "export": %f}}}`,
+					ts.UTC().Format(time.RFC3339), inverter.base+hours*inverter.importRate, inverter.base+hours*inverter.exportRate))
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(fmt.Sprintf(`{"data": [%s], "meta": {"current_page": 1, "last_page": 1}}`, strings.Join(data, ",")))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	givService := NewGivEnergyService(mockRoundTripper, "dummyBearerToken")
+
+	usage := make(UsageStore)
+	require.NoError(t, givService.FetchHalfHourlyInverterData(context.Background(), usage, []string{"ABC12345", "DEF67890"}, start, start.Add(3*time.Hour)))
+	require.InDelta(t, 1500+0.5*0.6, *usage[start].CumulativeImportInverter, 1e-6, "Expected the summed counters at the end of the first slot")
+	for ts := start.Add(30 * time.Minute); ts.Before(start.Add(150 * time.Minute)); ts = ts.Add(30 * time.Minute) {
+		row := usage[ts]
+		require.NotNil(t, row.GE_ImportKWh, "Expected a combined import at %s", ts)
+		require.InDelta(t, 0.5*(0.4+0.2), *row.GE_ImportKWh, 1e-6, "Unexpected combined import at %s", ts)
+		require.InDelta(t, 0.5*(0.2+0.6), *row.GE_ExportKWh, 1e-6, "Unexpected combined export at %s", ts)
+	}
+}
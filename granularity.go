@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Granularity is the width of the time slots the usage is bucketed into.
+type Granularity string
+
+const (
+	GranularityHalfHour Granularity = "half_hour"
+	GranularityHour     Granularity = "hour"
+	GranularityDay      Granularity = "day"
+)
+
+// parseGranularity validates a granularity name, defaulting to half-hourly when empty.
+func parseGranularity(value string) (Granularity, error) {
+	switch g := Granularity(value); g {
+	case "":
+		return GranularityHalfHour, nil
+	case GranularityHalfHour, GranularityHour, GranularityDay:
+		return g, nil
+	}
+	return "", fmt.Errorf("unknown granularity %q, expected %s, %s or %s",
+		value, GranularityHalfHour, GranularityHour, GranularityDay)
+}
+
+// slot returns the start of the slot containing t, in loc, or local time when nil. The zero
+// value is half-hourly.
+func (g Granularity) slot(t time.Time, loc *time.Location) time.Time {
+	switch g {
+	case GranularityHour:
+		return inZone(t.Truncate(time.Hour), loc)
+	case GranularityDay:
+		return truncateToMidnight(inZone(t, loc))
+	}
+	return halfHourSlot(t, loc)
+}
+
+// offsetSlot returns the start of the slot containing t when the meter's slot boundaries sit
+// offset past the hour and half-hour, such as :05 and :35. Daily slots always start at midnight.
+func (g Granularity) offsetSlot(t time.Time, offset time.Duration, loc *time.Location) time.Time {
+	if offset == 0 || g == GranularityDay {
+		return g.slot(t, loc)
+	}
+	return g.slot(t.Add(-offset), loc).Add(offset)
+}
+
+// parseSlotOffset validates a slot boundary offset, which must fall within the first half-hour.
+func parseSlotOffset(offset time.Duration) (time.Duration, error) {
+	if offset < 0 || offset >= 30*time.Minute {
+		return 0, fmt.Errorf("slot offset %s must be at least 0 and less than 30m", offset)
+	}
+	return offset, nil
+}
+
+// next returns the start of the slot following the one starting at t.
+func (g Granularity) next(t time.Time) time.Time {
+	switch g {
+	case GranularityHour:
+		return t.Add(time.Hour)
+	case GranularityDay:
+		return t.AddDate(0, 0, 1)
+	}
+	return t.Add(30 * time.Minute)
+}
+
+// prev returns the start of the slot preceding the one starting at t.
+func (g Granularity) prev(t time.Time) time.Time {
+	switch g {
+	case GranularityHour:
+		return t.Add(-time.Hour)
+	case GranularityDay:
+		return t.AddDate(0, 0, -1)
+	}
+	return t.Add(-30 * time.Minute)
+}
+
+// groupBy returns the Octopus consumption group_by value, nil for half-hourly.
+func (g Granularity) groupBy() *string {
+	switch g {
+	case GranularityHour, GranularityDay:
+		groupBy := string(g)
+		return &groupBy
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDailyGranularity(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	// Spans the spring clock change so one of the days is only 23 hours long
+	start := time.Date(2024, 3, 30, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(0, 0, 3)
+
+	var octopusResults []string
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		octopusResults = append(octopusResults, fmt.Sprintf(`{"interval_start": %q, "interval_end": %q, "consumption": 12.5}`,
+			day.Format(time.RFC3339), day.AddDate(0, 0, 1).Format(time.RFC3339)))
+	}
+
+	var givData []string
+	for ts := start; !ts.After(end); ts = ts.Add(time.Hour) {
+		givData = append(givData, fmt.Sprintf(`{"time": %q, "total": {"grid": {"import": %f, "export": 0}}}`,
+			ts.UTC().Format(time.RFC3339), ts.Sub(start).Hours()))
+	}
+
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			var responseBody string
+			if strings.Contains(req.URL.Path, "/consumption") {
+				require.Equal(t, "day", req.URL.Query().Get("group_by"), "Unexpected group_by")
+				responseBody = fmt.Sprintf(`{"count": %d, "next": null, "results": [%s]}`, len(octopusResults), strings.Join(octopusResults, ","))
+			} else {
+				responseBody = fmt.Sprintf(`{"data": [%s], "meta": {"current_page": 1, "last_page": 1}}`, strings.Join(givData, ","))
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	usage := make(map[time.Time]*UsageRow)
+
+	octopusService := NewOctopusService(mockRoundTripper, "dummyApiKey")
+	octopusService.Granularity = GranularityDay
+	meter := &MeterInfo{SerialNumber: "123456789", Mpan: "987654321"}
+	err := octopusService.GetMeterConsumption(context.Background(), usage, meter, start, end, func(value float64, estimated bool, row *UsageRow) {
+		row.OCTO_ImportKWh = &value
+	})
+	require.NoError(t, err)
+
+	givService := NewGivEnergyService(mockRoundTripper, "dummyBearerToken")
+	givService.Granularity = GranularityDay
+	require.NoError(t, givService.FetchHalfHourlyInverterData(context.Background(), usage, []string{"ABC12345"}, start, end.Add(time.Nanosecond)))
+
+	var days []*UsageRow
+	for timestamp, row := range usage {
+		require.Equal(t, truncateToMidnight(timestamp), timestamp, "Row not at local midnight")
+		if !timestamp.Before(start) {
+			days = append(days, row)
+		}
+	}
+	require.Len(t, days, 3, "Expected one row per day")
+
+	spring := usage[start.AddDate(0, 0, 1)]
+	require.NotNil(t, spring.OCTO_ImportKWh)
+	require.InDelta(t, 12.5, *spring.OCTO_ImportKWh, 0.0001)
+	require.NotNil(t, spring.GE_ImportKWh)
+	require.InDelta(t, 23, *spring.GE_ImportKWh, 0.0001, "Clock change day should be 23 hours of import")
+}
+
+func TestParseGranularity(t *testing.T) {
+	g, err := parseGranularity("")
+	require.NoError(t, err)
+	require.Equal(t, GranularityHalfHour, g)
+
+	g, err = parseGranularity("day")
+	require.NoError(t, err)
+	require.Equal(t, GranularityDay, g)
+
+	_, err = parseGranularity("week")
+	require.Error(t, err)
+}
+
+func TestSlotOffset(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.Local)
+	offset := 5 * time.Minute
+	cases := []struct {
+		at   time.Time
+		want time.Time
+	}{
+		{base.Add(5 * time.Minute), base.Add(5 * time.Minute)},
+		{base.Add(34 * time.Minute), base.Add(5 * time.Minute)},
+		{base.Add(35 * time.Minute), base.Add(35 * time.Minute)},
+		{base.Add(2 * time.Minute), base.Add(-25 * time.Minute)}, // before :05 belongs to the previous slot
+	}
+	for _, c := range cases {
+		require.Equal(t, c.want, GranularityHalfHour.offsetSlot(c.at, offset, nil), "Unexpected slot for %s", c.at.Format(time.Kitchen))
+	}
+	require.Equal(t, base.Add(-55*time.Minute), GranularityHour.offsetSlot(base.Add(2*time.Minute), offset, nil))
+	require.Equal(t, base.Add(-12*time.Hour), GranularityDay.offsetSlot(base, offset, nil), "Expected daily slots to ignore the offset")
+	require.Equal(t, base, GranularityHalfHour.offsetSlot(base.Add(29*time.Minute), 0, nil))
+
+	// Readings landing on the offset boundaries fill one slot each
+	service := &OctopusService{SlotOffset: offset}
+	usage := make(UsageStore)
+	service.PopulateRegisterReadings(usage, []RegisterReading{
+		{ReadAt: base.Add(5 * time.Minute), KWh: 1},
+		{ReadAt: base.Add(20 * time.Minute), KWh: 1.5},
+		{ReadAt: base.Add(35 * time.Minute), KWh: 2},
+	})
+	require.Len(t, usage, 2)
+	require.Equal(t, 1.5, *usage[base.Add(5*time.Minute)].OCTO_ImportRegisterKWh)
+	require.Equal(t, 2.0, *usage[base.Add(35*time.Minute)].OCTO_ImportRegisterKWh)
+
+	_, err := parseSlotOffset(30 * time.Minute)
+	require.Error(t, err)
+	_, err = parseSlotOffset(-time.Minute)
+	require.Error(t, err)
+}
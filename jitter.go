@@ -0,0 +1,25 @@
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// jitterSleep waits out a jitter, replaced in tests.
+var jitterSleep = time.Sleep
+
+// randomJitter returns a random duration from 0 up to, but not including, bound.
+func randomJitter(bound time.Duration) time.Duration {
+	if bound <= 0 {
+		return 0
+	}
+	return rand.N(bound)
+}
+
+// sleepJitter sleeps for a random duration below bound, spreading out the requests of runs
+// scheduled at the same time.
+func sleepJitter(bound time.Duration) {
+	if d := randomJitter(bound); d > 0 {
+		jitterSleep(d)
+	}
+}
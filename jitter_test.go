@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRandomJitter(t *testing.T) {
+	bound := 250 * time.Millisecond
+	for range 1000 {
+		d := randomJitter(bound)
+		require.GreaterOrEqual(t, d, time.Duration(0))
+		require.Less(t, d, bound)
+	}
+	require.Zero(t, randomJitter(0), "Expected no jitter when disabled")
+}
+
+func TestRequestJitter(t *testing.T) {
+	var waits []time.Duration
+	jitterSleep = func(d time.Duration) { waits = append(waits, d) }
+	t.Cleanup(func() { jitterSleep = time.Sleep })
+
+	pages := 0
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			pages++
+			next := "null"
+			if pages < 4 {
+				next = fmt.Sprintf(`"https://api.octopus.energy/v1/products/AGILE/?page=%d"`, pages+1)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`{"next": ` + next + `, "results": []}`)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	octopusService := NewOctopusService(mockRoundTripper, "dummyApiKey")
+	start := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	_, err := octopusService.FetchTariffs(context.Background(), "AGILE", "E-1R-AGILE-C", start, start.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.Empty(t, waits, "Expected no jitter by default")
+
+	octopusService.RequestJitter = 100 * time.Millisecond
+	pages = 0
+	_, err = octopusService.FetchTariffs(context.Background(), "AGILE", "E-1R-AGILE-C", start, start.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(waits), 3, "Expected at most a pause between each of the 4 pages")
+	for _, d := range waits {
+		require.Less(t, d, octopusService.RequestJitter)
+	}
+}
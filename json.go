@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+)
+
+// rowJSON encodes row as a JSON object of the given CSV columns, keyed by column name.
+// Figures are numbers, missing figures null, and the timestamp and labels strings.
+func rowJSON(row *UsageRow, columns []csvColumn) ([]byte, error) {
+	fields := make(map[string]any, len(columns))
+	for _, column := range columns {
+		fields[column.Name] = jsonValue(column.Name, column.Value(row))
+	}
+	return json.Marshal(fields)
+}
+
+// jsonValue converts a CSV field to its JSON value.
+func jsonValue(name, field string) any {
+	switch {
+	case name == "Timestamp" || slices.Contains(textColumns, name):
+		return field
+	case field == "" || field == "NaN":
+		return nil
+	}
+	if value, err := strconv.ParseFloat(field, 64); err == nil {
+		return value
+	}
+	if value, err := strconv.ParseBool(field); err == nil {
+		return value
+	}
+	return field
+}
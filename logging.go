@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// LogFormat selects how log records are written.
+type LogFormat string
+
+const (
+	// LogText writes the plain lines the standard logger does, warnings prefixed "Warning: ".
+	LogText LogFormat = "text"
+	// LogJSON writes a JSON object per record, for log collectors.
+	LogJSON LogFormat = "json"
+)
+
+func parseLogFormat(value string) (LogFormat, error) {
+	switch format := LogFormat(value); format {
+	case LogText, LogJSON:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown log format %q, expected %s or %s", value, LogText, LogJSON)
+}
+
+// setupLogging sends slog, and the standard logger through it at info level, to w in format,
+// dropping records below level.
+func setupLogging(w io.Writer, format LogFormat, level slog.Level) {
+	var handler slog.Handler
+	if format == LogJSON {
+		handler = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	} else {
+		handler = &plainHandler{w: w, level: level, mu: &sync.Mutex{}}
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// plainHandler writes records as the standard logger does, a timestamp and the message,
+// followed by any attributes as key=value.
+type plainHandler struct {
+	w     io.Writer
+	level slog.Level
+	attrs []slog.Attr
+	mu    *sync.Mutex
+}
+
+// levelPrefixes prefix the messages of levels other than info.
+var levelPrefixes = map[slog.Level]string{
+	slog.LevelDebug: "Debug: ",
+	slog.LevelWarn:  "Warning: ",
+	slog.LevelError: "Error: ",
+}
+
+func (h *plainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	var line strings.Builder
+	line.WriteString(r.Time.Format("2006/01/02 15:04:05 "))
+	line.WriteString(levelPrefixes[r.Level])
+	line.WriteString(r.Message)
+	write := func(a slog.Attr) bool {
+		fmt.Fprintf(&line, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		write(a)
+	}
+	r.Attrs(write)
+	line.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, line.String())
+	return err
+}
+
+func (h *plainHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &clone
+}
+
+// WithGroup isn't used, so groups are flattened into the attributes.
+func (h *plainHandler) WithGroup(string) slog.Handler {
+	return h
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// withLogging sets up logging to a buffer for the duration of the test.
+func withLogging(t *testing.T, format LogFormat, level slog.Level) *bytes.Buffer {
+	oldDefault, oldFlags := slog.Default(), log.Flags()
+	t.Cleanup(func() {
+		slog.SetDefault(oldDefault)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(oldFlags)
+	})
+	var out bytes.Buffer
+	setupLogging(&out, format, level)
+	return &out
+}
+
+func TestLoggingText(t *testing.T) {
+	out := withLogging(t, LogText, slog.LevelInfo)
+	log.Printf("Fetched %d GEO records", 3)
+	slog.Warn("failed to save Geo token", "err", "disk full")
+	slog.Debug("No GEO data")
+
+	require.Regexp(t, `^\d{4}/\d\d/\d\d \d\d:\d\d:\d\d Fetched 3 GEO records\n`+
+		`\d{4}/\d\d/\d\d \d\d:\d\d:\d\d Warning: failed to save Geo token err=disk full\n$`, out.String(),
+		"Expected the standard logger's lines, without the debug record")
+}
+
+func TestLoggingJSON(t *testing.T) {
+	out := withLogging(t, LogJSON, slog.LevelWarn)
+	log.Printf("Fetched %d GEO records", 3)
+	slog.Warn("failed to save Geo token")
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &record), "Expected only the warning, as one JSON object")
+	require.Equal(t, "WARN", record["level"])
+	require.Equal(t, "failed to save Geo token", record["msg"])
+}
@@ -1,12 +1,34 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+// envErrors collects invalid environment variable values for parseFlags to report.
+var envErrors []error
+
 // envOrString returns the environment variable value if set, otherwise returns the default value.
 func envOrString(key, def string) string {
 	if v, ok := os.LookupEnv(key); ok {
@@ -15,62 +37,721 @@ func envOrString(key, def string) string {
 	return def
 }
 
-func parseFlags() *Config {
+// envOrBool returns the environment variable parsed as a bool if set, otherwise returns the default value.
+func envOrBool(key string, def bool) bool {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		envErrors = append(envErrors, fmt.Errorf("invalid %s: %w", key, err))
+		return def
+	}
+	return b
+}
+
+// envOrDuration returns the environment variable parsed as a duration if set, otherwise returns the default value.
+func envOrDuration(key string, def time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		envErrors = append(envErrors, fmt.Errorf("invalid %s: %w", key, err))
+		return def
+	}
+	return d
+}
+
+// envOrFloat returns the environment variable parsed as a float if set, otherwise returns the default value.
+func envOrFloat(key string, def float64) float64 {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		envErrors = append(envErrors, fmt.Errorf("invalid %s: %w", key, err))
+		return def
+	}
+	return f
+}
+
+// envOrInt returns the environment variable parsed as an int if set, otherwise returns the default value.
+func envOrInt(key string, def int) int {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		envErrors = append(envErrors, fmt.Errorf("invalid %s: %w", key, err))
+		return def
+	}
+	return i
+}
+
+func parseFlags() (*Config, error) {
 	apiKey := flag.String("apikey", envOrString("OCTOPUS_API_KEY", ""), "Octopus API key")
 	givAPIKey := flag.String("givApikey", envOrString("GIVENERGY_API_KEY", ""), "GivEnergy API key")
 	accountID := flag.String("accountID", envOrString("OCTOPUS_ACCOUNT_ID", ""), "Octopus Account ID")
-	serial := flag.String("inverterSerial", envOrString("GIVENERGY_SERIAL", ""), "GivEnergy inverter serial number")
-	outCSV := flag.String("out", envOrString("OUTPUT_CSV", "output.csv"), "Output CSV file")
+	serial := flag.String("inverterSerial", envOrString("GIVENERGY_SERIAL", ""), "GivEnergy inverter serial number, or a comma separated list of them to sum")
+	outCSV := flag.String("out", envOrString("OUTPUT_CSV", "output.csv"), "Output CSV file, - for standard output")
 	cacheDir := flag.String("cache", envOrString("CACHE_DIR", "disable"), "Directory for HTTP cache ('disable' to disable, empty for temporary directory)")
 	startDateTime := flag.String("startDateTime", envOrString("START", ""), "Start date time for data fetching (optional, RFC3339 format)")
 	endDateTime := flag.String("endDateTime", envOrString("END", ""), "End date time for data fetching (optional, RFC3339 format)")
 	geoUsername := flag.String("geoUser", envOrString("GEO_USER", ""), "Geo Username")
 	geoPassword := flag.String("geoPassword", envOrString("GEO_PASSWORD", ""), "Geo Password")
+	standingCharge := flag.Float64("standingCharge", envOrFloat("OCTOPUS_STANDING_CHARGE", 0), "Import standing charge in pence per day, used for the effective import rate (default fetched from the import tariff)")
+	useGivMeterRegister := flag.Bool("givMeterRegister", envOrBool("GIVENERGY_METER_REGISTER", false), "Use the GivEnergy meter register for grid export instead of interpolated data points")
+	trimToRange := flag.Bool("trimToRange", envOrBool("TRIM_TO_RANGE", false), "Drop rows outside the requested start/end range")
+	importPriority := flag.String("importPriority", envOrString("IMPORT_PRIORITY", strings.Join(DefaultImportPriority, ",")), "Comma separated order of sources used for the priced import figure")
+	importSource := flag.Bool("importSource", envOrBool("IMPORT_SOURCE_COLUMN", false), "Add an Import_Source column recording which source was used for pricing")
+	costSource := flag.String("costSource", envOrString("COST_SOURCE", ""), "Source (octopus, geo or givenergy) driving the Import_Cost/Export_Cost columns, empty to omit them")
+	priceCaps := flag.String("priceCaps", envOrString("PRICE_CAPS", ""), "Comma separated price caps as from:to:unitRate:standingCharge (dates YYYY-MM-DD, to exclusive)")
+	reconcileTolerance := flag.Float64("reconcileTolerance", envOrFloat("RECONCILE_TOLERANCE", 0), "kWh difference between GivEnergy and Octopus import below which slots are treated as matching")
+	givFreshness := flag.Duration("givFreshness", envOrDuration("GIVENERGY_FRESHNESS", 2*time.Hour), "Maximum age of the latest GivEnergy data point before interpolation stops at it (0 to disable)")
+	excVat := flag.Bool("excVat", envOrBool("EXC_VAT_COLUMNS", false), "Add ex-VAT price and cost columns alongside the inc-VAT ones")
+	failOnGaps := flag.Bool("failOnGaps", envOrBool("FAIL_ON_GAPS", false), "Exit with code 4 when the output has slots with no Octopus import data")
+	mergeExisting := flag.String("merge-existing", envOrString("MERGE_EXISTING", ""), "Existing CSV to merge this run into, overwriting overlapping timestamps")
+	includeLive := flag.Bool("includeLive", envOrBool("INCLUDE_LIVE", false), "Append a row tagged live with the current Geo live power")
+	excludeEstimates := flag.Bool("excludeEstimates", envOrBool("EXCLUDE_ESTIMATES", false), "Skip Octopus readings flagged as estimated, leaving those slots as gaps")
+	costsCSV := flag.String("costs-csv", envOrString("COSTS_CSV", ""), "Also write a CSV with only the timestamp, price and cost columns")
+	checkpointFile := flag.String("checkpoint", envOrString("CHECKPOINT_FILE", ""), "File recording fetch progress so a failed run resumes where it stopped")
+	alignment := flag.Bool("alignment", envOrBool("ALIGNMENT_DIAGNOSTICS", false), "Report the slot lag that best aligns Geo and GivEnergy import with Octopus")
+	onlyGaps := flag.Bool("only-gaps", envOrBool("ONLY_GAPS", false), "Write only rows missing at least one expected source value")
+	precision := flag.String("precision", envOrString("PRECISION", ""), "Digits after the decimal point for all columns, or per group as energy=6,price=4,cumulative=4,cost=2,carbon=2,percent=2 (the defaults)")
+	floatFormat := flag.String("floatFormat", envOrString("FLOAT_FORMAT", ""), "Float format f, e or g for all columns, or per group as energy=g,price=f,cumulative=f (default f)")
+	geoAccounts := flag.String("geoAccounts", envOrString("GEO_ACCOUNTS", ""), "Additional Geo logins as comma separated label:username:password[:systemID]")
+	clampToAvailable := flag.Bool("clampToAvailable", envOrBool("CLAMP_TO_AVAILABLE", false), "Narrow the start and end to the Octopus readings available")
+	standingChargePlacement := flag.String("standingChargePlacement", envOrString("STANDING_CHARGE_PLACEMENT", ""), "Standing charge in the CSV: spread, first-slot or daily-row (default summary only)")
+	validateTotals := flag.String("validate-totals", envOrString("VALIDATE_TOTALS", ""), "Billed import kWh to check the summed Octopus import against (optional)")
+	validateTotalsTolerance := flag.Float64("validate-totals-tolerance", envOrFloat("VALIDATE_TOTALS_TOLERANCE", 0.5), "kWh difference from -validate-totals treated as matching")
+	proxy := flag.String("proxy", envOrString("PROXY", ""), "HTTP proxy URL for all requests, overriding HTTP_PROXY/HTTPS_PROXY")
+	registerReads := flag.Bool("registerReads", envOrBool("OCTOPUS_REGISTER_READS", false), "Add the cumulative Octopus import register reading per slot, where the meter exposes it")
+	forceRefresh := flag.Bool("force-refresh", envOrBool("FORCE_REFRESH", false), "Ignore the HTTP cache for this run, replacing cached responses with fresh ones")
+	fillExport := flag.Bool("fillExportFromGivEnergy", envOrBool("FILL_EXPORT_FROM_GIVENERGY", false), "Fill Octopus export gaps with GivEnergy export, flagged as estimated")
+	userAgent := flag.String("userAgent", envOrString("USER_AGENT", ""), "User-Agent sent on every request (default givenergy-octopus-gaps/<version>)")
+	tariffNames := flag.Bool("tariffNames", envOrBool("TARIFF_NAMES", false), "Add Import_Tariff and Export_Tariff columns with the friendly tariff names")
+	splitImportExport := flag.Bool("splitImportExport", envOrBool("SPLIT_IMPORT_EXPORT", false), "Write import and export columns to separate <output>.import.csv and <output>.export.csv files")
+	cacheReadDirs := flag.String("cacheReadDirs", envOrString("CACHE_READ_DIRS", ""), "Comma separated read-only cache directories checked after -cache, which alone is written to")
+	timestampFormat := flag.String("timestampFormat", envOrString("TIMESTAMP_FORMAT", string(TimestampRFC3339)), "Timestamp column format: rfc3339, epoch or epoch_ms")
+	costReconcileTolerance := flag.Float64("costReconcileTolerance", envOrFloat("COST_RECONCILE_TOLERANCE", 1), "Daily pence difference between GivEnergy and Octopus import costs treated as matching")
+	compareRegions := flag.String("compare-regions", envOrString("COMPARE_REGIONS", ""), "Comma separated region letters to price the import at, using the import product's rates for each region")
+	geoTokenTTL := flag.Duration("geoTokenTTL", envOrDuration("GEO_TOKEN_TTL", 15*time.Minute), "How long a Geo login is cached on disk and reused for (0 to disable)")
+	roundingMode := flag.String("roundingMode", envOrString("ROUNDING_MODE", string(RoundHalfUp)), "Cost rounding: half-up, half-even or truncate")
+	validateOutput := flag.Bool("validateOutput", envOrBool("VALIDATE_OUTPUT", false), "Read each CSV back once written and fail if its header or field counts are wrong")
+	printConfig := flag.Bool("print-config", false, "Print the resolved configuration, with secrets redacted, and exit")
+	carbonIntensity := flag.Bool("carbonIntensity", envOrBool("CARBON_INTENSITY", false), "Add the region's half-hourly carbon intensity and import emissions from the National Grid")
+	batteryArbitrage := flag.Bool("batteryArbitrage", envOrBool("BATTERY_ARBITRAGE", false), "Log each day's GivEnergy battery charging cost against the import it displaced when discharging")
+	slotOffset := flag.Duration("slotOffset", envOrDuration("SLOT_OFFSET", 0), "Offset of the meter's slot boundaries past the hour and half-hour, e.g. 5m for :05 and :35")
+	trimToCompleteDay := flag.Bool("trimToCompleteDay", envOrBool("TRIM_TO_COMPLETE_DAY", false), "Drop rows after the latest day Octopus, GEO and GivEnergy have all fully reported")
+	alignStartToMidnight := flag.Bool("start-align-to-midnight", envOrBool("START_ALIGN_TO_MIDNIGHT", false), "Move an explicit start back to the first slot of its day, midnight plus any slotOffset, as a derived start always is")
+	manualTariffs := flag.String("manualTariffs", envOrString("MANUAL_TARIFFS", ""), "JSON file of daily import and export rate windows to price against instead of the Octopus tariffs")
+	flushWindow := flag.String("flushWindow", envOrString("FLUSH_WINDOW", ""), "Fetch and write the output a day or month at a time to bound memory on long ranges: day or month")
+	startupJitter := flag.Duration("startupJitter", envOrDuration("STARTUP_JITTER", 0), "Wait a random time up to this before the first request, to spread out scheduled runs")
+	requestJitter := flag.Duration("requestJitter", envOrDuration("REQUEST_JITTER", 0), "Pause a random time up to this between paged Octopus and GivEnergy requests")
+	energyBalance := flag.Bool("energyBalance", envOrBool("ENERGY_BALANCE", false), "Add the residual of the GivEnergy solar, grid, battery and house load balance, which should be near zero")
+	importDelta := flag.Bool("importDelta", envOrBool("IMPORT_DELTA", false), "Add the Octopus import less the GivEnergy import of each slot, in kWh and percent, to spot CT clamp drift")
+	extraHeaders := flag.String("extraHeaders", envOrString("EXTRA_HEADERS", ""), "Comma separated Name:value headers to add to every request, e.g. for an API gateway")
+	fillMode := flag.String("fillMode", envOrString("FILL_MODE", string(FillSparse)), "How gaps in each source's usage are output: sparse, interpolate or forward-fill")
+	alignedOctopusFetch := flag.Bool("alignedOctopusFetch", envOrBool("ALIGNED_OCTOPUS_FETCH", false), "Fetch the Octopus consumption and tariffs together two weeks at a time instead of in separate passes")
+	validateSerial := flag.Bool("validateSerial", envOrBool("VALIDATE_SERIAL", true), "Check at startup that the inverter serial belongs to the GivEnergy account")
+	settlementLag := flag.Duration("settlementLag", envOrDuration("SETTLEMENT_LAG", 0), "Pull the default end of now back by this, e.g. 48h, to leave out recent days Octopus hasn't settled")
+	format := flag.String("format", envOrString("FORMAT", string(FormatWide)), "Output layout: wide, a column per figure, or tidy, a timestamp,metric,value,source row per figure")
+	geoAPIVersion := flag.String("geoAPIVersion", envOrString("GEO_API_VERSION", string(GeoAPIAuto)), "Geo readings and systems endpoint version: v1, v3 or auto to switch to v3 when v1 isn't found")
+	backfillMissingOnly := flag.Bool("backfill-missing-only", envOrBool("BACKFILL_MISSING_ONLY", false), "Fetch each source only over the gaps in the existing output CSV and write it back filled in")
+	tariffHistory := flag.String("tariffHistory", envOrString("TARIFF_HISTORY", ""), "CSV file to write each distinct import, export and standing charge rate window to")
+	accountConcurrency := flag.Int("accountConcurrency", envOrInt("ACCOUNT_CONCURRENCY", 4), "How many geoAccounts to fetch at once")
+	accountsContinueOnError := flag.Bool("accountsContinueOnError", envOrBool("ACCOUNTS_CONTINUE_ON_ERROR", true), "Write the geoAccounts that succeeded when others fail, rather than failing before writing")
+	explain := flag.String("explain", envOrString("EXPLAIN", ""), "Print how the slot at this RFC3339 time is priced, then exit")
+	retryProfile := flag.String("retryProfile", envOrString("RETRY_PROFILE", "default"), "Preset of retries, retryBackoff, requestTimeout, httpTimeout, accountConcurrency and givConcurrency: conservative, default or aggressive")
+	retries := flag.Int("retries", envOrInt("RETRIES", defaultRetries), "How many times a GET answered by 429 or a server error is retried, overriding retryProfile")
+	retryBackoff := flag.Duration("retryBackoff", envOrDuration("RETRY_BACKOFF", defaultRetryBackoff), "Wait before the first retry, doubling for each further retry, unless the response gives a Retry-After, overriding retryProfile")
+	requestTimeout := flag.Duration("requestTimeout", envOrDuration("REQUEST_TIMEOUT", 0), "How long to wait for each response's headers, 0 to wait indefinitely, overriding retryProfile; only has an effect below httpTimeout")
+	sqlitePath := flag.String("sqlite", envOrString("SQLITE", ""), "SQLite database to upsert the rows into, keyed on timestamp; set -out empty to write only the database")
+	cacheTTL := flag.Duration("cacheTTL", envOrDuration("CACHE_TTL", 0), "Refetch cached responses older than this, e.g. 24h, 0 to keep them forever")
+	givConcurrency := flag.Int("givConcurrency", envOrInt("GIV_CONCURRENCY", 4), "How many days of GivEnergy inverter data to fetch at once, overriding retryProfile")
+	gasUnits := flag.String("gasUnits", envOrString("GAS_UNITS", GasUnitsM3), "Unit the Octopus gas meter reports in: m3 (SMETS2) or kwh (SMETS1)")
+	gasCalorificValue := flag.Float64("gasCalorificValue", envOrFloat("GAS_CALORIFIC_VALUE", 39.5), "Calorific value in MJ/m³ gas volumes are converted to kWh at")
+	interval := flag.Duration("interval", envOrDuration("INTERVAL", 0), "Keep running, collecting new slots this often, e.g. 30m, and merging them into the output")
+	appendCSV := flag.Bool("append", envOrBool("APPEND", false), "Append the rows after the last timestamp already in the output rather than replacing it")
+	metricsAddr := flag.String("metrics", envOrString("METRICS", ""), "Serve Prometheus metrics of the latest slot on this address, e.g. :9090")
+	mqttBroker := flag.String("mqttBroker", envOrString("MQTT_BROKER", ""), "MQTT broker to publish rows to as retained JSON messages, e.g. tcp://localhost:1883")
+	mqttTopic := flag.String("mqttTopic", envOrString("MQTT_TOPIC", "givenergy-octopus-gaps"), "MQTT topic rows are published under, as <topic>/<timestamp> and <topic>/latest")
+	logFormat := flag.String("logFormat", envOrString("LOG_FORMAT", string(LogText)), "Log format: text or json")
+	logLevel := flag.String("logLevel", envOrString("LOG_LEVEL", "info"), "Lowest level logged: debug, info, warn or error")
+	httpTimeout := flag.Duration("httpTimeout", envOrDuration("HTTP_TIMEOUT", 30*time.Second), "How long each attempt, including reading its response, may take, 0 to wait indefinitely, overriding retryProfile")
+	tz := flag.String("tz", envOrString("OUTPUT_TZ", ""), "IANA time zone days are bucketed in and timestamps written in, e.g. Europe/London or UTC (default the machine's)")
+	geoExport := flag.Bool("geoExport", envOrBool("GEO_EXPORT", false), "Add the Geo export and generation columns, for Geo meters that report them")
+	granularity := flag.String("granularity", envOrString("GRANULARITY", string(GranularityHalfHour)), "Row granularity: half_hour, hour or day")
 	flag.Parse()
 
+	if len(envErrors) > 0 {
+		return nil, fmt.Errorf("%w: %w", ErrConfig, errors.Join(envErrors...))
+	}
+
 	if *apiKey == "" || *accountID == "" || *serial == "" || *givAPIKey == "" || *geoUsername == "" || *geoPassword == "" {
-		log.Fatalf("Required flags missing. Usage: %s -apikey=... -givApikey=... -accountID=... -inverterSerial=... -geoUser=... -geoPassword=...", os.Args[0])
+		return nil, fmt.Errorf("%w: required flags missing. Usage: %s -apikey=... -givApikey=... -accountID=... -inverterSerial=... -geoUser=... -geoPassword=...", ErrConfig, os.Args[0])
+	}
+
+	// The zone everything is bucketed and written in, in place of the machine's, and the one the
+	// flags below are read in
+	var location *time.Location
+	if *tz != "" {
+		var err error
+		location, err = time.LoadLocation(*tz)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid tz: %w", ErrConfig, err)
+		}
+	}
+
+	parsedImportPriority, err := parseSourcePriority(*importPriority)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid importPriority: %w", ErrConfig, err)
+	}
+
+	var parsedCostSource string
+	if *costSource != "" {
+		sources, err := parseSourcePriority(*costSource)
+		if err != nil || len(sources) != 1 {
+			return nil, fmt.Errorf("%w: invalid costSource: %q", ErrConfig, *costSource)
+		}
+		parsedCostSource = sources[0]
+	}
+
+	parsedPriceCaps, err := parsePriceCaps(*priceCaps, location)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid priceCaps: %w", ErrConfig, err)
+	}
+
+	parsedGranularity, err := parseGranularity(*granularity)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid granularity: %w", ErrConfig, err)
+	}
+
+	parsedExtraHeaders, err := parseHeaders(*extraHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid extraHeaders: %w", ErrConfig, err)
+	}
+
+	if *outCSV == "" && *sqlitePath == "" {
+		return nil, fmt.Errorf("%w: out can only be empty when writing to sqlite", ErrConfig)
+	}
+	if *sqlitePath != "" {
+		for flag, set := range map[string]bool{
+			"geoAccounts":           *geoAccounts != "",
+			"flushWindow":           *flushWindow != "",
+			"backfill-missing-only": *backfillMissingOnly,
+		} {
+			if set {
+				return nil, fmt.Errorf("%w: sqlite can't be combined with %s", ErrConfig, flag)
+			}
+		}
+	}
+
+	profile, err := parseRetryProfile(*retryProfile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid retryProfile: %w", ErrConfig, err)
+	}
+	// The profile fills in the settings not given by flag or environment variable
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	explicit := func(name, env string) bool {
+		_, ok := os.LookupEnv(env)
+		return set[name] || ok
+	}
+	if !explicit("retries", "RETRIES") {
+		*retries = profile.Retries
+	}
+	if !explicit("retryBackoff", "RETRY_BACKOFF") {
+		*retryBackoff = profile.RetryBackoff
+	}
+	if !explicit("requestTimeout", "REQUEST_TIMEOUT") {
+		*requestTimeout = profile.RequestTimeout
+	}
+	if !explicit("httpTimeout", "HTTP_TIMEOUT") {
+		*httpTimeout = profile.HTTPTimeout
+	}
+	if !explicit("accountConcurrency", "ACCOUNT_CONCURRENCY") {
+		*accountConcurrency = profile.AccountConcurrency
+	}
+	if !explicit("givConcurrency", "GIV_CONCURRENCY") {
+		*givConcurrency = profile.GivConcurrency
+	}
+	if *cacheTTL < 0 {
+		return nil, fmt.Errorf("%w: invalid cacheTTL: %s is negative", ErrConfig, *cacheTTL)
+	}
+	if *retries < 0 {
+		return nil, fmt.Errorf("%w: invalid retries: %d is negative", ErrConfig, *retries)
+	}
+	if *retryBackoff < 0 {
+		return nil, fmt.Errorf("%w: invalid retryBackoff: %s is negative", ErrConfig, *retryBackoff)
+	}
+	if *httpTimeout < 0 {
+		return nil, fmt.Errorf("%w: invalid httpTimeout: %s is negative", ErrConfig, *httpTimeout)
+	}
+	if *requestTimeout < 0 {
+		return nil, fmt.Errorf("%w: invalid requestTimeout: %s is negative", ErrConfig, *requestTimeout)
+	}
+
+	if *gasUnits != GasUnitsM3 && *gasUnits != GasUnitsKWh {
+		return nil, fmt.Errorf("%w: invalid gasUnits: %q is not m3 or kwh", ErrConfig, *gasUnits)
+	}
+	if *gasCalorificValue <= 0 {
+		return nil, fmt.Errorf("%w: invalid gasCalorificValue: %v is not positive", ErrConfig, *gasCalorificValue)
+	}
+	if *givConcurrency < 1 {
+		return nil, fmt.Errorf("%w: invalid givConcurrency: %d is less than 1", ErrConfig, *givConcurrency)
+	}
+	if *accountConcurrency < 1 {
+		return nil, fmt.Errorf("%w: invalid accountConcurrency: %d is less than 1", ErrConfig, *accountConcurrency)
+	}
+
+	parsedGeoAPIVersion, err := parseGeoAPIVersion(*geoAPIVersion)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid geoAPIVersion: %w", ErrConfig, err)
+	}
+
+	parsedFormat, err := parseOutputFormat(*format)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid format: %w", ErrConfig, err)
+	}
+	if parsedFormat == FormatTidy && *mergeExisting != "" {
+		return nil, fmt.Errorf("%w: tidy format can't be combined with merge-existing", ErrConfig)
+	}
+
+	parsedFillMode, err := parseFillMode(*fillMode)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid fillMode: %w", ErrConfig, err)
+	}
+	if parsedFillMode != FillSparse && *onlyGaps {
+		return nil, fmt.Errorf("%w: fillMode %s can't be combined with only-gaps", ErrConfig, parsedFillMode)
+	}
+
+	if *alignedOctopusFetch && *checkpointFile != "" {
+		return nil, fmt.Errorf("%w: alignedOctopusFetch can't be combined with checkpoint", ErrConfig)
+	}
+
+	if *backfillMissingOnly {
+		for flag, set := range map[string]bool{
+			"merge-existing":    *mergeExisting != "",
+			"onlyGaps":          *onlyGaps,
+			"splitImportExport": *splitImportExport,
+			"checkpoint":        *checkpointFile != "",
+			"geoAccounts":       *geoAccounts != "",
+			"flushWindow":       *flushWindow != "",
+			"tidy format":       parsedFormat == FormatTidy,
+		} {
+			if set {
+				return nil, fmt.Errorf("%w: backfill-missing-only can't be combined with %s", ErrConfig, flag)
+			}
+		}
+	}
+
+	parsedFlushWindow, err := parseFlushWindow(*flushWindow)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid flushWindow: %w", ErrConfig, err)
+	}
+	if parsedFlushWindow != FlushNone {
+		for flag, set := range map[string]bool{
+			"merge-existing":      *mergeExisting != "",
+			"onlyGaps":            *onlyGaps,
+			"splitImportExport":   *splitImportExport,
+			"costs-csv":           *costsCSV != "",
+			"checkpoint":          *checkpointFile != "",
+			"geoAccounts":         *geoAccounts != "",
+			"includeLive":         *includeLive,
+			"fillMode":            parsedFillMode != FillSparse,
+			"alignedOctopusFetch": *alignedOctopusFetch,
+		} {
+			if set {
+				return nil, fmt.Errorf("%w: flushWindow can't be combined with %s", ErrConfig, flag)
+			}
+		}
+	}
+
+	if *interval < 0 {
+		return nil, fmt.Errorf("%w: invalid interval: %s is negative", ErrConfig, *interval)
+	}
+	if *interval > 0 {
+		for flag, set := range map[string]bool{
+			"endDateTime":           *endDateTime != "",
+			"explain":               *explain != "",
+			"standard output":       *outCSV == "-",
+			"backfill-missing-only": *backfillMissingOnly,
+			"flushWindow":           *flushWindow != "",
+			"onlyGaps":              *onlyGaps,
+			"splitImportExport":     *splitImportExport,
+			"tidy format":           parsedFormat == FormatTidy,
+		} {
+			if set {
+				return nil, fmt.Errorf("%w: interval can't be combined with %s", ErrConfig, flag)
+			}
+		}
+	}
+
+	if *appendCSV {
+		for flag, set := range map[string]bool{
+			"standard output":       *outCSV == "-",
+			"merge-existing":        *mergeExisting != "",
+			"backfill-missing-only": *backfillMissingOnly,
+			"flushWindow":           *flushWindow != "",
+			"onlyGaps":              *onlyGaps,
+			"splitImportExport":     *splitImportExport,
+			"includeLive":           *includeLive,
+			"tidy format":           parsedFormat == FormatTidy,
+		} {
+			if set {
+				return nil, fmt.Errorf("%w: append can't be combined with %s", ErrConfig, flag)
+			}
+		}
+	}
+
+	parsedLogFormat, err := parseLogFormat(*logFormat)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid logFormat: %w", ErrConfig, err)
+	}
+	var parsedLogLevel slog.Level
+	if err := parsedLogLevel.UnmarshalText([]byte(*logLevel)); err != nil {
+		return nil, fmt.Errorf("%w: invalid logLevel: %w", ErrConfig, err)
+	}
+	// Before anything below logs
+	setupLogging(os.Stderr, parsedLogFormat, parsedLogLevel)
+
+	parsedSlotOffset, err := parseSlotOffset(*slotOffset)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid slotOffset: %w", ErrConfig, err)
+	}
+
+	parsedFloatFormat, err := parseFloatFormat(*floatFormat)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid floatFormat: %w", ErrConfig, err)
+	}
+
+	parsedPrecision, err := parsePrecision(*precision)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid precision: %w", ErrConfig, err)
+	}
+
+	parsedGeoAccounts, err := parseGeoAccounts(*geoAccounts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid geoAccounts: %w", ErrConfig, err)
+	}
+
+	parsedPlacement, err := parseStandingChargePlacement(*standingChargePlacement)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid standingChargePlacement: %w", ErrConfig, err)
+	}
+
+	var parsedExpectedImport *float64
+	if *validateTotals != "" {
+		expected, err := strconv.ParseFloat(*validateTotals, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid validate-totals: %w", ErrConfig, err)
+		}
+		parsedExpectedImport = &expected
+	}
+
+	var parsedProxy *url.URL
+	if *proxy != "" {
+		parsedProxy, err = url.Parse(*proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid proxy: %w", ErrConfig, err)
+		}
+		if parsedProxy.Scheme == "" || parsedProxy.Host == "" {
+			return nil, fmt.Errorf("%w: invalid proxy: %q is not an absolute URL", ErrConfig, *proxy)
+		}
+	}
+
+	var parsedSerials []string
+	for _, s := range strings.Split(*serial, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			parsedSerials = append(parsedSerials, s)
+		}
+	}
+	if len(parsedSerials) == 0 {
+		return nil, fmt.Errorf("%w: invalid inverterSerial: no serials in %q", ErrConfig, *serial)
+	}
+
+	var parsedCacheReadDirs []string
+	for _, dir := range strings.Split(*cacheReadDirs, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			parsedCacheReadDirs = append(parsedCacheReadDirs, path.Clean(dir))
+		}
+	}
+
+	parsedTimestampFormat, err := parseTimestampFormat(*timestampFormat)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid timestampFormat: %w", ErrConfig, err)
+	}
+
+	parsedCompareRegions, err := parseRegions(*compareRegions)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid compare-regions: %w", ErrConfig, err)
+	}
+
+	parsedRoundingMode, err := parseRoundingMode(*roundingMode)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid roundingMode: %w", ErrConfig, err)
 	}
 
 	var parsedStartTime *time.Time
 	if *startDateTime != "" {
 		parsedTime, err := time.Parse(time.RFC3339, *startDateTime)
 		if err != nil {
-			log.Fatalf("Invalid startTime format: %v", err)
+			return nil, fmt.Errorf("%w: invalid startTime format: %w", ErrConfig, err)
 		}
 		parsedStartTime = &parsedTime
 	}
 
+	var parsedExplainTime *time.Time
+	if *explain != "" {
+		parsedTime, err := time.Parse(time.RFC3339, *explain)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid explain time: %w", ErrConfig, err)
+		}
+		parsedExplainTime = &parsedTime
+	}
+
 	var parsedEndTime time.Time
 	if *endDateTime != "" {
 		parsedTime, err := time.Parse(time.RFC3339, *endDateTime)
 		if err != nil {
-			log.Fatalf("Invalid endTime format: %v", err)
+			return nil, fmt.Errorf("%w: invalid endTime format: %w", ErrConfig, err)
 		}
 		parsedEndTime = parsedTime
 	} else {
-		parsedEndTime = time.Now()
+		if *settlementLag < 0 {
+			return nil, fmt.Errorf("%w: invalid settlementLag: %s is negative", ErrConfig, *settlementLag)
+		}
+		parsedEndTime = time.Now().Add(-*settlementLag)
+		if *settlementLag > 0 {
+			slog.Info("Ending before now to leave out unsettled data", "end", parsedEndTime.Format(time.RFC3339), "settlementLag", *settlementLag)
+		}
 	}
 
-	return &Config{
+	config := &Config{
 		APIKey:         *apiKey,
 		GivAPIKey:      *givAPIKey,
 		AccountID:      *accountID,
-		SerialNumber:   *serial,
+		SerialNumbers:  parsedSerials,
 		OutputCSV:      *outCSV,
 		CacheDirectory: *cacheDir,
 		StartTime:      parsedStartTime,
 		EndTime:        parsedEndTime,
 		GeoUsername:    *geoUsername,
 		GeoPassword:    *geoPassword,
+
+		ImportStandingCharge: *standingCharge,
+		UseGivMeterRegister:  *useGivMeterRegister,
+		GivFreshness:         *givFreshness,
+		TrimToRange:          *trimToRange,
+		ImportPriority:       parsedImportPriority,
+		IncludeImportSource:  *importSource,
+		CostSource:           parsedCostSource,
+		PriceCaps:            parsedPriceCaps,
+		IncludeExcVat:        *excVat,
+		ReconcileTolerance:   *reconcileTolerance,
+		Granularity:          parsedGranularity,
+		FailOnGaps:           *failOnGaps,
+		MergeExisting:        *mergeExisting,
+		IncludeLive:          *includeLive,
+		ExcludeEstimates:     *excludeEstimates,
+		CostsCSV:             *costsCSV,
+		CheckpointFile:       *checkpointFile,
+		AlignmentDiagnostics: *alignment,
+		OnlyGaps:             *onlyGaps,
+		FloatFormat:          parsedFloatFormat,
+		Precision:            parsedPrecision,
+		GeoAccounts:          parsedGeoAccounts,
+		ClampToAvailable:     *clampToAvailable,
+
+		StandingChargePlacement: parsedPlacement,
+		ExpectedImportKWh:       parsedExpectedImport,
+		ExpectedImportTolerance: *validateTotalsTolerance,
+		Proxy:                   parsedProxy,
+		RegisterReads:           *registerReads,
+		ForceRefresh:            *forceRefresh,
+		FillExportFromGivEnergy: *fillExport,
+		UserAgent:               *userAgent,
+		TariffNames:             *tariffNames,
+		SplitImportExport:       *splitImportExport,
+		CacheReadDirs:           parsedCacheReadDirs,
+		TimestampFormat:         parsedTimestampFormat,
+		CostReconcileTolerance:  *costReconcileTolerance,
+		CompareRegions:          parsedCompareRegions,
+		GeoTokenTTL:             *geoTokenTTL,
+		RoundingMode:            parsedRoundingMode,
+		ValidateOutput:          *validateOutput,
+		PrintConfig:             *printConfig,
+		CarbonIntensity:         *carbonIntensity,
+		BatteryArbitrage:        *batteryArbitrage,
+		SlotOffset:              parsedSlotOffset,
+		TrimToCompleteDay:       *trimToCompleteDay,
+		AlignStartToMidnight:    *alignStartToMidnight,
+		ManualTariffFile:        *manualTariffs,
+		FlushWindow:             parsedFlushWindow,
+		StartupJitter:           *startupJitter,
+		RequestJitter:           *requestJitter,
+		EnergyBalance:           *energyBalance,
+		ImportDelta:             *importDelta,
+		ExtraHeaders:            parsedExtraHeaders,
+		FillMode:                parsedFillMode,
+		AlignedOctopusFetch:     *alignedOctopusFetch,
+		ValidateSerial:          *validateSerial,
+		SettlementLag:           *settlementLag,
+		OutputFormat:            parsedFormat,
+		GeoAPIVersion:           parsedGeoAPIVersion,
+		BackfillMissingOnly:     *backfillMissingOnly,
+		TariffHistoryFile:       *tariffHistory,
+		AccountConcurrency:      *accountConcurrency,
+		AccountsContinueOnError: *accountsContinueOnError,
+		ExplainTime:             parsedExplainTime,
+		RetryProfile:            *retryProfile,
+		Retries:                 *retries,
+		RetryBackoff:            *retryBackoff,
+		RequestTimeout:          *requestTimeout,
+		HTTPTimeout:             *httpTimeout,
+		Location:                location,
+		GeoExport:               *geoExport,
+		SQLitePath:              *sqlitePath,
+		CacheTTL:                *cacheTTL,
+		GivConcurrency:          *givConcurrency,
+		Interval:                *interval,
+		Append:                  *appendCSV,
+		MetricsAddr:             *metricsAddr,
+		MQTTBroker:              *mqttBroker,
+		MQTTTopic:               *mqttTopic,
+		LogFormat:               parsedLogFormat,
+		LogLevel:                parsedLogLevel,
+		GasUnits:                *gasUnits,
+		GasCalorificValue:       *gasCalorificValue,
+	}
+	return config, nil
+}
+
+// secretFields are the Config fields redacted by writeConfig.
+var secretFields = []string{"APIKey", "GivAPIKey", "GeoPassword"}
+
+// redact hides all but the last 4 characters of a secret.
+func redact(secret string) string {
+	if len(secret) <= 4 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", len(secret)-4) + secret[len(secret)-4:]
+}
+
+// writeConfig writes each Config field and its resolved value, one per line, with secrets redacted.
+func writeConfig(w io.Writer, config *Config) error {
+	redacted := *config
+	redacted.GeoAccounts = nil
+	for _, account := range config.GeoAccounts {
+		account.Password = redact(account.Password)
+		redacted.GeoAccounts = append(redacted.GeoAccounts, account)
+	}
+
+	value := reflect.ValueOf(redacted)
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		field := value.Field(i)
+		for field.Kind() == reflect.Pointer && !field.IsNil() {
+			field = field.Elem()
+		}
+
+		var s string
+		switch {
+		case slices.Contains(secretFields, name):
+			s = redact(field.String())
+		case field.Kind() == reflect.Pointer:
+			s = "<unset>"
+		case name == "Proxy":
+			u := field.Interface().(url.URL)
+			s = u.Redacted()
+		case name == "Location":
+			s = config.Location.String()
+		case name == "MQTTBroker":
+			if u, err := url.Parse(field.String()); err == nil {
+				s = u.Redacted()
+			}
+		case name == "ExtraHeaders":
+			// Gateway headers usually carry keys
+			var headers []string
+			for header, headerValue := range config.ExtraHeaders {
+				headers = append(headers, header+":"+redact(headerValue))
+			}
+			slices.Sort(headers)
+			s = strings.Join(headers, ",")
+		case name == "FloatFormat":
+			var formats []string
+			for group, format := range config.FloatFormat {
+				formats = append(formats, fmt.Sprintf("%s=%c", group, format))
+			}
+			slices.Sort(formats)
+			s = strings.Join(formats, ",")
+		default:
+			s = fmt.Sprint(field.Interface())
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", name, s); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 func main() {
-	config := parseFlags()
-	app := NewApp(config)
+	if err := run(); err != nil {
+		slog.Error("Application error", "err", err)
+		os.Exit(exitCode(err))
+	}
+}
 
-	if err := app.Run(); err != nil {
-		log.Fatalf("Application error: %v", err)
+func run() error {
+	config, err := parseFlags()
+	if err != nil {
+		return err
+	}
+	if config.PrintConfig {
+		return writeConfig(os.Stdout, config)
 	}
+
+	app, err := NewApp(config)
+	if err != nil {
+		return err
+	}
+
+	// Ctrl-C cancels the fetches rather than waiting for the range to finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if config.ExplainTime != nil {
+		return app.explainPricing(ctx, os.Stdout, *config.ExplainTime)
+	}
+
+	if config.MetricsAddr != "" {
+		registry := prometheus.NewRegistry()
+		app.Metrics = NewMetrics(registry)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		server := &http.Server{Addr: config.MetricsAddr, Handler: mux}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Metrics server failed", "err", err)
+			}
+		}()
+		defer server.Close()
+		slog.Info("Serving metrics", "addr", config.MetricsAddr, "path", "/metrics")
+	}
+
+	if config.MQTTBroker != "" {
+		app.MQTT, err = NewMQTTSink(config.MQTTBroker, config.MQTTTopic, app.csvOptions())
+		if err != nil {
+			return err
+		}
+		defer app.MQTT.Close()
+	}
+
+	return app.Run(ctx)
 }
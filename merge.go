@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// csvParsers sets the raw UsageRow fields from their CSV columns. Derived columns, such as
+// costs, have no parser and are recomputed when the rows are written back out.
+var csvParsers = map[string]func(row *UsageRow, value string) error{
+	"GE_Cumulative_Import": floatParser(func(row *UsageRow) **float64 { return &row.CumulativeImportInverter }),
+	"GE_Cumulative_Export": floatParser(func(row *UsageRow) **float64 { return &row.CumulativeExportInverter }),
+	"GE_Import_KWh":        floatParser(func(row *UsageRow) **float64 { return &row.GE_ImportKWh }),
+	"GE_Export_KWh":        floatParser(func(row *UsageRow) **float64 { return &row.GE_ExportKWh }),
+	"GEO_Import_KWh":       wattHourParser(func(row *UsageRow) **int64 { return &row.GEO_ImportWh }),
+	"GEO_Export_KWh":       wattHourParser(func(row *UsageRow) **int64 { return &row.GEO_ExportWh }),
+	"GEO_Generation_KWh":   wattHourParser(func(row *UsageRow) **int64 { return &row.GEO_GenerationWh }),
+	"OCTO_Import_KWh":      floatParser(func(row *UsageRow) **float64 { return &row.OCTO_ImportKWh }),
+	"OCTO_Export_KWh":      floatParser(func(row *UsageRow) **float64 { return &row.OCTO_ExportKWh }),
+	"GEO_Gas_KWh":          wattHourParser(func(row *UsageRow) **int64 { return &row.GEO_ImportGasWh }),
+	"Import_Price":         floatParser(func(row *UsageRow) **float64 { return &row.ImportPrice }),
+	"Export_Price":         floatParser(func(row *UsageRow) **float64 { return &row.ExportPrice }),
+	"Import_Price_ExcVat":  floatParser(func(row *UsageRow) **float64 { return &row.ImportPriceExcVat }),
+	"Export_Price_ExcVat":  floatParser(func(row *UsageRow) **float64 { return &row.ExportPriceExcVat }),
+	"Tag": func(row *UsageRow, value string) error {
+		row.Tag = value
+		return nil
+	},
+	"Account": func(row *UsageRow, value string) error {
+		row.Account = value
+		return nil
+	},
+}
+
+// parseCSVFloat parses a float column value, treating "NaN" and empty values as missing.
+func parseCSVFloat(value string) (*float64, error) {
+	if value == "" || value == "NaN" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func floatParser(field func(row *UsageRow) **float64) func(row *UsageRow, value string) error {
+	return func(row *UsageRow, value string) error {
+		f, err := parseCSVFloat(value)
+		*field(row) = f
+		return err
+	}
+}
+
+// wattHourParser parses a kWh column into a Wh field.
+func wattHourParser(field func(row *UsageRow) **int64) func(row *UsageRow, value string) error {
+	return func(row *UsageRow, value string) error {
+		f, err := parseCSVFloat(value)
+		if err != nil || f == nil {
+			*field(row) = nil
+			return err
+		}
+		wh := int64(math.Round(*f * 1000))
+		*field(row) = &wh
+		return nil
+	}
+}
+
+// readCSV reads rows previously written by writeCSV, with timestamps in loc. Columns are matched
+// on their header names, so files written with different optional columns can be read; unknown
+// columns are ignored.
+func readCSV(filename string, loc *time.Location) ([]*UsageRow, error) {
+	file, err := openInput(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseCSVRows(file, filename, loc)
+}
+
+// parseCSVRows parses the rows of a CSV read from r as readCSV does, name identifying it in
+// errors.
+func parseCSVRows(r io.Reader, name string, loc *time.Location) ([]*UsageRow, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	timestampIndex := -1
+	for i, name := range header {
+		if name == "Timestamp" {
+			timestampIndex = i
+		}
+	}
+	if timestampIndex < 0 {
+		return nil, fmt.Errorf("%s has no Timestamp column", name)
+	}
+
+	var data []*UsageRow
+	for line, record := range records[1:] {
+		timestamp, err := parseTimestamp(record[timestampIndex])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid timestamp: %w", line+2, err)
+		}
+
+		row := &UsageRow{Timestamp: inZone(timestamp, loc)}
+		for i, name := range header {
+			parse, ok := csvParsers[name]
+			if !ok {
+				continue
+			}
+			if err := parse(row, record[i]); err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s: %w", line+2, name, err)
+			}
+		}
+		data = append(data, row)
+	}
+	return data, nil
+}
+
+// fillMissing sets the figures row lacks from older, an earlier row for the same slot.
+func fillMissing(row, older *UsageRow) {
+	fields, olderFields := reflect.ValueOf(row).Elem(), reflect.ValueOf(older).Elem()
+	for i := 0; i < fields.NumField(); i++ {
+		if field := fields.Field(i); field.Kind() == reflect.Pointer && field.IsNil() {
+			field.Set(olderFields.Field(i))
+		}
+	}
+}
+
+// mergeKey identifies a row by its account and timestamp.
+type mergeKey struct {
+	account   string
+	timestamp int64
+}
+
+// mergeRows combines existing and newer rows by account and timestamp, the newer row winning
+// where both have one, and returns them sorted by timestamp.
+func mergeRows(existing, newer []*UsageRow) []*UsageRow {
+	byTime := make(map[mergeKey]*UsageRow, len(existing)+len(newer))
+	for _, row := range existing {
+		byTime[mergeKey{row.Account, row.Timestamp.Unix()}] = row
+	}
+	for _, row := range newer {
+		byTime[mergeKey{row.Account, row.Timestamp.Unix()}] = row
+	}
+
+	merged := make([]*UsageRow, 0, len(byTime))
+	for _, row := range byTime {
+		merged = append(merged, row)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Timestamp.Equal(merged[j].Timestamp) {
+			return merged[i].Account < merged[j].Account
+		}
+		return merged[i].Timestamp.Before(merged[j].Timestamp)
+	})
+	return merged
+}
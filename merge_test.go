@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeExistingCSV(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	slot := func(i int) time.Time { return start.Add(time.Duration(i) * 30 * time.Minute) }
+	rows := func(from, to int, kwh float64) []*UsageRow {
+		var data []*UsageRow
+		for i := from; i < to; i++ {
+			data = append(data, &UsageRow{
+				Timestamp:      slot(i),
+				OCTO_ImportKWh: floatPtr(kwh),
+				GEO_ImportWh:   func() *int64 { wh := int64(kwh * 1000); return &wh }(),
+				ImportPrice:    floatPtr(20),
+			})
+		}
+		return data
+	}
+
+	// The existing file was written without the optional columns
+	filename := filepath.Join(t.TempDir(), "master.csv")
+	require.NoError(t, writeCSV(filename, rows(0, 4, 1), CSVOptions{}))
+
+	existing, err := readCSV(filename, nil)
+	require.NoError(t, err)
+	require.Len(t, existing, 4)
+
+	merged := mergeRows(existing, rows(2, 6, 2))
+	require.NoError(t, writeCSV(filename, merged, CSVOptions{IncludeExcVat: true}))
+
+	data, err := readCSV(filename, nil)
+	require.NoError(t, err)
+	require.Len(t, data, 6)
+	for i, row := range data {
+		require.True(t, slot(i).Equal(row.Timestamp), "Unexpected timestamp %s", row.Timestamp)
+
+		expected := 1.0
+		if i >= 2 {
+			expected = 2.0
+		}
+		require.NotNil(t, row.OCTO_ImportKWh)
+		require.InDelta(t, expected, *row.OCTO_ImportKWh, 0.0001, "Row %d", i)
+		require.Equal(t, int64(expected*1000), *row.GEO_ImportWh, "Row %d", i)
+		require.Nil(t, row.GE_ImportKWh, "Row %d", i)
+	}
+}
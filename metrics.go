@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics exposes the latest slot's figures as Prometheus gauges.
+type Metrics struct {
+	importKWh   *prometheus.GaugeVec
+	exportKWh   *prometheus.GaugeVec
+	importCost  *prometheus.GaugeVec
+	importPrice prometheus.Gauge
+	exportPrice prometheus.Gauge
+	slot        *prometheus.GaugeVec
+}
+
+// NewMetrics creates the gauges and registers them with reg.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		importKWh: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "energy_import_kwh",
+			Help: "Import in the latest slot with a reading, by source.",
+		}, []string{"source"}),
+		exportKWh: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "energy_export_kwh",
+			Help: "Export in the latest slot with a reading, by source.",
+		}, []string{"source"}),
+		importCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "energy_import_cost_pence",
+			Help: "Cost of the import in the latest priced slot with a reading, by source.",
+		}, []string{"source"}),
+		importPrice: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "energy_import_price_pence",
+			Help: "Import unit rate of the latest priced slot, p/kWh.",
+		}),
+		exportPrice: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "energy_export_price_pence",
+			Help: "Export unit rate of the latest priced slot, p/kWh.",
+		}),
+		slot: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "energy_latest_slot_timestamp_seconds",
+			Help: "Start of the latest slot with a reading, by source.",
+		}, []string{"source"}),
+	}
+	reg.MustRegister(m.importKWh, m.exportKWh, m.importCost, m.importPrice, m.exportPrice, m.slot)
+	return m
+}
+
+// metricSources are the figures of each source the gauges are set from.
+var metricSources = []struct {
+	Label  string
+	Import func(row *UsageRow) *float64
+	Export func(row *UsageRow) *float64
+}{
+	{"ge", func(row *UsageRow) *float64 { return row.GE_ImportKWh }, func(row *UsageRow) *float64 { return row.GE_ExportKWh }},
+	{"octo", func(row *UsageRow) *float64 { return row.OCTO_ImportKWh }, func(row *UsageRow) *float64 { return row.OCTO_ExportKWh }},
+	{"geo", func(row *UsageRow) *float64 { return convertInt64(row.GEO_ImportWh, 1000) }, func(row *UsageRow) *float64 { return convertInt64(row.GEO_ExportWh, 1000) }},
+}
+
+// Update sets the gauges from the latest rows of data, sorted by timestamp, that have each
+// figure, costing imports with mode. Gauges without a figure in data are left as they were.
+func (m *Metrics) Update(data []*UsageRow, mode RoundingMode) {
+	latest := func(value func(row *UsageRow) *float64) *UsageRow {
+		for i := len(data) - 1; i >= 0; i-- {
+			if data[i].Tag == "" && value(data[i]) != nil {
+				return data[i]
+			}
+		}
+		return nil
+	}
+
+	for _, source := range metricSources {
+		if row := latest(source.Import); row != nil {
+			m.importKWh.WithLabelValues(source.Label).Set(*source.Import(row))
+			m.slot.WithLabelValues(source.Label).Set(float64(row.Timestamp.Unix()))
+		}
+		if row := latest(source.Export); row != nil {
+			m.exportKWh.WithLabelValues(source.Label).Set(*source.Export(row))
+		}
+		priced := func(row *UsageRow) *float64 {
+			return costPence(source.Import(row), row.ImportPrice, mode, defaultPrecision[GroupCost])
+		}
+		if row := latest(priced); row != nil {
+			m.importCost.WithLabelValues(source.Label).Set(*priced(row))
+		}
+	}
+	if row := latest(func(row *UsageRow) *float64 { return row.ImportPrice }); row != nil {
+		m.importPrice.Set(*row.ImportPrice)
+	}
+	if row := latest(func(row *UsageRow) *float64 { return row.ExportPrice }); row != nil {
+		m.exportPrice.Set(*row.ExportPrice)
+	}
+}
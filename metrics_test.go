@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsUpdate(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	wh := int64(300)
+	data := []*UsageRow{
+		{Timestamp: start, OCTO_ImportKWh: floatPtr(0.5), GE_ImportKWh: floatPtr(0.4), GE_ExportKWh: floatPtr(0.1), ImportPrice: floatPtr(20), ExportPrice: floatPtr(15)},
+		// Octopus hasn't published the latest slot yet
+		{Timestamp: start.Add(30 * time.Minute), GE_ImportKWh: floatPtr(0.6), GE_ExportKWh: floatPtr(0), GEO_ImportWh: &wh, ImportPrice: floatPtr(25)},
+	}
+
+	registry := prometheus.NewRegistry()
+	metrics := NewMetrics(registry)
+	metrics.Update(data, RoundHalfUp)
+
+	expected := `
+# HELP energy_import_kwh Import in the latest slot with a reading, by source.
+# TYPE energy_import_kwh gauge
+energy_import_kwh{source="ge"} 0.6
+energy_import_kwh{source="geo"} 0.3
+energy_import_kwh{source="octo"} 0.5
+# HELP energy_import_price_pence Import unit rate of the latest priced slot, p/kWh.
+# TYPE energy_import_price_pence gauge
+energy_import_price_pence 25
+# HELP energy_export_price_pence Export unit rate of the latest priced slot, p/kWh.
+# TYPE energy_export_price_pence gauge
+energy_export_price_pence 15
+`
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"energy_import_kwh", "energy_import_price_pence", "energy_export_price_pence"))
+	require.Equal(t, 0.0, testutil.ToFloat64(metrics.exportKWh.WithLabelValues("ge")))
+	require.Equal(t, 10.0, testutil.ToFloat64(metrics.importCost.WithLabelValues("octo")))
+	require.Equal(t, float64(start.Add(30*time.Minute).Unix()), testutil.ToFloat64(metrics.slot.WithLabelValues("ge")))
+}
@@ -9,25 +9,57 @@ type UsageRow struct {
 	CumulativeExportInverter    *float64
 	ImportPrice                 *float64
 	ExportPrice                 *float64
+	ImportPriceExcVat           *float64
+	ExportPriceExcVat           *float64
 	GEO_ImportGasWh             *int64
 	GEO_ImportWh                *int64
 	GE_ImportKWh                *float64
 	GE_ExportKWh                *float64
+	GE_BatteryChargeKWh         *float64
+	GE_BatteryDischargeKWh      *float64
+	GE_SolarKWh                 *float64
+	GE_ConsumptionKWh           *float64
 	GEO_ImportMilliPenceCost    *int64
 	GEO_ImportGasMilliPenceCost *int64
+	GEO_ExportWh                *int64 // only on systems that meter export
+	GEO_ExportMilliPenceCost    *int64
+	GEO_GenerationWh            *int64 // only on systems that meter generation
 	OCTO_ImportKWh              *float64
 	OCTO_ExportKWh              *float64
+	OCTO_ImportEstimated        bool     // Octopus flagged the import reading as an estimate
+	OCTO_ExportEstimated        bool     // Octopus flagged the export reading as an estimate
+	OCTO_ExportFilledFrom       string   // source OCTO_ExportKWh was filled from, empty when published by Octopus
+	ImportKWh                   *float64 // import figure used for pricing
+	ImportSource                string   // source of ImportKWh
+	ExportKWh                   *float64 // export figure used for pricing
+	ExportSource                string   // source of ExportKWh
+	GEO_LiveWatts               *int64   // live electricity power, only on the live row
+	GEO_LiveGasWatts            *int64   // live gas power, only on the live row
+	Tag                         string   // TagLive for the live snapshot row
+	Account                     string   // account the row belongs to when several are output
+	StandingCharge              *float64 // pence, placed according to the StandingChargePlacement
+	OCTO_ImportRegisterKWh      *float64 // cumulative import register reading
+	CarbonIntensity             *float64 // regional grid carbon intensity, gCO2/kWh
+	OCTO_GasKWh                 *float64 // Octopus gas consumption converted to kWh
 }
 
+// UsageStore holds the rows of a run keyed by their slot start time.
+type UsageStore map[time.Time]*UsageRow
+
+// TagLive marks the row holding the live power snapshot rather than slot usage.
+const TagLive = "live"
+
 type MeterInfo struct {
 	ProductCode  string
 	TariffCode   string
 	SerialNumber string
 	Mpan         string // used for both mpan/mprn
+	DisplayName  string // human readable product name, e.g. "Agile Octopus"
 }
 
 type TariffData struct {
-	Rate      float64
-	ValidFrom *time.Time
-	ValidTo   *time.Time
+	Rate       float64 // including VAT
+	RateExcVat float64
+	ValidFrom  *time.Time
+	ValidTo    *time.Time
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttTimeout bounds connecting to the broker and each publish.
+const mqttTimeout = 10 * time.Second
+
+// mqttPublisher is the part of the MQTT client MQTTSink publishes with.
+type mqttPublisher interface {
+	Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token
+}
+
+// MQTTSink publishes rows to an MQTT broker as retained JSON messages, each slot to
+// <topic>/<UTC timestamp> and the latest to <topic>/latest, for Home Assistant and the like.
+type MQTTSink struct {
+	client  mqttPublisher
+	topic   string
+	columns []csvColumn
+}
+
+// NewMQTTSink connects to broker, e.g. tcp://localhost:1883, and returns a sink publishing
+// opts' columns under topic. The client reconnects by itself when the connection drops, and
+// keeps trying when the broker can't be reached at first.
+func NewMQTTSink(broker, topic string, opts CSVOptions) (*MQTTSink, error) {
+	options := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID("givenergy-octopus-gaps").
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("lost MQTT connection, reconnecting", "broker", broker, "err", err)
+		})
+	client := mqtt.NewClient(options)
+	token := client.Connect()
+	if !token.WaitTimeout(mqttTimeout) {
+		slog.Warn("MQTT broker not reachable yet, retrying in the background", "broker", broker)
+	} else if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker %s: %w", broker, err)
+	}
+	return &MQTTSink{client: client, topic: strings.TrimSuffix(topic, "/"), columns: csvColumns(opts)}, nil
+}
+
+// Publish publishes each slot row of data, sorted by timestamp, then the last of them as the
+// latest. Failed publishes don't stop the rest; the error reports how many failed.
+func (s *MQTTSink) Publish(data []*UsageRow) error {
+	var latest *UsageRow
+	failed := 0
+	var lastErr error
+	publish := func(topic string, row *UsageRow) {
+		payload, err := rowJSON(row, s.columns)
+		if err == nil {
+			token := s.client.Publish(topic, 1, true, payload)
+			if !token.WaitTimeout(mqttTimeout) {
+				err = fmt.Errorf("timed out publishing to %s", topic)
+			} else {
+				err = token.Error()
+			}
+		}
+		if err != nil {
+			failed++
+			lastErr = err
+		}
+	}
+
+	for _, row := range data {
+		if row.Tag != "" {
+			continue
+		}
+		publish(s.topic+"/"+row.Timestamp.UTC().Format(time.RFC3339), row)
+		latest = row
+	}
+	if latest != nil {
+		publish(s.topic+"/latest", latest)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to publish %d MQTT messages: %w", failed, lastErr)
+	}
+	return nil
+}
+
+// Close disconnects from the broker, waiting briefly for in-flight messages.
+func (s *MQTTSink) Close() {
+	if client, ok := s.client.(mqtt.Client); ok {
+		client.Disconnect(250)
+	}
+}
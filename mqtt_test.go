@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeToken is a completed MQTT token.
+type fakeToken struct {
+	mqtt.Token
+	err error
+}
+
+func (t fakeToken) WaitTimeout(time.Duration) bool { return true }
+func (t fakeToken) Error() error                   { return t.err }
+
+// fakePublisher records publishes, failing those to topics in fail.
+type fakePublisher struct {
+	published map[string][]byte
+	retained  map[string]bool
+	fail      map[string]bool
+}
+
+func (p *fakePublisher) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	if p.fail[topic] {
+		return fakeToken{err: errors.New("not connected")}
+	}
+	p.published[topic] = payload.([]byte)
+	p.retained[topic] = retained
+	return fakeToken{}
+}
+
+func TestMQTTSinkPublish(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := []*UsageRow{
+		{Timestamp: start, OCTO_ImportKWh: floatPtr(0.5), ImportPrice: floatPtr(20)},
+		{Timestamp: start.Add(30 * time.Minute), GE_ImportKWh: floatPtr(0.25)},
+		{Timestamp: start.Add(time.Hour), Tag: TagLive},
+	}
+	publisher := &fakePublisher{published: map[string][]byte{}, retained: map[string]bool{},
+		fail: map[string]bool{"energy/2025-01-01T00:00:00Z": true}}
+	sink := &MQTTSink{client: publisher, topic: "energy", columns: csvColumns(CSVOptions{})}
+
+	err := sink.Publish(data)
+	require.ErrorContains(t, err, "failed to publish 1 MQTT messages", "Expected the failure reported without stopping the rest")
+	require.Len(t, publisher.published, 2, "Expected the second slot and latest, not the live row")
+	require.True(t, publisher.retained["energy/latest"])
+
+	var latest map[string]any
+	require.NoError(t, json.Unmarshal(publisher.published["energy/latest"], &latest))
+	require.Equal(t, "2025-01-01T00:30:00Z", latest["Timestamp"])
+	require.Equal(t, 0.25, latest["GE_Import_KWh"])
+	require.Nil(t, latest["OCTO_Import_KWh"], "Expected a missing figure as null")
+	require.Equal(t, publisher.published["energy/2025-01-01T00:30:00Z"], publisher.published["energy/latest"])
+}
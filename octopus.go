@@ -1,23 +1,50 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/go-openapi/runtime"
 	httptransport "github.com/go-openapi/runtime/client"
 	"github.com/go-openapi/strfmt"
 	octopus "github.com/mgazza/go-octopus-energy/client"
 	"github.com/mgazza/go-octopus-energy/client/accounts"
 	"github.com/mgazza/go-octopus-energy/client/electricity_meter_points"
+	"github.com/mgazza/go-octopus-energy/client/gas_meter_points"
 	"github.com/mgazza/go-octopus-energy/client/products"
+	"github.com/mgazza/go-octopus-energy/models"
 )
 
 // OctopusService handles interactions with the Octopus Energy API.
 type OctopusService struct {
 	Client *octopus.OctopusEnergyRESTAPI
+
+	// Granularity is the slot width consumption is requested and bucketed at, half-hourly by default.
+	Granularity Granularity
+	// SlotOffset moves the slot boundaries past the hour and half-hour, for meters reporting at e.g. :05 and :35.
+	SlotOffset time.Duration
+	// Location is the zone slots are bucketed in, local time when nil.
+	Location *time.Location
+	// ExcludeEstimates skips consumption readings Octopus flags as estimated, leaving those slots empty.
+	ExcludeEstimates bool
+	// Checkpoint, when set, records each fetched consumption page so a restarted run can skip it.
+	Checkpoint *Checkpoint
+	// RequestJitter bounds a random pause between paged requests.
+	RequestJitter time.Duration
+	// GraphQLURL is the API register readings are fetched from.
+	GraphQLURL string
+	// RegisterTransport is used for the register reading requests. They are GraphQL POSTs to a
+	// single URL, so shouldn't go through the URL keyed cache.
+	RegisterTransport http.RoundTripper
+
+	apiKey string
 }
 
 // NewOctopusService creates a new OctopusService with pre-configured authentication.
@@ -29,12 +56,16 @@ func NewOctopusService(rt http.RoundTripper, apiKey string) *OctopusService {
 
 	client := octopus.New(transport, strfmt.Default)
 	return &OctopusService{
-		Client: client,
+		Client:            client,
+		GraphQLURL:        krakenGraphQLURL,
+		RegisterTransport: rt,
+		apiKey:            apiKey,
 	}
 }
 
 // GetMetersAndTariff fetches meter information and tariff details.
-// returns the import, export and gas meter
+// returns the import, export and gas meter, the export and gas meters being nil when the
+// account has none
 func (s *OctopusService) GetMetersAndTariff(accountID string) (*MeterInfo, *MeterInfo, *MeterInfo, error) {
 	params := accounts.NewGetAccountParams().WithAccountID(accountID)
 	response, err := s.Client.Accounts.GetAccount(params, nil)
@@ -43,7 +74,7 @@ func (s *OctopusService) GetMetersAndTariff(accountID string) (*MeterInfo, *Mete
 	}
 
 	if len(response.Payload.Properties) < 1 {
-		return nil, nil, nil, fmt.Errorf("no properties found on the account")
+		return nil, nil, nil, fmt.Errorf("%w: account %s has no properties, check the account ID", ErrNoImportMeter, accountID)
 	}
 
 	property := response.Payload.Properties[0]
@@ -55,60 +86,109 @@ func (s *OctopusService) GetMetersAndTariff(accountID string) (*MeterInfo, *Mete
 		return nil, nil, nil, fmt.Errorf("failed to fetch products: %w", err)
 	}
 
-	findProductCode := func(productCode string) string {
+	findProduct := func(tariffCode string) *models.Products {
 		for _, p := range productResponse.Payload.Results {
-			if strings.Contains(productCode, *p.Code) {
-				return *p.Code
+			if strings.Contains(tariffCode, *p.Code) {
+				return p
 			}
 		}
-		return ""
+		return nil
+	}
+	meterInfo := func(tariffCode, serialNumber, mpan string) *MeterInfo {
+		meter := &MeterInfo{TariffCode: tariffCode, SerialNumber: serialNumber, Mpan: mpan}
+		if p := findProduct(tariffCode); p != nil {
+			meter.ProductCode, meter.DisplayName = *p.Code, productDisplayName(p)
+		}
+		return meter
 	}
 
+	// A property can have several import or export meter points, e.g. after a meter exchange or
+	// when the export register has its own MPAN. A point is export if Octopus flags it so or its
+	// tariff is an export product. Of the points in each direction, the first with an agreement
+	// active now is used, falling back to the first listed.
+	now := time.Now()
 	var importMeter, exportMeter, gasMeter *MeterInfo
+	var importActive, exportActive bool
+	var importPoints, exportPoints int
 	for _, meterPoint := range property.ElectricityMeterPoints {
 		if len(meterPoint.Meters) < 1 {
 			continue
 		}
 
-		tariffCode := meterPoint.Agreements[len(meterPoint.Agreements)-1].TariffCode
-		productCode := findProductCode(tariffCode)
+		agreement, active := currentAgreement(meterPoint.Agreements, now)
+		meter := meterInfo(agreement.TariffCode, meterPoint.Meters[0].SerialNumber, meterPoint.Mpan)
+		product := findProduct(agreement.TariffCode)
 
-		if meterPoint.IsExport {
-			exportMeter = &MeterInfo{
-				ProductCode:  productCode,
-				TariffCode:   tariffCode,
-				SerialNumber: meterPoint.Meters[0].SerialNumber,
-				Mpan:         meterPoint.Mpan,
+		if meterPoint.IsExport || (product != nil && product.Direction == models.ProductsDirectionEXPORT) {
+			exportPoints++
+			if exportMeter == nil || (active && !exportActive) {
+				exportMeter, exportActive = meter, active
 			}
 		} else {
-			importMeter = &MeterInfo{
-				ProductCode:  productCode,
-				TariffCode:   tariffCode,
-				SerialNumber: meterPoint.Meters[0].SerialNumber,
-				Mpan:         meterPoint.Mpan,
+			importPoints++
+			if importMeter == nil || (active && !importActive) {
+				importMeter, importActive = meter, active
 			}
 		}
 	}
+	if importPoints > 1 {
+		slog.Info("Account has several import meter points", "count", importPoints, "using", importMeter.Mpan)
+	}
+	if exportPoints > 1 {
+		slog.Info("Account has several export meter points", "count", exportPoints, "using", exportMeter.Mpan)
+	}
 
 	for _, meterPoint := range property.GasMeterPoints {
 		if len(meterPoint.Meters) < 1 {
 			continue
 		}
 
-		tariffCode := meterPoint.Agreements[len(meterPoint.Agreements)-1].TariffCode
-		productCode := findProductCode(tariffCode)
+		agreement, _ := currentAgreement(meterPoint.Agreements, now)
+		gasMeter = meterInfo(agreement.TariffCode, meterPoint.Meters[0].SerialNumber, meterPoint.Mprn)
+	}
 
-		gasMeter = &MeterInfo{
-			ProductCode:  productCode,
-			TariffCode:   tariffCode,
-			SerialNumber: meterPoint.Meters[0].SerialNumber,
-			Mpan:         meterPoint.Mprn,
+	if importMeter == nil {
+		return nil, nil, nil, fmt.Errorf("%w: account %s has no electricity meter point with a meter, check the account ID", ErrNoImportMeter, accountID)
+	}
+	for _, meter := range []*MeterInfo{importMeter, exportMeter} {
+		if meter != nil && meter.ProductCode == "" {
+			return nil, nil, nil, fmt.Errorf("%w: %s on meter %s, check the tariff is a current Octopus product", ErrNoProductMatch, meter.TariffCode, meter.SerialNumber)
 		}
 	}
 
 	return importMeter, exportMeter, gasMeter, nil
 }
 
+// currentAgreement returns the first agreement in effect at now, or the last agreement when
+// none is. It returns an empty agreement if there are none.
+func currentAgreement(agreements []*models.AccountAgreement, now time.Time) (agreement *models.AccountAgreement, active bool) {
+	for _, a := range agreements {
+		if a == nil {
+			continue
+		}
+		from, to := time.Time(a.ValidFrom), time.Time(a.ValidTo)
+		if !from.After(now) && (to.IsZero() || now.Before(to)) {
+			return a, true
+		}
+		agreement = a
+	}
+	if agreement == nil {
+		agreement = &models.AccountAgreement{}
+	}
+	return agreement, false
+}
+
+// productDisplayName returns the product's display name, falling back to its full name and code.
+func productDisplayName(p *models.Products) string {
+	switch {
+	case p.DisplayName != nil && *p.DisplayName != "":
+		return *p.DisplayName
+	case p.FullName != nil && *p.FullName != "":
+		return *p.FullName
+	}
+	return *p.Code
+}
+
 // GetLastReading fetches the start date time of the last reading from the Octopus API.
 func (s *OctopusService) GetLastReading(meter *MeterInfo) (time.Time, float64, error) {
 	orderBy := "-period"
@@ -131,12 +211,12 @@ func (s *OctopusService) GetLastReading(meter *MeterInfo) (time.Time, float64, e
 }
 
 // FetchTariffs fetches tariff data for the specified parameters.
-func (s *OctopusService) FetchTariffs(productCode, tariffCode string, start, end time.Time) ([]TariffData, error) {
+func (s *OctopusService) FetchTariffs(ctx context.Context, productCode, tariffCode string, start, end time.Time) ([]TariffData, error) {
 	var allTariffs []TariffData
 	pageSize := int64(672) // Fetch two weeks of half-hour slots per page
 	page := int64(1)
 
-	params := products.NewListElectricityTariffStandardUnitRatesParams().
+	params := products.NewListElectricityTariffStandardUnitRatesParamsWithContext(ctx).
 		WithProductCode(productCode).
 		WithTariffCode(tariffCode).
 		WithPeriodFrom((*strfmt.DateTime)(&start)).
@@ -144,6 +224,9 @@ func (s *OctopusService) FetchTariffs(productCode, tariffCode string, start, end
 		WithPageSize(&pageSize)
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		params.WithPage(&page)
 		response, err := s.Client.Products.ListElectricityTariffStandardUnitRates(params, nil)
 		if err != nil {
@@ -152,9 +235,10 @@ func (s *OctopusService) FetchTariffs(productCode, tariffCode string, start, end
 
 		for _, rate := range response.Payload.Results {
 			allTariffs = append(allTariffs, TariffData{
-				Rate:      rate.ValueIncVat,
-				ValidFrom: (*time.Time)(rate.ValidFrom),
-				ValidTo:   (*time.Time)(rate.ValidTo),
+				Rate:       rate.ValueIncVat,
+				RateExcVat: rate.ValueExcVat,
+				ValidFrom:  (*time.Time)(rate.ValidFrom),
+				ValidTo:    (*time.Time)(rate.ValidTo),
 			})
 		}
 
@@ -163,52 +247,300 @@ func (s *OctopusService) FetchTariffs(productCode, tariffCode string, start, end
 		}
 
 		page++
+		sleepJitter(s.RequestJitter)
 	}
 
 	return allTariffs, nil
 }
 
-// GetMeterConsumption gets meter readings for the specified parameters.
-func (s *OctopusService) GetMeterConsumption(usage map[time.Time]*UsageRow, meter *MeterInfo, startDateTime, endDateTime time.Time, update func(value float64, row *UsageRow)) error {
-	total := 0
+// FetchStandingCharges fetches the daily standing charges of a tariff from start to end, the
+// rate of each interval in pence per day.
+func (s *OctopusService) FetchStandingCharges(ctx context.Context, productCode, tariffCode string, start, end time.Time) ([]TariffData, error) {
+	var charges []TariffData
 	page := int64(1)
+
+	params := products.NewListElectricityTariffStandingChargesParamsWithContext(ctx).
+		WithProductCode(productCode).
+		WithTariffCode(tariffCode).
+		WithPeriodFrom((*strfmt.DateTime)(&start)).
+		WithPeriodTo((*strfmt.DateTime)(&end))
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		params.WithPage(&page)
+		response, err := s.Client.Products.ListElectricityTariffStandingCharges(params, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch standing charges: %w", err)
+		}
+
+		for _, charge := range response.Payload.Results {
+			charges = append(charges, TariffData{
+				Rate:       charge.ValueIncVat,
+				RateExcVat: charge.ValueExcVat,
+				ValidFrom:  (*time.Time)(charge.ValidFrom),
+				ValidTo:    (*time.Time)(charge.ValidTo),
+			})
+		}
+
+		if response.Payload.Next == nil {
+			break
+		}
+
+		page++
+		sleepJitter(s.RequestJitter)
+	}
+
+	return charges, nil
+}
+
+// GetMeterConsumption gets meter readings for the specified parameters.
+func (s *OctopusService) GetMeterConsumption(ctx context.Context, usage map[time.Time]*UsageRow, meter *MeterInfo, startDateTime, endDateTime time.Time, update func(value float64, estimated bool, row *UsageRow)) error {
 	pageSize := int64(336) // two weeks of 30 mins
-	params := electricity_meter_points.NewListConsumptionForAnElectricityMeterParams().
+	params := electricity_meter_points.NewListConsumptionForAnElectricityMeterParamsWithContext(ctx).
 		WithMpan(meter.Mpan).
 		WithSerialNumber(meter.SerialNumber).
 		WithPeriodFrom((*strfmt.DateTime)(&startDateTime)).
 		WithPeriodTo((*strfmt.DateTime)(&endDateTime)).
-		WithPageSize(&pageSize).
-		WithPage(&page)
+		WithGroupBy(s.Granularity.groupBy()).
+		WithPageSize(&pageSize)
+
+	return s.consumption(ctx, usage, meter, func(page int64, flags map[time.Time]resultFlags) (*models.PaginatedConsumptionList, error) {
+		response, err := s.Client.ElectricityMeterPoints.ListConsumptionForAnElectricityMeter(params.WithPage(&page), nil, withResultFlags(flags))
+		if err != nil {
+			return nil, fmt.Errorf("error querying octopus data: %w", err)
+		}
+		if !response.IsSuccess() {
+			return nil, fmt.Errorf("error querying octopus data: %v", response.Error())
+		}
+		return response.Payload, nil
+	}, update)
+}
+
+// GetGasConsumption gets the gas meter's readings, as reported by the meter: cubic metres for
+// SMETS2 meters and kWh for SMETS1 meters.
+func (s *OctopusService) GetGasConsumption(ctx context.Context, usage map[time.Time]*UsageRow, meter *MeterInfo, startDateTime, endDateTime time.Time, update func(value float64, estimated bool, row *UsageRow)) error {
+	pageSize := int64(336) // two weeks of 30 mins
+	params := gas_meter_points.NewListConsumptionForaGasMeterParamsWithContext(ctx).
+		WithMprn(meter.Mpan).
+		WithSerialNumber(meter.SerialNumber).
+		WithPeriodFrom((*strfmt.DateTime)(&startDateTime)).
+		WithPeriodTo((*strfmt.DateTime)(&endDateTime)).
+		WithGroupBy(s.Granularity.groupBy()).
+		WithPageSize(&pageSize)
 
-	for {
-		response, err := s.Client.ElectricityMeterPoints.ListConsumptionForAnElectricityMeter(params, nil)
+	return s.consumption(ctx, usage, meter, func(page int64, flags map[time.Time]resultFlags) (*models.PaginatedConsumptionList, error) {
+		response, err := s.Client.GasMeterPoints.ListConsumptionForaGasMeter(params.WithPage(&page), nil, gas_meter_points.ClientOption(withResultFlags(flags)))
 		if err != nil {
-			return fmt.Errorf("error querying octopus data: %w", err)
+			return nil, fmt.Errorf("error querying octopus gas data: %w", err)
 		}
 		if !response.IsSuccess() {
-			return fmt.Errorf("error querying octopus data: %v", response.Error())
+			return nil, fmt.Errorf("error querying octopus gas data: %v", response.Error())
+		}
+		return response.Payload, nil
+	}, update)
+}
+
+// Gas units the Octopus gas meter reports consumption in.
+const (
+	GasUnitsM3  = "m3"
+	GasUnitsKWh = "kwh"
+)
+
+// gasVolumeCorrection corrects a metered gas volume for temperature and pressure.
+const gasVolumeCorrection = 1.02264
+
+// gasKWh converts a gas reading in units to kWh, using calorificValue in MJ/m³ for volumes.
+func gasKWh(value float64, units string, calorificValue float64) float64 {
+	if units == GasUnitsKWh {
+		return value
+	}
+	return value * gasVolumeCorrection * calorificValue / 3.6
+}
+
+// consumption fetches every page of a meter's consumption with list, passing each reported
+// slot's value to update.
+func (s *OctopusService) consumption(ctx context.Context, usage map[time.Time]*UsageRow, meter *MeterInfo,
+	list func(page int64, flags map[time.Time]resultFlags) (*models.PaginatedConsumptionList, error),
+	update func(value float64, estimated bool, row *UsageRow)) error {
+	total := 0
+	excluded := 0
+	noConsumption := 0
+	slots := make(map[time.Time]bool)
+	checkpointKey := meter.Mpan + "/" + meter.SerialNumber
+	page := s.Checkpoint.page(checkpointKey)
+	if page > 1 {
+		slog.Info("Resuming Octopus consumption", "meter", meter.SerialNumber, "page", page)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		flags := make(map[time.Time]resultFlags)
+		payload, err := list(page, flags)
+		if err != nil {
+			return err
 		}
 
-		for _, r := range response.Payload.Results {
+		for _, r := range payload.Results {
 			total++
-			hf := time.Time(*r.IntervalStart).Truncate(30 * time.Minute).Local()
+			flag := flags[time.Time(*r.IntervalStart).UTC()]
+			if flag.NoConsumption {
+				// Leave the slot missing rather than recording a zero Octopus never reported
+				noConsumption++
+				continue
+			}
+			estimated := flag.Estimated
+			if estimated && s.ExcludeEstimates {
+				excluded++
+				continue
+			}
+			hf := s.Granularity.offsetSlot(time.Time(*r.IntervalStart), s.SlotOffset, s.Location)
+			slots[hf] = true
 			row, ok := usage[hf]
 			if !ok {
 				rt := UsageRow{Timestamp: hf}
 				row = &rt
 				usage[hf] = row
 			}
-			update(r.Consumption, row)
+			update(r.Consumption, estimated, row)
 		}
 
-		if response.Payload.Next == nil {
+		if err := s.Checkpoint.pageDone(checkpointKey, page+1); err != nil {
+			return err
+		}
+		if payload.Next == nil {
 			break
 		}
 		page++
+		sleepJitter(s.RequestJitter)
 	}
 
-	log.Printf("Fetched %d Octopus records", total)
+	slog.Info("Fetched Octopus records", "count", total)
+	if excluded > 0 {
+		slog.Info("Excluded estimated Octopus records", "count", excluded)
+	}
+	if noConsumption > 0 {
+		slog.Warn("skipped Octopus records with no consumption", "count", noConsumption)
+	}
+	if s.Granularity.groupBy() == nil {
+		logClockChanges("Octopus", slots, s.Location)
+	}
 
 	return nil
 }
+
+// GetAvailableRange returns the start of the first and the end of the last consumption
+// readings Octopus has for the meter. ok is false when the meter has no readings.
+func (s *OctopusService) GetAvailableRange(meter *MeterInfo) (first, last time.Time, ok bool, err error) {
+	pageSize := int64(1)
+	query := func(orderBy string) (*models.Consumption, error) {
+		params := electricity_meter_points.NewListConsumptionForAnElectricityMeterParams().
+			WithMpan(meter.Mpan).
+			WithSerialNumber(meter.SerialNumber).
+			WithOrderBy(&orderBy).
+			WithPageSize(&pageSize)
+
+		response, err := s.Client.ElectricityMeterPoints.ListConsumptionForAnElectricityMeter(params, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error querying octopus data: %w", err)
+		}
+		if len(response.Payload.Results) == 0 {
+			return nil, nil
+		}
+		return response.Payload.Results[0], nil
+	}
+
+	earliest, err := query("period")
+	if err != nil || earliest == nil {
+		return first, last, false, err
+	}
+	latest, err := query("-period")
+	if err != nil || latest == nil {
+		return first, last, false, err
+	}
+	return time.Time(*earliest.IntervalStart), time.Time(*latest.IntervalEnd), true, nil
+}
+
+// ClampToAvailable narrows [start, end] to the readings Octopus has for the meter, logging
+// any adjustment. The range is returned unchanged when the meter has no readings.
+func (s *OctopusService) ClampToAvailable(meter *MeterInfo, start, end time.Time) (time.Time, time.Time, error) {
+	first, last, ok, err := s.GetAvailableRange(meter)
+	if err != nil {
+		return start, end, fmt.Errorf("failed to get available range: %w", err)
+	}
+	if !ok {
+		slog.Warn("no Octopus readings for meter, not clamping the range", "meter", meter.SerialNumber)
+		return start, end, nil
+	}
+
+	if start.Before(first) {
+		slog.Info("Clamping start to the first Octopus reading", "start", start.Format(time.RFC3339), "first", inZone(first, s.Location).Format(time.RFC3339))
+		start = first.In(start.Location())
+	}
+	if end.After(last) {
+		slog.Info("Clamping end to the end of the last Octopus reading", "end", end.Format(time.RFC3339), "last", inZone(last, s.Location).Format(time.RFC3339))
+		end = last.In(end.Location())
+	}
+	return start, end, nil
+}
+
+// resultFlags is what the generated consumption model doesn't capture about a result.
+type resultFlags struct {
+	Estimated     bool // is_estimated was set
+	NoConsumption bool // consumption was null or absent, which the model would decode as 0
+}
+
+// withResultFlags records the flags of each consumption result, keyed by its UTC interval
+// start. The generated model has no field for is_estimated and can't tell a null consumption
+// from a genuine zero, so the response body is decoded here before being passed on to the
+// generated reader.
+func withResultFlags(flags map[time.Time]resultFlags) electricity_meter_points.ClientOption {
+	return func(op *runtime.ClientOperation) {
+		op.Reader = &resultFlagsReader{next: op.Reader, flags: flags}
+	}
+}
+
+type resultFlagsReader struct {
+	next  runtime.ClientResponseReader
+	flags map[time.Time]resultFlags
+}
+
+func (r *resultFlagsReader) ReadResponse(response runtime.ClientResponse, consumer runtime.Consumer) (interface{}, error) {
+	body, err := io.ReadAll(response.Body())
+	if err != nil {
+		return nil, err
+	}
+
+	var page struct {
+		Results []struct {
+			IntervalStart time.Time `json:"interval_start"`
+			Consumption   *float64  `json:"consumption"`
+			IsEstimated   bool      `json:"is_estimated"`
+		} `json:"results"`
+	}
+	if response.Code()/100 == 2 && json.Unmarshal(body, &page) == nil {
+		for _, result := range page.Results {
+			if result.IsEstimated || result.Consumption == nil {
+				r.flags[result.IntervalStart.UTC()] = resultFlags{
+					Estimated:     result.IsEstimated,
+					NoConsumption: result.Consumption == nil,
+				}
+			}
+		}
+	}
+
+	return r.next.ReadResponse(replayedResponse{response, body}, consumer)
+}
+
+// replayedResponse serves an already read body to the next response reader.
+type replayedResponse struct {
+	runtime.ClientResponse
+	body []byte
+}
+
+func (r replayedResponse) Body() io.ReadCloser {
+	return io.NopCloser(bytes.NewReader(r.body))
+}
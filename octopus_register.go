@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// krakenGraphQLURL is the Octopus GraphQL API, which exposes the smart meter register readings
+// the REST API doesn't.
+const krakenGraphQLURL = "https://api.octopus.energy/v1/graphql/"
+
+// RegisterReading is a cumulative meter register value, as shown on the meter display.
+type RegisterReading struct {
+	ReadAt time.Time
+	KWh    float64
+}
+
+// graphQL posts query with variables to the Octopus GraphQL API and decodes the data into out.
+func (s *OctopusService) graphQL(token, query string, variables map[string]any, out any) error {
+	body, err := json.Marshal(map[string]any{"query": query, "variables": variables})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.GraphQLURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := (&http.Client{Transport: s.RegisterTransport}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		var messages []string
+		for _, e := range result.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("graphql: %s", strings.Join(messages, "; "))
+	}
+	return json.Unmarshal(result.Data, out)
+}
+
+// registerDeviceID returns the smart meter device ID for the meter serial on the account,
+// empty if the meter has none.
+func (s *OctopusService) registerDeviceID(token, accountID, serialNumber string) (string, error) {
+	const query = `query($account: String!) {
+  account(accountNumber: $account) {
+    electricityAgreements(active: true) {
+      meterPoint {
+        meters(includeInactive: false) {
+          serialNumber
+          smartDevices { deviceId }
+        }
+      }
+    }
+  }
+}`
+	var data struct {
+		Account struct {
+			ElectricityAgreements []struct {
+				MeterPoint struct {
+					Meters []struct {
+						SerialNumber string `json:"serialNumber"`
+						SmartDevices []struct {
+							DeviceID string `json:"deviceId"`
+						} `json:"smartDevices"`
+					} `json:"meters"`
+				} `json:"meterPoint"`
+			} `json:"electricityAgreements"`
+		} `json:"account"`
+	}
+	if err := s.graphQL(token, query, map[string]any{"account": accountID}, &data); err != nil {
+		return "", fmt.Errorf("failed to fetch meter devices: %w", err)
+	}
+
+	for _, agreement := range data.Account.ElectricityAgreements {
+		for _, meter := range agreement.MeterPoint.Meters {
+			if meter.SerialNumber == serialNumber && len(meter.SmartDevices) > 0 {
+				return meter.SmartDevices[0].DeviceID, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// GetRegisterReadings fetches the cumulative register readings for meter between start and
+// end. Meters that don't expose register readings return none and no error.
+func (s *OctopusService) GetRegisterReadings(accountID string, meter *MeterInfo, start, end time.Time) ([]RegisterReading, error) {
+	var auth struct {
+		ObtainKrakenToken struct {
+			Token string `json:"token"`
+		} `json:"obtainKrakenToken"`
+	}
+	err := s.graphQL("", `mutation($key: String!) { obtainKrakenToken(input: {APIKey: $key}) { token } }`,
+		map[string]any{"key": s.apiKey}, &auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain token: %w", err)
+	}
+	token := auth.ObtainKrakenToken.Token
+
+	deviceID, err := s.registerDeviceID(token, accountID, meter.SerialNumber)
+	if err != nil {
+		return nil, err
+	}
+	if deviceID == "" {
+		return nil, nil
+	}
+
+	const query = `query($device: String!, $start: DateTime!, $end: DateTime!) {
+  smartMeterTelemetry(deviceId: $device, start: $start, end: $end, grouping: HALF_HOURLY) {
+    readAt
+    consumption
+  }
+}`
+	var data struct {
+		SmartMeterTelemetry []struct {
+			ReadAt      time.Time `json:"readAt"`
+			Consumption *float64  `json:"consumption,string"` // cumulative Wh
+		} `json:"smartMeterTelemetry"`
+	}
+	variables := map[string]any{
+		"device": deviceID,
+		"start":  start.UTC().Format(time.RFC3339),
+		"end":    end.UTC().Format(time.RFC3339),
+	}
+	if err := s.graphQL(token, query, variables, &data); err != nil {
+		return nil, fmt.Errorf("failed to fetch register readings: %w", err)
+	}
+
+	var readings []RegisterReading
+	for _, t := range data.SmartMeterTelemetry {
+		if t.Consumption == nil {
+			continue
+		}
+		readings = append(readings, RegisterReading{ReadAt: inZone(t.ReadAt, s.Location), KWh: *t.Consumption / 1000})
+	}
+	return readings, nil
+}
+
+// PopulateRegisterReadings sets each slot's register value to the last reading taken within it.
+func (s *OctopusService) PopulateRegisterReadings(usage UsageStore, readings []RegisterReading) {
+	latest := make(map[time.Time]time.Time)
+	for _, reading := range readings {
+		slot := s.Granularity.offsetSlot(reading.ReadAt, s.SlotOffset, s.Location)
+		if last, ok := latest[slot]; ok && reading.ReadAt.Before(last) {
+			continue
+		}
+		latest[slot] = reading.ReadAt
+
+		row, ok := usage[slot]
+		if !ok {
+			row = &UsageRow{Timestamp: slot}
+			usage[slot] = row
+		}
+		value := reading.KWh
+		row.OCTO_ImportRegisterKWh = &value
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func registerRoundTripper(t *testing.T, meters string) *MockRoundTripper {
+	return &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+
+			var responseBody string
+			switch {
+			case strings.Contains(string(body), "obtainKrakenToken"):
+				responseBody = `{"data": {"obtainKrakenToken": {"token": "dummyToken"}}}`
+			case strings.Contains(string(body), "electricityAgreements"):
+				require.Equal(t, "dummyToken", req.Header.Get("Authorization"))
+				responseBody = `{"data": {"account": {"electricityAgreements": [{"meterPoint": {"meters": ` + meters + `}}]}}}`
+			case strings.Contains(string(body), "smartMeterTelemetry"):
+				require.Contains(t, string(body), "00-11-22")
+				responseBody = `{"data": {"smartMeterTelemetry": [
+					{"readAt": "2025-01-01T00:00:00+00:00", "consumption": "1234500.0"},
+					{"readAt": "2025-01-01T00:10:00+00:00", "consumption": "1234600.0"},
+					{"readAt": "2025-01-01T00:30:00+00:00", "consumption": "1234750.0"},
+					{"readAt": "2025-01-01T01:00:00+00:00", "consumption": null}
+				]}}`
+			default:
+				t.Fatalf("unexpected query %s", body)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+}
+
+func TestGetRegisterReadings(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	rt := registerRoundTripper(t, `[{"serialNumber": "123456789", "smartDevices": [{"deviceId": "00-11-22"}]}]`)
+	service := NewOctopusService(rt, "dummyApiKey")
+	meter := &MeterInfo{SerialNumber: "123456789", Mpan: "987654321"}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	readings, err := service.GetRegisterReadings("A-123", meter, start, start.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, readings, 3, "Readings without a value should be skipped")
+
+	usage := make(UsageStore)
+	service.PopulateRegisterReadings(usage, readings)
+	require.Len(t, usage, 2)
+
+	first := usage[start.Local()]
+	require.NotNil(t, first.OCTO_ImportRegisterKWh)
+	require.InDelta(t, 1234.6, *first.OCTO_ImportRegisterKWh, 1e-9, "Slot should hold its last reading")
+	require.InDelta(t, 1234.75, *usage[start.Add(30*time.Minute).Local()].OCTO_ImportRegisterKWh, 1e-9)
+}
+
+func TestGetRegisterReadingsUnsupportedMeter(t *testing.T) {
+	rt := registerRoundTripper(t, `[{"serialNumber": "123456789", "smartDevices": []}]`)
+	service := NewOctopusService(rt, "dummyApiKey")
+	meter := &MeterInfo{SerialNumber: "123456789", Mpan: "987654321"}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	readings, err := service.GetRegisterReadings("A-123", meter, start, start.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, readings)
+}
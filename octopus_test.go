@@ -2,8 +2,11 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -35,7 +38,7 @@ func TestFetchTariffs(t *testing.T) {
 	start := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
 	end := time.Date(2025, 1, 15, 23, 59, 59, 0, time.UTC)
 
-	tariffs, err := octopusService.FetchTariffs("AGILE-24-10-01", "E-1R-AGILE-24-10-01-M", start, end)
+	tariffs, err := octopusService.FetchTariffs(context.Background(), "AGILE-24-10-01", "E-1R-AGILE-24-10-01-M", start, end)
 	require.NoError(t, err, "Expected no error while fetching tariffs")
 	require.Len(t, tariffs, 1, "Expected 1 tariff")
 	require.Equal(t, 20.769, tariffs[0].Rate, "Unexpected tariff rate")
@@ -116,8 +119,8 @@ func TestGetMetersAndTariff(t *testing.T) {
 				// Response for product list
 				responseBody := `{
 					"results": [
-						{"code": "AGILE-24-10-01"},
-						{"code": "EXPORT-24-10-01"}
+						{"code": "AGILE-24-10-01", "display_name": "Agile Octopus", "full_name": "Agile Octopus October 2024 v1"},
+						{"code": "EXPORT-24-10-01", "full_name": "Outgoing Octopus October 2024"}
 					]
 				}`
 				return &http.Response{
@@ -141,4 +144,340 @@ func TestGetMetersAndTariff(t *testing.T) {
 
 	require.Equal(t, "987654321", exportMeter.Mpan, "Unexpected export meter MPAN")
 	require.Equal(t, "E-1R-EXPORT-24-10-01-M", exportMeter.TariffCode, "Unexpected export tariff code")
+
+	require.Equal(t, "Agile Octopus", importMeter.DisplayName, "Expected the product display name")
+	require.Equal(t, "Outgoing Octopus October 2024", exportMeter.DisplayName, "Expected the full name without a display name")
+}
+
+func TestGetMetersAndTariffMultipleMeterPoints(t *testing.T) {
+	// An old import point whose agreement has ended, the current import point, and an export
+	// register on its own MPAN that isn't flagged is_export but is on an export product
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			var responseBody string
+			switch req.URL.Path {
+			case "/v1/accounts/dummyAccountId":
+				responseBody = `{"properties": [{"electricity_meter_points": [
+					{"mpan": "111", "meters": [{"serial_number": "OLD"}],
+					 "agreements": [{"tariff_code": "E-1R-AGILE-24-10-01-M", "valid_from": "2023-01-01T00:00:00Z", "valid_to": "2024-01-01T00:00:00Z"}]},
+					{"mpan": "222", "meters": [{"serial_number": "EXP"}],
+					 "agreements": [{"tariff_code": "E-1R-EXPORT-24-10-01-M", "valid_from": "2024-01-01T00:00:00Z"}]},
+					{"mpan": "333", "meters": [{"serial_number": "IMP"}],
+					 "agreements": [{"tariff_code": "E-1R-AGILE-24-10-01-M", "valid_from": "2024-01-01T00:00:00Z"}]}
+				]}]}`
+			case "/v1/products/":
+				responseBody = `{"results": [
+					{"code": "AGILE-24-10-01", "direction": "IMPORT"},
+					{"code": "EXPORT-24-10-01", "direction": "EXPORT"}
+				]}`
+			default:
+				return nil, fmt.Errorf("unexpected request %s", req.URL)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(responseBody)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	octopusService := NewOctopusService(mockRoundTripper, "dummyApiKey")
+
+	importMeter, exportMeter, _, err := octopusService.GetMetersAndTariff("dummyAccountId")
+	require.NoError(t, err)
+	require.Equal(t, "333", importMeter.Mpan, "Expected the import point with an active agreement")
+	require.Equal(t, "IMP", importMeter.SerialNumber)
+	require.Equal(t, "222", exportMeter.Mpan, "Expected the export product's point to be export")
+	require.Equal(t, "EXPORT-24-10-01", exportMeter.ProductCode)
+}
+
+func TestGetMetersAndTariffNoMatch(t *testing.T) {
+	importPoint := `{"mpan": "123456789", "meters": [{"serial_number": "SN123"}], "agreements": [{"tariff_code": "E-1R-AGILE-24-10-01-M"}]}`
+	exportPoint := `{"mpan": "987654321", "meters": [{"serial_number": "SN987"}], "agreements": [{"tariff_code": "E-1R-EXPORT-24-10-01-M"}], "is_export": true}`
+
+	tests := []struct {
+		name       string
+		properties string
+		products   string
+		expected   error
+	}{
+		{name: "No properties", properties: `[]`, products: `[{"code": "AGILE-24-10-01"}]`, expected: ErrNoImportMeter},
+		{name: "No meter points", properties: `[{"electricity_meter_points": []}]`, products: `[{"code": "AGILE-24-10-01"}]`, expected: ErrNoImportMeter},
+		{
+			name:       "Import meter point without meters",
+			properties: `[{"electricity_meter_points": [{"mpan": "123456789", "meters": [], "agreements": []}, ` + exportPoint + `]}]`,
+			products:   `[{"code": "AGILE-24-10-01"}, {"code": "EXPORT-24-10-01"}]`,
+			expected:   ErrNoImportMeter,
+		},
+		{
+			name:       "Unknown product",
+			properties: `[{"electricity_meter_points": [` + importPoint + `, ` + exportPoint + `]}]`,
+			products:   `[{"code": "EXPORT-24-10-01"}]`,
+			expected:   ErrNoProductMatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRoundTripper := &MockRoundTripper{
+				Handler: func(req *http.Request) (*http.Response, error) {
+					responseBody := fmt.Sprintf(`{"properties": %s}`, tt.properties)
+					if req.URL.Path == "/v1/products/" {
+						responseBody = fmt.Sprintf(`{"results": %s}`, tt.products)
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+						Header:     make(http.Header),
+					}, nil
+				},
+			}
+
+			octopusService := NewOctopusService(mockRoundTripper, "dummyApiKey")
+			_, _, _, err := octopusService.GetMetersAndTariff("dummyAccountId")
+			require.ErrorIs(t, err, tt.expected)
+			require.Contains(t, err.Error(), "check the")
+		})
+	}
+}
+
+func TestGetMeterConsumptionClockChange(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	tests := []struct {
+		name     string
+		day      time.Time
+		expected int
+	}{
+		{name: "Spring forward", day: time.Date(2024, 3, 31, 0, 0, 0, 0, time.Local), expected: 46},
+		{name: "Fall back", day: time.Date(2024, 10, 27, 0, 0, 0, 0, time.Local), expected: 50},
+		{name: "Ordinary day", day: time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local), expected: 48},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			end := test.day.AddDate(0, 0, 1)
+
+			// One result per UTC half-hour across the local day
+			var results []string
+			for ts := test.day.UTC(); ts.Before(end); ts = ts.Add(30 * time.Minute) {
+				results = append(results, fmt.Sprintf(`{"interval_start": %q, "interval_end": %q, "consumption": 0.5}`,
+					ts.Format(time.RFC3339), ts.Add(30*time.Minute).Format(time.RFC3339)))
+			}
+
+			mockRoundTripper := &MockRoundTripper{
+				Handler: func(req *http.Request) (*http.Response, error) {
+					responseBody := fmt.Sprintf(`{"count": %d, "next": null, "results": [%s]}`, len(results), strings.Join(results, ","))
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+						Header:     make(http.Header),
+					}, nil
+				},
+			}
+
+			octopusService := NewOctopusService(mockRoundTripper, "dummyApiKey")
+			meter := &MeterInfo{SerialNumber: "123456789", Mpan: "987654321"}
+
+			usage := make(map[time.Time]*UsageRow)
+			err := octopusService.GetMeterConsumption(context.Background(), usage, meter, test.day, end, func(value float64, estimated bool, row *UsageRow) {
+				row.OCTO_ImportKWh = &value
+			})
+			require.NoError(t, err)
+			require.Len(t, usage, test.expected, "Unexpected number of distinct buckets")
+			require.Equal(t, test.expected, slotsInDay(test.day, nil), "Unexpected slots in day")
+
+			for timestamp, row := range usage {
+				require.NotNil(t, row.OCTO_ImportKWh, "Empty bucket created at %s", timestamp)
+			}
+		})
+	}
+}
+
+func TestGetMeterConsumptionExcludeEstimates(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	responseBody := `{"count": 3, "next": null, "results": [
+		{"interval_start": "2025-01-01T00:00:00Z", "interval_end": "2025-01-01T00:30:00Z", "consumption": 0.5, "is_estimated": false},
+		{"interval_start": "2025-01-01T00:30:00Z", "interval_end": "2025-01-01T01:00:00Z", "consumption": 0.7, "is_estimated": true},
+		{"interval_start": "2025-01-01T01:00:00Z", "interval_end": "2025-01-01T01:30:00Z", "consumption": 0.9}
+	]}`
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	meter := &MeterInfo{SerialNumber: "123456789", Mpan: "987654321"}
+	update := func(value float64, estimated bool, row *UsageRow) {
+		row.OCTO_ImportKWh = &value
+		row.OCTO_ImportEstimated = estimated
+	}
+
+	tests := []struct {
+		name             string
+		excludeEstimates bool
+		expected         []time.Time
+	}{
+		{name: "Estimates kept", expected: []time.Time{start, start.Add(30 * time.Minute), start.Add(time.Hour)}},
+		{name: "Estimates excluded", excludeEstimates: true, expected: []time.Time{start, start.Add(time.Hour)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			octopusService := NewOctopusService(mockRoundTripper, "dummyApiKey")
+			octopusService.ExcludeEstimates = test.excludeEstimates
+
+			usage := make(map[time.Time]*UsageRow)
+			require.NoError(t, octopusService.GetMeterConsumption(context.Background(), usage, meter, start, start.Add(90*time.Minute), update))
+			require.Len(t, usage, len(test.expected))
+			for _, ts := range test.expected {
+				row, ok := usage[ts.Local()]
+				require.True(t, ok, "Missing row for %s", ts)
+				require.Equal(t, ts.Equal(start.Add(30*time.Minute)), row.OCTO_ImportEstimated, "Unexpected estimate flag for %s", ts)
+			}
+		})
+	}
+}
+
+func TestGetMeterConsumptionZeroVsMissing(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	slot := func(i int) time.Time { return start.Add(time.Duration(i) * 30 * time.Minute).Local() }
+	// A genuine zero, a null consumption, at 01:30 no result at all and at 02:30 a result
+	// without a consumption field
+	responseBody := `{"count": 5, "next": null, "results": [
+		{"interval_start": "2025-01-01T00:00:00Z", "interval_end": "2025-01-01T00:30:00Z", "consumption": 0.0},
+		{"interval_start": "2025-01-01T00:30:00Z", "interval_end": "2025-01-01T01:00:00Z", "consumption": null},
+		{"interval_start": "2025-01-01T01:00:00Z", "interval_end": "2025-01-01T01:30:00Z", "consumption": 0.4},
+		{"interval_start": "2025-01-01T02:00:00Z", "interval_end": "2025-01-01T02:30:00Z", "consumption": 0.6},
+		{"interval_start": "2025-01-01T02:30:00Z", "interval_end": "2025-01-01T03:00:00Z"},
+		{"interval_start": "2025-01-01T03:00:00Z", "interval_end": "2025-01-01T03:30:00Z", "consumption": 0.2}
+	]}`
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(responseBody)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	octopusService := NewOctopusService(mockRoundTripper, "dummyApiKey")
+
+	usage := make(UsageStore)
+	meter := &MeterInfo{SerialNumber: "123456789", Mpan: "987654321"}
+	require.NoError(t, octopusService.GetMeterConsumption(context.Background(), usage, meter, start, start.Add(210*time.Minute), func(value float64, estimated bool, row *UsageRow) {
+		row.OCTO_ImportKWh = &value
+	}))
+
+	require.NotNil(t, usage[slot(0)].OCTO_ImportKWh, "Expected the genuine zero to be kept")
+	require.Equal(t, 0.0, *usage[slot(0)].OCTO_ImportKWh)
+	require.NotContains(t, usage, slot(1), "Expected the null consumption to be left missing")
+	require.NotContains(t, usage, slot(5), "Expected the absent consumption to be left missing rather than zero")
+
+	data := []*UsageRow{usage[slot(0)], usage[slot(2)], usage[slot(4)], usage[slot(6)]}
+	require.Equal(t, []time.Time{slot(1), slot(3), slot(5)}, findGaps(data, GranularityHalfHour), "Expected only the missing slots to be gaps")
+}
+
+func TestGetGasConsumption(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			require.Equal(t, "/v1/gas-meter-points/1234567890/meters/G4A123/consumption/", req.URL.Path)
+			responseBody := `{"count": 2, "next": null, "results": [
+				{"interval_start": "2025-01-01T00:00:00Z", "interval_end": "2025-01-01T00:30:00Z", "consumption": 0.1},
+				{"interval_start": "2025-01-01T00:30:00Z", "interval_end": "2025-01-01T01:00:00Z", "consumption": 0.0}
+			]}`
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(responseBody)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	octopusService := NewOctopusService(mockRoundTripper, "dummyApiKey")
+
+	usage := make(UsageStore)
+	meter := &MeterInfo{SerialNumber: "G4A123", Mpan: "1234567890"}
+	require.NoError(t, octopusService.GetGasConsumption(context.Background(), usage, meter, start, start.Add(time.Hour), func(value float64, estimated bool, row *UsageRow) {
+		kWh := gasKWh(value, GasUnitsM3, 39.5)
+		row.OCTO_GasKWh = &kWh
+	}))
+
+	require.Len(t, usage, 2)
+	require.InDelta(t, 0.1*1.02264*39.5/3.6, *usage[start.Local()].OCTO_GasKWh, 1e-9)
+	require.Equal(t, 0.0, *usage[start.Add(30*time.Minute).Local()].OCTO_GasKWh)
+	require.Equal(t, 2.5, gasKWh(2.5, GasUnitsKWh, 39.5), "Expected kWh readings to be kept as they are")
+}
+
+func TestClampToAvailable(t *testing.T) {
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			require.Equal(t, "1", req.URL.Query().Get("page_size"))
+
+			// Readings run from 2025-01-10 to the slot ending 2025-01-20T12:00:00Z
+			var responseBody string
+			switch req.URL.Query().Get("order_by") {
+			case "period":
+				responseBody = `{"count": 500, "results": [{"interval_start": "2025-01-10T00:00:00Z", "interval_end": "2025-01-10T00:30:00Z", "consumption": 0.2}]}`
+			case "-period":
+				responseBody = `{"count": 500, "results": [{"interval_start": "2025-01-20T11:30:00Z", "interval_end": "2025-01-20T12:00:00Z", "consumption": 0.3}]}`
+			default:
+				t.Fatalf("unexpected order_by %q", req.URL.Query().Get("order_by"))
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(responseBody))),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	octopusService := NewOctopusService(mockRoundTripper, "dummyApiKey")
+	meter := &MeterInfo{SerialNumber: "123456789", Mpan: "987654321"}
+
+	start, end, err := octopusService.ClampToAvailable(meter,
+		time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, "2025-01-10T00:00:00Z", start.Format(time.RFC3339), "Expected start clamped to the first reading")
+	require.Equal(t, "2025-01-20T12:00:00Z", end.Format(time.RFC3339), "Expected end clamped to the last reading")
+
+	// A range within the available data is left alone
+	inStart, inEnd := time.Date(2025, 1, 12, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC)
+	start, end, err = octopusService.ClampToAvailable(meter, inStart, inEnd)
+	require.NoError(t, err)
+	require.Equal(t, inStart, start)
+	require.Equal(t, inEnd, end)
+}
+
+func TestGetMeterConsumptionCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Every page points at another, so only cancellation stops the fetch
+	requests := 0
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			requests++
+			cancel()
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body: io.NopCloser(strings.NewReader(`{"count": 2, "next": "https://api.octopus.energy/next", "results": [
+					{"interval_start": "2025-01-01T00:00:00Z", "interval_end": "2025-01-01T00:30:00Z", "consumption": 0.5}
+				]}`)),
+				Header: make(http.Header),
+			}, nil
+		},
+	}
+	octopusService := NewOctopusService(mockRoundTripper, "dummyApiKey")
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	meter := &MeterInfo{SerialNumber: "123456789", Mpan: "987654321"}
+	err := octopusService.GetMeterConsumption(ctx, make(UsageStore), meter, start, start.Add(24*time.Hour), func(value float64, estimated bool, row *UsageRow) {
+		row.OCTO_ImportKWh = &value
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, requests, "Expected no further pages fetched once cancelled")
 }
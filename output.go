@@ -0,0 +1,164 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// openOutput opens filename for writing. Regular files are written to a temporary file
+// alongside, which Close renames over filename, so an interrupted or failed write leaves any
+// existing file untouched; Abort discards it. Named pipes (FIFOs) and "-", standard output,
+// are written in place, and stream is true so the caller flushes each record as it is written
+// rather than buffering the whole file. Filenames ending .gz are gzip compressed.
+func openOutput(filename string) (w *outputFile, stream bool, err error) {
+	out := &outputFile{}
+	if filename == "-" {
+		out.file = os.Stdout
+		stream = true
+	} else if info, err := os.Stat(filename); err == nil && info.Mode()&os.ModeNamedPipe != 0 {
+		out.file, err = os.OpenFile(filename, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, false, err
+		}
+		stream = true
+	} else {
+		mode := os.FileMode(0644)
+		if err == nil {
+			mode = info.Mode().Perm()
+		}
+		out.file, err = os.CreateTemp(filepath.Dir(filename), ".tmp-*-"+filepath.Base(filename))
+		if err != nil {
+			return nil, false, err
+		}
+		if err := out.file.Chmod(mode); err != nil {
+			out.Abort()
+			return nil, false, err
+		}
+		out.path = filename
+	}
+
+	out.Writer = out.file
+	if isGzip(filename) {
+		out.gzip = gzip.NewWriter(out.file)
+		out.Writer = out.gzip
+	}
+	return out, stream, nil
+}
+
+// openAppend opens the existing file filename for appending in place. Compressed files get a
+// further gzip member, which readers decompress as a continuation of the file.
+func openAppend(filename string) (*outputFile, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, err
+	}
+	out := &outputFile{Writer: file, file: file}
+	if isGzip(filename) {
+		out.gzip = gzip.NewWriter(file)
+		out.Writer = out.gzip
+	}
+	return out, nil
+}
+
+// outputFile is an output opened by openOutput.
+type outputFile struct {
+	io.Writer
+	file *os.File
+	gzip *gzip.Writer
+	path string // the name the temporary file is renamed to, empty when written in place
+	done bool
+
+	// verify, when set, checks the finished temporary file before it is renamed into place
+	verify func(name string) error
+}
+
+// Close finishes the output, writing the gzip footer when compressed, and renames the
+// temporary file into place. Errors discard the temporary file.
+func (o *outputFile) Close() error {
+	if o.done {
+		return nil
+	}
+	if o.gzip != nil {
+		if err := o.gzip.Close(); err != nil {
+			o.Abort()
+			return err
+		}
+	}
+	o.done = true
+	if o.file == os.Stdout {
+		return nil
+	}
+	if err := o.file.Close(); err != nil {
+		o.remove()
+		return err
+	}
+	if o.path == "" {
+		return nil
+	}
+	if o.verify != nil {
+		if err := o.verify(o.file.Name()); err != nil {
+			o.remove()
+			return err
+		}
+	}
+	if err := os.Rename(o.file.Name(), o.path); err != nil {
+		o.remove()
+		return err
+	}
+	return nil
+}
+
+// Abort discards an output that hasn't been closed, leaving any existing file untouched. It
+// does nothing once the output is closed, so it can be deferred.
+func (o *outputFile) Abort() {
+	if o.done {
+		return
+	}
+	o.done = true
+	if o.file != os.Stdout {
+		o.file.Close()
+	}
+	o.remove()
+}
+
+func (o *outputFile) remove() {
+	if o.path != "" {
+		os.Remove(o.file.Name())
+	}
+}
+
+// openInput opens filename for reading, decompressing it when it ends .gz.
+func openInput(filename string) (io.ReadCloser, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !isGzip(filename) {
+		return file, nil
+	}
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &gzipReadFile{Reader: reader, file: file}, nil
+}
+
+func isGzip(filename string) bool {
+	return strings.HasSuffix(filename, ".gz")
+}
+
+// gzipReadFile decompresses reads from the underlying file.
+type gzipReadFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipReadFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
@@ -0,0 +1,57 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCSVToFIFO(t *testing.T) {
+	fifo := filepath.Join(t.TempDir(), "output.fifo")
+	require.NoError(t, syscall.Mkfifo(fifo, 0600))
+
+	type result struct {
+		records [][]string
+		err     error
+	}
+	done := make(chan result)
+	go func() {
+		file, err := os.Open(fifo)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer file.Close()
+		records, err := csv.NewReader(file).ReadAll()
+		done <- result{records, err}
+	}()
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := []*UsageRow{
+		{Timestamp: start, OCTO_ImportKWh: floatPtr(1)},
+		{Timestamp: start.Add(30 * time.Minute), OCTO_ImportKWh: floatPtr(2)},
+	}
+	require.NoError(t, writeCSV(fifo, data, CSVOptions{}))
+
+	select {
+	case r := <-done:
+		require.NoError(t, r.err)
+		require.Len(t, r.records, 3)
+		require.Equal(t, "Timestamp", r.records[0][0])
+		require.Equal(t, start.Format(time.RFC3339), r.records[1][0])
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out reading from fifo")
+	}
+
+	// The fifo is still a fifo rather than having been replaced by a regular file
+	info, err := os.Stat(fifo)
+	require.NoError(t, err)
+	require.NotZero(t, info.Mode()&os.ModeNamedPipe)
+}
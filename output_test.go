@@ -0,0 +1,80 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCSVGzip(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var data []*UsageRow
+	for i := 0; i < 3; i++ {
+		data = append(data, &UsageRow{
+			Timestamp:      start.Add(time.Duration(i) * 30 * time.Minute),
+			OCTO_ImportKWh: floatPtr(float64(i) / 2),
+		})
+	}
+
+	filename := filepath.Join(t.TempDir(), "output.csv.gz")
+	require.NoError(t, writeCSV(filename, data, CSVOptions{}))
+
+	file, err := os.Open(filename)
+	require.NoError(t, err)
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	require.NoError(t, err, "Expected a gzip stream")
+	records, err := csv.NewReader(reader).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	require.Equal(t, "Timestamp", records[0][0])
+	require.Equal(t, "2025-01-01T01:00:00Z", records[3][0])
+
+	// Reading back through readCSV decompresses too
+	rows, err := readCSV(filename, nil)
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	require.InDelta(t, 1, *rows[2].OCTO_ImportKWh, 0.0001)
+}
+
+func TestWriteCSVAtomic(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := []*UsageRow{
+		{Timestamp: start, OCTO_ImportKWh: floatPtr(0.5)},
+		{Timestamp: start.Add(30 * time.Minute), OCTO_ImportKWh: floatPtr(0.7)},
+	}
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "output.csv")
+	require.NoError(t, os.WriteFile(filename, []byte("original\n"), 0640))
+
+	// A run failing part way through its rows never closes the writer
+	w, err := newCSVWriter(filename, CSVOptions{})
+	require.NoError(t, err)
+	require.NoError(t, w.Write(data[:1]))
+	w.file.Abort()
+
+	contents, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, "original\n", string(contents), "Expected the existing file to be untouched")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "Expected the temporary file to be removed")
+
+	// A completed write replaces it, keeping its permissions
+	require.NoError(t, writeCSV(filename, data, CSVOptions{Validate: true}))
+	rows, err := readCSV(filename, nil)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	info, err := os.Stat(filename)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PriceCap is a unit rate and standing charge ceiling applying over [From, To),
+// such as the Energy Price Guarantee.
+type PriceCap struct {
+	From           time.Time
+	To             time.Time
+	UnitRate       float64 // pence per kWh
+	StandingCharge float64 // pence per day
+}
+
+// findPriceCap returns the cap in force at t, or nil if there isn't one.
+func findPriceCap(t time.Time, caps []PriceCap) *PriceCap {
+	for i := range caps {
+		if !t.Before(caps[i].From) && t.Before(caps[i].To) {
+			return &caps[i]
+		}
+	}
+	return nil
+}
+
+// capRate clamps rate to the unit rate cap in force at t, reporting whether it was clamped.
+func capRate(t time.Time, rate *float64, caps []PriceCap) (*float64, bool) {
+	if rate == nil {
+		return nil, false
+	}
+	priceCap := findPriceCap(t, caps)
+	if priceCap == nil || *rate <= priceCap.UnitRate {
+		return rate, false
+	}
+	capped := priceCap.UnitRate
+	return &capped, true
+}
+
+// capStandingCharge clamps a daily standing charge to the cap in force on day.
+func capStandingCharge(day time.Time, charge float64, caps []PriceCap) float64 {
+	if priceCap := findPriceCap(day, caps); priceCap != nil && charge > priceCap.StandingCharge {
+		return priceCap.StandingCharge
+	}
+	return charge
+}
+
+// parsePriceCaps parses a comma separated list of caps in the form
+// from:to:unitRate:standingCharge, where from and to are dates (YYYY-MM-DD) in loc, or local
+// dates when nil, and to is exclusive.
+func parsePriceCaps(s string, loc *time.Location) ([]PriceCap, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	var caps []PriceCap
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("invalid price cap %q, expected from:to:unitRate:standingCharge", entry)
+		}
+		from, err := time.ParseInLocation("2006-01-02", parts[0], loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price cap start %q: %w", parts[0], err)
+		}
+		to, err := time.ParseInLocation("2006-01-02", parts[1], loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price cap end %q: %w", parts[1], err)
+		}
+		unitRate, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price cap unit rate %q: %w", parts[2], err)
+		}
+		standingCharge, err := strconv.ParseFloat(parts[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price cap standing charge %q: %w", parts[3], err)
+		}
+		caps = append(caps, PriceCap{From: from, To: to, UnitRate: unitRate, StandingCharge: standingCharge})
+	}
+	return caps, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapRate(t *testing.T) {
+	caps, err := parsePriceCaps("2022-10-01:2023-07-01:34.0:46.36", nil)
+	require.NoError(t, err)
+	require.Len(t, caps, 1)
+
+	tariffs := []TariffData{{Rate: 40.0}}
+
+	tests := []struct {
+		name         string
+		time         time.Time
+		expectRate   float64
+		expectCapped bool
+	}{
+		{name: "Before cap period", time: time.Date(2022, 9, 30, 23, 30, 0, 0, time.Local), expectRate: 40.0},
+		{name: "Within cap period", time: time.Date(2023, 1, 15, 12, 0, 0, 0, time.Local), expectRate: 34.0, expectCapped: true},
+		{name: "After cap period", time: time.Date(2023, 7, 1, 0, 0, 0, 0, time.Local), expectRate: 40.0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rate, capped := capRate(test.time, findRateForTime(test.time, tariffs), caps)
+			require.NotNil(t, rate)
+			require.Equal(t, test.expectRate, *rate)
+			require.Equal(t, test.expectCapped, capped)
+		})
+	}
+
+	// Rates already below the cap are left alone
+	rate, capped := capRate(time.Date(2023, 1, 15, 12, 0, 0, 0, time.Local), floatPtr(20), caps)
+	require.False(t, capped)
+	require.Equal(t, 20.0, *rate)
+
+	// Missing rates stay missing
+	rate, capped = capRate(time.Date(2023, 1, 15, 12, 0, 0, 0, time.Local), nil, caps)
+	require.Nil(t, rate)
+	require.False(t, capped)
+
+	require.Equal(t, 46.36, capStandingCharge(time.Date(2023, 1, 15, 0, 0, 0, 0, time.Local), 60, caps))
+	require.Equal(t, 60.0, capStandingCharge(time.Date(2023, 8, 1, 0, 0, 0, 0, time.Local), 60, caps))
+}
+
+func TestParsePriceCapsInvalid(t *testing.T) {
+	_, err := parsePriceCaps("2022-10-01:2023-07-01:34.0", nil)
+	require.Error(t, err)
+
+	_, err = parsePriceCaps("2022-10-01:July:34.0:46.36", nil)
+	require.Error(t, err)
+}
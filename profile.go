@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RetryProfile is a coherent combination of the retry, timeout and concurrency settings.
+type RetryProfile struct {
+	// Retries is how many times a GET answered by 429 Too Many Requests or a server error is retried.
+	Retries int
+	// RetryBackoff is the wait before the first retry, doubling for each further retry.
+	RetryBackoff time.Duration
+	// RequestTimeout bounds the wait for each response's headers, 0 waiting indefinitely.
+	RequestTimeout time.Duration
+	// HTTPTimeout bounds each attempt, from sending it to reading the whole response, 0 waiting
+	// indefinitely.
+	HTTPTimeout time.Duration
+	// AccountConcurrency is how many GeoAccounts are fetched at once.
+	AccountConcurrency int
+	// GivConcurrency is how many days of GivEnergy inverter data are fetched at once.
+	GivConcurrency int
+}
+
+// retryProfiles are the named presets, the individual settings overriding them.
+var retryProfiles = map[string]RetryProfile{
+	// conservative is for flaky connections and shared API limits: patient retries, a generous
+	// timeout and one account and day at a time.
+	"conservative": {Retries: 4, RetryBackoff: 5 * time.Second, RequestTimeout: 2 * time.Minute, HTTPTimeout: 5 * time.Minute, AccountConcurrency: 1, GivConcurrency: 1},
+	// default is the behaviour without a profile.
+	"default": {Retries: defaultRetries, RetryBackoff: defaultRetryBackoff, RequestTimeout: 0, HTTPTimeout: 30 * time.Second, AccountConcurrency: 4, GivConcurrency: 4},
+	// aggressive fails fast: a single quick retry, a short timeout and more accounts and days at once.
+	"aggressive": {Retries: 1, RetryBackoff: time.Second, RequestTimeout: 30 * time.Second, HTTPTimeout: 30 * time.Second, AccountConcurrency: 8, GivConcurrency: 8},
+}
+
+// parseRetryProfile returns the named preset, the default when name is empty.
+func parseRetryProfile(name string) (RetryProfile, error) {
+	if name == "" {
+		name = "default"
+	}
+	profile, ok := retryProfiles[name]
+	if !ok {
+		names := make([]string, 0, len(retryProfiles))
+		for name := range retryProfiles {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return RetryProfile{}, fmt.Errorf("unknown retry profile %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// rateIndex looks up the tariff interval covering a time by binary search rather than the
+// linear scan of findTariffForTime, for pricing many slots against many intervals, such as a
+// year of Agile rates.
+type rateIndex struct {
+	intervals []TariffData
+	order     []int       // indexes into intervals, sorted by ValidFrom then slice order
+	reach     []time.Time // latest ValidTo of order[:i+1], so the search can stop early
+}
+
+// openEnded stands in for a nil ValidTo in rateIndex.reach.
+var openEnded = time.Unix(1<<62, 0)
+
+// buildRateIndex indexes intervals by ValidFrom. intervals must not change while it's in use.
+func buildRateIndex(intervals []TariffData) *rateIndex {
+	order := make([]int, len(intervals))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return intervals[order[j]].startsAfter(&intervals[order[i]]) })
+
+	reach := make([]time.Time, len(order))
+	for i, o := range order {
+		to := openEnded
+		if intervals[o].ValidTo != nil {
+			to = *intervals[o].ValidTo
+		}
+		if i > 0 && reach[i-1].After(to) {
+			to = reach[i-1]
+		}
+		reach[i] = to
+	}
+	return &rateIndex{intervals: intervals, order: order, reach: reach}
+}
+
+// find returns the interval covering t, or nil if there isn't one, matching findTariffForTime:
+// where intervals overlap the most recent ValidFrom wins, then the first in slice order.
+func (idx *rateIndex) find(t time.Time) *TariffData {
+	// The first interval starting after t; every candidate is before it
+	n := sort.Search(len(idx.order), func(i int) bool {
+		from := idx.intervals[idx.order[i]].ValidFrom
+		return from != nil && from.After(t)
+	})
+
+	var match *TariffData
+	for i := n - 1; i >= 0 && t.Before(idx.reach[i]); i-- {
+		iv := &idx.intervals[idx.order[i]]
+		if match != nil && !sameStart(iv, match) {
+			break
+		}
+		if iv.covers(t) {
+			// Keep going back through intervals with the same start for the first in slice order
+			match = iv
+		}
+	}
+	return match
+}
+
+// rate returns the rate of the interval covering t, or nil if there isn't one.
+func (idx *rateIndex) rate(t time.Time) *float64 {
+	if tariff := idx.find(t); tariff != nil {
+		return &tariff.Rate
+	}
+	return nil
+}
+
+// sameStart reports whether a and b have the same ValidFrom, both nil counting as the same.
+func sameStart(a, b *TariffData) bool {
+	if a.ValidFrom == nil || b.ValidFrom == nil {
+		return a.ValidFrom == nil && b.ValidFrom == nil
+	}
+	return a.ValidFrom.Equal(*b.ValidFrom)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// agileYear returns a year of half-hourly rates from start, as an Agile tariff publishes them.
+func agileYear(start time.Time) []TariffData {
+	var rates []TariffData
+	for ts := start; ts.Before(start.AddDate(1, 0, 0)); ts = ts.Add(30 * time.Minute) {
+		from, to := ts, ts.Add(30*time.Minute)
+		rates = append(rates, TariffData{Rate: float64(ts.Hour()) + float64(ts.Minute())/100, ValidFrom: &from, ValidTo: &to})
+	}
+	// Newest first, as the API returns them
+	for i, j := 0, len(rates)-1; i < j; i, j = i+1, j-1 {
+		rates[i], rates[j] = rates[j], rates[i]
+	}
+	return rates
+}
+
+func TestRateIndexMatchesLinearSearch(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) *time.Time {
+		ts := start.Add(time.Duration(hours) * time.Hour)
+		return &ts
+	}
+
+	// Random overlapping, open ended, duplicate-start and gapped intervals
+	r := rand.New(rand.NewSource(1))
+	var intervals []TariffData
+	for i := 0; i < 200; i++ {
+		iv := TariffData{Rate: float64(i)}
+		if r.Intn(20) > 0 {
+			iv.ValidFrom = at(r.Intn(500))
+		}
+		if r.Intn(20) > 0 {
+			from := 0
+			if iv.ValidFrom != nil {
+				from = int(iv.ValidFrom.Sub(start) / time.Hour)
+			}
+			iv.ValidTo = at(from + 1 + r.Intn(24))
+		}
+		intervals = append(intervals, iv)
+	}
+
+	index := buildRateIndex(intervals)
+	for ts := start.Add(-time.Hour); ts.Before(start.Add(600 * time.Hour)); ts = ts.Add(15 * time.Minute) {
+		require.Same(t, findTariffForTime(ts, intervals), index.find(ts), "Mismatch at %s", ts)
+	}
+
+	agile := agileYear(start)
+	index = buildRateIndex(agile)
+	for ts := start.Add(-time.Hour); ts.Before(start.AddDate(1, 0, 1)); ts = ts.Add(7 * time.Hour) {
+		require.Same(t, findTariffForTime(ts, agile), index.find(ts), "Mismatch at %s", ts)
+		require.Equal(t, findRateForTime(ts, agile), index.rate(ts), "Mismatch at %s", ts)
+	}
+	require.Nil(t, buildRateIndex(nil).find(start))
+}
+
+func BenchmarkFindTariffForTime(b *testing.B) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	rates := agileYear(start)
+
+	// Price a week of slots against a year of rates
+	b.Run("linear", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for ts := start; ts.Before(start.AddDate(0, 0, 7)); ts = ts.Add(30 * time.Minute) {
+				findTariffForTime(ts, rates)
+			}
+		}
+	})
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			index := buildRateIndex(rates)
+			for ts := start; ts.Before(start.AddDate(0, 0, 7)); ts = ts.Add(30 * time.Minute) {
+				index.find(ts)
+			}
+		}
+	})
+}
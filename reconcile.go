@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// maxLoggedDivergences limits how many individual divergent slots are logged.
+const maxLoggedDivergences = 10
+
+// Divergence is a slot where the GivEnergy and Octopus import figures disagree.
+type Divergence struct {
+	Timestamp time.Time
+	GivEnergy float64
+	Octopus   float64
+	Diff      float64 // Octopus - GivEnergy, kWh
+}
+
+// ReconcileReport summarises the per-slot comparison of GivEnergy and Octopus import.
+type ReconcileReport struct {
+	Compared     int
+	Flagged      []Divergence
+	TotalAbsDiff float64 // kWh, across the flagged slots only
+}
+
+// reconcileImport compares GivEnergy and Octopus import for every slot where both are present.
+// Differences no larger than tolerance (kWh) are treated as matching and are not flagged.
+func reconcileImport(data []*UsageRow, tolerance float64) ReconcileReport {
+	var report ReconcileReport
+	for _, row := range data {
+		if row.GE_ImportKWh == nil || row.OCTO_ImportKWh == nil {
+			continue
+		}
+		report.Compared++
+
+		diff := *row.OCTO_ImportKWh - *row.GE_ImportKWh
+		if math.Abs(diff) <= tolerance {
+			continue
+		}
+		report.Flagged = append(report.Flagged, Divergence{
+			Timestamp: row.Timestamp,
+			GivEnergy: *row.GE_ImportKWh,
+			Octopus:   *row.OCTO_ImportKWh,
+			Diff:      diff,
+		})
+		report.TotalAbsDiff += math.Abs(diff)
+	}
+	return report
+}
+
+// importDelta returns how much more import Octopus metered than the GivEnergy inverter measured
+// for the slot, in kWh, or nil unless both are present. A delta that grows over time points at
+// CT clamp drift.
+func importDelta(row *UsageRow) *float64 {
+	if row.GE_ImportKWh == nil || row.OCTO_ImportKWh == nil {
+		return nil
+	}
+	delta := *row.OCTO_ImportKWh - *row.GE_ImportKWh
+	return &delta
+}
+
+// importDeltaPercent returns importDelta as a percentage of the Octopus import, or nil when
+// either is missing or Octopus metered no import.
+func importDeltaPercent(row *UsageRow) *float64 {
+	delta := importDelta(row)
+	if delta == nil || *row.OCTO_ImportKWh == 0 {
+		return nil
+	}
+	percent := *delta / *row.OCTO_ImportKWh * 100
+	return &percent
+}
+
+// logReconcileReport writes the reconciliation summary and the first few flagged slots to the log.
+func logReconcileReport(report ReconcileReport, tolerance float64) {
+	slog.Info("Reconciliation", "divergent", len(report.Flagged), "compared", report.Compared,
+		"toleranceKWh", tolerance, "totalDiffKWh", fmt.Sprintf("%.4f", report.TotalAbsDiff))
+	for i, d := range report.Flagged {
+		if i == maxLoggedDivergences {
+			slog.Warn("more divergent slots", "count", len(report.Flagged)-maxLoggedDivergences)
+			break
+		}
+		slog.Warn("GivEnergy and Octopus import diverge", "slot", d.Timestamp.Format(time.RFC3339),
+			"givEnergyKWh", fmt.Sprintf("%.4f", d.GivEnergy), "octopusKWh", fmt.Sprintf("%.4f", d.Octopus), "diffKWh", fmt.Sprintf("%+.4f", d.Diff))
+	}
+}
+
+// TotalCheck compares the summed Octopus import with an expected total, such as the billed kWh.
+type TotalCheck struct {
+	Expected float64 // kWh
+	Actual   float64 // kWh
+	Diff     float64 // Actual - Expected, kWh
+	Pass     bool
+}
+
+// validateTotal sums the Octopus import in data and checks it is within tolerance (kWh) of expected.
+func validateTotal(data []*UsageRow, expected, tolerance float64) TotalCheck {
+	check := TotalCheck{Expected: expected}
+	for _, row := range data {
+		if row.OCTO_ImportKWh != nil {
+			check.Actual += *row.OCTO_ImportKWh
+		}
+	}
+	check.Diff = check.Actual - expected
+	check.Pass = math.Abs(check.Diff) <= tolerance
+	return check
+}
+
+// logTotalCheck writes the result of a total check to the log.
+func logTotalCheck(check TotalCheck, tolerance float64) {
+	level, result := slog.LevelInfo, "PASS"
+	if !check.Pass {
+		level, result = slog.LevelError, "FAIL"
+	}
+	slog.Log(context.Background(), level, "Total validation "+result, "octopusKWh", fmt.Sprintf("%.3f", check.Actual),
+		"expectedKWh", fmt.Sprintf("%.3f", check.Expected), "diffKWh", fmt.Sprintf("%+.3f", check.Diff), "toleranceKWh", tolerance)
+}
+
+// CostDivergence is a day where pricing the GivEnergy import gives a different cost to
+// pricing the Octopus import.
+type CostDivergence struct {
+	Day       time.Time
+	GivEnergy float64 // pence
+	Octopus   float64 // pence
+	Diff      float64 // Octopus - GivEnergy, pence
+}
+
+// reconcileCost prices the GivEnergy and Octopus import of each local day at the slot import
+// price, over the slots where both are present, and returns the days whose costs differ by
+// more than tolerance (pence). Slot costs are rounded by mode as in the CSV. As both use the
+// same price, a divergence points at metering rather than pricing.
+func reconcileCost(data []*UsageRow, tolerance float64, mode RoundingMode, loc *time.Location) []CostDivergence {
+	byDay := make(map[time.Time]*CostDivergence)
+	var days []time.Time
+	for _, row := range data {
+		geCost := costPence(row.GE_ImportKWh, row.ImportPrice, mode, defaultPrecision[GroupCost])
+		octoCost := costPence(row.OCTO_ImportKWh, row.ImportPrice, mode, defaultPrecision[GroupCost])
+		if geCost == nil || octoCost == nil {
+			continue
+		}
+		day := truncateToMidnight(inZone(row.Timestamp, loc))
+		d, ok := byDay[day]
+		if !ok {
+			d = &CostDivergence{Day: day}
+			byDay[day] = d
+			days = append(days, day)
+		}
+		d.GivEnergy += *geCost
+		d.Octopus += *octoCost
+	}
+
+	var flagged []CostDivergence
+	for _, day := range days {
+		d := byDay[day]
+		d.Diff = d.Octopus - d.GivEnergy
+		if math.Abs(d.Diff) > tolerance {
+			flagged = append(flagged, *d)
+		}
+	}
+	return flagged
+}
+
+// logCostReconciliation writes the days whose GivEnergy and Octopus costs diverge to the log.
+func logCostReconciliation(flagged []CostDivergence, tolerance float64) {
+	slog.Info("Cost reconciliation", "divergentDays", len(flagged), "tolerance", fmt.Sprintf("%.2fp", tolerance))
+	for i, d := range flagged {
+		if i == maxLoggedDivergences {
+			slog.Warn("more divergent days", "count", len(flagged)-maxLoggedDivergences)
+			break
+		}
+		slog.Warn("GivEnergy and Octopus import costs diverge", "day", d.Day.Format(time.DateOnly),
+			"givEnergy", fmt.Sprintf("%.2fp", d.GivEnergy), "octopus", fmt.Sprintf("%.2fp", d.Octopus), "diff", fmt.Sprintf("%+.2fp", d.Diff))
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcileImportTolerance(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := []*UsageRow{
+		{Timestamp: start, GE_ImportKWh: floatPtr(1.000), OCTO_ImportKWh: floatPtr(1.001)},                       // rounding noise
+		{Timestamp: start.Add(30 * time.Minute), GE_ImportKWh: floatPtr(1.000), OCTO_ImportKWh: floatPtr(0.999)}, // rounding noise
+		{Timestamp: start.Add(60 * time.Minute), GE_ImportKWh: floatPtr(1.000), OCTO_ImportKWh: floatPtr(1.250)}, // real divergence
+		{Timestamp: start.Add(90 * time.Minute), GE_ImportKWh: floatPtr(1.000)},                                  // not comparable
+	}
+
+	report := reconcileImport(data, 0.002)
+	require.Equal(t, 3, report.Compared)
+	require.Len(t, report.Flagged, 1)
+	require.Equal(t, start.Add(60*time.Minute), report.Flagged[0].Timestamp)
+	require.InDelta(t, 0.25, report.Flagged[0].Diff, 1e-9)
+	require.InDelta(t, 0.25, report.TotalAbsDiff, 1e-9)
+
+	// Without a tolerance every difference is flagged
+	report = reconcileImport(data, 0)
+	require.Len(t, report.Flagged, 3)
+}
+
+func TestValidateTotal(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := []*UsageRow{
+		{Timestamp: start, OCTO_ImportKWh: floatPtr(1.25)},
+		{Timestamp: start.Add(30 * time.Minute), OCTO_ImportKWh: floatPtr(0.5)},
+		{Timestamp: start.Add(60 * time.Minute)}, // gap
+		{Timestamp: start.Add(90 * time.Minute), OCTO_ImportKWh: floatPtr(2.25)},
+	}
+
+	check := validateTotal(data, 4.05, 0.1)
+	require.True(t, check.Pass)
+	require.InDelta(t, 4.0, check.Actual, 1e-9)
+	require.InDelta(t, -0.05, check.Diff, 1e-9)
+
+	check = validateTotal(data, 5, 0.1)
+	require.False(t, check.Pass)
+	require.InDelta(t, -1.0, check.Diff, 1e-9)
+}
+
+func TestReconcileCost(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	next := day.AddDate(0, 0, 1)
+	data := []*UsageRow{
+		// GivEnergy under-reads by 0.5 kWh at 20p on the first day
+		{Timestamp: day, GE_ImportKWh: floatPtr(1.0), OCTO_ImportKWh: floatPtr(1.5), ImportPrice: floatPtr(20)},
+		{Timestamp: day.Add(30 * time.Minute), GE_ImportKWh: floatPtr(2.0), OCTO_ImportKWh: floatPtr(2.0), ImportPrice: floatPtr(30)},
+		{Timestamp: day.Add(60 * time.Minute), OCTO_ImportKWh: floatPtr(4.0), ImportPrice: floatPtr(30)}, // not comparable
+		// The second day matches
+		{Timestamp: next, GE_ImportKWh: floatPtr(1.0), OCTO_ImportKWh: floatPtr(1.0), ImportPrice: floatPtr(20)},
+	}
+
+	flagged := reconcileCost(data, 1, RoundHalfUp, nil)
+	require.Len(t, flagged, 1)
+	require.Equal(t, day, flagged[0].Day)
+	require.InDelta(t, 80, flagged[0].GivEnergy, 1e-9)
+	require.InDelta(t, 90, flagged[0].Octopus, 1e-9)
+	require.InDelta(t, 10, flagged[0].Diff, 1e-9)
+
+	require.Empty(t, reconcileCost(data, 10, RoundHalfUp, nil), "Expected the divergence within tolerance")
+}
+
+func TestImportDeltaColumns(t *testing.T) {
+	rows := []*UsageRow{
+		{GE_ImportKWh: floatPtr(1.0), OCTO_ImportKWh: floatPtr(1.25)},
+		{GE_ImportKWh: floatPtr(0.5)},   // Octopus missing
+		{OCTO_ImportKWh: floatPtr(0.5)}, // GivEnergy missing
+		{GE_ImportKWh: floatPtr(0.1), OCTO_ImportKWh: floatPtr(0)},
+	}
+
+	require.InDelta(t, 0.25, *importDelta(rows[0]), 1e-9)
+	require.InDelta(t, 20, *importDeltaPercent(rows[0]), 1e-9)
+	for _, row := range rows[1:3] {
+		require.Nil(t, importDelta(row))
+		require.Nil(t, importDeltaPercent(row))
+	}
+	require.InDelta(t, -0.1, *importDelta(rows[3]), 1e-9)
+	require.Nil(t, importDeltaPercent(rows[3]), "Expected no percentage of zero import")
+
+	values := map[string][]string{}
+	for _, column := range csvColumns(CSVOptions{IncludeImportDelta: true}) {
+		for _, row := range rows {
+			values[column.Name] = append(values[column.Name], column.Value(row))
+		}
+	}
+	require.Equal(t, []string{"0.250000", "NaN", "NaN", "-0.100000"}, values["Import_Delta_KWh"])
+	require.Equal(t, []string{"20.00", "NaN", "NaN", "NaN"}, values["Import_Delta_Percent"])
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// gspRegions are the grid supply point group letters used as the tariff code suffix.
+var gspRegions = []string{"A", "B", "C", "D", "E", "F", "G", "H", "J", "K", "L", "M", "N", "P"}
+
+// parseRegions parses comma separated region letters, e.g. "A,C".
+func parseRegions(value string) ([]string, error) {
+	var regions []string
+	for _, region := range strings.Split(value, ",") {
+		region = strings.ToUpper(strings.TrimSpace(region))
+		if region == "" {
+			continue
+		}
+		if !slices.Contains(gspRegions, region) {
+			return nil, fmt.Errorf("unknown region %q, expected one of %s", region, strings.Join(gspRegions, ""))
+		}
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
+
+// regionTariffCode returns tariffCode, e.g. E-1R-AGILE-24-10-01-M, for a different region.
+func regionTariffCode(tariffCode, region string) (string, error) {
+	i := strings.LastIndex(tariffCode, "-")
+	if i < 0 || !slices.Contains(gspRegions, tariffCode[i+1:]) {
+		return "", fmt.Errorf("tariff code %q has no region suffix", tariffCode)
+	}
+	return tariffCode[:i+1] + region, nil
+}
+
+// RegionCost is what the Octopus import would have cost at a region's unit rates.
+type RegionCost struct {
+	Region   string
+	KWh      float64
+	Cost     float64 // pence, unit rates only
+	Unpriced int     // slots with import but no rate
+}
+
+// regionCosts prices the Octopus import in data at each region's unit rates, in region order.
+func regionCosts(data []*UsageRow, tariffs map[string][]TariffData) []RegionCost {
+	var costs []RegionCost
+	for region, rates := range tariffs {
+		cost := RegionCost{Region: region}
+		index := buildRateIndex(rates)
+		for _, row := range data {
+			if row.OCTO_ImportKWh == nil {
+				continue
+			}
+			rate := index.rate(row.Timestamp)
+			if rate == nil {
+				cost.Unpriced++
+				continue
+			}
+			cost.KWh += *row.OCTO_ImportKWh
+			cost.Cost += *row.OCTO_ImportKWh * *rate
+		}
+		costs = append(costs, cost)
+	}
+	slices.SortFunc(costs, func(a, b RegionCost) int { return strings.Compare(a.Region, b.Region) })
+	return costs
+}
+
+// fetchRegionTariffs fetches the import product's unit rates for each region.
+func (app *App) fetchRegionTariffs(ctx context.Context, regions []string, start, end time.Time) (map[string][]TariffData, error) {
+	tariffs := make(map[string][]TariffData)
+	for _, region := range regions {
+		tariffCode, err := regionTariffCode(app.ImportMeter.TariffCode, region)
+		if err != nil {
+			return nil, err
+		}
+		rates, err := app.OctopusService.FetchTariffs(ctx, app.ImportMeter.ProductCode, tariffCode, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s tariffs: %w", tariffCode, err)
+		}
+		tariffs[region] = rates
+	}
+	return tariffs, nil
+}
+
+// logRegionComparison writes what the import would have cost in each region.
+func logRegionComparison(productCode string, costs []RegionCost) {
+	for _, cost := range costs {
+		attrs := []any{"product", productCode, "region", cost.Region, "kWh", fmt.Sprintf("%.3f", cost.KWh), "cost", fmt.Sprintf("%.2fp", cost.Cost)}
+		if cost.KWh > 0 {
+			attrs = append(attrs, "rate", fmt.Sprintf("%.4fp/kWh", cost.Cost/cost.KWh))
+		}
+		if cost.Unpriced > 0 {
+			attrs = append(attrs, "slotsWithoutRate", cost.Unpriced)
+		}
+		slog.Info("Region comparison", attrs...)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegionCosts(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var data []*UsageRow
+	for i := 0; i < 4; i++ {
+		data = append(data, &UsageRow{Timestamp: start.Add(time.Duration(i) * 30 * time.Minute), OCTO_ImportKWh: floatPtr(0.5)})
+	}
+	data = append(data, &UsageRow{Timestamp: start.Add(2 * time.Hour)}) // no import
+
+	halfHourly := func(rates ...float64) []TariffData {
+		var tariffs []TariffData
+		for i, rate := range rates {
+			from := start.Add(time.Duration(i) * 30 * time.Minute)
+			tariffs = append(tariffs, TariffData{Rate: rate, ValidFrom: ptrTime(from), ValidTo: ptrTime(from.Add(30 * time.Minute))})
+		}
+		return tariffs
+	}
+
+	costs := regionCosts(data, map[string][]TariffData{
+		"M": halfHourly(10, 20, 30, 40),
+		"C": halfHourly(20, 20, 20), // missing the last slot
+	})
+	require.Equal(t, []RegionCost{
+		{Region: "C", KWh: 1.5, Cost: 30, Unpriced: 1},
+		{Region: "M", KWh: 2, Cost: 50},
+	}, costs)
+}
+
+func TestRegionTariffCode(t *testing.T) {
+	code, err := regionTariffCode("E-1R-AGILE-24-10-01-M", "C")
+	require.NoError(t, err)
+	require.Equal(t, "E-1R-AGILE-24-10-01-C", code)
+
+	_, err = regionTariffCode("AGILE-24-10-01", "C")
+	require.Error(t, err)
+
+	regions, err := parseRegions("a, C")
+	require.NoError(t, err)
+	require.Equal(t, []string{"A", "C"}, regions)
+	_, err = parseRegions("I")
+	require.Error(t, err)
+}
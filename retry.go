@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultRetries is how many times RetryingRoundTripper retries a request unless configured.
+const defaultRetries = 2
+
+// defaultRetryBackoff is the wait before the first retry unless configured.
+const defaultRetryBackoff = 2 * time.Second
+
+// retrySleep waits between retries. Replaced in tests.
+var retrySleep = sleepContext
+
+// sleepContext waits for d, returning early with the context's error when it is cancelled.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// RetryingRoundTripper retries idempotent requests answered by 429 Too Many Requests or a
+// server error, waiting Backoff before the first retry and doubling it for each further one,
+// or as long as the response's Retry-After header asks.
+type RetryingRoundTripper struct {
+	// Next is the transport requests are made with.
+	Next http.RoundTripper
+	// Retries is how many times a request is retried before its last response is returned.
+	Retries int
+	// Backoff is the wait before the first retry without a Retry-After header.
+	Backoff time.Duration
+}
+
+func (t *RetryingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.Next.RoundTrip(req)
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := t.Next.RoundTrip(req)
+		if err != nil || attempt == t.Retries || !retryable(resp.StatusCode) {
+			return resp, err
+		}
+		wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if !ok {
+			wait = t.Backoff << attempt
+		}
+		resp.Body.Close()
+
+		slog.Warn("request failed, retrying", "method", req.Method, "url", req.URL.Redacted(), "status", resp.Status, "wait", wait)
+		if err := retrySleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryable reports whether a response status is worth retrying.
+func retryable(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// parseRetryAfter parses a Retry-After header, either a number of seconds or an HTTP date,
+// into the wait from now. ok is false when the header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) (wait time.Duration, ok bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryingRoundTripper(t *testing.T) {
+	var waits []time.Duration
+	oldSleep := retrySleep
+	t.Cleanup(func() { retrySleep = oldSleep })
+	retrySleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	calls := 0
+	mock := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			calls++
+			header := make(http.Header)
+			if calls == 1 {
+				header.Set("Retry-After", "3")
+			}
+			if calls <= 2 {
+				return &http.Response{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests",
+					Body: io.NopCloser(strings.NewReader("slow down")), Header: header}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("ok")), Header: header}, nil
+		},
+	}
+	client := &http.Client{Transport: &RetryingRoundTripper{Next: mock, Retries: 3, Backoff: time.Second}}
+
+	resp, err := client.Get("https://api.example/readings")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 3, calls)
+	require.Equal(t, []time.Duration{3 * time.Second, 2 * time.Second}, waits, "Expected Retry-After honoured, then the doubled backoff")
+
+	// Once the retries are used up the last response is returned
+	calls, waits = 0, nil
+	client.Transport = &RetryingRoundTripper{Next: mock, Retries: 1, Backoff: time.Second}
+	resp, err = client.Get("https://api.example/readings")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, 2, calls)
+
+	// Requests that aren't idempotent aren't retried
+	calls = 0
+	resp, err = client.Post("https://api.example/login", "application/json", strings.NewReader("{}"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, 1, calls)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	wait, ok := parseRetryAfter("120", now)
+	require.True(t, ok)
+	require.Equal(t, 2*time.Minute, wait)
+
+	wait, ok = parseRetryAfter("Wed, 01 Jan 2025 12:00:30 GMT", now)
+	require.True(t, ok)
+	require.Equal(t, 30*time.Second, wait)
+
+	_, ok = parseRetryAfter("soon", now)
+	require.False(t, ok)
+	_, ok = parseRetryAfter("", now)
+	require.False(t, ok)
+}
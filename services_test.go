@@ -3,6 +3,11 @@ package main
 
 import (
 	"net/http"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/require"
 )
 
 // MockRoundTripper is a mock implementation of http.RoundTripper.
@@ -13,3 +18,13 @@ type MockRoundTripper struct {
 func (m *MockRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	return m.Handler(req)
 }
+
+// withLocation sets time.Local to the named zone for the duration of the test.
+func withLocation(t *testing.T, name string) {
+	loc, err := time.LoadLocation(name)
+	require.NoError(t, err)
+
+	orig := time.Local
+	time.Local = loc
+	t.Cleanup(func() { time.Local = orig })
+}
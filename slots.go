@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// inZone returns t in loc, the zone output is bucketed and written in, or in local time when
+// loc is nil.
+func inZone(t time.Time, loc *time.Location) time.Time {
+	if loc == nil {
+		return t.Local()
+	}
+	return t.In(loc)
+}
+
+// halfHourSlot returns the start of the half-hour slot containing t, in loc.
+// Truncation is done on the absolute instant rather than the wall clock so the two
+// 01:00-01:30 slots on the autumn clock change remain distinct buckets.
+func halfHourSlot(t time.Time, loc *time.Location) time.Time {
+	return inZone(t.Truncate(30*time.Minute), loc)
+}
+
+// slotsInDay returns the number of half-hour slots in the day in loc containing t.
+// This is 48 on most days, 46 when the clocks go forward and 50 when they go back.
+func slotsInDay(t time.Time, loc *time.Location) int {
+	start := truncateToMidnight(inZone(t, loc))
+	end := start.AddDate(0, 0, 1)
+	return int(end.Sub(start) / (30 * time.Minute))
+}
+
+// logClockChanges reports the days in loc in slots whose length is not 24 hours,
+// along with how many distinct half-hour buckets were actually received for them.
+func logClockChanges(source string, slots map[time.Time]bool, loc *time.Location) {
+	received := make(map[time.Time]int)
+	for t := range slots {
+		received[truncateToMidnight(inZone(t, loc))]++
+	}
+
+	for day, count := range received {
+		if expected := slotsInDay(day, loc); expected != 48 {
+			slog.Info("Data spans a clock change", "source", source, "day", day.Format("2006-01-02"),
+				"expectedHalfHours", expected, "received", count)
+		}
+	}
+}
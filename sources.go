@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Names of the data sources that can provide a figure for a slot.
+const (
+	SourceOctopus   = "octopus"
+	SourceGeo       = "geo"
+	SourceGivEnergy = "givenergy"
+)
+
+// DefaultImportPriority is the order in which import figures are preferred for pricing.
+var DefaultImportPriority = []string{SourceOctopus, SourceGeo, SourceGivEnergy}
+
+// importFromSource returns the import kWh reported by source for the row, or nil if absent.
+func importFromSource(row *UsageRow, source string) *float64 {
+	switch source {
+	case SourceOctopus:
+		return row.OCTO_ImportKWh
+	case SourceGeo:
+		return convertInt64(row.GEO_ImportWh, 1000)
+	case SourceGivEnergy:
+		return row.GE_ImportKWh
+	}
+	return nil
+}
+
+// exportFromSource returns the export kWh reported by source for the row, or nil if absent.
+func exportFromSource(row *UsageRow, source string) *float64 {
+	switch source {
+	case SourceOctopus:
+		return row.OCTO_ExportKWh
+	case SourceGivEnergy:
+		return row.GE_ExportKWh
+	}
+	return nil
+}
+
+// selectImport returns the first import figure available in priority order, along with its source.
+func selectImport(row *UsageRow, priority []string) (*float64, string) {
+	for _, source := range priority {
+		if value := importFromSource(row, source); value != nil {
+			return value, source
+		}
+	}
+	return nil, ""
+}
+
+// selectExport returns the first export figure available in priority order, along with its source.
+func selectExport(row *UsageRow, priority []string) (*float64, string) {
+	for _, source := range priority {
+		if value := exportFromSource(row, source); value != nil {
+			return value, source
+		}
+	}
+	return nil, ""
+}
+
+// withPrimary returns priority reordered so that primary comes first, keeping the
+// remaining sources as fallbacks in their original order.
+func withPrimary(primary string, priority []string) []string {
+	if primary == "" {
+		return priority
+	}
+	ordered := []string{primary}
+	for _, source := range priority {
+		if source != primary {
+			ordered = append(ordered, source)
+		}
+	}
+	return ordered
+}
+
+// selectCostFigures chooses the import and export figures used for the canonical costs.
+func selectCostFigures(row *UsageRow, priority []string) {
+	row.ImportKWh, row.ImportSource = selectImport(row, priority)
+	row.ExportKWh, row.ExportSource = selectExport(row, priority)
+}
+
+// parseSourcePriority parses a comma separated list of source names.
+func parseSourcePriority(s string) ([]string, error) {
+	var priority []string
+	for _, source := range strings.Split(s, ",") {
+		source = strings.ToLower(strings.TrimSpace(source))
+		switch source {
+		case SourceOctopus, SourceGeo, SourceGivEnergy:
+			priority = append(priority, source)
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown source %q", source)
+		}
+	}
+	if len(priority) == 0 {
+		return nil, fmt.Errorf("no sources given")
+	}
+	return priority, nil
+}
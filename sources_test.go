@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectImport(t *testing.T) {
+	geoWh := int64(1200)
+	row := &UsageRow{
+		OCTO_ImportKWh: floatPtr(1.1),
+		GEO_ImportWh:   &geoWh,
+		GE_ImportKWh:   floatPtr(1.3),
+	}
+
+	tests := []struct {
+		name           string
+		priority       []string
+		row            *UsageRow
+		expectSource   string
+		expectImportKW float64
+	}{
+		{name: "Default priority", priority: DefaultImportPriority, row: row, expectSource: SourceOctopus, expectImportKW: 1.1},
+		{name: "Geo first", priority: []string{SourceGeo, SourceOctopus}, row: row, expectSource: SourceGeo, expectImportKW: 1.2},
+		{name: "GivEnergy first", priority: []string{SourceGivEnergy, SourceGeo, SourceOctopus}, row: row, expectSource: SourceGivEnergy, expectImportKW: 1.3},
+		{name: "Falls through missing source", priority: DefaultImportPriority, row: &UsageRow{GE_ImportKWh: floatPtr(1.3)}, expectSource: SourceGivEnergy, expectImportKW: 1.3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, source := selectImport(test.row, test.priority)
+			require.Equal(t, test.expectSource, source)
+			require.NotNil(t, value)
+			require.InDelta(t, test.expectImportKW, *value, 1e-9)
+		})
+	}
+
+	value, source := selectImport(&UsageRow{}, DefaultImportPriority)
+	require.Nil(t, value)
+	require.Empty(t, source)
+}
+
+func TestParseSourcePriority(t *testing.T) {
+	priority, err := parseSourcePriority("GivEnergy, octopus")
+	require.NoError(t, err)
+	require.Equal(t, []string{SourceGivEnergy, SourceOctopus}, priority)
+
+	_, err = parseSourcePriority("octopus,wibble")
+	require.Error(t, err)
+}
+
+func TestWriteCSVImportSourceColumn(t *testing.T) {
+	geoWh := int64(1200)
+	row := &UsageRow{
+		Timestamp:      time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		OCTO_ImportKWh: floatPtr(1.1),
+		GEO_ImportWh:   &geoWh,
+		GE_ImportKWh:   floatPtr(1.3),
+	}
+	row.ImportKWh, row.ImportSource = selectImport(row, []string{SourceGeo, SourceOctopus, SourceGivEnergy})
+
+	filename := filepath.Join(t.TempDir(), "output.csv")
+	require.NoError(t, writeCSV(filename, []*UsageRow{row}, CSVOptions{IncludeImportSource: true}))
+
+	records := readCSVFile(t, filename)
+	require.Len(t, records, 2)
+	header := records[0]
+	require.Equal(t, "Import_Source", header[len(header)-1])
+	require.Equal(t, SourceGeo, records[1][len(header)-1])
+}
+
+// readCSVFile reads all records, including the header, from a CSV file.
+func readCSVFile(t *testing.T, filename string) [][]string {
+	file, err := os.Open(filename)
+	require.NoError(t, err)
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+	return records
+}
+
+func TestCostSourceFallback(t *testing.T) {
+	priority := withPrimary(SourceOctopus, []string{SourceGivEnergy, SourceGeo, SourceOctopus})
+	require.Equal(t, []string{SourceOctopus, SourceGivEnergy, SourceGeo}, priority)
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := []*UsageRow{
+		// Octopus present
+		{Timestamp: start, OCTO_ImportKWh: floatPtr(2), OCTO_ExportKWh: floatPtr(1), GE_ImportKWh: floatPtr(3), GE_ExportKWh: floatPtr(4), ImportPrice: floatPtr(10), ExportPrice: floatPtr(15)},
+		// Octopus missing, falls back to GivEnergy
+		{Timestamp: start.Add(30 * time.Minute), GE_ImportKWh: floatPtr(3), GE_ExportKWh: floatPtr(4), ImportPrice: floatPtr(10), ExportPrice: floatPtr(15)},
+	}
+	for _, row := range data {
+		selectCostFigures(row, priority)
+	}
+	require.Equal(t, SourceOctopus, data[0].ImportSource)
+	require.Equal(t, SourceGivEnergy, data[1].ImportSource)
+	require.Equal(t, SourceGivEnergy, data[1].ExportSource)
+
+	filename := filepath.Join(t.TempDir(), "output.csv")
+	require.NoError(t, writeCSV(filename, data, CSVOptions{IncludeCost: true}))
+
+	records := readCSVFile(t, filename)
+	header := records[0]
+	importCost := slices.Index(header, "Import_Cost")
+	exportCost := slices.Index(header, "Export_Cost")
+	require.NotEqual(t, -1, importCost)
+	require.NotEqual(t, -1, exportCost)
+
+	require.Equal(t, "20.00", records[1][importCost])
+	require.Equal(t, "15.00", records[1][exportCost])
+	require.Equal(t, "30.00", records[2][importCost])
+	require.Equal(t, "60.00", records[2][exportCost])
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteTable is the table rows are written to.
+const sqliteTable = "usage"
+
+// integerColumns hold whole numbers, stored as INTEGER rather than REAL.
+var integerColumns = []string{"GEO_Live_Watts", "GEO_Live_Gas_Watts"}
+
+// sqliteType returns the SQLite type of a CSV column.
+func sqliteType(name string) string {
+	switch {
+	case slices.Contains(textColumns, name):
+		return "TEXT"
+	case slices.Contains(integerColumns, name):
+		return "INTEGER"
+	}
+	return "REAL"
+}
+
+// sqliteValue converts a CSV field to the value stored for it, nil for a missing figure.
+func sqliteValue(name, field string) (any, error) {
+	if field == "" || field == "NaN" {
+		return nil, nil
+	}
+	switch sqliteType(name) {
+	case "TEXT":
+		return field, nil
+	case "INTEGER":
+		return strconv.ParseInt(field, 10, 64)
+	}
+	return strconv.ParseFloat(field, 64)
+}
+
+// writeSQLite upserts data into the usage table of the SQLite database at path, creating the
+// database and table as needed. The table has a nullable column per CSV column opts selects,
+// added to an existing table when missing, and is keyed on the timestamp in UTC epoch seconds
+// so re-runs update rows rather than duplicating them.
+func writeSQLite(path string, data []*UsageRow, opts CSVOptions) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	columns := slices.DeleteFunc(csvColumns(opts), func(column csvColumn) bool { return column.Name == "Timestamp" })
+	if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (timestamp INTEGER PRIMARY KEY)`, sqliteTable)); err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+	existing, err := sqliteColumns(db)
+	if err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if existing[column.Name] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %q ADD COLUMN %q %s`, sqliteTable, column.Name, sqliteType(column.Name))); err != nil {
+			return fmt.Errorf("failed to add column %s: %w", column.Name, err)
+		}
+	}
+
+	names := []string{"timestamp"}
+	placeholders := []string{"?"}
+	updates := make([]string, 0, len(columns))
+	for _, column := range columns {
+		names = append(names, strconv.Quote(column.Name))
+		placeholders = append(placeholders, "?")
+		updates = append(updates, fmt.Sprintf("%q = excluded.%q", column.Name, column.Name))
+	}
+	query := fmt.Sprintf(`INSERT INTO %q (%s) VALUES (%s) ON CONFLICT(timestamp) DO UPDATE SET %s`,
+		sqliteTable, strings.Join(names, ", "), strings.Join(placeholders, ", "), strings.Join(updates, ", "))
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range data {
+		values := []any{row.Timestamp.UTC().Unix()}
+		for _, column := range columns {
+			value, err := sqliteValue(column.Name, column.Value(row))
+			if err != nil {
+				return fmt.Errorf("invalid %s at %s: %w", column.Name, row.Timestamp, err)
+			}
+			values = append(values, value)
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// sqliteColumns returns the names of the usage table's columns.
+func sqliteColumns(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT name FROM pragma_table_info(%s)`, strconv.Quote(sqliteTable)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSQLiteUpserts(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "usage.db")
+
+	require.NoError(t, writeSQLite(path, []*UsageRow{
+		{Timestamp: start, OCTO_ImportKWh: floatPtr(0.5)},
+		{Timestamp: start.Add(30 * time.Minute), OCTO_ImportKWh: floatPtr(0.7)},
+	}, CSVOptions{}))
+	// A re-run overlapping the first updates the shared slot rather than duplicating it
+	require.NoError(t, writeSQLite(path, []*UsageRow{
+		{Timestamp: start.Add(30 * time.Minute), OCTO_ImportKWh: floatPtr(0.8), GE_ImportKWh: floatPtr(0.6)},
+		{Timestamp: start.Add(time.Hour), OCTO_ImportKWh: floatPtr(0.9)},
+	}, CSVOptions{IncludeImportSource: true}))
+
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var count int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM usage`).Scan(&count))
+	require.Equal(t, 3, count)
+
+	var octo float64
+	var ge, geo sql.NullFloat64
+	require.NoError(t, db.QueryRow(`SELECT OCTO_Import_KWh, GE_Import_KWh, GEO_Import_KWh FROM usage WHERE timestamp = ?`,
+		start.Add(30*time.Minute).Unix()).Scan(&octo, &ge, &geo))
+	require.Equal(t, 0.8, octo)
+	require.Equal(t, sql.NullFloat64{Float64: 0.6, Valid: true}, ge)
+	require.False(t, geo.Valid, "Expected missing figures stored as NULL")
+
+	// Columns only the second run selected are added to the existing table
+	var source sql.NullString
+	require.NoError(t, db.QueryRow(`SELECT Import_Source FROM usage WHERE timestamp = ?`, start.Unix()).Scan(&source))
+	require.False(t, source.Valid)
+}
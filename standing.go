@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// StandingChargePlacement controls how the daily standing charge appears in the per-slot output.
+type StandingChargePlacement string
+
+const (
+	// StandingChargeNone leaves the standing charge to the summary only.
+	StandingChargeNone StandingChargePlacement = ""
+	// StandingChargeSpread divides each day's charge evenly over its slots.
+	StandingChargeSpread StandingChargePlacement = "spread"
+	// StandingChargeFirstSlot puts each day's charge on its first slot.
+	StandingChargeFirstSlot StandingChargePlacement = "first-slot"
+	// StandingChargeDailyRow adds a row tagged TagStandingCharge at the start of each day.
+	StandingChargeDailyRow StandingChargePlacement = "daily-row"
+)
+
+// TagStandingCharge marks the rows added by StandingChargeDailyRow.
+const TagStandingCharge = "standing_charge"
+
+func parseStandingChargePlacement(value string) (StandingChargePlacement, error) {
+	switch p := StandingChargePlacement(value); p {
+	case StandingChargeNone, StandingChargeSpread, StandingChargeFirstSlot, StandingChargeDailyRow:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown standing charge placement %q, expected %s, %s or %s",
+		value, StandingChargeSpread, StandingChargeFirstSlot, StandingChargeDailyRow)
+}
+
+// slotsPerDay returns the number of slots of granularity g in the local day containing t.
+func slotsPerDay(t time.Time, g Granularity, loc *time.Location) int {
+	start := truncateToMidnight(inZone(t, loc))
+	end := start.AddDate(0, 0, 1)
+	count := 0
+	for slot := start; slot.Before(end); slot = g.next(slot) {
+		count++
+	}
+	return count
+}
+
+// placeStandingCharge sets the standing charge (pence) on data, sorted by timestamp, according
+// to placement, returning the rows to output. Only StandingChargeDailyRow adds rows.
+func placeStandingCharge(data []*UsageRow, placement StandingChargePlacement, standingCharge func(day time.Time) float64, g Granularity, loc *time.Location) []*UsageRow {
+	if placement == StandingChargeNone {
+		return data
+	}
+
+	var rows []*UsageRow
+	var lastDay time.Time
+	for _, row := range data {
+		day := truncateToMidnight(inZone(row.Timestamp, loc))
+		firstOfDay := !day.Equal(lastDay)
+		lastDay = day
+
+		var charge float64
+		switch placement {
+		case StandingChargeSpread:
+			charge = standingCharge(day) / float64(slotsPerDay(day, g, loc))
+		case StandingChargeFirstSlot:
+			if firstOfDay {
+				charge = standingCharge(day)
+			}
+		case StandingChargeDailyRow:
+			if firstOfDay {
+				dayCharge := standingCharge(day)
+				rows = append(rows, &UsageRow{Timestamp: day, StandingCharge: &dayCharge, Tag: TagStandingCharge})
+			}
+			rows = append(rows, row)
+			continue
+		}
+		row.StandingCharge = &charge
+		rows = append(rows, row)
+	}
+	return rows
+}
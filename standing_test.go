@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlaceStandingChargeFirstSlot(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+	var data []*UsageRow
+	for ts := start; ts.Before(start.AddDate(0, 0, 2)); ts = ts.Add(30 * time.Minute) {
+		data = append(data, &UsageRow{Timestamp: ts})
+	}
+
+	rows := placeStandingCharge(data, StandingChargeFirstSlot, func(time.Time) float64 { return 45.5 }, GranularityHalfHour, nil)
+	require.Len(t, rows, len(data))
+
+	for i, row := range rows {
+		require.NotNil(t, row.StandingCharge)
+		if i%48 == 0 {
+			require.InDelta(t, 45.5, *row.StandingCharge, 0.0001, "Slot 0 of the day should carry the charge")
+		} else {
+			require.Zero(t, *row.StandingCharge, "Only slot 0 of the day should carry the charge")
+		}
+	}
+}
+
+func TestFetchedStandingChargeOncePerDay(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	mockRoundTripper := &MockRoundTripper{
+		Handler: func(req *http.Request) (*http.Response, error) {
+			require.Equal(t, "/v1/products/AGILE-24-10-01/electricity-tariffs/E-1R-AGILE-24-10-01-M/standing-charges/", req.URL.Path)
+			responseBody := `{"count": 2, "next": null, "results": [
+				{"value_inc_vat": 60.0, "valid_from": "2024-06-01T23:00:00Z", "valid_to": null},
+				{"value_inc_vat": 45.5, "valid_from": "2024-01-01T00:00:00Z", "valid_to": "2024-06-01T23:00:00Z"}
+			]}`
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(responseBody)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+	app := &App{
+		Config:         &Config{},
+		OctopusService: NewOctopusService(mockRoundTripper, "dummyApiKey"),
+		ImportMeter:    &MeterInfo{ProductCode: "AGILE-24-10-01", TariffCode: "E-1R-AGILE-24-10-01-M"},
+	}
+
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(0, 0, 2)
+	require.NoError(t, app.fetchStandingCharges(context.Background(), start, end.UTC()))
+	require.Equal(t, 45.5, app.standingCharge(start))
+	require.Equal(t, 60.0, app.standingCharge(start.AddDate(0, 0, 1)))
+
+	var data []*UsageRow
+	for ts := start; ts.Before(end); ts = ts.Add(30 * time.Minute) {
+		data = append(data, &UsageRow{Timestamp: ts})
+	}
+	for _, placement := range []StandingChargePlacement{StandingChargeFirstSlot, StandingChargeSpread} {
+		var total float64
+		for _, row := range placeStandingCharge(data, placement, app.standingCharge, GranularityHalfHour, nil) {
+			total += *row.StandingCharge
+		}
+		require.InDelta(t, 45.5+60.0, total, 0.0001, "Expected each day's charge once with %s placement", placement)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Summary holds totals across the output rows.
+type Summary struct {
+	Days                int
+	TotalImportKWh      float64
+	TotalImportCost     float64 // pence, unit rate only
+	TotalStandingCharge float64 // pence
+	// EffectiveImportRate is the pence per kWh including the amortised standing charge.
+	// It is nil when there was no import to spread the costs over.
+	EffectiveImportRate *float64
+}
+
+// summarise totals the Octopus import figures in data and adds the daily standing
+// charge (pence) returned by standingCharge for each distinct local day present.
+func summarise(data []*UsageRow, standingCharge func(day time.Time) float64, loc *time.Location) Summary {
+	var summary Summary
+	days := make(map[time.Time]bool)
+
+	for _, row := range data {
+		days[truncateToMidnight(inZone(row.Timestamp, loc))] = true
+		if row.OCTO_ImportKWh == nil {
+			continue
+		}
+		summary.TotalImportKWh += *row.OCTO_ImportKWh
+		if row.ImportPrice != nil {
+			summary.TotalImportCost += *row.OCTO_ImportKWh * *row.ImportPrice
+		}
+	}
+
+	summary.Days = len(days)
+	for day := range days {
+		summary.TotalStandingCharge += standingCharge(day)
+	}
+
+	if summary.TotalImportKWh > 0 {
+		rate := (summary.TotalImportCost + summary.TotalStandingCharge) / summary.TotalImportKWh
+		summary.EffectiveImportRate = &rate
+	}
+
+	return summary
+}
+
+// logSummary writes the summary totals to the log.
+func logSummary(summary Summary) {
+	slog.Info("Import", "kWh", fmt.Sprintf("%.3f", summary.TotalImportKWh), "days", summary.Days,
+		"unitCost", fmt.Sprintf("%.2fp", summary.TotalImportCost), "standingCharge", fmt.Sprintf("%.2fp", summary.TotalStandingCharge))
+	if summary.EffectiveImportRate == nil {
+		slog.Info("Effective import rate: n/a (no import)")
+		return
+	}
+	slog.Info("Effective import rate", "rate", fmt.Sprintf("%.4fp/kWh", *summary.EffectiveImportRate))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummariseEffectiveImportRate(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+
+	// Two days of 1 kWh per half-hour at 20p/kWh with a 50p daily standing charge
+	var data []*UsageRow
+	for ts := start; ts.Before(start.AddDate(0, 0, 2)); ts = ts.Add(30 * time.Minute) {
+		data = append(data, &UsageRow{Timestamp: ts, OCTO_ImportKWh: floatPtr(1), ImportPrice: floatPtr(20)})
+	}
+
+	summary := summarise(data, fixedStandingCharge(50), nil)
+	require.Equal(t, 2, summary.Days)
+	require.InDelta(t, 96, summary.TotalImportKWh, 1e-9)
+	require.InDelta(t, 1920, summary.TotalImportCost, 1e-9)
+	require.InDelta(t, 100, summary.TotalStandingCharge, 1e-9)
+	require.NotNil(t, summary.EffectiveImportRate)
+	require.InDelta(t, (1920.0+100.0)/96.0, *summary.EffectiveImportRate, 1e-9)
+}
+
+func TestSummariseZeroUsage(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	data := []*UsageRow{
+		{Timestamp: start, OCTO_ImportKWh: floatPtr(0), ImportPrice: floatPtr(20)},
+		{Timestamp: start.Add(30 * time.Minute), ImportPrice: floatPtr(20)},
+	}
+
+	summary := summarise(data, fixedStandingCharge(50), nil)
+	require.Equal(t, 1, summary.Days)
+	require.InDelta(t, 50, summary.TotalStandingCharge, 1e-9)
+	require.Nil(t, summary.EffectiveImportRate, "Expected no effective rate without import")
+}
+
+// fixedStandingCharge returns a standing charge lookup with the same charge every day.
+func fixedStandingCharge(pence float64) func(time.Time) float64 {
+	return func(time.Time) float64 { return pence }
+}
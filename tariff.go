@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// TariffProvider supplies the unit rates usage is priced against.
+type TariffProvider interface {
+	// Tariffs returns the rates for the import or export side covering start to end.
+	Tariffs(ctx context.Context, direction Direction, start, end time.Time) ([]TariffData, error)
+}
+
+// OctopusTariffProvider prices usage at the Octopus tariffs of the account's meters.
+type OctopusTariffProvider struct {
+	Service     *OctopusService
+	ImportMeter *MeterInfo
+	ExportMeter *MeterInfo
+}
+
+// Tariffs fetches the unit rates of the direction's meter from Octopus, none when the account
+// has no meter in that direction.
+func (p *OctopusTariffProvider) Tariffs(ctx context.Context, direction Direction, start, end time.Time) ([]TariffData, error) {
+	meter := p.ImportMeter
+	if direction == DirectionExport {
+		meter = p.ExportMeter
+	}
+	if meter == nil {
+		return nil, nil
+	}
+	return p.Service.FetchTariffs(ctx, meter.ProductCode, meter.TariffCode, start, end)
+}
+
+// RateWindow is a daily time of use window, from and to being local HH:MM times. A window whose
+// to is not after its from runs past midnight, so 00:00 to 00:00 covers the whole day.
+type RateWindow struct {
+	From string  `json:"from"`
+	To   string  `json:"to"`
+	Rate float64 `json:"rate"` // p/kWh including VAT
+}
+
+// ManualTariffProvider prices usage against a fixed daily schedule of rate windows.
+type ManualTariffProvider struct {
+	Import []RateWindow `json:"import"`
+	Export []RateWindow `json:"export"`
+	// VAT is the percentage included in the import rates, used to work out the rates excluding it.
+	VAT float64 `json:"vat"`
+	// Location is the zone the windows' clock times are in, local time when nil.
+	Location *time.Location `json:"-"`
+}
+
+// LoadManualTariffProvider reads a JSON schedule such as
+//
+//	{"import": [{"from": "00:30", "to": "07:30", "rate": 7.5}, {"from": "07:30", "to": "00:30", "rate": 27}],
+//	 "export": [{"from": "00:00", "to": "00:00", "rate": 15}], "vat": 5}
+func LoadManualTariffProvider(path string) (*ManualTariffProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var provider ManualTariffProvider
+	if err := json.Unmarshal(data, &provider); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	for name, windows := range map[string][]RateWindow{"import": provider.Import, "export": provider.Export} {
+		for _, w := range windows {
+			if _, _, err := w.clock(); err != nil {
+				return nil, fmt.Errorf("invalid %s window %s-%s: %w", name, w.From, w.To, err)
+			}
+		}
+	}
+	return &provider, nil
+}
+
+// clock returns the window's from and to as offsets from midnight.
+func (w RateWindow) clock() (from, to time.Duration, err error) {
+	parse := func(value string) (time.Duration, error) {
+		t, err := time.Parse("15:04", value)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+	}
+	if from, err = parse(w.From); err != nil {
+		return 0, 0, err
+	}
+	if to, err = parse(w.To); err != nil {
+		return 0, 0, err
+	}
+	return from, to, nil
+}
+
+// Tariffs lays the direction's windows out over each local day from start to end.
+func (p *ManualTariffProvider) Tariffs(_ context.Context, direction Direction, start, end time.Time) ([]TariffData, error) {
+	windows := p.Import
+	vat := p.VAT
+	if direction == DirectionExport {
+		windows = p.Export
+		vat = 0 // export payments carry no VAT
+	}
+
+	// Wall clock times, so windows keep their local times over the clock changes
+	at := func(day time.Time, offset time.Duration) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), 0, int(offset/time.Minute), 0, 0, day.Location())
+	}
+
+	var tariffs []TariffData
+	// Start the day before for windows running past midnight into the first day
+	for day := truncateToMidnight(inZone(start, p.Location)).AddDate(0, 0, -1); day.Before(end); day = day.AddDate(0, 0, 1) {
+		for _, w := range windows {
+			from, to, err := w.clock()
+			if err != nil {
+				return nil, err
+			}
+			validFrom := at(day, from)
+			validTo := at(day, to)
+			if to <= from {
+				validTo = at(day.AddDate(0, 0, 1), to)
+			}
+			tariffs = append(tariffs, TariffData{
+				Rate:       w.Rate,
+				RateExcVat: w.Rate / (1 + vat/100),
+				ValidFrom:  &validFrom,
+				ValidTo:    &validTo,
+			})
+		}
+	}
+	return tariffs, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// TariffWindow is a period over which a rate was unchanged.
+type TariffWindow struct {
+	Type string // import, export or standing_charge
+	From *time.Time
+	To   *time.Time // nil when the rate is open ended
+	Rate float64    // p/kWh including VAT, or p/day for the standing charge
+}
+
+// mergeTariffWindows returns the distinct rate windows of tariffs, sorted by start, merging
+// consecutive windows of the same rate.
+func mergeTariffWindows(kind string, tariffs []TariffData) []TariffWindow {
+	sorted := make([]TariffData, len(tariffs))
+	copy(sorted, tariffs)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[j].startsAfter(&sorted[i]) })
+
+	var windows []TariffWindow
+	for _, tariff := range sorted {
+		if last := len(windows) - 1; last >= 0 && windows[last].Rate == tariff.Rate &&
+			windows[last].To != nil && tariff.ValidFrom != nil && windows[last].To.Equal(*tariff.ValidFrom) {
+			windows[last].To = tariff.ValidTo
+			continue
+		}
+		windows = append(windows, TariffWindow{Type: kind, From: tariff.ValidFrom, To: tariff.ValidTo, Rate: tariff.Rate})
+	}
+	return windows
+}
+
+// standingChargeWindows returns the standing charge of each local day from start to end as an
+// interval per day, for mergeTariffWindows to merge.
+func standingChargeWindows(start, end time.Time, charge func(day time.Time) float64, loc *time.Location) []TariffData {
+	var days []TariffData
+	for day := truncateToMidnight(inZone(start, loc)); day.Before(end); day = day.AddDate(0, 0, 1) {
+		from, to := day, day.AddDate(0, 0, 1)
+		days = append(days, TariffData{Rate: charge(day), ValidFrom: &from, ValidTo: &to})
+	}
+	return days
+}
+
+// writeTariffHistory writes the import, export and standing charge rate windows from start to
+// end to filename, a row per distinct window rather than per slot.
+func (app *App) writeTariffHistory(ctx context.Context, filename string, start, end time.Time) error {
+	importTariffs, err := app.Tariffs.Tariffs(ctx, DirectionImport, start, end.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to fetch import tariffs: %w", err)
+	}
+	exportTariffs, err := app.Tariffs.Tariffs(ctx, DirectionExport, start, end.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to fetch export tariffs: %w", err)
+	}
+
+	var windows []TariffWindow
+	windows = append(windows, mergeTariffWindows(string(DirectionImport), importTariffs)...)
+	windows = append(windows, mergeTariffWindows(string(DirectionExport), exportTariffs)...)
+	windows = append(windows, mergeTariffWindows(TagStandingCharge, standingChargeWindows(start, end, app.standingCharge, app.Config.Location))...)
+	return writeTariffWindows(filename, windows)
+}
+
+// writeTariffWindows writes windows as a Type, Valid_From, Valid_To, Rate CSV, leaving open
+// ended bounds empty.
+func writeTariffWindows(filename string, windows []TariffWindow) error {
+	file, _, err := openOutput(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Abort()
+
+	bound := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	writer := csv.NewWriter(file)
+	if err := writer.Write([]string{"Type", "Valid_From", "Valid_To", "Rate"}); err != nil {
+		return err
+	}
+	for _, w := range windows {
+		if err := writer.Write([]string{w.Type, bound(w.From), bound(w.To), strconv.FormatFloat(w.Rate, 'f', -1, 64)}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeTariffWindows(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	slot := func(i int) *time.Time { t := start.Add(time.Duration(i) * 30 * time.Minute); return &t }
+
+	// Newest first, as Octopus lists them, with a repeated rate either side of a change
+	tariffs := []TariffData{
+		{Rate: 20, ValidFrom: slot(4), ValidTo: nil},
+		{Rate: 15, ValidFrom: slot(3), ValidTo: slot(4)},
+		{Rate: 20, ValidFrom: slot(2), ValidTo: slot(3)},
+		{Rate: 20, ValidFrom: slot(1), ValidTo: slot(2)},
+		{Rate: 20, ValidFrom: slot(0), ValidTo: slot(1)},
+	}
+	require.Equal(t, []TariffWindow{
+		{Type: "import", From: slot(0), To: slot(3), Rate: 20},
+		{Type: "import", From: slot(3), To: slot(4), Rate: 15},
+		{Type: "import", From: slot(4), To: nil, Rate: 20},
+	}, mergeTariffWindows("import", tariffs), "Expected consecutive equal rates merged into one window")
+
+	days := standingChargeWindows(start, start.AddDate(0, 0, 3), func(day time.Time) float64 { return 45.5 }, nil)
+	require.Len(t, days, 3)
+	windows := mergeTariffWindows(TagStandingCharge, days)
+	require.Len(t, windows, 1, "Expected an unchanged standing charge as one window")
+
+	filename := filepath.Join(t.TempDir(), "tariffs.csv")
+	require.NoError(t, writeTariffWindows(filename, mergeTariffWindows("import", tariffs)))
+	records := readCSVFile(t, filename)
+	require.Equal(t, []string{"Type", "Valid_From", "Valid_To", "Rate"}, records[0])
+	require.Equal(t, []string{"import", "2025-01-01T00:00:00Z", "2025-01-01T01:30:00Z", "20"}, records[1])
+	require.Equal(t, []string{"import", "2025-01-01T02:00:00Z", "", "20"}, records[3])
+}
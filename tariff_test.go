@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManualTariffProvider(t *testing.T) {
+	withLocation(t, "Europe/London")
+
+	path := filepath.Join(t.TempDir(), "tariffs.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"import": [{"from": "00:30", "to": "07:30", "rate": 10.5}, {"from": "07:30", "to": "00:30", "rate": 31.5}],
+		"export": [{"from": "00:00", "to": "00:00", "rate": 15}],
+		"vat": 5
+	}`), 0o644))
+	provider, err := LoadManualTariffProvider(path)
+	require.NoError(t, err)
+	var _ TariffProvider = provider
+
+	day := time.Date(2025, 3, 30, 0, 0, 0, 0, time.Local) // the clocks go forward at 01:00
+	end := day.AddDate(0, 0, 1)
+	importTariffs, err := provider.Tariffs(context.Background(), DirectionImport, day, end)
+	require.NoError(t, err)
+	exportTariffs, err := provider.Tariffs(context.Background(), DirectionExport, day, end)
+	require.NoError(t, err)
+
+	app := &App{Config: &Config{}}
+	importRates, exportRates := buildRateIndex(importTariffs), buildRateIndex(exportTariffs)
+	var importCost, exportCost float64
+	for ts := day; ts.Before(end); ts = ts.Add(30 * time.Minute) {
+		row := &UsageRow{Timestamp: ts, OCTO_ImportKWh: floatPtr(1), OCTO_ExportKWh: floatPtr(0.5)}
+		app.priceRow(ts, row, importRates, exportRates)
+		require.NotNil(t, row.ImportPrice, "Missing import price at %s", ts)
+		importCost += *costPence(row.OCTO_ImportKWh, row.ImportPrice, RoundHalfUp, 2)
+		exportCost += *costPence(row.OCTO_ExportKWh, row.ExportPrice, RoundHalfUp, 2)
+
+		if ts.Equal(day) || ts.Equal(time.Date(2025, 3, 30, 7, 30, 0, 0, time.Local)) {
+			require.Equal(t, 31.5, *row.ImportPrice, "Expected the day rate at %s", ts)
+			require.InDelta(t, 30, *row.ImportPriceExcVat, 1e-9)
+		}
+		if ts.Equal(time.Date(2025, 3, 30, 3, 0, 0, 0, time.Local)) {
+			require.Equal(t, 10.5, *row.ImportPrice, "Expected the night rate at %s", ts)
+		}
+	}
+
+	// A 23 hour day: the night window 00:30-07:30 is 6 hours of wall clock, 12 slots, leaving 34 day slots
+	require.InDelta(t, 12*10.5+34*31.5, importCost, 1e-9)
+	require.InDelta(t, 46*7.5, exportCost, 1e-9)
+}
+
+func TestLoadManualTariffProviderInvalidWindow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tariffs.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"import": [{"from": "7pm", "to": "00:00", "rate": 10}]}`), 0o644))
+	_, err := LoadManualTariffProvider(path)
+	require.ErrorContains(t, err, "invalid import window")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// OutputFormat selects the layout of the output CSV.
+type OutputFormat string
+
+const (
+	// FormatWide writes a row per slot with a column per figure, the default.
+	FormatWide OutputFormat = "wide"
+	// FormatTidy writes a row per slot and figure, for ggplot and pandas.
+	FormatTidy OutputFormat = "tidy"
+)
+
+// parseOutputFormat validates an output format name, defaulting to wide when empty.
+func parseOutputFormat(value string) (OutputFormat, error) {
+	switch f := OutputFormat(value); f {
+	case "":
+		return FormatWide, nil
+	case FormatWide, FormatTidy:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown output format %q, expected %s or %s", value, FormatWide, FormatTidy)
+}
+
+// tidyHeader is the header of the tidy format.
+var tidyHeader = []string{"timestamp", "metric", "value", "source"}
+
+// textColumns hold labels rather than figures, so are left out of the tidy format.
+var textColumns = []string{"Tag", "Account", "Import_Source", "Import_Tariff", "Export_Tariff", "OCTO_Export_Filled_From"}
+
+// sourcePrefixes map the wide column name prefixes to the source of the figure.
+var sourcePrefixes = []struct {
+	prefix string
+	source string
+}{
+	// GEO_ before GE_, which it also starts with
+	{"GEO_", SourceGeo},
+	{"GE_", SourceGivEnergy},
+	{"OCTO_", SourceOctopus},
+}
+
+// tidyMetric splits a wide column name into the metric and the source it came from, empty
+// for figures such as prices that don't come from a meter.
+func tidyMetric(name string) (metric, source string) {
+	for _, p := range sourcePrefixes {
+		if metric, ok := strings.CutPrefix(name, p.prefix); ok {
+			return metric, p.source
+		}
+	}
+	return name, ""
+}
+
+// tidyRecords expands a row into a record per figure the columns select, omitting missing ones.
+func tidyRecords(row *UsageRow, columns []csvColumn, timestamp string) [][]string {
+	var records [][]string
+	for _, column := range columns {
+		if column.Name == "Timestamp" || slices.Contains(textColumns, column.Name) {
+			continue
+		}
+		value := column.Value(row)
+		if value == "" || value == "NaN" {
+			continue
+		}
+		metric, source := tidyMetric(column.Name)
+		records = append(records, []string{timestamp, metric, value, source})
+	}
+	return records
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPTimeout(t *testing.T) {
+	// A connection that never answers
+	hung := &MockRoundTripper{Handler: func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}}
+
+	client := &http.Client{Transport: &RetryingRoundTripper{Next: withTimeout(hung, 20*time.Millisecond), Retries: 2}}
+	start := time.Now()
+	_, err := client.Get("https://api.givenergy.cloud/v1/inverter/SN1/data-points/2025-01-01")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	// The deadline stays in force while the body is read, and is released when it's closed
+	var reqCtx context.Context
+	answered := &MockRoundTripper{Handler: func(req *http.Request) (*http.Response, error) {
+		reqCtx = req.Context()
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+	}}
+	req, err := http.NewRequest(http.MethodGet, "https://api.octopus.energy/v1/products/", nil)
+	require.NoError(t, err)
+	resp, err := withTimeout(answered, time.Hour).RoundTrip(req)
+	require.NoError(t, err)
+	require.NoError(t, reqCtx.Err())
+	require.NoError(t, resp.Body.Close())
+	require.ErrorIs(t, reqCtx.Err(), context.Canceled)
+
+	require.Same(t, answered, withTimeout(answered, 0))
+}
+
+func TestRequestTimeoutWithinHTTPTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-body" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	get := func(path string, requestTimeout, httpTimeout time.Duration) error {
+		client := &http.Client{Transport: withTimeout(newTransport(nil, requestTimeout), httpTimeout)}
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+
+	// The request timeout fails slow headers well within the HTTP timeout
+	start := time.Now()
+	err := get("/slow-headers", 20*time.Millisecond, time.Hour)
+	require.ErrorContains(t, err, "timeout awaiting response headers")
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	// The HTTP timeout fails slow headers when it's the shorter
+	err = get("/slow-headers", time.Hour, 20*time.Millisecond)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Only the HTTP timeout covers reading the body
+	err = get("/slow-body", 20*time.Millisecond, 50*time.Millisecond)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}